@@ -12,8 +12,12 @@ import (
 )
 
 func (cli *HyperClient) HyperCmdAttach(args ...string) error {
-	var parser = gflag.NewParser(nil, gflag.Default)
-	parser.Usage = "attach CONTAINER\n\nattach to the tty of a specified container in a pod"
+	var opts struct {
+		DetachKeys string `long:"detach-keys" value-name:"\"\"" description:"the keys to detach from the container, like ctrl-p,ctrl-q, ctrl-d by default"`
+		ReadOnly   bool   `long:"read-only" default:"false" value-name:"false" description:"watch the container, without sending input to it"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "attach [OPTIONS] POD|CONTAINER\n\nattach to the tty of a container, or of the first container of a pod given by its name or a prefix of its id"
 	args, err := parser.Parse()
 	if err != nil {
 		if !strings.Contains(err.Error(), "Usage") {
@@ -26,32 +30,29 @@ func (cli *HyperClient) HyperCmdAttach(args ...string) error {
 		return fmt.Errorf("Can not accept the 'attach' command without Container ID!")
 	}
 	var (
-		podName     = args[1]
-		hostname    = ""
-		tag         = cli.GetTag()
-		containerId = podName
+		podName  = args[1]
+		hostname = ""
+		tag      = cli.GetTag()
 	)
 
+	// the daemon takes a container, or a pod by its name or id for its
+	// first container
 	v := url.Values{}
-	if strings.Contains(podName, "pod-") {
-		hostname, err = cli.GetPodInfo(podName)
-		if err != nil {
-			return err
-		}
-		if hostname == "" {
-			return fmt.Errorf("The pod(%s) does not exist, please create it before exec!", podName)
-		}
-		containerId, err = cli.GetContainerByPod(podName)
-		if err != nil {
-			return err
-		}
-		v.Set("type", "container")
-		v.Set("value", containerId)
+	v.Set("type", "container")
+	v.Set("value", podName)
+	v.Set("tag", tag)
+	if opts.DetachKeys != "" {
+		v.Set("detachKeys", opts.DetachKeys)
+	}
+	if opts.ReadOnly {
+		v.Set("readonly", "yes")
+	}
+	tty := cli.isTerminalIn && cli.isTerminalOut
+	if tty {
+		v.Set("tty", "yes")
 	} else {
-		v.Set("type", "container")
-		v.Set("value", containerId)
+		v.Set("stream", "multiplexed")
 	}
-	v.Set("tag", tag)
 
 	var (
 		hijacked = make(chan io.Closer)
@@ -66,11 +67,13 @@ func (cli *HyperClient) HyperCmdAttach(args ...string) error {
 	}()
 
 	errCh = promise.Go(func() error {
-		return cli.hijack("POST", "/attach?"+v.Encode(), true, cli.in, cli.out, cli.out, hijacked, nil, hostname)
+		return cli.hijack("POST", "/attach?"+v.Encode(), tty, cli.in, cli.out, cli.err, hijacked, nil, hostname)
 	})
 
-	if err := cli.monitorTtySize(podName, tag); err != nil {
-		fmt.Printf("Monitor tty size fail for %s!\n", podName)
+	if tty {
+		if err := cli.monitorTtySize(podName, tag); err != nil {
+			fmt.Printf("Monitor tty size fail for %s!\n", podName)
+		}
 	}
 
 	// Acknowledge the hijack before starting
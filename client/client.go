@@ -30,6 +30,17 @@ type HyperClient struct {
 	transport     *http.Transport
 }
 
+// StatusError reports a failure of the command run by the client, the
+// hyper binary exits with StatusCode.
+type StatusError struct {
+	Status     string
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("Status: %s, Code: %d", e.Status, e.StatusCode)
+}
+
 var funcMap = template.FuncMap{
 	"json": func(v interface{}) string {
 		a, _ := json.Marshal(v)
@@ -38,12 +49,15 @@ var funcMap = template.FuncMap{
 }
 
 func (cli *HyperClient) getMethod(args ...string) (func(...string) error, bool) {
-	camelArgs := make([]string, len(args))
-	for i, s := range args {
-		if len(s) == 0 {
-			return nil, false
+	camelArgs := []string{}
+	for _, arg := range args {
+		// dashed commands, like port-forward, become PortForward
+		for _, s := range strings.Split(arg, "-") {
+			if len(s) == 0 {
+				return nil, false
+			}
+			camelArgs = append(camelArgs, strings.ToUpper(s[:1])+strings.ToLower(s[1:]))
 		}
-		camelArgs[i] = strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
 	}
 	methodName := "HyperCmd" + strings.Join(camelArgs, "")
 	method := reflect.ValueOf(cli).MethodByName(methodName)
@@ -111,7 +125,7 @@ func NewHyperClient(proto, addr string, tlsConfig *tls.Config) *HyperClient {
 		addr:          addr,
 		in:            os.Stdin,
 		out:           os.Stdout,
-		err:           os.Stdout,
+		err:           os.Stderr,
 		inFd:          inFd,
 		outFd:         outFd,
 		isTerminalIn:  isTerminalIn,
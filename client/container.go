@@ -0,0 +1,110 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+	"hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper container add|rm, changing the containers of a running pod
+func (cli *HyperClient) HyperCmdContainer(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "container add|rm POD ...\n\nadd a container to a running pod, or remove one from it"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	return fmt.Errorf("\"container\" requires a subcommand, add or rm.\n")
+}
+
+func (cli *HyperClient) HyperCmdContainerAdd(args ...string) error {
+	var opts struct {
+		File string `short:"f" long:"file" value-name:"\"\"" description:"the spec file of the container"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "container add POD -f CONTAINER_FILE\n\ncreate a container and start it in a running pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 || opts.File == "" {
+		return fmt.Errorf("\"container add\" requires a pod and the container spec file given with -f.\n")
+	}
+	podId := args[2]
+	jsonbody, err := ioutil.ReadFile(opts.File)
+	if err != nil {
+		return err
+	}
+
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("containerArgs", string(jsonbody))
+	remoteInfo, err := cli.containerCall("/container/add?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Container %s is added to pod %s\n", remoteInfo.Get("ID"), podId)
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdContainerRm(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "container rm POD NAME\n\nstop a container of a running pod and destroy it"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 4 {
+		return fmt.Errorf("\"container rm\" requires a pod and the name of the container.\n")
+	}
+	podId := args[2]
+
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("container", args[3])
+	remoteInfo, err := cli.containerCall("/container/remove?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Container %s is removed from pod %s\n", remoteInfo.Get("ID"), podId)
+	return nil
+}
+
+func (cli *HyperClient) containerCall(path string) (*engine.Env, error) {
+	body, _, err := readBody(cli.call("POST", path, nil, nil))
+	if err != nil {
+		return nil, err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return nil, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	if errCode := remoteInfo.GetInt("Code"); errCode != types.E_OK {
+		return nil, fmt.Errorf("Error code is %d, Cause is %s", errCode, remoteInfo.Get("Cause"))
+	}
+	return remoteInfo, nil
+}
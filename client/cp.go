@@ -0,0 +1,85 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"hyper/utils"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper cp POD:[CONTAINER:]PATH LOCALPATH
+// hyper cp LOCALPATH POD:[CONTAINER:]PATH
+func (cli *HyperClient) HyperCmdCp(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "cp POD:[CONTAINER:]PATH LOCALPATH | LOCALPATH POD:[CONTAINER:]PATH\n\ncopy files between a container of a running pod and the local filesystem"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"cp\" requires 2 arguments, the source and the destination.")
+	}
+
+	if v, ok := parseRemotePath(args[1]); ok {
+		if _, remote := parseRemotePath(args[2]); remote {
+			return fmt.Errorf("Can not copy between two pods.")
+		}
+		return cli.copyFromPod(v, args[2])
+	}
+	if v, ok := parseRemotePath(args[2]); ok {
+		return cli.copyToPod(args[1], v)
+	}
+	return fmt.Errorf("One of the source and the destination must be POD:[CONTAINER:]PATH.")
+}
+
+func parseRemotePath(arg string) (url.Values, bool) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") || !strings.Contains(arg, ":") {
+		return nil, false
+	}
+	fields := strings.SplitN(arg, ":", 3)
+	v := url.Values{}
+	v.Set("podName", fields[0])
+	if len(fields) == 3 {
+		v.Set("container", fields[1])
+	}
+	v.Set("path", fields[len(fields)-1])
+	return v, true
+}
+
+func (cli *HyperClient) copyFromPod(v url.Values, local string) error {
+	dest, err := filepath.Abs(local)
+	if err != nil {
+		return err
+	}
+	body, _, _, err := cli.clientRequest("GET", "/pod/archive?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return utils.ExtractLocalArchive(body, dest)
+}
+
+func (cli *HyperClient) copyToPod(local string, v url.Values) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(utils.TarPath(local, pw))
+	}()
+	defer pr.Close()
+
+	headers := map[string][]string{"Content-Type": {"application/x-tar"}}
+	body, _, _, err := cli.clientRequest("PUT", "/pod/archive?"+v.Encode(), pr, headers)
+	if err != nil {
+		return err
+	}
+	body.Close()
+	return nil
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper cron create|rm|ls|runs, running a fresh pod at each tick of a
+// schedule
+func (cli *HyperClient) HyperCmdCron(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "cron create|rm|ls|runs ...\n\nmanage the crons which run a fresh pod at each tick of a schedule"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	return fmt.Errorf("\"cron\" requires a subcommand, create, rm, ls or runs.\n")
+}
+
+func (cli *HyperClient) HyperCmdCronCreate(args ...string) error {
+	var opts struct {
+		Schedule    string `long:"schedule" value-name:"\"\"" description:"the cron expression, like \"0 2 * * *\" or @hourly"`
+		PodFile     string `short:"p" long:"podfile" value-name:"\"\"" description:"the pod file of the runs"`
+		Yaml        bool   `short:"y" long:"yaml" default:"false" default-mask:"-" description:"the pod file is Yaml"`
+		Name        string `long:"name" value-name:"\"\"" description:"the name of the cron, the one of the pod by default"`
+		Concurrency string `long:"concurrency" value-name:"allow" description:"what a tick does while a run is active: allow another run, forbid it, or replace the active one"`
+		History     int    `long:"history" value-name:"5" default-mask:"-" description:"the number of runs to keep with their pods"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "cron create --schedule SCHEDULE -p POD_FILE [OPTIONS]\n\ncreate and run a fresh pod of the pod file at each tick of the schedule"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if opts.Schedule == "" || opts.PodFile == "" {
+		return fmt.Errorf("\"cron create\" requires a schedule and a pod file.\n")
+	}
+
+	jsonbody, err := ioutil.ReadFile(opts.PodFile)
+	if err != nil {
+		return err
+	}
+	if opts.Yaml == true {
+		jsonbody, err = cli.ConvertYamlToJson(jsonbody)
+		if err != nil {
+			return err
+		}
+	}
+
+	v := url.Values{}
+	v.Set("podArgs", string(jsonbody))
+	v.Set("schedule", opts.Schedule)
+	v.Set("name", opts.Name)
+	v.Set("concurrency", opts.Concurrency)
+	if opts.History != 0 {
+		v.Set("history", strconv.Itoa(opts.History))
+	}
+	remoteInfo, err := cli.containerCall("/cron/create?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Cron ID is %s\n", remoteInfo.Get("ID"))
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdCronRm(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "cron rm CRON\n\nremove a cron, given by its name or id, the pods of its runs are left"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"cron rm\" requires the name or the id of the cron.\n")
+	}
+
+	v := url.Values{}
+	v.Set("name", args[2])
+	remoteInfo, err := cli.containerCall("/cron/remove?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Cron %s is removed\n", remoteInfo.Get("ID"))
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdCronLs(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "cron ls\n\nlist the crons with their next tick and the status of their last run"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	remoteInfo, err := cli.cronGet("/cron/list")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-16s %-15s %-15s %-12s %-20s %s\n", "ID", "Name", "Schedule", "Concurrency", "Next", "Last")
+	for _, c := range remoteInfo.GetList("cronData") {
+		fields := strings.SplitN(c, ":", 6)
+		if len(fields) < 6 {
+			continue
+		}
+		fmt.Printf("%-16s %-15s %-15s %-12s %-20s %s\n", fields[0], fields[1], fields[2], fields[3], unixTime(fields[4]), fields[5])
+	}
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdCronRuns(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "cron runs CRON\n\nlist the last runs of a cron with the exit codes of their containers"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"cron runs\" requires the name or the id of the cron.\n")
+	}
+
+	v := url.Values{}
+	v.Set("name", args[2])
+	remoteInfo, err := cli.cronGet("/cron/runs?" + v.Encode())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-30s %-20s %-20s %-10s %-10s %s\n", "Pod", "Scheduled", "Finished", "Status", "Exit", "Cause")
+	for _, r := range remoteInfo.GetList("runData") {
+		// the cause may have colons
+		fields := strings.SplitN(r, ":", 6)
+		if len(fields) < 6 {
+			continue
+		}
+		fmt.Printf("%-30s %-20s %-20s %-10s %-10s %s\n", fields[0], unixTime(fields[1]), unixTime(fields[2]), fields[3], fields[4], fields[5])
+	}
+	return nil
+}
+
+func (cli *HyperClient) cronGet(path string) (*engine.Env, error) {
+	body, _, err := readBody(cli.call("GET", path, nil, nil))
+	if err != nil {
+		return nil, err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return nil, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo, nil
+}
+
+// unixTime formats the unix time of the daemon, 0 is none
+func unixTime(s string) string {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+type event struct {
+	Time   int64  `json:"time"`
+	Type   string `json:"type"`
+	Action string `json:"action"`
+	ID     string `json:"id"`
+	Pod    string `json:"pod"`
+	Vm     string `json:"vm"`
+	Info   string `json:"info"`
+}
+
+// hyper events, streaming the lifecycle events of pods, VMs, containers,
+// execs and volumes
+func (cli *HyperClient) HyperCmdEvents(args ...string) error {
+	var opts struct {
+		Since  string   `long:"since" value-name:"\"\"" description:"show the events since the time, a unix time or a duration like 10m"`
+		Filter []string `short:"f" long:"filter" value-name:"[]" description:"show the events matching KEY=VALUE, the keys are type, action, id, pod and vm"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "events [--since TIME] [--filter KEY=VALUE ...]\n\nstream the lifecycle events of the daemon"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	v := url.Values{}
+	if opts.Since != "" {
+		since, err := parseSince(opts.Since)
+		if err != nil {
+			return err
+		}
+		v.Set("since", strconv.FormatInt(since, 10))
+	}
+	for _, f := range opts.Filter {
+		v.Add("filter", f)
+	}
+
+	body, _, _, err := cli.clientRequest("GET", "/events?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var ev event
+		if err := dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		fmt.Fprintln(cli.out, formatEvent(&ev))
+	}
+}
+
+// parseSince returns the unix time of a unix time or a duration before now
+func parseSince(since string) (int64, error) {
+	if t, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(since)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid since %q, should be a unix time or a duration", since)
+	}
+	return time.Now().Add(-d).Unix(), nil
+}
+
+func formatEvent(ev *event) string {
+	line := fmt.Sprintf("%s %s %s %s", time.Unix(0, ev.Time).Format(time.RFC3339Nano), ev.Type, ev.Action, ev.ID)
+	var attrs []string
+	if ev.Pod != "" && ev.Pod != ev.ID {
+		attrs = append(attrs, "pod="+ev.Pod)
+	}
+	if ev.Vm != "" && ev.Vm != ev.ID {
+		attrs = append(attrs, "vm="+ev.Vm)
+	}
+	if ev.Info != "" {
+		attrs = append(attrs, ev.Info)
+	}
+	if len(attrs) > 0 {
+		line += " (" + strings.Join(attrs, ", ") + ")"
+	}
+	return line
+}
@@ -14,8 +14,14 @@ import (
 
 func (cli *HyperClient) HyperCmdExec(args ...string) error {
 	var opts struct {
-		Attach bool `short:"a" long:"attach" default:"true" value-name:"false" description:"attach current terminal to the stdio of command"`
-		Vm     bool `long:"vm" default:"false" value-name:"false" description:"attach to vm"`
+		Attach  bool     `short:"a" long:"attach" default:"true" value-name:"false" description:"attach current terminal to the stdio of command"`
+		Vm      bool     `long:"vm" default:"false" value-name:"false" description:"attach to vm"`
+		Tty     bool     `short:"t" long:"tty" default:"false" value-name:"false" description:"allocate a pseudo-TTY for the command, implied when stdin is a terminal"`
+		Env     []string `short:"e" long:"env" value-name:"KEY=VALUE" description:"set environment variables for the command"`
+		Workdir string   `short:"w" long:"workdir" value-name:"DIR" description:"working directory of the command"`
+		User    string   `short:"u" long:"user" value-name:"USER" description:"run the command as USER (name or uid[:gid])"`
+
+		DetachKeys string `long:"detach-keys" value-name:"\"\"" description:"the keys to detach from the command, like ctrl-p,ctrl-q, ctrl-d by default"`
 	}
 	var parser = gflag.NewParser(&opts, gflag.Default|gflag.IgnoreUnknown)
 	parser.Usage = "exec [OPTIONS] POD|CONTAINER COMMAND [ARGS...]\n\nrun a command in a container of a running pod"
@@ -34,10 +40,9 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 		return fmt.Errorf("Can not accept the 'exec' command without command!")
 	}
 	var (
-		podName     = args[1]
-		hostname    = ""
-		tag         = cli.GetTag()
-		containerId string
+		podName  = args[1]
+		hostname = ""
+		tag      = cli.GetTag()
 	)
 	command, err := json.Marshal(args[2:])
 	if err != nil {
@@ -50,27 +55,35 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 		v.Set("type", "pod")
 		v.Set("value", podName)
 	} else {
-		if strings.Contains(podName, "pod-") {
-			hostname, err = cli.GetPodInfo(podName)
-			if err != nil {
-				return err
-			}
-			if hostname == "" {
-				return fmt.Errorf("The POD : %s does not exist, please create it before exec!", podName)
-			}
-			containerId, err = cli.GetContainerByPod(podName)
-			if err != nil {
-				return err
-			}
-			v.Set("type", "container")
-			v.Set("value", containerId)
-		} else {
-			v.Set("type", "container")
-			v.Set("value", podName)
-		}
+		// the daemon takes a container, or a pod by its name or id for its
+		// first container
+		v.Set("type", "container")
+		v.Set("value", podName)
 	}
 	v.Set("command", string(command))
 	v.Set("tag", tag)
+	if len(opts.Env) > 0 {
+		env, err := json.Marshal(opts.Env)
+		if err != nil {
+			return err
+		}
+		v.Set("env", string(env))
+	}
+	if opts.Workdir != "" {
+		v.Set("workdir", opts.Workdir)
+	}
+	if opts.User != "" {
+		v.Set("user", opts.User)
+	}
+	if opts.DetachKeys != "" {
+		v.Set("detachKeys", opts.DetachKeys)
+	}
+	tty := opts.Tty || (cli.isTerminalIn && cli.isTerminalOut)
+	if tty {
+		v.Set("tty", "yes")
+	} else {
+		v.Set("stream", "multiplexed")
+	}
 
 	var (
 		hijacked = make(chan io.Closer)
@@ -85,11 +98,13 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 	}()
 
 	errCh = promise.Go(func() error {
-		return cli.hijack("POST", "/exec?"+v.Encode(), true, cli.in, cli.out, cli.out, hijacked, nil, hostname)
+		return cli.hijack("POST", "/exec?"+v.Encode(), tty, cli.in, cli.out, cli.err, hijacked, nil, hostname)
 	})
 
-	if err := cli.monitorTtySize(podName, tag); err != nil {
-		fmt.Printf("Monitor tty size fail for %s!\n", podName)
+	if tty {
+		if err := cli.monitorTtySize(podName, tag); err != nil {
+			fmt.Printf("Monitor tty size fail for %s!\n", podName)
+		}
 	}
 
 	// Acknowledge the hijack before starting
@@ -112,9 +127,38 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 		return err
 	}
 	//fmt.Printf("Success to exec the command %s for POD %s!\n", command, podName)
+
+	code, err := cli.GetExitCode(tag)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return StatusError{StatusCode: code}
+	}
 	return nil
 }
 
+func (cli *HyperClient) GetExitCode(tag string) (int, error) {
+	v := url.Values{}
+	v.Set("tag", tag)
+	body, _, err := readBody(cli.call("GET", "/exitcode?"+v.Encode(), nil, nil))
+	if err != nil {
+		return -1, err
+	}
+
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return -1, err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return -1, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+	return remoteInfo.GetInt("ExitCode"), nil
+}
+
 func (cli *HyperClient) GetPodInfo(podName string) (string, error) {
 	// get the pod or container info before we start the exec
 	v := url.Values{}
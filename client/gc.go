@@ -0,0 +1,79 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper gc [--dry-run], remove the resources left by the VMs and the pods
+// which are gone, like after a crash of hyperd
+func (cli *HyperClient) HyperCmdGc(args ...string) error {
+	var opts struct {
+		DryRun bool `long:"dry-run" default:"false" value-name:"false" description:"only list the orphans, remove nothing"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "gc [--dry-run]\n\nremove the mounts, dirs, devices, containers, taps, port maps and records left by the VMs and pods which are gone, an orphan is removed once two gc in a row find it"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	v := url.Values{}
+	if opts.DryRun {
+		v.Set("dryRun", "yes")
+	}
+	body, _, err := readBody(cli.call("POST", "/gc?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	orphans := remoteInfo.GetList("Orphans")
+	removed := make(map[string]bool)
+	for _, o := range remoteInfo.GetList("Removed") {
+		removed[o] = true
+	}
+	if len(orphans) == 0 {
+		fmt.Printf("No orphan is found\n")
+	} else if opts.DryRun {
+		fmt.Printf("%d orphans are found:\n", len(orphans))
+	} else {
+		fmt.Printf("%d orphans are found, %d are removed:\n", len(orphans), len(removed))
+	}
+	for _, o := range orphans {
+		if removed[o] {
+			fmt.Printf("  %s (removed)\n", o)
+		} else {
+			fmt.Printf("  %s\n", o)
+		}
+	}
+	if !opts.DryRun && len(removed) < len(orphans) {
+		fmt.Printf("An orphan is only removed once two gc in a row find it, the others are left to the next gc\n")
+	}
+	errs := remoteInfo.GetList("Errors")
+	for _, e := range errs {
+		fmt.Printf("Error: %s\n", e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d orphans could not be removed or looked for\n", len(errs))
+	}
+	return nil
+}
@@ -17,11 +17,23 @@ Command:
   create                 create a pod into 'pending' status, but without running it
   replace                replace a running pod with a new one, the old one become 'pending'
   rm                     destroy a pod
+  restart                restart a container of a running pod, or all of them, in the same VM
   attach                 attach to the tty of a specified container in a pod
+  cp                     copy files between a container of a running pod and the local filesystem
+  port-forward           forward local ports to ports of a running pod
+  container              add a container to a running pod, or remove one from it
+  volume                 attach a volume to a container of a running pod, or detach it
+  network                create, remove or list the networks which pods can join
+  kernel                 add, remove or list the guest kernels which pods can choose
+  cron                   run a fresh pod at each tick of a schedule, and list the runs
 
   pull                   pull an image from a Docker registry server
   info                   display system-wide information
+  events                 stream the lifecycle events of pods, VMs and containers
+  sessions               list or play the recorded attach and exec sessions of a pod
   list                   list all pods or containers
+  inspect                show the spec, state, VM, network and volumes of a pod
+  gc                     remove the resources left by the VMs and pods which are gone
 
 Help Options:
   -h, --help             Show this help message
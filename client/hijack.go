@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"hyper/lib/promise"
+	"hyper/lib/stdcopy"
 	"hyper/lib/term"
 	"hyper/utils"
 )
@@ -98,7 +99,12 @@ func (cli *HyperClient) hijack(method, path string, setRawTerminal bool, in io.R
 				}
 			}()
 
-			_, err = io.Copy(stdout, br)
+			// without a tty, stdout and stderr come multiplexed
+			if setRawTerminal && stdout != nil {
+				_, err = io.Copy(stdout, br)
+			} else {
+				_, err = stdcopy.StdCopy(stdout, stderr, br)
+			}
 			// fmt.Printf("[hijack] End of stdout\n")
 			return err
 		})
@@ -42,6 +42,10 @@ func (cli *HyperClient) HyperCmdInfo(args ...string) error {
 	fmt.Printf("PODs: %d\n", remoteInfo.GetInt("Pods"))
 	memTotal := remoteInfo.GetInt("MemTotal")
 	fmt.Printf("Total Memory: %d KB\n", memTotal)
+	if remoteInfo.Exists("CommittedCpus") {
+		fmt.Printf("Committed vCPUs: %d/%d\n", remoteInfo.GetInt("CommittedCpus"), remoteInfo.GetInt("CpuCapacity"))
+		fmt.Printf("Committed Memory: %d/%d MB\n", remoteInfo.GetInt("CommittedMemory"), remoteInfo.GetInt("MemoryCapacity"))
+	}
 	fmt.Printf("Operating System: %s\n", remoteInfo.Get("Operating System"))
 
 	return nil
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper inspect [--format TEMPLATE] POD, print all the daemon knows about a
+// pod as JSON, or through a template
+func (cli *HyperClient) HyperCmdInspect(args ...string) error {
+	var opts struct {
+		Format string `short:"f" long:"format" value-name:"\"\"" description:"format the output with the Go template, like {{.status}} or {{json .vm}}"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "inspect [OPTIONS] POD\n\nshow the spec, the state, the VM, the network and the volumes of a pod, given by its name or a prefix of its id"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("\"inspect\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+
+	v := url.Values{}
+	v.Set("podName", args[1])
+	body, _, err := readBody(cli.call("GET", "/pod/inspect?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+
+	if opts.Format == "" {
+		var out bytes.Buffer
+		if err := json.Indent(&out, body, "", "    "); err != nil {
+			return err
+		}
+		out.WriteString("\n")
+		_, err := out.WriteTo(cli.out)
+		return err
+	}
+
+	tmpl, err := template.New("").Funcs(funcMap).Parse(opts.Format)
+	if err != nil {
+		return fmt.Errorf("Template parsing error: %v\n", err)
+	}
+	// the fields are the ones of the JSON, the numbers are kept as they are
+	var info map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&info); err != nil {
+		return err
+	}
+	if err := tmpl.Execute(cli.out, info); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "\n")
+	return nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper kernel add|rm|ls, managing the catalog of guest kernels pods choose
+func (cli *HyperClient) HyperCmdKernel(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "kernel add|rm|ls ...\n\nmanage the guest kernels which pods can choose"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	return fmt.Errorf("\"kernel\" requires a subcommand, add, rm or ls.\n")
+}
+
+func (cli *HyperClient) HyperCmdKernelAdd(args ...string) error {
+	var opts struct {
+		Kernel string `long:"kernel" value-name:"\"\"" description:"the kernel image to add"`
+		Initrd string `long:"initrd" value-name:"\"\"" description:"the initrd of the kernel, the one of the daemon by default"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "kernel add NAME --kernel KERNEL [--initrd INITRD]\n\nadd a kernel to the catalog, the files are copied by the daemon"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 || opts.Kernel == "" {
+		return fmt.Errorf("\"kernel add\" requires a name and the kernel.\n")
+	}
+
+	v := url.Values{}
+	v.Set("name", args[2])
+	// the daemon runs on the same host, it needs the absolute paths
+	kernel, err := filepath.Abs(opts.Kernel)
+	if err != nil {
+		return err
+	}
+	v.Set("kernel", kernel)
+	if opts.Initrd != "" {
+		initrd, err := filepath.Abs(opts.Initrd)
+		if err != nil {
+			return err
+		}
+		v.Set("initrd", initrd)
+	}
+	remoteInfo, err := cli.containerCall("/kernel/add?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Kernel %s is added\n", remoteInfo.Get("ID"))
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdKernelRm(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "kernel rm NAME\n\nremove a kernel which no pod chooses"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"kernel rm\" requires the name of the kernel.\n")
+	}
+
+	v := url.Values{}
+	v.Set("name", args[2])
+	remoteInfo, err := cli.containerCall("/kernel/remove?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Kernel %s is removed\n", remoteInfo.Get("ID"))
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdKernelLs(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "kernel ls\n\nlist the kernels of the catalog"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	body, _, err := readBody(cli.call("GET", "/kernel/list", nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	fmt.Printf("%-15s %-45s %s\n", "Name", "Kernel", "Initrd")
+	for _, k := range remoteInfo.GetList("kernelData") {
+		fields := strings.Split(k, ":")
+		initrd := fields[2]
+		if initrd == "" {
+			initrd = "(default)"
+		}
+		fmt.Printf("%-15s %-45s %s\n", fields[0], fields[1], initrd)
+	}
+	return nil
+}
@@ -81,22 +81,30 @@ func (cli *HyperClient) HyperCmdList(args ...string) error {
 	}
 
 	if item == "pod" {
-		fmt.Printf("%15s%30s%20s%10s\n", "POD ID", "POD Name", "VM name", "Status")
+		fmt.Printf("%15s%30s%20s%18s%10s\n", "POD ID", "POD Name", "VM name", "Status", "Restarts")
 		for _, p := range podResponse {
 			fields := strings.Split(p, ":")
 			var podName = fields[1]
 			if len(fields[1]) > 27 {
 				podName = fields[1][:27]
 			}
-			fmt.Printf("%15s%30s%20s%10s\n", fields[0], podName, fields[2], fields[3])
+			restarts := "0"
+			if len(fields) > 4 {
+				restarts = fields[4]
+			}
+			fmt.Printf("%15s%30s%20s%18s%10s\n", fields[0], podName, fields[2], fields[3], restarts)
 		}
 	}
 
 	if item == "container" {
-		fmt.Printf("%-66s%15s%10s\n", "Container ID", "POD ID", "Status")
+		fmt.Printf("%-66s%15s%10s%10s\n", "Container ID", "POD ID", "Status", "Restarts")
 		for _, c := range containerResponse {
 			fields := strings.Split(c, ":")
-			fmt.Printf("%-66s%15s%10s\n", fields[0], fields[1], fields[2])
+			restarts := "0"
+			if len(fields) > 3 {
+				restarts = fields[3]
+			}
+			fmt.Printf("%-66s%15s%10s%10s\n", fields[0], fields[1], fields[2], restarts)
 		}
 	}
 	return nil
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper network create|rm|ls, managing the networks pods can join
+func (cli *HyperClient) HyperCmdNetwork(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "network create|rm|ls ...\n\nmanage the networks which pods can join"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	return fmt.Errorf("\"network\" requires a subcommand, create, rm or ls.\n")
+}
+
+func (cli *HyperClient) HyperCmdNetworkCreate(args ...string) error {
+	var opts struct {
+		Subnet string `long:"subnet" value-name:"\"\"" description:"the subnet of the network, in CIDR format"`
+		Bridge string `long:"bridge" value-name:"\"\"" description:"the bridge of the network, hyper-NAME by default"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "network create NAME --subnet SUBNET [--bridge BRIDGE]\n\ncreate a network, pods on different networks are isolated"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 || opts.Subnet == "" {
+		return fmt.Errorf("\"network create\" requires a name and the subnet.\n")
+	}
+
+	v := url.Values{}
+	v.Set("name", args[2])
+	v.Set("subnet", opts.Subnet)
+	v.Set("bridge", opts.Bridge)
+	remoteInfo, err := cli.containerCall("/network/create?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Network %s is created\n", remoteInfo.Get("ID"))
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdNetworkRm(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "network rm NAME\n\nremove a network which no pod uses"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"network rm\" requires the name of the network.\n")
+	}
+
+	v := url.Values{}
+	v.Set("name", args[2])
+	remoteInfo, err := cli.containerCall("/network/remove?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Network %s is removed\n", remoteInfo.Get("ID"))
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdNetworkLs(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "network ls\n\nlist all the networks"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	body, _, err := readBody(cli.call("GET", "/network/list", nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	fmt.Printf("%15s%20s%20s\n", "Name", "Bridge", "Subnet")
+	for _, n := range remoteInfo.GetList("networkData") {
+		fields := strings.Split(n, ":")
+		fmt.Printf("%15s%20s%20s\n", fields[0], fields[1], fields[2])
+	}
+	return nil
+}
@@ -92,7 +92,7 @@ func (cli *HyperClient) HyperCmdStart(args ...string) error {
 		Mem int `short:"m" long:"memory" default:"128" value-name:"128" description:"Memory size (MB) for the VM"`
 	}
 	var parser = gflag.NewParser(&opts, gflag.Default)
-	parser.Usage = "start [-c 1 -m 128]| POD_ID \n\nlaunch a 'pending' pod"
+	parser.Usage = "start [-c 1 -m 128]| POD \n\nlaunch a 'pending' pod, given by its name or a prefix of its id"
 	args, err := parser.Parse()
 	if err != nil {
 		if !strings.Contains(err.Error(), "Usage") {
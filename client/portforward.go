@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper port-forward POD [LOCAL:]REMOTE...
+func (cli *HyperClient) HyperCmdPortForward(args ...string) error {
+	var opts struct {
+		Address string `long:"address" default:"127.0.0.1" value-name:"127.0.0.1" description:"local address to listen on"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "port-forward [OPTIONS] POD [LOCAL:]REMOTE...\n\nforward local ports to ports of a running pod, through the hyper daemon"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"port-forward\" requires a pod and at least one port mapping.")
+	}
+
+	var (
+		podName   = args[1]
+		listeners = []net.Listener{}
+		errCh     = make(chan error, len(args)-2)
+	)
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	for _, mapping := range args[2:] {
+		local, remote, err := parsePortMapping(mapping)
+		if err != nil {
+			return err
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(opts.Address, strconv.Itoa(local)))
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+		fmt.Printf("Forwarding from %s -> %d\n", l.Addr().String(), remote)
+
+		go func(l net.Listener, remote int) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					errCh <- err
+					return
+				}
+				go cli.forwardConn(conn, podName, remote)
+			}
+		}(l, remote)
+	}
+
+	return <-errCh
+}
+
+func parsePortMapping(mapping string) (int, int, error) {
+	ports := strings.SplitN(mapping, ":", 2)
+	remote, err := strconv.Atoi(ports[len(ports)-1])
+	if err != nil || remote <= 0 || remote > 65535 {
+		return -1, -1, fmt.Errorf("Invalid port mapping %s", mapping)
+	}
+	local := remote
+	if len(ports) == 2 {
+		local, err = strconv.Atoi(ports[0])
+		if err != nil || local < 0 || local > 65535 {
+			return -1, -1, fmt.Errorf("Invalid port mapping %s", mapping)
+		}
+	}
+	return local, remote, nil
+}
+
+func (cli *HyperClient) forwardConn(conn net.Conn, podName string, remote int) {
+	defer conn.Close()
+
+	v := url.Values{}
+	v.Set("podName", podName)
+	v.Set("port", strconv.Itoa(remote))
+	v.Set("tag", cli.GetTag())
+
+	// the stream is multiplexed, what the pod writes to stderr is an error
+	// message of the connector
+	err := cli.hijack("POST", "/pod/portforward?"+v.Encode(), false, conn, conn, cli.err, nil, nil, "")
+	if err != nil {
+		fmt.Fprintf(cli.err, "Error forwarding %s to port %d: %s\n", conn.RemoteAddr().String(), remote, err.Error())
+	}
+}
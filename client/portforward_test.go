@@ -0,0 +1,36 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	for _, c := range []struct {
+		mapping string
+		local   int
+		remote  int
+		valid   bool
+	}{
+		{"8080", 8080, 8080, true},
+		{"9090:80", 9090, 80, true},
+		// any free local port
+		{"0:80", 0, 80, true},
+		{"a:80", 0, 0, false},
+		{"80:a", 0, 0, false},
+		{"70000", 0, 0, false},
+		{"70000:80", 0, 0, false},
+		{"0", 0, 0, false},
+		{"", 0, 0, false},
+	} {
+		local, remote, err := parsePortMapping(c.mapping)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%q is parsed as %d:%d, it is invalid", c.mapping, local, remote)
+			}
+			continue
+		}
+		if err != nil || local != c.local || remote != c.remote {
+			t.Errorf("%q is parsed as %d:%d %v, should be %d:%d", c.mapping, local, remote, err, c.local, c.remote)
+		}
+	}
+}
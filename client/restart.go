@@ -0,0 +1,43 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper restart POD [CONTAINER], restart the containers of a running pod in
+// place, the pod keeps its VM, network and volumes
+func (cli *HyperClient) HyperCmdRestart(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "restart POD_ID [CONTAINER]\n\nrestart a container of a running pod, or all of its containers, in the same VM"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("\"restart\" requires a minimum of 1 argument, please provide POD ID.\n")
+	}
+	podId := args[1]
+
+	v := url.Values{}
+	v.Set("podId", podId)
+	if len(args) > 2 {
+		v.Set("container", args[2])
+	}
+	if _, err := cli.containerCall("/container/restart?" + v.Encode()); err != nil {
+		return err
+	}
+	if len(args) > 2 {
+		fmt.Printf("Container %s of pod %s is restarted\n", args[2], podId)
+	} else {
+		fmt.Printf("The containers of pod %s are restarted\n", podId)
+	}
+	return nil
+}
@@ -12,7 +12,7 @@ import (
 
 func (cli *HyperClient) HyperCmdRm(args ...string) error {
 	var parser = gflag.NewParser(nil, gflag.Default)
-	parser.Usage = "rm POD_ID\n\ndestroy a pod"
+	parser.Usage = "rm POD\n\ndestroy a pod, given by its name or a prefix of its id"
 	args, err := parser.Parse()
 	if err != nil {
 		if !strings.Contains(err.Error(), "Usage") {
@@ -195,6 +195,7 @@ func (cli *HyperClient) HyperCmdRun(args ...string) error {
 	v.Set("type", "container")
 	v.Set("value", containerId)
 	v.Set("tag", tag)
+	v.Set("tty", "yes")
 
 	// Block the return until the chan gets closed
 	defer func() {
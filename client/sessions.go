@@ -0,0 +1,158 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper sessions ls|play, the recorded attach and exec sessions of pods
+func (cli *HyperClient) HyperCmdSessions(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "sessions ls|play ...\n\nlist or play the recorded attach and exec sessions"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	return fmt.Errorf("\"sessions\" requires a subcommand, ls or play.\n")
+}
+
+func (cli *HyperClient) HyperCmdSessionsLs(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "sessions ls POD_ID\n\nlist the recorded sessions of a pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"sessions ls\" requires the ID of the pod.\n")
+	}
+
+	v := url.Values{}
+	v.Set("pod", args[2])
+	body, _, err := readBody(cli.call("GET", "/session/list?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	fmt.Printf("%-20s %-20s %10s  %s\n", "Session ID", "Started", "Size", "Command")
+	for _, s := range remoteInfo.GetList("sessionData") {
+		fields := strings.SplitN(s, ":", 4)
+		if len(fields) < 4 {
+			continue
+		}
+		started, _ := strconv.ParseInt(fields[1], 10, 64)
+		fmt.Printf("%-20s %-20s %10s  %s\n", fields[0], time.Unix(started, 0).Format("2006-01-02 15:04:05"), fields[2], fields[3])
+	}
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdSessionsPlay(args ...string) error {
+	var opts struct {
+		Speed float64 `short:"s" long:"speed" default:"1" value-name:"1" description:"play the session at the speed"`
+		Raw   bool    `long:"raw" default:"false" value-name:"false" description:"print the recording in the asciicast format"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "sessions play [OPTIONS] SESSION_ID\n\nreplay the output of a recorded session"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"sessions play\" requires the ID of the session.\n")
+	}
+	if opts.Speed <= 0 {
+		return fmt.Errorf("The speed should be positive.\n")
+	}
+
+	v := url.Values{}
+	v.Set("id", args[2])
+	body, _, _, err := cli.clientRequest("GET", "/session/play?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if opts.Raw {
+		_, err := io.Copy(cli.out, body)
+		return err
+	}
+	return playCast(body, cli.out, opts.Speed)
+}
+
+// playCast writes the output events of an asciicast v2 recording at their
+// time, the input and the window size changes are skipped
+func playCast(in io.Reader, out io.Writer, speed float64) error {
+	reader := bufio.NewReader(in)
+	// the header, the size of the recorded terminal is not restored
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		return fmt.Errorf("Got a broken recording: %s", err.Error())
+	}
+
+	start := time.Now()
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var (
+			ev      []interface{}
+			elapsed float64
+			kind    string
+			data    string
+			ok      bool
+		)
+		if err := json.Unmarshal(line, &ev); err != nil || len(ev) != 3 {
+			return fmt.Errorf("Got a broken event: %s", strings.TrimSpace(string(line)))
+		}
+		if elapsed, ok = ev[0].(float64); !ok {
+			continue
+		}
+		if kind, ok = ev[1].(string); !ok || kind != "o" {
+			continue
+		}
+		if data, ok = ev[2].(string); !ok {
+			continue
+		}
+		at := start.Add(time.Duration(elapsed / speed * float64(time.Second)))
+		if wait := at.Sub(time.Now()); wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := io.WriteString(out, data); err != nil {
+			return err
+		}
+	}
+}
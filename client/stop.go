@@ -17,7 +17,7 @@ func (cli *HyperClient) HyperCmdStop(args ...string) error {
 		//		Novm        bool     `long:"onlypod" default:"false" value-name:"false" description:"Stop a Pod, but left the VM running"`
 	}
 	var parser = gflag.NewParser(&opts, gflag.Default)
-	parser.Usage = "stop POD_ID\n\nstop a running pod"
+	parser.Usage = "stop POD\n\nstop a running pod, given by its name or a prefix of its id"
 	args, err := parser.Parse()
 	if err != nil {
 		if !strings.Contains(err.Error(), "Usage") {
@@ -2,6 +2,7 @@ package client
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 
 	gflag "github.com/jessevdk/go-flags"
@@ -54,3 +55,43 @@ func (cli *HyperClient) HyperCmdVm(args ...string) error {
 	fmt.Printf("New VM id is %s\n", remoteInfo.Get("ID"))
 	return nil
 }
+
+// hyper vm trace VM_ID, showing the recent events and state transitions of
+// a VM
+func (cli *HyperClient) HyperCmdVmTrace(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "vm trace VM_ID\n\nshow the recent events and state transitions of a VM"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"vm trace\" requires the ID of the VM.\n")
+	}
+
+	v := url.Values{}
+	v.Set("vm", args[2])
+	body, _, err := readBody(cli.call("GET", "/vm/trace?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	for _, line := range remoteInfo.GetList("traceData") {
+		fmt.Println(line)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// hyper volume attach|detach, changing the volumes of a running pod
+func (cli *HyperClient) HyperCmdVolume(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "volume attach|detach POD VOLUME ...\n\nattach a volume to a container of a running pod, or detach it"
+	_, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	return fmt.Errorf("\"volume\" requires a subcommand, attach or detach.\n")
+}
+
+func (cli *HyperClient) HyperCmdVolumeAttach(args ...string) error {
+	var opts struct {
+		Container string `short:"c" long:"container" value-name:"\"\"" description:"the container to mount the volume to"`
+		Path      string `short:"p" long:"path" value-name:"\"\"" description:"the mount point in the container"`
+		ReadOnly  bool   `long:"ro" default:"false" description:"mount the volume read only"`
+		Source    string `short:"s" long:"source" value-name:"\"\"" description:"the source of a volume the pod does not have yet"`
+		Driver    string `short:"d" long:"driver" value-name:"\"\"" description:"the driver of a volume the pod does not have yet, vfs, raw or qcow2"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "volume attach POD VOLUME --container CONTAINER --path PATH [--ro]\n\nmount a volume to a path of a container in a running pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 4 || opts.Container == "" || opts.Path == "" {
+		return fmt.Errorf("\"volume attach\" requires a pod, a volume, the container and the path.\n")
+	}
+	podId := args[2]
+
+	v := url.Values{}
+	v.Set("podId", podId)
+	v.Set("volume", args[3])
+	v.Set("container", opts.Container)
+	v.Set("path", opts.Path)
+	if opts.ReadOnly {
+		v.Set("readonly", "yes")
+	}
+	v.Set("source", opts.Source)
+	v.Set("driver", opts.Driver)
+	remoteInfo, err := cli.containerCall("/volume/attach?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s is attached to %s of container %s\n", remoteInfo.Get("ID"), opts.Path, opts.Container)
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdVolumeDetach(args ...string) error {
+	var opts struct {
+		Container string `short:"c" long:"container" value-name:"\"\"" description:"the container to unmount the volume from"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "volume detach POD VOLUME --container CONTAINER\n\nunmount a volume from a container in a running pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 4 || opts.Container == "" {
+		return fmt.Errorf("\"volume detach\" requires a pod, a volume and the container.\n")
+	}
+
+	v := url.Values{}
+	v.Set("podId", args[2])
+	v.Set("volume", args[3])
+	v.Set("container", opts.Container)
+	remoteInfo, err := cli.containerCall("/volume/detach?" + v.Encode())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s is detached from container %s\n", remoteInfo.Get("ID"), opts.Container)
+	return nil
+}
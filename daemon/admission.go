@@ -0,0 +1,138 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+
+	"hyper/engine"
+	"hyper/lib/sysinfo"
+	"hyper/types"
+)
+
+const (
+	// the VM of a pod which sets no resources
+	defaultVmCpu = 1
+	defaultVmMem = 128
+
+	// the config of the admission unless set by the config file
+	defaultReservedCpus     = 0
+	defaultReservedMemory   = 512 //MB kept for the host
+	defaultCpuOvercommit    = 4.0
+	defaultMemoryOvercommit = 1.0
+)
+
+// vmResources are the vCPUs and the memory in MB committed to a VM
+type vmResources struct {
+	Cpu int
+	Mem int
+}
+
+// admission tracks the resources committed to the VMs. A VM is admitted
+// before it is launched, as it shows up in the VM list only once booted,
+// and its resources are released when it is removed from the list.
+type admission struct {
+	sync.Mutex
+	vms map[string]vmResources
+}
+
+func newAdmission() *admission {
+	return &admission{
+		vms: make(map[string]vmResources),
+	}
+}
+
+// committed returns the resources committed to all VMs, the caller must
+// hold the lock
+func (a *admission) committed() vmResources {
+	total := vmResources{}
+	for _, r := range a.vms {
+		total.Cpu += r.Cpu
+		total.Mem += r.Mem
+	}
+	return total
+}
+
+// admit commits the resources to a VM if they fit in the capacity along
+// with those committed already
+func (a *admission) admit(vmId string, r, capacity vmResources) error {
+	a.Lock()
+	defer a.Unlock()
+	used := a.committed()
+	if old, ok := a.vms[vmId]; ok {
+		used.Cpu -= old.Cpu
+		used.Mem -= old.Mem
+	}
+	if used.Cpu+r.Cpu > capacity.Cpu || used.Mem+r.Mem > capacity.Mem {
+		return fmt.Errorf("Not enough resources on the host for VM %s of %d vCPUs and %d MB, %d/%d vCPUs and %d/%d MB are committed",
+			vmId, r.Cpu, r.Mem, used.Cpu, capacity.Cpu, used.Mem, capacity.Mem)
+	}
+	a.vms[vmId] = r
+	return nil
+}
+
+// hostCapacity returns the vCPUs and the memory the VMs could commit, that
+// is the host resources less the reserve, times the overcommit ratios
+func hostCapacity(cfg *daemonConfig) (vmResources, error) {
+	meminfo, err := sysinfo.GetMemInfo()
+	if err != nil {
+		return vmResources{}, err
+	}
+	cpus := sysinfo.GetCpuNum() - cfg.ReservedCpus
+	mem := int(meminfo.MemTotal/1024) - cfg.ReservedMemory
+	return vmResources{
+		Cpu: int(float64(cpus) * cfg.CpuOvercommit),
+		Mem: int(float64(mem) * cfg.MemoryOvercommit),
+	}, nil
+}
+
+// admitVm commits the resources to a VM about to be launched, it returns
+// E_NO_RESOURCE if the host could not take them
+func (daemon *Daemon) admitVm(vmId string, cpu, mem int) (int, string, error) {
+	capacity, err := hostCapacity(daemon.config())
+	if err != nil {
+		return -1, "", err
+	}
+	if err := daemon.admission.admit(vmId, vmResources{cpu, mem}, capacity); err != nil {
+		return types.E_NO_RESOURCE, err.Error(), err
+	}
+	return types.E_OK, "", nil
+}
+
+// commitVm records the resources of a VM added to the VM list, the VMs
+// which were not admitted, like the ones associated again when hyperd
+// starts, are counted as they are
+func (daemon *Daemon) commitVm(vm *Vm) {
+	daemon.admission.Lock()
+	defer daemon.admission.Unlock()
+	if _, ok := daemon.admission.vms[vm.Id]; ok {
+		return
+	}
+	r := vmResources{vm.Cpu, vm.Mem}
+	if r.Cpu == 0 {
+		r.Cpu = defaultVmCpu
+	}
+	if r.Mem == 0 {
+		r.Mem = defaultVmMem
+	}
+	daemon.admission.vms[vm.Id] = r
+}
+
+func (daemon *Daemon) releaseVm(vmId string) {
+	daemon.admission.Lock()
+	delete(daemon.admission.vms, vmId)
+	daemon.admission.Unlock()
+}
+
+// setCommitted adds the committed resources and the capacity of the host
+// to the info of the daemon
+func (daemon *Daemon) setCommitted(v *engine.Env) {
+	daemon.admission.Lock()
+	used := daemon.admission.committed()
+	daemon.admission.Unlock()
+	v.SetInt("CommittedCpus", used.Cpu)
+	v.SetInt("CommittedMemory", used.Mem)
+	if capacity, err := hostCapacity(daemon.config()); err == nil {
+		v.SetInt("CpuCapacity", capacity.Cpu)
+		v.SetInt("MemoryCapacity", capacity.Mem)
+	}
+}
@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"testing"
+)
+
+func TestAdmit(t *testing.T) {
+	a := newAdmission()
+	capacity := vmResources{Cpu: 4, Mem: 1024}
+
+	if err := a.admit("vm-a", vmResources{2, 512}, capacity); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.admit("vm-b", vmResources{2, 768}, capacity); err == nil {
+		t.Error("a VM over the memory capacity is admitted")
+	}
+	if err := a.admit("vm-b", vmResources{3, 256}, capacity); err == nil {
+		t.Error("a VM over the cpu capacity is admitted")
+	}
+	if err := a.admit("vm-b", vmResources{2, 512}, capacity); err != nil {
+		t.Errorf("a VM fitting in the rest is rejected: %s", err.Error())
+	}
+	// admitting a VM again replaces its resources
+	if err := a.admit("vm-b", vmResources{1, 256}, capacity); err != nil {
+		t.Errorf("a VM is rejected by its own resources: %s", err.Error())
+	}
+	if used := a.committed(); used != (vmResources{3, 768}) {
+		t.Errorf("committed %+v, should be 3 vCPUs and 768 MB", used)
+	}
+}
+
+func TestCommitVm(t *testing.T) {
+	daemon := &Daemon{registry: newRegistry(), admission: newAdmission()}
+
+	// a VM associated again counts with the defaults if its pod sets none
+	daemon.AddVm(&Vm{Id: "vm-a"})
+	daemon.AddVm(&Vm{Id: "vm-b", Cpu: 2, Mem: 256})
+	if used := daemon.admission.committed(); used != (vmResources{defaultVmCpu + 2, defaultVmMem + 256}) {
+		t.Errorf("committed %+v after adding the VMs", used)
+	}
+	daemon.RemoveVm("vm-a")
+	daemon.RemoveVm("vm-b")
+	if used := daemon.admission.committed(); used != (vmResources{}) {
+		t.Errorf("committed %+v after removing the VMs", used)
+	}
+}
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"path"
+
+	"hyper/engine"
+	"hyper/hypervisor"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+	"hyper/utils"
+)
+
+// CmdPodArchive copies a path of a container in a running pod as a tar
+// stream. With "get" the archive is written to Stdout, with "put" the one
+// read from Stdin is extracted to the path.
+func (daemon *Daemon) CmdPodArchive(job *engine.Job) error {
+	if len(job.Args) < 4 {
+		return fmt.Errorf("Can not copy files without pod, container, path and direction!")
+	}
+	var (
+		podName   = job.Args[0]
+		cName     = job.Args[1]
+		cPath     = job.Args[2]
+		direction = job.Args[3]
+	)
+	if direction != "get" && direction != "put" {
+		return fmt.Errorf("Unknown direction of copy: %s", direction)
+	}
+
+	mypod := daemon.registry.Pod(podName)
+	if mypod == nil {
+		return fmt.Errorf("Can not find the POD instance of %s", podName)
+	}
+	if mypod.Status() != types.S_POD_RUNNING || mypod.Vm() == "" {
+		return fmt.Errorf("The POD %s is not running", podName)
+	}
+
+	var container *Container
+	for _, c := range mypod.Containers() {
+		if cName == "" || c.Id == cName || c.Name == cName {
+			container = c
+			break
+		}
+	}
+	if container == nil {
+		return fmt.Errorf("Can not find container %s in POD %s", cName, podName)
+	}
+
+	glog.V(1).Infof("%s %s of container %s in pod %s", direction, cPath, container.Id, podName)
+	storageDriver := daemon.Storage.StorageType
+	if storageDriver == "aufs" || storageDriver == "overlay" {
+		// the rootfs is mounted in the share dir of the VM on the host
+		root := path.Join(hypervisor.BaseDir, mypod.Vm(), hypervisor.ShareDirTag, container.Id, "rootfs")
+		if direction == "get" {
+			src, err := utils.ScopedPath(root, cPath)
+			if err != nil {
+				return err
+			}
+			return utils.TarPath(src, job.Stdout)
+		}
+		return utils.ExtractArchive(job.Stdin, root, cPath)
+	}
+
+	return daemon.transferFile(mypod.Vm(), container.Id, cPath, direction, job.Stdin, job.Stdout)
+}
+
+// transferFile asks the init of the VM to pack or unpack the archive, for
+// the rootfs which is not reachable from the host.
+func (daemon *Daemon) transferFile(vmId, container, cPath, direction string, in io.ReadCloser, out io.WriteCloser) error {
+	callback := make(chan *types.QemuResponse, 1)
+	cmd := &hypervisor.FileTransferCommand{
+		Container: container,
+		Path:      cPath,
+		Streams: &hypervisor.TtyIO{
+			ClientTag: pod.RandStr(8, "alphanum"),
+			Callback:  callback,
+		},
+	}
+	if direction == "get" {
+		cmd.Direction = "out"
+		cmd.Streams.Stdout = out
+	} else {
+		cmd.Direction = "in"
+		cmd.Streams.Stdin = in
+	}
+
+	qemuChan, err := daemon.GetQemuChan(vmId)
+	if err != nil {
+		return err
+	}
+	qemuChan.Event <- cmd
+
+	res := <-callback
+	if res.Code != types.E_EXEC_FINISH {
+		return fmt.Errorf("Fail to copy %s: %s", cPath, res.Cause)
+	}
+	if code, ok := res.Data.(int); ok && code != 0 {
+		return fmt.Errorf("Fail to copy %s, init returned %d", cPath, code)
+	}
+	return nil
+}
@@ -5,9 +5,30 @@ import (
 	"hyper/engine"
 	"hyper/hypervisor"
 	"hyper/lib/glog"
+	"hyper/lib/stdcopy"
 	"hyper/types"
+	"io"
 )
 
+type muxWriter struct {
+	io.Writer
+	closer io.Closer
+}
+
+func (w *muxWriter) Close() error {
+	if w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
+
+// multiplexStreams frames stdout and stderr of a client without tty which
+// asks for it on its single stream, only the stdout side closes the stream.
+func multiplexStreams(out io.WriteCloser) (io.WriteCloser, io.WriteCloser) {
+	return &muxWriter{stdcopy.NewStdWriter(out, stdcopy.Stdout), out},
+		&muxWriter{stdcopy.NewStdWriter(out, stdcopy.Stderr), nil}
+}
+
 func (daemon *Daemon) CmdAttach(job *engine.Job) (err error) {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not execute 'attach' command without any container/pod ID!")
@@ -22,13 +43,12 @@ func (daemon *Daemon) CmdAttach(job *engine.Job) (err error) {
 
 	// We need find the vm id which running POD, and stop it
 	if typeKey == "pod" {
-		podName = typeVal
+		podName, err = daemon.ResolvePod(typeVal)
 	} else {
-		container := typeVal
-		podName, err = daemon.GetPodByContainer(container)
-		if err != nil {
-			return
-		}
+		typeVal, podName, err = daemon.resolveContainer(typeVal)
+	}
+	if err != nil {
+		return
 	}
 	vmid, err := daemon.GetPodVmByName(podName)
 	if err != nil {
@@ -41,8 +61,19 @@ func (daemon *Daemon) CmdAttach(job *engine.Job) (err error) {
 
 	ttyIO.Stdin = job.Stdin
 	ttyIO.Stdout = job.Stdout
+	if job.GetenvBool("multiplexed") {
+		ttyIO.Stdout, ttyIO.Stderr = multiplexStreams(job.Stdout)
+	}
 	ttyIO.ClientTag = tag
 	ttyIO.Callback = qemuCallback
+	ttyIO.ReadOnly = job.GetenvBool("readonly")
+	if ttyIO.DetachKeys, err = hypervisor.ParseDetachKeys(job.Getenv("detachKeys")); err != nil {
+		return err
+	}
+	ttyIO.Recorder, err = daemon.sessionRecorder(podName, "attach "+typeVal)
+	if err != nil {
+		return err
+	}
 
 	var attachCommand = &hypervisor.AttachCommand{
 		Streams: &ttyIO,
@@ -53,11 +84,11 @@ func (daemon *Daemon) CmdAttach(job *engine.Job) (err error) {
 	} else {
 		attachCommand.Container = typeVal
 	}
-	qemuEvent, _, _, err := daemon.GetQemuChan(vmid)
+	qemuChan, err := daemon.GetQemuChan(vmid)
 	if err != nil {
 		return err
 	}
-	qemuEvent.(chan hypervisor.VmEvent) <- attachCommand
+	qemuChan.Event <- attachCommand
 
 	<-qemuCallback
 	defer func() {
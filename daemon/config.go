@@ -0,0 +1,315 @@
+package daemon
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/lib/portallocator"
+	"hyper/network"
+	apiserver "hyper/server"
+
+	"github.com/Unknwon/goconfig"
+)
+
+// daemonConfig holds the settings of the config file of hyperd. It is not
+// changed once loaded, a reload replaces it as a whole.
+type daemonConfig struct {
+	Kernel         string
+	Initrd         string
+	Bios           string
+	Cbfs           string
+	Bridge         string
+	BridgeIP       string
+	Host           string
+	BootTimeout    int    //seconds to wait for a VM to boot, 0 for the default
+	BootRetries    int    //fresh VMs to try after a VM failed to boot
+	RecordSessions bool   //record the attach and exec sessions of all pods
+	LogLevel       string //level of the V logs, empty leaves the --v flag alone
+	PortRange      string //BEGIN-END of the host ports given out, empty for the system range
+	IPRange        string //subnet of the bridge network given to pods, empty for all of it
+
+	// the host resources kept out of the VMs, and the ratios the rest is
+	// overcommitted by
+	ReservedCpus     int
+	ReservedMemory   int //MB
+	CpuOvercommit    float64
+	MemoryOvercommit float64
+}
+
+func loadConfig(file string) (*daemonConfig, error) {
+	cfg, err := goconfig.LoadConfigFile(file)
+	if err != nil {
+		return nil, err
+	}
+	get := func(key string) string {
+		v, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, key)
+		return v
+	}
+	c := &daemonConfig{
+		Kernel:    get("Kernel"),
+		Initrd:    get("Initrd"),
+		Bios:      get("Bios"),
+		Cbfs:      get("Cbfs"),
+		Bridge:    get("Bridge"),
+		BridgeIP:  get("BridgeIP"),
+		Host:      get("Host"),
+		LogLevel:  get("LogLevel"),
+		PortRange: get("PortRange"),
+		IPRange:   get("IPRange"),
+
+		ReservedCpus:     defaultReservedCpus,
+		ReservedMemory:   defaultReservedMemory,
+		CpuOvercommit:    defaultCpuOvercommit,
+		MemoryOvercommit: defaultMemoryOvercommit,
+	}
+	if v := get("BootTimeout"); v != "" {
+		if c.BootTimeout, err = strconv.Atoi(v); err != nil || c.BootTimeout < 0 {
+			return nil, fmt.Errorf("Invalid BootTimeout %s in the config", v)
+		}
+	}
+	if v := get("BootRetries"); v != "" {
+		if c.BootRetries, err = strconv.Atoi(v); err != nil || c.BootRetries < 0 {
+			return nil, fmt.Errorf("Invalid BootRetries %s in the config", v)
+		}
+	}
+	if v := get("ReservedCpus"); v != "" {
+		if c.ReservedCpus, err = strconv.Atoi(v); err != nil || c.ReservedCpus < 0 {
+			return nil, fmt.Errorf("Invalid ReservedCpus %s in the config", v)
+		}
+	}
+	if v := get("ReservedMemory"); v != "" {
+		if c.ReservedMemory, err = strconv.Atoi(v); err != nil || c.ReservedMemory < 0 {
+			return nil, fmt.Errorf("Invalid ReservedMemory %s in the config", v)
+		}
+	}
+	if v := get("CpuOvercommit"); v != "" {
+		if c.CpuOvercommit, err = strconv.ParseFloat(v, 64); err != nil || c.CpuOvercommit <= 0 {
+			return nil, fmt.Errorf("Invalid CpuOvercommit %s in the config", v)
+		}
+	}
+	if v := get("MemoryOvercommit"); v != "" {
+		if c.MemoryOvercommit, err = strconv.ParseFloat(v, 64); err != nil || c.MemoryOvercommit <= 0 {
+			return nil, fmt.Errorf("Invalid MemoryOvercommit %s in the config", v)
+		}
+	}
+	if v := get("RecordSessions"); v != "" {
+		if c.RecordSessions, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("Invalid RecordSessions %s in the config", v)
+		}
+	}
+	if c.LogLevel != "" {
+		if level, err := strconv.Atoi(c.LogLevel); err != nil || level < 0 {
+			return nil, fmt.Errorf("Invalid LogLevel %s in the config", c.LogLevel)
+		}
+	}
+	if c.PortRange != "" {
+		if _, _, err := parsePortRange(c.PortRange); err != nil {
+			return nil, err
+		}
+	}
+	if c.IPRange != "" {
+		if _, _, err := net.ParseCIDR(c.IPRange); err != nil {
+			return nil, fmt.Errorf("Invalid IPRange %s in the config", c.IPRange)
+		}
+	}
+	return c, nil
+}
+
+// parsePortRange parses a range of ports like 40000-50000
+func parsePortRange(r string) (int, int, error) {
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) == 2 {
+		begin, err1 := strconv.Atoi(parts[0])
+		end, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil && begin > 0 && begin <= end && end <= 65535 {
+			return begin, end, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("Invalid PortRange %s in the config, should be like 40000-50000", r)
+}
+
+// config returns the config the daemon runs with at the moment
+func (daemon *Daemon) config() *daemonConfig {
+	daemon.configLock.RLock()
+	defer daemon.configLock.RUnlock()
+	return daemon.cfg
+}
+
+// ApiHost returns the address of the API set by the Host of the config
+func (daemon *Daemon) ApiHost() string {
+	return daemon.config().Host
+}
+
+func setLogLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+	return flag.Set("v", level)
+}
+
+func setPortRange(r string) error {
+	if r == "" {
+		portallocator.ResetPortRange()
+		return nil
+	}
+	begin, end, err := parsePortRange(r)
+	if err != nil {
+		return err
+	}
+	return portallocator.SetPortRange(begin, end)
+}
+
+// applyConfig applies the settings of a config loaded when the daemon
+// starts, the default network must be set up already.
+func applyConfig(cfg *daemonConfig) error {
+	if err := setLogLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	if cfg.PortRange != "" {
+		if err := setPortRange(cfg.PortRange); err != nil {
+			return err
+		}
+	}
+	if cfg.IPRange != "" {
+		if err := network.SetIPRange(cfg.IPRange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload reads the config file again and applies the settings which could
+// change at runtime. The log level, the API host, the port and IP ranges
+// and the admission of VMs apply at once, the boot settings apply to the
+// VMs created afterwards. It
+// returns the settings applied, and the changed settings which need hyperd
+// to be restarted, those are left as they are.
+func (daemon *Daemon) Reload() ([]string, []string, error) {
+	daemon.reloadLock.Lock()
+	defer daemon.reloadLock.Unlock()
+
+	cfg, err := loadConfig(daemon.eng.Config)
+	if err != nil {
+		glog.Errorf("Read config file (%s) failed, %s", daemon.eng.Config, err.Error())
+		return nil, nil, err
+	}
+	old := daemon.config()
+	applied, restart := []string{}, []string{}
+
+	// every setting is checked before any is applied, and the applied ones
+	// are rolled back if one still fails, so a bad setting leaves the
+	// daemon with the old config
+	if cfg.IPRange != old.IPRange {
+		if err := network.CheckIPRange(cfg.IPRange); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.PortRange != "" {
+		if _, _, err := parsePortRange(cfg.PortRange); err != nil {
+			return nil, nil, err
+		}
+	}
+	rollback := []func(){}
+	for _, s := range []struct {
+		name     string
+		old, new string
+		set      func(string) error
+	}{
+		{"IPRange", old.IPRange, cfg.IPRange, network.SetIPRange},
+		{"PortRange", old.PortRange, cfg.PortRange, setPortRange},
+		{"LogLevel", old.LogLevel, cfg.LogLevel, setLogLevel},
+	} {
+		if s.new == s.old {
+			continue
+		}
+		if err := s.set(s.new); err != nil {
+			for i := len(rollback) - 1; i >= 0; i-- {
+				rollback[i]()
+			}
+			return nil, nil, err
+		}
+		set, value := s.set, s.old
+		rollback = append(rollback, func() { set(value) })
+		applied = append(applied, s.name)
+	}
+	if cfg.Host != old.Host {
+		daemon.moveApiHost(old.Host, cfg.Host)
+		applied = append(applied, "Host")
+	}
+
+	for _, s := range []struct {
+		name    string
+		changed bool
+	}{
+		{"Kernel", cfg.Kernel != old.Kernel},
+		{"Initrd", cfg.Initrd != old.Initrd},
+		{"Bios", cfg.Bios != old.Bios},
+		{"Cbfs", cfg.Cbfs != old.Cbfs},
+		{"BootTimeout", cfg.BootTimeout != old.BootTimeout},
+		{"BootRetries", cfg.BootRetries != old.BootRetries},
+		{"RecordSessions", cfg.RecordSessions != old.RecordSessions},
+		{"ReservedCpus", cfg.ReservedCpus != old.ReservedCpus},
+		{"ReservedMemory", cfg.ReservedMemory != old.ReservedMemory},
+		{"CpuOvercommit", cfg.CpuOvercommit != old.CpuOvercommit},
+		{"MemoryOvercommit", cfg.MemoryOvercommit != old.MemoryOvercommit},
+	} {
+		if s.changed {
+			applied = append(applied, s.name)
+		}
+	}
+
+	// the bridge is set up once, keep the running one in the config
+	if cfg.Bridge != old.Bridge {
+		restart = append(restart, "Bridge")
+		cfg.Bridge = old.Bridge
+	}
+	if cfg.BridgeIP != old.BridgeIP {
+		restart = append(restart, "BridgeIP")
+		cfg.BridgeIP = old.BridgeIP
+	}
+
+	daemon.configLock.Lock()
+	daemon.cfg = cfg
+	daemon.configLock.Unlock()
+
+	glog.V(0).Infof("The config is reloaded, applied %v, need restart %v", applied, restart)
+	return applied, restart, nil
+}
+
+// moveApiHost stops serving the API on the old host of the config and
+// starts serving it on the new one
+func (daemon *Daemon) moveApiHost(oldHost, newHost string) {
+	if oldHost != "" {
+		if err := apiserver.CloseApi(oldHost); err != nil {
+			glog.Warningf("Stop serving the API on %s: %s", oldHost, err.Error())
+		}
+	}
+	if newHost == "" {
+		return
+	}
+	go func() {
+		if err := daemon.eng.Job("serveapi", newHost).Run(); err != nil {
+			glog.Errorf("Serve the API on %s: %s", newHost, err.Error())
+		}
+	}()
+}
+
+func (daemon *Daemon) CmdDaemonReload(job *engine.Job) error {
+	applied, restart, err := daemon.Reload()
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.SetList("Applied", applied)
+	v.SetList("Restart", restart)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, file, content string) {
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "config")
+
+	writeTestConfig(t, file, "Kernel=/k\nBootRetries=2\nPortRange=40000-40100\nIPRange=192.168.123.128/25\n")
+	cfg, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Kernel != "/k" || cfg.BootRetries != 2 || cfg.PortRange != "40000-40100" || cfg.IPRange != "192.168.123.128/25" {
+		t.Errorf("config is loaded as %+v", cfg)
+	}
+
+	for _, bad := range []string{
+		"BootTimeout=-1\n",
+		"RecordSessions=maybe\n",
+		"LogLevel=high\n",
+		"PortRange=40000\n",
+		"PortRange=50000-40000\n",
+		"IPRange=192.168.123.0\n",
+	} {
+		writeTestConfig(t, file, bad)
+		if _, err := loadConfig(file); err == nil {
+			t.Errorf("config %q is loaded", bad)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "hyper-config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	daemon.eng.Config = path.Join(dir, "config")
+	writeTestConfig(t, daemon.eng.Config, "Kernel=/k1\nBridge=hyper0\n")
+	if daemon.cfg, err = loadConfig(daemon.eng.Config); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestConfig(t, daemon.eng.Config, "Kernel=/k2\nBridge=hyper1\nBootTimeout=30\n")
+	applied, restart, err := daemon.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, []string{"Kernel", "BootTimeout"}) {
+		t.Errorf("applied %v", applied)
+	}
+	if !reflect.DeepEqual(restart, []string{"Bridge"}) {
+		t.Errorf("need restart %v", restart)
+	}
+	if cfg := daemon.config(); cfg.Kernel != "/k2" || cfg.BootTimeout != 30 || cfg.Bridge != "hyper0" {
+		t.Errorf("config after the reload is %+v", cfg)
+	}
+
+	// a bad config leaves the running one alone
+	writeTestConfig(t, daemon.eng.Config, "Kernel=/k3\nBootRetries=x\n")
+	if _, _, err := daemon.Reload(); err == nil {
+		t.Error("a bad config is reloaded")
+	}
+	if daemon.config().Kernel != "/k2" {
+		t.Errorf("kernel is %s after a bad reload", daemon.config().Kernel)
+	}
+
+	// nor does a bad port range with a new IP range
+	writeTestConfig(t, daemon.eng.Config, "Kernel=/k2\nBridge=hyper0\nBootTimeout=30\nIPRange=192.168.123.128/25\nPortRange=50000-40000\n")
+	if _, _, err := daemon.Reload(); err == nil {
+		t.Error("a bad port range is reloaded")
+	}
+	if cfg := daemon.config(); cfg.IPRange != "" || cfg.PortRange != "" {
+		t.Errorf("the IP range is %q and the port range is %q after a bad reload", cfg.IPRange, cfg.PortRange)
+	}
+}
@@ -0,0 +1,300 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"hyper/engine"
+	"hyper/hypervisor"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+)
+
+// CmdContainerAdd creates a container from the JSON spec in the second
+// argument and starts it in the running pod given by the first one.
+func (daemon *Daemon) CmdContainerAdd(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not add a container without pod and container spec!")
+	}
+	podId := job.Args[0]
+	spec, err := pod.ProcessContainerBytes([]byte(job.Args[1]))
+	if err != nil {
+		return err
+	}
+
+	mypod, userPod, err := daemon.runningPodSpec(podId)
+	if err != nil {
+		return err
+	}
+	defer mypod.Unlock()
+	for _, c := range userPod.Containers {
+		if c.Name == spec.Name {
+			return fmt.Errorf("The container %s already exists in POD %s", spec.Name, podId)
+		}
+	}
+	userPod.Containers = append(userPod.Containers, *spec)
+	if err := userPod.Validate(); err != nil {
+		return err
+	}
+
+	containerId, err := daemon.createContainer(podId, spec.Image)
+	if err != nil {
+		return err
+	}
+	files := make(map[string](pod.UserFile))
+	for _, v := range userPod.Files {
+		files[v.Name] = v
+	}
+	sharedDir := path.Join(hypervisor.BaseDir, mypod.Vm(), hypervisor.ShareDirTag)
+	info, err := daemon.prepareContainer(containerId, sharedDir, spec, files)
+	if err != nil {
+		daemon.dockerCli.SendCmdDelete(containerId)
+		return err
+	}
+
+	glog.V(1).Infof("add container %s (%s) to pod %s", spec.Name, containerId, podId)
+	res, err := daemon.sendHotplug(mypod.Vm(), &hypervisor.NewContainerCommand{
+		Spec: spec,
+		Info: info,
+	})
+	if err != nil || res.Code != types.E_OK {
+		if err != nil || res.Code == types.E_BAD_REQUEST {
+			// the VM never took the rootfs over
+			releaseRootfs(sharedDir, info)
+		}
+		if err == nil {
+			err = fmt.Errorf("Fail to add container %s: %s", spec.Name, res.Cause)
+		}
+		if _, _, e := daemon.dockerCli.SendCmdDelete(containerId); e != nil {
+			glog.V(1).Infof("Error to rm container: %s", e.Error())
+		}
+		return err
+	}
+
+	container := &Container{
+		Id:     containerId,
+		Name:   spec.Name,
+		PodId:  podId,
+		Image:  spec.Image,
+		Cmds:   []string{},
+		status: types.S_POD_RUNNING,
+	}
+	daemon.registry.AddContainer(container)
+	mypod.SetContainers(append(mypod.Containers(), container))
+	if err := daemon.updatePodSpec(mypod, userPod, res.Data); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", containerId)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdContainerRm stops the container named by the second argument, given by
+// name or id, removes it from the running pod and deletes it.
+func (daemon *Daemon) CmdContainerRm(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not remove a container without pod and container name!")
+	}
+	podId := job.Args[0]
+	name := job.Args[1]
+
+	mypod, userPod, err := daemon.runningPodSpec(podId)
+	if err != nil {
+		return err
+	}
+	defer mypod.Unlock()
+	idx, err := podContainer(mypod, userPod, name)
+	if err != nil {
+		return err
+	}
+	containerId := mypod.Containers()[idx].Id
+
+	glog.V(1).Infof("remove container %s (%s) from pod %s", name, containerId, podId)
+	res, err := daemon.sendHotplug(mypod.Vm(), &hypervisor.RemoveContainerCommand{
+		Id: containerId,
+	})
+	if err != nil {
+		return err
+	}
+	if res.Code != types.E_OK {
+		return fmt.Errorf("Fail to remove container %s: %s", name, res.Cause)
+	}
+
+	userPod.Containers = append(userPod.Containers[:idx], userPod.Containers[idx+1:]...)
+	containers := mypod.Containers()
+	mypod.SetContainers(append(containers[:idx], containers[idx+1:]...))
+	daemon.registry.RemoveContainer(containerId)
+	if err := daemon.updatePodSpec(mypod, userPod, res.Data); err != nil {
+		return err
+	}
+	if _, _, err := daemon.dockerCli.SendCmdDelete(containerId); err != nil {
+		glog.V(1).Infof("Error to rm container: %s", err.Error())
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", containerId)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdContainerRestart restarts the container named by the second argument,
+// given by name or id, in the running pod, or all of its containers without
+// one. The VM, the network and the volumes of the pod are kept.
+func (daemon *Daemon) CmdContainerRestart(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not restart containers without pod ID!")
+	}
+	podId := job.Args[0]
+
+	mypod, userPod, err := daemon.runningPodSpec(podId)
+	if err != nil {
+		return err
+	}
+	defer mypod.Unlock()
+	containers := mypod.Containers()
+	id := podId
+	if len(job.Args) > 1 && job.Args[1] != "" {
+		idx, err := podContainer(mypod, userPod, job.Args[1])
+		if err != nil {
+			return err
+		}
+		containers = []*Container{mypod.Containers()[idx]}
+		id = containers[0].Id
+	}
+
+	for _, c := range containers {
+		glog.V(1).Infof("restart container %s (%s) of pod %s", c.Name, c.Id, podId)
+		res, err := daemon.sendHotplug(mypod.Vm(), &hypervisor.RestartContainerCommand{
+			Id: c.Id,
+		})
+		if err != nil {
+			return err
+		}
+		if res.Code != types.E_OK {
+			return fmt.Errorf("Fail to restart container %s: %s", c.Name, res.Cause)
+		}
+		c.SetStatus(types.S_POD_RUNNING)
+		c.Restarted()
+		daemon.LogEvent("container", "restart", c.Id, podId, mypod.Vm(), "")
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", id)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// runningPodSpec returns a running pod, locked for the change the caller
+// makes, and its spec.
+func (daemon *Daemon) runningPodSpec(podId string) (*Pod, *pod.UserPod, error) {
+	mypod := daemon.lockPod(podId)
+	if mypod == nil {
+		return nil, nil, fmt.Errorf("Can not find the POD instance of %s", podId)
+	}
+	if mypod.Status() != types.S_POD_RUNNING || mypod.Vm() == "" {
+		mypod.Unlock()
+		return nil, nil, fmt.Errorf("The POD %s is not running", podId)
+	}
+	data, err := daemon.GetPodByName(podId)
+	if err != nil {
+		mypod.Unlock()
+		return nil, nil, err
+	}
+	userPod, err := pod.ProcessPodBytes(data)
+	if err != nil {
+		mypod.Unlock()
+		return nil, nil, err
+	}
+	return mypod, userPod, nil
+}
+
+// podContainer finds a container of a pod by name or id, it returns its
+// index in both the pod and its spec.
+func podContainer(mypod *Pod, userPod *pod.UserPod, name string) (int, error) {
+	for i, c := range mypod.Containers() {
+		if i >= len(userPod.Containers) {
+			break
+		}
+		if c.Id == name || userPod.Containers[i].Name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("Can not find container %s in POD %s", name, mypod.Id)
+}
+
+// sendHotplug passes a command changing the containers or the volumes of a
+// running pod to its VM and waits for the result.
+func (daemon *Daemon) sendHotplug(vmId string, cmd hypervisor.VmEvent) (*types.QemuResponse, error) {
+	callback := make(chan *types.QemuResponse, 1)
+	switch c := cmd.(type) {
+	case *hypervisor.NewContainerCommand:
+		c.Callback = callback
+	case *hypervisor.RemoveContainerCommand:
+		c.Callback = callback
+	case *hypervisor.RestartContainerCommand:
+		c.Callback = callback
+	case *hypervisor.AttachVolumeCommand:
+		c.Callback = callback
+	case *hypervisor.DetachVolumeCommand:
+		c.Callback = callback
+	}
+
+	qemuChan, err := daemon.GetQemuChan(vmId)
+	if err != nil {
+		return nil, err
+	}
+	qemuChan.Event <- cmd
+
+	return <-callback, nil
+}
+
+// updatePodSpec stores the spec and the containers of a pod changed while
+// it runs, with the persist info of its VM.
+func (daemon *Daemon) updatePodSpec(mypod *Pod, userPod *pod.UserPod, vmData interface{}) error {
+	podData, err := json.Marshal(userPod)
+	if err != nil {
+		return err
+	}
+	err = daemon.store.updatePod(mypod.Id, func(rec *podRecord, b *storeBatch) error {
+		rec.Spec = podData
+		rec.Containers = containerIds(mypod.Containers())
+		if data, ok := vmData.([]byte); ok && len(data) > 0 {
+			return b.PutVm(&vmRecord{Id: rec.Vm, Pod: mypod.Id, Data: data})
+		}
+		return nil
+	})
+	if err != nil {
+		glog.V(1).Info("Found an error while saveing the POD file")
+	}
+	return err
+}
+
+// releaseRootfs undoes prepareContainer for a rootfs the VM did not take.
+func releaseRootfs(sharedDir string, info *hypervisor.ContainerInfo) {
+	if info.Fstype == "dir" {
+		hypervisor.UmountContainerDir(sharedDir, info.Image)
+		return
+	}
+	done := make(chan hypervisor.VmEvent, 1)
+	hypervisor.UmountDMDevice(info.Image, info.Id, done)
+	<-done
+}
@@ -0,0 +1,608 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+)
+
+const (
+	// the concurrency policies, what a tick does while a run is active
+	cronAllow   = "allow"   // start another run
+	cronForbid  = "forbid"  // skip the tick
+	cronReplace = "replace" // remove the active runs and start a new one
+
+	defaultCronHistory = 5
+
+	// the status of a run besides the ones of its pod
+	cronSkipped = "skipped"
+	cronUnknown = "unknown" // the pod is gone before it finished
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a parsed cron expression, each field is a bit set of the
+// values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// the day matches both the dom and the dow if one of them is *, either
+	// of them otherwise
+	domStar, dowStar bool
+}
+
+// parseCronField parses a field of the expression, like *, */15, 1-5,
+// 0,30 or 10-40/10
+func parseCronField(field string, min, max int) (uint64, bool, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		var (
+			rng  = item
+			step = 1
+		)
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step < 1 {
+				return 0, false, fmt.Errorf("invalid step in %s", item)
+			}
+			rng = item[:i]
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, false, fmt.Errorf("invalid value %s", item)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, false, fmt.Errorf("invalid value %s", item)
+				}
+			} else if step > 1 {
+				// 5/15 is from 5 to the end by 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, false, fmt.Errorf("%s is out of %d-%d", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, field == "*", nil
+}
+
+// parseCron parses an expression of the 5 fields minute, hour, day of month,
+// month and day of week, or a macro like @daily
+func parseCron(expr string) (*cronSchedule, error) {
+	if m, ok := cronMacros[expr]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid schedule %q, it needs 5 fields: minute hour day month weekday", expr)
+	}
+	var (
+		s   = &cronSchedule{}
+		err error
+	)
+	if s.minute, _, err = parseCronField(fields[0], 0, 59); err == nil {
+		if s.hour, _, err = parseCronField(fields[1], 0, 23); err == nil {
+			if s.dom, s.domStar, err = parseCronField(fields[2], 1, 31); err == nil {
+				if s.month, _, err = parseCronField(fields[3], 1, 12); err == nil {
+					s.dow, s.dowStar, err = parseCronField(fields[4], 0, 7)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid schedule %q, %s", expr, err.Error())
+	}
+	// sunday is 0 or 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time matching the schedule after t, or the zero
+// time if none comes in 5 years, like for Feb 30
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// cronRun is a tick of a cron, the pod it started and how it ended
+type cronRun struct {
+	Pod       string `json:"pod,omitempty"`
+	Scheduled int64  `json:"scheduled"`          //unix time in nanoseconds
+	Finished  int64  `json:"finished,omitempty"` //unix time in nanoseconds
+	Status    string `json:"status"`
+	ExitCodes []int  `json:"exitCodes,omitempty"`
+	Cause     string `json:"cause,omitempty"`
+}
+
+func (r *cronRun) active() bool {
+	return r.Finished == 0 && r.Status != cronSkipped && r.Status != cronUnknown
+}
+
+// cronRecord is a cron in the db, with its last runs
+type cronRecord struct {
+	Id          string          `json:"id"`
+	Name        string          `json:"name"`
+	Schedule    string          `json:"schedule"`
+	Concurrency string          `json:"concurrency"`
+	History     int             `json:"history"`
+	Spec        json.RawMessage `json:"spec"`
+	Runs        []*cronRun      `json:"runs,omitempty"`
+}
+
+type cronJob struct {
+	rec      *cronRecord
+	schedule *cronSchedule
+	timer    *time.Timer
+	next     time.Time
+	// serializes the ticks of the cron
+	tick sync.Mutex
+}
+
+// cronScheduler runs the pods of the crons at their ticks
+type cronScheduler struct {
+	sync.Mutex
+	jobs map[string]*cronJob
+}
+
+func newCronScheduler() *cronScheduler {
+	return &cronScheduler{
+		jobs: make(map[string]*cronJob),
+	}
+}
+
+// lookup returns the cron of an id or a name, the caller must hold the lock
+func (cs *cronScheduler) lookup(ref string) (*cronJob, error) {
+	if job, ok := cs.jobs[ref]; ok {
+		return job, nil
+	}
+	for _, job := range cs.jobs {
+		if job.rec.Name == ref {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("Can not find cron %s", ref)
+}
+
+// arm sets the timer of a cron for its next tick, the caller must hold the
+// lock
+func (daemon *Daemon) armCron(job *cronJob) {
+	job.next = job.schedule.next(time.Now())
+	if job.next.IsZero() {
+		glog.Warningf("Cron %s never ticks again", job.rec.Id)
+		return
+	}
+	job.timer = time.AfterFunc(job.next.Sub(time.Now()), func() {
+		daemon.cronTick(job)
+	})
+}
+
+// cronTick starts the run of a tick by the concurrency policy of the cron,
+// and drops the runs beyond the history with their pods
+func (daemon *Daemon) cronTick(job *cronJob) {
+	job.tick.Lock()
+	defer job.tick.Unlock()
+
+	cs := daemon.crons
+	cs.Lock()
+	if cs.jobs[job.rec.Id] != job {
+		// removed meanwhile
+		cs.Unlock()
+		return
+	}
+	scheduled := job.next
+	daemon.armCron(job)
+	var (
+		active = []string{}
+		run    = &cronRun{Scheduled: scheduled.UnixNano()}
+	)
+	daemon.refreshCronRuns(job.rec)
+	for _, r := range job.rec.Runs {
+		if r.active() && r.Pod != "" {
+			active = append(active, r.Pod)
+		}
+	}
+	spec, name := job.rec.Spec, fmt.Sprintf("%s-%d", job.rec.Name, scheduled.Unix())
+	concurrency := job.rec.Concurrency
+	cs.Unlock()
+
+	switch {
+	case len(active) > 0 && concurrency == cronForbid:
+		run.Status = cronSkipped
+		run.Cause = fmt.Sprintf("pod %s is still active", strings.Join(active, ", "))
+	default:
+		if concurrency == cronReplace {
+			for _, podId := range active {
+				if err := daemon.runCronJob("podRm", podId); err != nil {
+					glog.Warningf("Cron %s failed to remove pod %s: %s", job.rec.Id, podId, err.Error())
+				}
+			}
+		}
+		run.Pod, run.Status, run.Cause = daemon.startCronPod(spec, name)
+	}
+	glog.V(1).Infof("Cron %s ticks, pod %s %s %s", job.rec.Id, run.Pod, run.Status, run.Cause)
+	daemon.LogEvent("cron", "tick", job.rec.Id, run.Pod, "", run.Status)
+
+	cs.Lock()
+	job.rec.Runs = append(job.rec.Runs, run)
+	dropped := daemon.trimCronRuns(job.rec)
+	if cs.jobs[job.rec.Id] == job {
+		if err := daemon.writeCron(job.rec); err != nil {
+			glog.Errorf("Fail to write cron %s: %s", job.rec.Id, err.Error())
+		}
+	}
+	cs.Unlock()
+	for _, podId := range dropped {
+		if err := daemon.runCronJob("podRm", podId); err != nil {
+			glog.Warningf("Cron %s failed to remove pod %s: %s", job.rec.Id, podId, err.Error())
+		}
+	}
+}
+
+// startCronPod runs a fresh pod of the spec under the name, it returns the
+// pod, which may be left even if it fails to start, and the status
+func (daemon *Daemon) startCronPod(spec json.RawMessage, name string) (string, string, string) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(spec, &fields); err != nil {
+		return "", "failed", err.Error()
+	}
+	fields["id"] = name
+	podArgs, err := json.Marshal(fields)
+	if err != nil {
+		return "", "failed", err.Error()
+	}
+	if err := daemon.runCronJob("podRun", string(podArgs)); err != nil {
+		podId := ""
+		if p := daemon.registry.PodByName(name); p != nil {
+			podId = p.Id
+		}
+		return podId, "failed", err.Error()
+	}
+	p := daemon.registry.PodByName(name)
+	if p == nil {
+		return "", cronUnknown, "the pod is gone at once"
+	}
+	return p.Id, statusName(p.Status()), ""
+}
+
+func (daemon *Daemon) runCronJob(name string, args ...string) error {
+	job := daemon.eng.Job(name, args...)
+	job.Stdout.Add(bytes.NewBuffer(nil))
+	return job.Run()
+}
+
+// refreshCronRuns updates the active runs by their pods, the caller must
+// hold the lock of the scheduler
+func (daemon *Daemon) refreshCronRuns(rec *cronRecord) bool {
+	changed := false
+	for _, r := range rec.Runs {
+		if !r.active() {
+			continue
+		}
+		p := daemon.registry.Pod(r.Pod)
+		if p == nil {
+			r.Status, r.Finished = cronUnknown, time.Now().UnixNano()
+			changed = true
+			continue
+		}
+		status := p.Status()
+		if s := statusName(status); s != r.Status {
+			r.Status, changed = s, true
+		}
+		if status != types.S_POD_SUCCEEDED && status != types.S_POD_FAILED {
+			continue
+		}
+		_, _, finished := p.Times()
+		if finished.IsZero() {
+			finished = time.Now()
+		}
+		r.Finished = finished.UnixNano()
+		r.ExitCodes = []int{}
+		for _, c := range p.Containers() {
+			code, _ := c.Exit()
+			r.ExitCodes = append(r.ExitCodes, code)
+		}
+		changed = true
+	}
+	return changed
+}
+
+// trimCronRuns drops the oldest finished runs beyond the history, it
+// returns the pods of the dropped runs. The active runs are kept.
+func (daemon *Daemon) trimCronRuns(rec *cronRecord) []string {
+	var (
+		dropped = []string{}
+		extra   = len(rec.Runs) - rec.History
+		kept    = []*cronRun{}
+	)
+	for _, r := range rec.Runs {
+		if extra > 0 && !r.active() {
+			extra--
+			if r.Pod != "" && daemon.registry.Pod(r.Pod) != nil {
+				dropped = append(dropped, r.Pod)
+			}
+			continue
+		}
+		kept = append(kept, r)
+	}
+	rec.Runs = kept
+	return dropped
+}
+
+// watchCrons updates the runs of the crons as their pods finish
+func (daemon *Daemon) watchCrons() {
+	_, events := daemon.events.subscribe(time.Now().UnixNano())
+	for ev := range events {
+		if ev.Type != "pod" || (ev.Action != "finish" && ev.Action != "fail") {
+			continue
+		}
+		daemon.crons.Lock()
+		for _, job := range daemon.crons.jobs {
+			for _, r := range job.rec.Runs {
+				if r.Pod != ev.Pod {
+					continue
+				}
+				if daemon.refreshCronRuns(job.rec) {
+					if err := daemon.writeCron(job.rec); err != nil {
+						glog.Errorf("Fail to write cron %s: %s", job.rec.Id, err.Error())
+					}
+				}
+				break
+			}
+		}
+		daemon.crons.Unlock()
+	}
+}
+
+func (daemon *Daemon) writeCron(rec *cronRecord) error {
+	b := &storeBatch{}
+	if err := b.PutCron(rec); err != nil {
+		return err
+	}
+	return daemon.store.Write(b)
+}
+
+// StartCrons loads the crons from the db and arms them, it is started once
+// the pods are restored
+func (daemon *Daemon) StartCrons() error {
+	recs, err := daemon.store.Crons()
+	if err != nil {
+		return err
+	}
+	daemon.crons.Lock()
+	defer daemon.crons.Unlock()
+	for _, rec := range recs {
+		schedule, err := parseCron(rec.Schedule)
+		if err != nil {
+			glog.Warningf("Skip cron %s: %s", rec.Id, err.Error())
+			continue
+		}
+		job := &cronJob{rec: rec, schedule: schedule}
+		// the pods which finished while hyperd was down
+		if daemon.refreshCronRuns(rec) {
+			if err := daemon.writeCron(rec); err != nil {
+				glog.Errorf("Fail to write cron %s: %s", rec.Id, err.Error())
+			}
+		}
+		daemon.crons.jobs[rec.Id] = job
+		daemon.armCron(job)
+	}
+	go daemon.watchCrons()
+	return nil
+}
+
+// CmdCronCreate adds a cron running the pod spec of the argument at the
+// schedule given in the env
+func (daemon *Daemon) CmdCronCreate(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not create a cron without pod spec!")
+	}
+	spec := job.Args[0]
+	userPod, err := pod.ProcessPodBytes([]byte(spec))
+	if err != nil {
+		return err
+	}
+	if err := userPod.Validate(); err != nil {
+		return err
+	}
+	schedule, err := parseCron(job.Getenv("schedule"))
+	if err != nil {
+		return err
+	}
+	rec := &cronRecord{
+		Id:          fmt.Sprintf("cron-%s", pod.RandStr(10, "alpha")),
+		Name:        job.Getenv("name"),
+		Schedule:    job.Getenv("schedule"),
+		Concurrency: job.Getenv("concurrency"),
+		History:     defaultCronHistory,
+		Spec:        json.RawMessage(spec),
+	}
+	if rec.Name == "" {
+		rec.Name = userPod.Name
+	}
+	switch rec.Concurrency {
+	case "":
+		rec.Concurrency = cronAllow
+	case cronAllow, cronForbid, cronReplace:
+	default:
+		return fmt.Errorf("Invalid concurrency policy %s, it is allow, forbid or replace", rec.Concurrency)
+	}
+	if h := job.Getenv("history"); h != "" {
+		if rec.History, err = strconv.Atoi(h); err != nil || rec.History < 1 {
+			return fmt.Errorf("Invalid history %s, it keeps at least 1 run", h)
+		}
+	}
+
+	daemon.crons.Lock()
+	defer daemon.crons.Unlock()
+	if other, err := daemon.crons.lookup(rec.Name); err == nil {
+		return fmt.Errorf("The cron name %s is already used by cron %s", rec.Name, other.rec.Id)
+	}
+	if err := daemon.writeCron(rec); err != nil {
+		return err
+	}
+	cj := &cronJob{rec: rec, schedule: schedule}
+	daemon.crons.jobs[rec.Id] = cj
+	daemon.armCron(cj)
+	daemon.LogEvent("cron", "create", rec.Id, "", "", rec.Schedule)
+
+	v := &engine.Env{}
+	v.Set("ID", rec.Id)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdCronRm removes the cron of the id or name of the argument, the pods
+// it started are left
+func (daemon *Daemon) CmdCronRm(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not remove a cron without its id or name!")
+	}
+	daemon.crons.Lock()
+	defer daemon.crons.Unlock()
+	cj, err := daemon.crons.lookup(job.Args[0])
+	if err != nil {
+		return err
+	}
+	b := &storeBatch{}
+	b.DeleteCron(cj.rec.Id)
+	if err := daemon.store.Write(b); err != nil {
+		return err
+	}
+	if cj.timer != nil {
+		cj.timer.Stop()
+	}
+	delete(daemon.crons.jobs, cj.rec.Id)
+	daemon.LogEvent("cron", "remove", cj.rec.Id, "", "", "")
+
+	v := &engine.Env{}
+	v.Set("ID", cj.rec.Id)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) CmdCronList(job *engine.Job) error {
+	daemon.crons.Lock()
+	var cronJsonResponse = []string{}
+	for _, cj := range daemon.crons.jobs {
+		last := ""
+		if n := len(cj.rec.Runs); n > 0 {
+			last = cj.rec.Runs[n-1].Status
+		}
+		next := int64(0)
+		if !cj.next.IsZero() {
+			next = cj.next.Unix()
+		}
+		cronJsonResponse = append(cronJsonResponse, fmt.Sprintf("%s:%s:%s:%s:%d:%s",
+			cj.rec.Id, cj.rec.Name, cj.rec.Schedule, cj.rec.Concurrency, next, last))
+	}
+	daemon.crons.Unlock()
+
+	v := &engine.Env{}
+	v.SetList("cronData", cronJsonResponse)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdCronRuns lists the last runs of the cron of the argument, the cause of
+// a run is the last field as it may have colons
+func (daemon *Daemon) CmdCronRuns(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not list the runs of a cron without its id or name!")
+	}
+	daemon.crons.Lock()
+	cj, err := daemon.crons.lookup(job.Args[0])
+	if err != nil {
+		daemon.crons.Unlock()
+		return err
+	}
+	var runJsonResponse = []string{}
+	for _, r := range cj.rec.Runs {
+		codes := make([]string, len(r.ExitCodes))
+		for i, code := range r.ExitCodes {
+			codes[i] = strconv.Itoa(code)
+		}
+		finished := int64(0)
+		if r.Finished != 0 {
+			finished = time.Unix(0, r.Finished).Unix()
+		}
+		runJsonResponse = append(runJsonResponse, fmt.Sprintf("%s:%d:%d:%s:%s:%s",
+			r.Pod, time.Unix(0, r.Scheduled).Unix(), finished, r.Status, strings.Join(codes, ","), r.Cause))
+	}
+	daemon.crons.Unlock()
+
+	v := &engine.Env{}
+	v.SetList("runData", runJsonResponse)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
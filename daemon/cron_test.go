@@ -0,0 +1,149 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"hyper/types"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{
+		"0 2 * * *",
+		"*/15 * * * *",
+		"0,30 8-18 * * 1-5",
+		"5/20 0 1 */3 7",
+		"@daily",
+	} {
+		if _, err := parseCron(expr); err != nil {
+			t.Errorf("%q is not parsed: %v", expr, err)
+		}
+	}
+	for _, expr := range []string{
+		"",
+		"0 2 * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q is parsed, it is invalid", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, c := range []struct {
+		expr, from, next string
+	}{
+		{"0 2 * * *", "2016-03-01 01:59", "2016-03-01 02:00"},
+		{"0 2 * * *", "2016-03-01 02:00", "2016-03-02 02:00"},
+		{"*/15 * * * *", "2016-03-01 10:16", "2016-03-01 10:30"},
+		{"0 0 1 1 *", "2016-06-15 12:00", "2017-01-01 00:00"},
+		{"@hourly", "2016-12-31 23:30", "2017-01-01 00:00"},
+		// 2016-03-05 is a saturday, 7 is sunday
+		{"30 9 * * 7", "2016-03-05 10:00", "2016-03-06 09:30"},
+		{"0 0 * * 1-5", "2016-03-05 10:00", "2016-03-07 00:00"},
+		// either the day of month or the day of week
+		{"0 0 15 * 1", "2016-03-05 10:00", "2016-03-07 00:00"},
+		{"0 0 29 2 *", "2016-03-01 00:00", "2020-02-29 00:00"},
+	} {
+		s, err := parseCron(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.next(at(c.from)); !got.Equal(at(c.next)) {
+			t.Errorf("the tick of %q after %s is %s, should be %s", c.expr, c.from, got, c.next)
+		}
+	}
+	s, _ := parseCron("0 0 30 2 *")
+	if got := s.next(time.Now()); !got.IsZero() {
+		t.Errorf("Feb 30 ticks at %s", got)
+	}
+}
+
+func TestTrimCronRuns(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	daemon.registry.AddPod(&Pod{Id: "pod-old", Name: "old"})
+	rec := &cronRecord{
+		History: 2,
+		Runs: []*cronRun{
+			{Pod: "pod-old", Status: "succeeded", Finished: 1},
+			{Pod: "pod-active", Status: "running"},
+			{Status: cronSkipped},
+			{Pod: "pod-new", Status: "failed", Finished: 3},
+		},
+	}
+	dropped := daemon.trimCronRuns(rec)
+	if len(dropped) != 1 || dropped[0] != "pod-old" {
+		t.Errorf("the pods %v are dropped, should be pod-old", dropped)
+	}
+	if len(rec.Runs) != 2 || rec.Runs[0].Pod != "pod-active" || rec.Runs[1].Pod != "pod-new" {
+		t.Errorf("the runs left are %v", rec.Runs)
+	}
+}
+
+func TestCronForbid(t *testing.T) {
+	daemon, eng, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	dat, err := runJob(eng, "cronCreate", storeTestSpec)
+	if err == nil {
+		t.Fatal("a cron is created without schedule")
+	}
+	job := eng.Job("cronCreate", storeTestSpec)
+	job.Setenv("schedule", "0 2 * * *")
+	job.Setenv("concurrency", cronForbid)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	cj, err := daemon.crons.lookup("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cj.timer.Stop()
+
+	active := &Pod{Id: "pod-active", Name: "test-1"}
+	active.SetStatus(types.S_POD_RUNNING)
+	daemon.registry.AddPod(active)
+	cj.rec.Runs = []*cronRun{{Pod: active.Id, Status: "running"}}
+	daemon.cronTick(cj)
+
+	runs := cj.rec.Runs
+	if len(runs) != 2 || runs[1].Status != cronSkipped || runs[1].Pod != "" {
+		t.Fatalf("the tick with an active run is not skipped: %v", runs[len(runs)-1])
+	}
+	// the run is persisted with its cron
+	recs, err := daemon.store.Crons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Name != "test" || len(recs[0].Runs) != 2 {
+		t.Fatalf("the crons in the db are %v", recs)
+	}
+
+	if dat, err = runJob(eng, "cronRm", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if dat["ID"] != cj.rec.Id {
+		t.Errorf("cron %v is removed, should be %s", dat["ID"], cj.rec.Id)
+	}
+	if recs, _ := daemon.store.Crons(); len(recs) != 0 {
+		t.Errorf("the cron is left in the db")
+	}
+}
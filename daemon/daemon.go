@@ -15,41 +15,54 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/Unknwon/goconfig"
 	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 type Vm struct {
-	Id                 string
-	Pod                *Pod
-	Status             uint
-	Cpu                int
-	Mem                int
-	qemuChan           interface{}
-	mainQemuClientChan interface{}
-	qemuClientChan     interface{}
+	Id  string
+	Pod *Pod
+	Cpu int
+	Mem int
+
+	lock   sync.Mutex
+	status uint
 }
 
 type Pod struct {
-	Id            string
-	Name          string
-	Vm            string
-	Wg            *sync.WaitGroup
-	Containers    []*Container
-	Status        uint
-	Type          string
-	RestartPolicy string
+	Id         string
+	Name       string
+	Wg         *sync.WaitGroup
+	Type       string
+	MaxRetries int
+
+	// jobs changing the pod hold busy, the fields below are protected by
+	// lock
+	busy          sync.Mutex
+	lock          sync.Mutex
+	vm            string
+	status        uint
+	restartPolicy string
+	containers    []*Container
+	// when the pod was created, and last started and finished running
+	created  time.Time
+	started  time.Time
+	finished time.Time
 }
 
 type Container struct {
-	Id     string
-	Name   string
-	PodId  string
-	Image  string
-	Cmds   []string
-	Status uint
+	Id    string
+	Name  string
+	PodId string
+	Image string
+	Cmds  []string
+
+	lock     sync.Mutex
+	status   uint
+	restarts int
+	exitCode int
+	finished time.Time
 }
 
 type Storage struct {
@@ -60,25 +73,30 @@ type Storage struct {
 	DmPoolData  *dm.DeviceMapper
 }
 
+// hyperRoot is where hyperd keeps its db
+const hyperRoot = "/var/lib/hyper/"
+
 type Daemon struct {
-	ID                string
-	db                *leveldb.DB
-	eng               *engine.Engine
-	dockerCli         *docker.DockerCli
-	containerList     []*Container
-	podList           map[string]*Pod
-	vmList            map[string]*Vm
-	qemuChan          map[string]interface{}
-	qemuClientChan    map[string]interface{}
-	subQemuClientChan map[string]interface{}
-	kernel            string
-	initrd            string
-	bios              string
-	cbfs              string
-	BridgeIface       string
-	BridgeIP          string
-	Host              string
-	Storage           *Storage
+	ID          string
+	db          *leveldb.DB
+	store       *store
+	eng         *engine.Engine
+	dockerCli   *docker.DockerCli
+	registry    *registry
+	admission   *admission
+	cfg         *daemonConfig
+	configLock  sync.RWMutex
+	reloadLock  sync.Mutex
+	BridgeIface string
+	BridgeIP    string
+	Storage     *Storage
+	exitCodes   map[string]chan int
+	exitLock    sync.Mutex
+	events      *eventLog
+	kernels     *kernelCatalog
+	restarts    *restartStates
+	gc          *collector
+	crons       *cronScheduler
 }
 
 // Install installs daemon capabilities to eng.
@@ -92,15 +110,40 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 		"podCreate":         daemon.CmdPodCreate,
 		"podStart":          daemon.CmdPodStart,
 		"podInfo":           daemon.CmdPodInfo,
+		"podArchive":        daemon.CmdPodArchive,
+		"containerAdd":      daemon.CmdContainerAdd,
+		"containerRm":       daemon.CmdContainerRm,
+		"containerRestart":  daemon.CmdContainerRestart,
+		"volumeAttach":      daemon.CmdVolumeAttach,
+		"volumeDetach":      daemon.CmdVolumeDetach,
+		"networkCreate":     daemon.CmdNetworkCreate,
+		"networkRm":         daemon.CmdNetworkRm,
+		"networkList":       daemon.CmdNetworkList,
+		"kernelAdd":         daemon.CmdKernelAdd,
+		"kernelRm":          daemon.CmdKernelRm,
+		"kernelList":        daemon.CmdKernelList,
 		"podRm":             daemon.CmdPodRm,
 		"podRun":            daemon.CmdPodRun,
 		"podStop":           daemon.CmdPodStop,
 		"vmCreate":          daemon.CmdVmCreate,
 		"vmKill":            daemon.CmdVmKill,
+		"vmTrace":           daemon.CmdVmTrace,
 		"list":              daemon.CmdList,
 		"exec":              daemon.CmdExec,
+		"exitcode":          daemon.CmdExitCode,
 		"attach":            daemon.CmdAttach,
+		"portForward":       daemon.CmdPortForward,
 		"tty":               daemon.CmdTty,
+		"events":            daemon.CmdEvents,
+		"sessionList":       daemon.CmdSessionList,
+		"sessionPlay":       daemon.CmdSessionPlay,
+		"daemonReload":      daemon.CmdDaemonReload,
+		"gc":                daemon.CmdGC,
+		"podInspect":        daemon.CmdPodInspect,
+		"cronCreate":        daemon.CmdCronCreate,
+		"cronRm":            daemon.CmdCronRm,
+		"cronList":          daemon.CmdCronList,
+		"cronRuns":          daemon.CmdCronRuns,
 		"serveapi":          apiserver.ServeApi,
 		"acceptconnections": apiserver.AcceptConnections,
 	} {
@@ -113,45 +156,35 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 }
 
 func (daemon *Daemon) Restore() error {
+	if err := daemon.restoreNetworks(); err != nil {
+		return err
+	}
+	if err := daemon.restoreKernels(); err != nil {
+		return err
+	}
+
 	if daemon.GetPodNum() == 0 {
 		return nil
 	}
 
-	podList := map[string]string{}
-
-	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("pod-")), nil)
-	for iter.Next() {
-		key := iter.Key()
-		value := iter.Value()
-		if strings.Contains(string(key), "pod-container-") {
-			glog.V(1).Infof(string(value))
-			continue
-		}
-		glog.V(1).Infof("Get the pod item, pod is %s!", key)
-		err := daemon.db.Delete(key, nil)
-		if err != nil {
-			return err
-		}
-		podList[string(key)[4:]] = string(value)
-	}
-	iter.Release()
-	err := iter.Error()
+	pods, err := daemon.store.Pods()
 	if err != nil {
 		return err
 	}
-	for k, v := range podList {
+	for _, rec := range pods {
+		glog.V(1).Infof("Get the pod item, pod is %s!", rec.Id)
 		wg := new(sync.WaitGroup)
-		err = daemon.CreatePod(v, k, wg)
+		err = daemon.CreatePod(string(rec.Spec), rec.Id, wg)
 		if err != nil {
 			glog.Warning("Got a unexpected error, %s", err.Error())
+			daemon.gc.keepPod(rec.Id)
 			continue
 		}
-		vmId, err := daemon.GetVmByPod(k)
-		if err != nil {
-			glog.V(1).Info(err.Error(), " for ", k)
+		if rec.Vm == "" {
+			glog.V(1).Info("Can not find the VM for ", rec.Id)
 			continue
 		}
-		daemon.podList[k].Vm = string(vmId)
+		daemon.registry.Pod(rec.Id).SetVm(rec.Vm)
 	}
 
 	// associate all VMs
@@ -194,21 +227,15 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 		return nil, err
 	}
 
-	cfg, err := goconfig.LoadConfigFile(eng.Config)
+	cfg, err := loadConfig(eng.Config)
 	if err != nil {
 		glog.Errorf("Read config file (%s) failed, %s", eng.Config, err.Error())
 		return nil, err
 	}
-	kernel, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Kernel")
-	initrd, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Initrd")
-	glog.V(0).Infof("The config: kernel=%s, initrd=%s", kernel, initrd)
-	biface, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Bridge")
-	bridgeip, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "BridgeIP")
-	glog.V(0).Infof("The config: bridge=%s, ip=%s", biface, bridgeip)
-	bios, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Bios")
-	cbfs, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Cbfs")
-	glog.V(0).Infof("The config: bios=%s, cbfs=%s", bios, cbfs)
-	host, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Host")
+	glog.V(0).Infof("The config: kernel=%s, initrd=%s", cfg.Kernel, cfg.Initrd)
+	glog.V(0).Infof("The config: bridge=%s, ip=%s", cfg.Bridge, cfg.BridgeIP)
+	glog.V(0).Infof("The config: bios=%s, cbfs=%s", cfg.Bios, cfg.Cbfs)
+	glog.V(0).Infof("The config: boot timeout=%d, boot retries=%d", cfg.BootTimeout, cfg.BootRetries)
 
 	var tempdir = "/var/run/hyper/"
 	os.Setenv("TMPDIR", tempdir)
@@ -216,50 +243,52 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 		return nil, err
 	}
 
-	var realRoot = "/var/lib/hyper/"
 	// Create the root directory if it doesn't exists
-	if err := os.MkdirAll(realRoot, 0755); err != nil && !os.IsExist(err) {
+	if err := os.MkdirAll(hyperRoot, 0755); err != nil && !os.IsExist(err) {
 		return nil, err
 	}
 
-	if err := network.InitNetwork(biface, bridgeip); err != nil {
+	if err := network.InitNetwork(cfg.Bridge, cfg.BridgeIP); err != nil {
 		glog.Errorf("InitNetwork failed, %s\n", err.Error())
 		return nil, err
 	}
+	if err := applyConfig(cfg); err != nil {
+		glog.Errorf("Apply the config failed, %s\n", err.Error())
+		return nil, err
+	}
 
 	var (
 		proto   = "unix"
 		addr    = "/var/run/docker.sock"
-		db_file = fmt.Sprintf("%s/hyper.db", realRoot)
+		db_file = fmt.Sprintf("%s/hyper.db", hyperRoot)
 	)
 	db, err := leveldb.OpenFile(db_file, nil)
 	if err != nil {
 		glog.Errorf("open leveldb file failed, %s\n", err.Error())
 		return nil, err
 	}
+	store := newStore(db)
+	if err := store.migrate(); err != nil {
+		glog.Errorf("migrate the db failed, %s\n", err.Error())
+		db.Close()
+		return nil, err
+	}
 	dockerCli := docker.NewDockerCli("", proto, addr, nil)
-	qemuchan := map[string]interface{}{}
-	qemuclient := map[string]interface{}{}
-	subQemuClient := map[string]interface{}{}
-	cList := []*Container{}
-	pList := map[string]*Pod{}
-	vList := map[string]*Vm{}
 	daemon := &Daemon{
-		ID:                fmt.Sprintf("%d", os.Getpid()),
-		db:                db,
-		eng:               eng,
-		kernel:            kernel,
-		initrd:            initrd,
-		bios:              bios,
-		cbfs:              cbfs,
-		dockerCli:         dockerCli,
-		containerList:     cList,
-		podList:           pList,
-		vmList:            vList,
-		qemuChan:          qemuchan,
-		qemuClientChan:    qemuclient,
-		subQemuClientChan: subQemuClient,
-		Host:              host,
+		ID:        fmt.Sprintf("%d", os.Getpid()),
+		db:        db,
+		store:     store,
+		eng:       eng,
+		cfg:       cfg,
+		dockerCli: dockerCli,
+		registry:  newRegistry(),
+		admission: newAdmission(),
+		exitCodes: map[string]chan int{},
+		events:    newEventLog(),
+		kernels:   newKernelCatalog(),
+		restarts:  newRestartStates(),
+		gc:        newCollector(),
+		crons:     newCronScheduler(),
 	}
 
 	stor := &Storage{}
@@ -362,272 +391,207 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 }
 
 func (daemon *Daemon) GetPodNum() int64 {
-	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("pod-")), nil)
-	var i int64 = 0
-	for iter.Next() {
-		key := iter.Key()
-		if strings.Contains(string(key), "pod-container-") {
-			continue
-		}
-		i++
-	}
-	iter.Release()
-	err := iter.Error()
+	pods, err := daemon.store.Pods()
 	if err != nil {
 		return 0
 	}
-	return i
+	return int64(len(pods))
 }
 
 func (daemon *Daemon) GetRunningPodNum() int64 {
 	var num int64 = 0
-	for _, v := range daemon.podList {
-		if v.Status == types.S_POD_RUNNING {
+	for _, v := range daemon.registry.Pods() {
+		if v.Status() == types.S_POD_RUNNING {
 			num++
 		}
 	}
 	return num
 }
 
-func (daemon *Daemon) WritePodToDB(podName string, podData []byte) error {
-	key := fmt.Sprintf("pod-%s", podName)
-	_, err := daemon.db.Get([]byte(key), nil)
-	if err != nil {
-		err = daemon.db.Put([]byte(key), podData, nil)
-		if err != nil {
-			return err
-		}
-	} else {
-		err = daemon.db.Delete([]byte(key), nil)
-		if err != nil {
-			return err
-		}
-		err = daemon.db.Put([]byte(key), podData, nil)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (daemon *Daemon) GetPodByName(podName string) ([]byte, error) {
-	key := fmt.Sprintf("pod-%s", podName)
-	data, err := daemon.db.Get([]byte(key), nil)
+	rec, err := daemon.store.Pod(podName)
 	if err != nil {
 		return []byte(""), err
 	}
-	return data, nil
+	return rec.Spec, nil
 }
 
+// DeletePodFromDB removes the records of a pod at once, with the ones of
+// its VM and its volumes. The dm devices of the volumes are removed first.
 func (daemon *Daemon) DeletePodFromDB(podName string) error {
-	key := fmt.Sprintf("pod-%s", podName)
-	err := daemon.db.Delete([]byte(key), nil)
-	if err != nil {
+	rec, err := daemon.store.Pod(podName)
+	if err != nil && err != leveldb.ErrNotFound {
 		return err
 	}
-	return nil
+	b := &storeBatch{}
+	if err := daemon.deleteVolumes(podName, b); err != nil {
+		return err
+	}
+	if rec != nil && rec.Vm != "" {
+		b.DeleteVm(rec.Vm)
+	}
+	b.DeletePod(podName)
+	return daemon.store.Write(b)
 }
 
 func (daemon *Daemon) SetVolumeId(podId, volName, dev_id string) error {
-	key := fmt.Sprintf("vol-%s-%s", podId, dev_id)
-	err := daemon.db.Put([]byte(key), []byte(fmt.Sprintf("%s:%s", volName, dev_id)), nil)
+	devId, err := strconv.Atoi(dev_id)
 	if err != nil {
 		return err
 	}
-	return nil
+	b := &storeBatch{}
+	if err := b.PutVolume(&volumeRecord{Pod: podId, Name: volName, DevId: devId}); err != nil {
+		return err
+	}
+	return daemon.store.Write(b)
 }
 
 func (daemon *Daemon) GetMaxDeviceId() (int, error) {
-	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("vol-")), nil)
-	maxId := 1
-	for iter.Next() {
-		value := iter.Value()
-		fields := strings.Split(string(value), ":")
-		id, _ := strconv.Atoi(fields[1])
-		if id > maxId {
-			maxId = id
-		}
-	}
-	iter.Release()
-	err := iter.Error()
+	volumes, err := daemon.store.Volumes("")
 	if err != nil {
 		return -1, err
 	}
+	maxId := 1
+	for _, v := range volumes {
+		if v.DevId > maxId {
+			maxId = v.DevId
+		}
+	}
 	return maxId, nil
 }
 
 func (daemon *Daemon) GetVolumeId(podId, volName string) (int, error) {
-	dev_id := 0
-	key := fmt.Sprintf("vol-%s", podId)
-	iter := daemon.db.NewIterator(util.BytesPrefix([]byte(key)), nil)
-	for iter.Next() {
-		value := iter.Value()
-		fields := strings.Split(string(value), ":")
-		if fields[0] == volName {
-			dev_id, _ = strconv.Atoi(fields[1])
-		}
-	}
-	iter.Release()
-	err := iter.Error()
+	volumes, err := daemon.store.Volumes(podId)
 	if err != nil {
 		return -1, err
 	}
+	dev_id := 0
+	for _, v := range volumes {
+		if v.Name == volName {
+			dev_id = v.DevId
+		}
+	}
 	return dev_id, nil
 }
 
 func (daemon *Daemon) DeleteVolumeId(podId string) error {
-	key := fmt.Sprintf("vol-%s", podId)
-	iter := daemon.db.NewIterator(util.BytesPrefix([]byte(key)), nil)
-	for iter.Next() {
-		value := iter.Key()
-		if string(value)[4:18] == podId {
-			fields := strings.Split(string(iter.Value()), ":")
-			dev_id, _ := strconv.Atoi(fields[1])
-			if err := dm.DeleteVolume(daemon.Storage.DmPoolData, dev_id); err != nil {
-				glog.Error(err.Error())
-				return err
-			}
-		}
-		err := daemon.db.Delete(value, nil)
-		if err != nil {
-			return err
-		}
-	}
-	iter.Release()
-	err := iter.Error()
-	if err != nil {
+	b := &storeBatch{}
+	if err := daemon.deleteVolumes(podId, b); err != nil {
 		return err
 	}
-	return nil
+	return daemon.store.Write(b)
 }
-func (daemon *Daemon) WritePodAndContainers(podName string) error {
-	key := fmt.Sprintf("pod-container-%s", podName)
-	value := ""
-	for _, c := range daemon.podList[podName].Containers {
-		if value == "" {
-			value = c.Id
-		} else {
-			value = value + ":" + c.Id
-		}
-	}
-	err := daemon.db.Put([]byte(key), []byte(value), nil)
+
+// deleteVolumes removes the dm devices of the volumes of a pod, and puts
+// the removal of their records in the batch.
+func (daemon *Daemon) deleteVolumes(podId string, b *storeBatch) error {
+	volumes, err := daemon.store.Volumes(podId)
 	if err != nil {
 		return err
 	}
+	for _, v := range volumes {
+		if err := dm.DeleteVolume(daemon.Storage.DmPoolData, v.DevId); err != nil {
+			glog.Error(err.Error())
+			return err
+		}
+		b.DeleteVolume(v)
+	}
 	return nil
 }
 
+func containerIds(containers []*Container) []string {
+	ids := make([]string, len(containers))
+	for i, c := range containers {
+		ids[i] = c.Id
+	}
+	return ids
+}
+
 func (daemon *Daemon) GetPodContainersByName(podName string) ([]string, error) {
-	key := fmt.Sprintf("pod-container-%s", podName)
-	data, err := daemon.db.Get([]byte(key), nil)
+	rec, err := daemon.store.Pod(podName)
 	if err != nil {
 		return nil, err
 	}
-	containers := strings.Split(string(data), ":")
-	return containers, nil
+	if len(rec.Containers) == 0 {
+		return nil, fmt.Errorf("Can not find the containers of pod %s", podName)
+	}
+	return rec.Containers, nil
 }
 
 func (daemon *Daemon) DeletePodContainerFromDB(podName string) error {
-	key := fmt.Sprintf("pod-container-%s", podName)
-	err := daemon.db.Delete([]byte(key), nil)
-	if err != nil {
-		return err
+	err := daemon.store.updatePod(podName, func(rec *podRecord, b *storeBatch) error {
+		rec.Containers = nil
+		return nil
+	})
+	if err == leveldb.ErrNotFound {
+		return nil
 	}
-	return nil
+	return err
 }
 
 func (daemon *Daemon) GetVmByPod(podId string) (string, error) {
-	key := fmt.Sprintf("vm-%s", podId)
-	data, err := daemon.db.Get([]byte(key), nil)
+	rec, err := daemon.store.Pod(podId)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	if rec.Vm == "" {
+		return "", fmt.Errorf("Can not find the VM of pod %s", podId)
+	}
+	return rec.Vm, nil
 }
 
-func (daemon *Daemon) UpdateVmByPod(podId, vmId string) error {
+// UpdateVmByPod stores the VM running a pod with its persist info
+func (daemon *Daemon) UpdateVmByPod(podId, vmId string, data []byte) error {
 	glog.V(1).Infof("Add or Update the VM info for pod(%s)", podId)
-	key := fmt.Sprintf("vm-%s", podId)
-	_, err := daemon.db.Get([]byte(key), nil)
-	if err == nil {
-		err = daemon.db.Delete([]byte(key), nil)
-		if err != nil {
-			return err
-		}
-		err = daemon.db.Put([]byte(key), []byte(vmId), nil)
-		if err != nil {
-			return err
-		}
-	} else {
-		err = daemon.db.Put([]byte(key), []byte(vmId), nil)
-		if err != nil {
-			return err
+	err := daemon.store.updatePod(podId, func(rec *podRecord, b *storeBatch) error {
+		if rec.Vm != "" && rec.Vm != vmId {
+			b.DeleteVm(rec.Vm)
 		}
+		rec.Vm = vmId
+		return b.PutVm(&vmRecord{Id: vmId, Pod: podId, Data: data})
+	})
+	if err != nil {
+		return err
 	}
 	glog.V(1).Infof("success to add or  update the VM info for pod(%s)", podId)
 	return nil
 }
 
 func (daemon *Daemon) DeleteVmByPod(podId string) error {
-	key := fmt.Sprintf("vm-%s", podId)
-	vmId, err := daemon.db.Get([]byte(key), nil)
-	if err != nil {
-		return err
-	}
-	err = daemon.db.Delete([]byte(key), nil)
+	err := daemon.store.updatePod(podId, func(rec *podRecord, b *storeBatch) error {
+		if rec.Vm == "" {
+			return fmt.Errorf("Can not find the VM of pod %s", podId)
+		}
+		b.DeleteVm(rec.Vm)
+		rec.Vm = ""
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if err = daemon.DeleteVmData(string(vmId)); err != nil {
-		return err
-	}
 	glog.V(1).Infof("success to delete the VM info for pod(%s)", podId)
 	return nil
 }
 
 func (daemon *Daemon) GetPodVmByName(podName string) (string, error) {
-	pod := daemon.podList[podName]
+	pod := daemon.registry.Pod(podName)
 	if pod == nil {
 		return "", fmt.Errorf("Not found VM for pod(%s)", podName)
 	}
-	return pod.Vm, nil
+	return pod.Vm(), nil
 }
 
-func (daemon *Daemon) GetQemuChan(vmid string) (interface{}, interface{}, interface{}, error) {
-	if daemon.qemuChan[vmid] != nil && daemon.qemuClientChan[vmid] != nil {
-		return daemon.qemuChan[vmid], daemon.qemuClientChan[vmid], daemon.subQemuClientChan[vmid], nil
-	}
-	return nil, nil, nil, fmt.Errorf("Can not find the Qemu chan for pod: %s!", vmid)
+func (daemon *Daemon) GetQemuChan(vmid string) (*QemuChan, error) {
+	return daemon.registry.QemuChan(vmid)
 }
 
 func (daemon *Daemon) DeleteQemuChan(vmid string) error {
-	if daemon.qemuChan[vmid] != nil {
-		delete(daemon.qemuChan, vmid)
-	}
-	if daemon.qemuClientChan[vmid] != nil {
-		delete(daemon.qemuClientChan, vmid)
-	}
-	if daemon.subQemuClientChan[vmid] != nil {
-		delete(daemon.subQemuClientChan, vmid)
-	}
-
+	daemon.registry.DeleteQemuChan(vmid)
 	return nil
 }
 
-func (daemon *Daemon) SetQemuChan(vmid string, qemuchan, qemuclient, subQemuClient interface{}) error {
-	if daemon.qemuChan[vmid] == nil {
-		if qemuchan != nil {
-			daemon.qemuChan[vmid] = qemuchan
-		}
-		if qemuclient != nil {
-			daemon.qemuClientChan[vmid] = qemuclient
-		}
-		if subQemuClient != nil {
-			daemon.subQemuClientChan[vmid] = subQemuClient
-		}
-		return nil
-	}
-	return fmt.Errorf("Already find a Qemu chan for vm: %s!", vmid)
+func (daemon *Daemon) SetQemuChan(vmid string, qemuChan *QemuChan) error {
+	return daemon.registry.SetQemuChan(vmid, qemuChan)
 }
 
 func (daemon *Daemon) SetPodByContainer(containerId, podId, name, image string, cmds []string, status uint) error {
@@ -637,111 +601,115 @@ func (daemon *Daemon) SetPodByContainer(containerId, podId, name, image string,
 		PodId:  podId,
 		Image:  image,
 		Cmds:   cmds,
-		Status: status,
+		status: status,
 	}
-	daemon.containerList = append(daemon.containerList, container)
+	daemon.registry.AddContainer(container)
 
 	return nil
 }
 
 func (daemon *Daemon) GetPodByContainer(containerId string) (string, error) {
-	var c *Container
-	for _, c = range daemon.containerList {
-		if c.Id == containerId {
-			break
-		}
-	}
-	if c.Id != containerId {
+	c := daemon.registry.Container(containerId)
+	if c == nil {
 		return "", fmt.Errorf("Can not find that container!")
 	}
 
 	return c.PodId, nil
 }
 
-func (daemon *Daemon) AddPod(pod *Pod) {
-	daemon.podList[pod.Id] = pod
+// ResolvePod returns the id of the pod a user refers to, by its id, its
+// name or an unambiguous prefix of its id
+func (daemon *Daemon) ResolvePod(ref string) (string, error) {
+	p, err := daemon.registry.LookupPod(ref)
+	if err != nil {
+		return "", err
+	}
+	return p.Id, nil
 }
 
-func (daemon *Daemon) RemovePod(podId string) {
-	for _, c := range daemon.podList[podId].Containers {
-		for i, cl := range daemon.containerList {
-			if cl.Id == c.Id {
-				daemon.containerList = append(daemon.containerList[:i], daemon.containerList[i+1:]...)
-			}
-		}
+// resolveContainer returns the container a user refers to, by its id, or
+// by its pod for the first container of the pod, and the id of the pod
+func (daemon *Daemon) resolveContainer(ref string) (string, string, error) {
+	if c := daemon.registry.Container(ref); c != nil {
+		return c.Id, c.PodId, nil
 	}
-	delete(daemon.podList, podId)
+	podId, err := daemon.ResolvePod(ref)
+	if err != nil {
+		return "", "", fmt.Errorf("Can not find that container or pod(%s)", ref)
+	}
+	containers := daemon.registry.PodContainers(podId)
+	if len(containers) == 0 {
+		return "", "", fmt.Errorf("The pod(%s) has no container", podId)
+	}
+	return containers[0].Id, podId, nil
+}
+
+func (daemon *Daemon) AddPod(pod *Pod) error {
+	return daemon.registry.AddPod(pod)
+}
+
+func (daemon *Daemon) RemovePod(podId string) {
+	daemon.registry.RemovePod(podId)
 }
 
 func (daemon *Daemon) AddVm(vm *Vm) {
-	daemon.vmList[vm.Id] = vm
+	daemon.commitVm(vm)
+	daemon.registry.AddVm(vm)
 }
 
 func (daemon *Daemon) RemoveVm(vmId string) {
-	delete(daemon.vmList, vmId)
+	daemon.registry.RemoveVm(vmId)
+	daemon.releaseVm(vmId)
 }
 
 func (daemon *Daemon) SetContainerStatus(podId string, status uint) {
-	for _, c := range daemon.podList[podId].Containers {
-		c.Status = status
+	if mypod := daemon.registry.Pod(podId); mypod != nil {
+		for _, c := range mypod.Containers() {
+			c.SetStatus(status)
+		}
 	}
 }
 
 func (daemon *Daemon) SetPodContainerStatus(podId string, data []uint32) {
+	mypod := daemon.registry.Pod(podId)
+	if mypod == nil {
+		return
+	}
 	failure := 0
-	for i, c := range daemon.podList[podId].Containers {
+	reason := "succeeded"
+	vmId := mypod.Vm()
+	for i, c := range mypod.Containers() {
+		if i >= len(data) {
+			break
+		}
 		if data[i] != 0 {
+			if failure == 0 {
+				reason = fmt.Sprintf("container %s exit code %d", c.Name, data[i])
+			}
 			failure++
-			c.Status = types.S_POD_FAILED
+			c.SetStatus(types.S_POD_FAILED)
 		} else {
-			c.Status = types.S_POD_SUCCEEDED
+			c.SetStatus(types.S_POD_SUCCEEDED)
 		}
+		c.Exited(int(data[i]))
+		daemon.LogEvent("container", "die", c.Id, podId, vmId, fmt.Sprintf("exit code %d", data[i]))
 	}
+	daemon.podExited(podId, reason)
 	if failure == 0 {
-		daemon.podList[podId].Status = types.S_POD_SUCCEEDED
+		mypod.SetStatus(types.S_POD_SUCCEEDED)
+		daemon.LogPodEvent(podId, "finish", "")
 	} else {
-		daemon.podList[podId].Status = types.S_POD_FAILED
+		mypod.SetStatus(types.S_POD_FAILED)
+		daemon.LogPodEvent(podId, "fail", fmt.Sprintf("%d containers failed", failure))
 	}
 }
 
-func (daemon *Daemon) UpdateVmData(vmId string, data []byte) error {
-	key := fmt.Sprintf("vmdata-%s", vmId)
-	_, err := daemon.db.Get([]byte(key), nil)
-	if err == nil {
-		err = daemon.db.Delete([]byte(key), nil)
-		if err != nil {
-			return err
-		}
-		err = daemon.db.Put([]byte(key), data, nil)
-		if err != nil {
-			return err
-		}
-	}
-	if err != nil && strings.Contains(err.Error(), "not found") {
-		err = daemon.db.Put([]byte(key), data, nil)
-		if err != nil {
-			return err
-		}
-	}
-	return err
-}
-
 func (daemon *Daemon) GetVmData(vmId string) ([]byte, error) {
-	key := fmt.Sprintf("vmdata-%s", vmId)
-	data, err := daemon.db.Get([]byte(key), nil)
+	rec, err := daemon.store.Vm(vmId)
 	if err != nil {
 		return []byte(""), err
 	}
-	return data, nil
-}
-
-func (daemon *Daemon) DeleteVmData(vmId string) error {
-	key := fmt.Sprintf("vmdata-%s", vmId)
-	err := daemon.db.Delete([]byte(key), nil)
-	if err != nil {
-		return err
-	}
-	return nil
+	return rec.Data, nil
 }
 
 // If the stop is 1, we do not delete the pool data. Or just delete it.
@@ -756,17 +724,26 @@ func (daemon *Daemon) CleanVolume(stop int) error {
 
 func (daemon *Daemon) DestroyAllVm() error {
 	glog.V(0).Info("The daemon will stop all pod")
-	for _, pod := range daemon.podList {
+	for _, pod := range daemon.registry.Pods() {
 		daemon.StopPod(pod.Id, "yes")
 	}
-	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("vm-")), nil)
-	for iter.Next() {
-		key := iter.Key()
-		daemon.db.Delete(key, nil)
+	// the VMs are gone, so are their records
+	pods, err := daemon.store.Pods()
+	if err != nil {
+		return err
 	}
-	iter.Release()
-	err := iter.Error()
-	return err
+	b := &storeBatch{}
+	for _, rec := range pods {
+		if rec.Vm == "" {
+			continue
+		}
+		b.DeleteVm(rec.Vm)
+		rec.Vm = ""
+		if err := b.PutPod(rec); err != nil {
+			return err
+		}
+	}
+	return daemon.store.Write(b)
 }
 
 func (daemon *Daemon) DestroyAndKeepVm() error {
@@ -784,8 +761,8 @@ func (daemon *Daemon) DestroyAndKeepVm() error {
 func (daemon *Daemon) shutdown() error {
 	glog.V(0).Info("The daemon will be shutdown")
 	glog.V(0).Info("Shutdown all VMs")
-	for vm, _ := range daemon.vmList {
-		daemon.KillVm(vm)
+	for _, vm := range daemon.registry.Vms() {
+		daemon.KillVm(vm.Id)
 	}
 	daemon.db.Close()
 	glog.Flush()
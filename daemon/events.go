@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+)
+
+const (
+	// the events kept for the subscribers asking for the past ones
+	eventHistory = 1024
+	// a newline is sent to an idle subscriber at this interval, to find out
+	// the subscribers which are gone
+	eventKeepalive = 30 * time.Second
+)
+
+// Event is a change in the lifecycle of a pod, VM, container, exec or
+// volume, the events are streamed to the clients of /events
+type Event struct {
+	Time   int64  `json:"time"` //unix time in nanoseconds
+	Type   string `json:"type"` //pod, vm, container, exec or volume
+	Action string `json:"action"`
+	ID     string `json:"id"`
+	Pod    string `json:"pod,omitempty"`
+	Vm     string `json:"vm,omitempty"`
+	Info   string `json:"info,omitempty"`
+}
+
+type eventLog struct {
+	sync.Mutex
+	history []*Event
+	subs    map[chan *Event]bool
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		history: []*Event{},
+		subs:    make(map[chan *Event]bool),
+	}
+}
+
+func (l *eventLog) publish(ev *Event) {
+	l.Lock()
+	defer l.Unlock()
+	if len(l.history) >= eventHistory {
+		l.history = l.history[1:]
+	}
+	l.history = append(l.history, ev)
+	for ch := range l.subs {
+		select {
+		case ch <- ev:
+		default:
+			glog.Warningf("event subscriber is too slow, drop event %s %s", ev.Type, ev.Action)
+		}
+	}
+}
+
+// subscribe returns the events since the time and a chan of the following
+// ones
+func (l *eventLog) subscribe(since int64) ([]*Event, chan *Event) {
+	l.Lock()
+	defer l.Unlock()
+	past := []*Event{}
+	for _, ev := range l.history {
+		if ev.Time >= since {
+			past = append(past, ev)
+		}
+	}
+	ch := make(chan *Event, 128)
+	l.subs[ch] = true
+	return past, ch
+}
+
+func (l *eventLog) unsubscribe(ch chan *Event) {
+	l.Lock()
+	delete(l.subs, ch)
+	l.Unlock()
+}
+
+// LogEvent records an event of an object, the pod and the VM of the event
+// are optional.
+func (daemon *Daemon) LogEvent(typ, action, id, podId, vmId, info string) {
+	ev := &Event{
+		Time:   time.Now().UnixNano(),
+		Type:   typ,
+		Action: action,
+		ID:     id,
+		Pod:    podId,
+		Vm:     vmId,
+		Info:   info,
+	}
+	glog.V(1).Infof("event: %s %s %s %s", typ, action, id, info)
+	daemon.events.publish(ev)
+}
+
+// LogPodEvent records an event of a pod on its current VM
+func (daemon *Daemon) LogPodEvent(podId, action, info string) {
+	vmId := ""
+	if p := daemon.registry.Pod(podId); p != nil {
+		vmId = p.Vm()
+	}
+	daemon.LogEvent("pod", action, podId, podId, vmId, info)
+}
+
+type eventFilter map[string][]string
+
+func parseEventFilter(filters []string) (eventFilter, error) {
+	f := eventFilter{}
+	for _, kv := range filters {
+		fields := strings.SplitN(kv, "=", 2)
+		if len(fields) != 2 || fields[1] == "" {
+			return nil, fmt.Errorf("Invalid filter %q, should be KEY=VALUE", kv)
+		}
+		switch fields[0] {
+		case "type", "action", "id", "pod", "vm":
+		default:
+			return nil, fmt.Errorf("Invalid filter key %q, should be one of type, action, id, pod and vm", fields[0])
+		}
+		f[fields[0]] = append(f[fields[0]], fields[1])
+	}
+	return f, nil
+}
+
+// match checks an event against the filter, the values of a key are OR-ed
+// and the keys are AND-ed. A pod could be given by its name as well.
+func (daemon *Daemon) matchEvent(f eventFilter, ev *Event) bool {
+	for key, values := range f {
+		var field string
+		switch key {
+		case "type":
+			field = ev.Type
+		case "action":
+			field = ev.Action
+		case "id":
+			field = ev.ID
+		case "pod":
+			field = ev.Pod
+		case "vm":
+			field = ev.Vm
+		}
+		matched := false
+		for _, v := range values {
+			if v == field || (key == "pod" && field != "" && daemon.podNameOf(field) == v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (daemon *Daemon) podNameOf(podId string) string {
+	if p := daemon.registry.Pod(podId); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+// CmdEvents streams the events as JSON objects, the ones since the unix
+// time of env "since" are sent first. It returns once the client is gone.
+func (daemon *Daemon) CmdEvents(job *engine.Job) error {
+	var since int64
+	if s := job.Getenv("since"); s != "" {
+		secs, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid since %q, should be a unix time", s)
+		}
+		since = time.Unix(secs, 0).UnixNano()
+	} else {
+		since = time.Now().UnixNano()
+	}
+	filter, err := parseEventFilter(job.GetenvList("filter"))
+	if err != nil {
+		return err
+	}
+
+	past, ch := daemon.events.subscribe(since)
+	defer daemon.events.unsubscribe(ch)
+
+	// let the client know the stream is open
+	if _, err := job.Stdout.Write([]byte("\n")); err != nil {
+		return nil
+	}
+	enc := json.NewEncoder(job.Stdout)
+	send := func(ev *Event) error {
+		if !daemon.matchEvent(filter, ev) {
+			return nil
+		}
+		return enc.Encode(ev)
+	}
+
+	for _, ev := range past {
+		if err := send(ev); err != nil {
+			return nil
+		}
+	}
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case ev := <-ch:
+			err = send(ev)
+		case <-keepalive.C:
+			_, err = job.Stdout.Write([]byte("\n"))
+		}
+		if err != nil {
+			glog.V(1).Infof("event subscriber is gone: %s", err.Error())
+			return nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEventFilter(t *testing.T) {
+	for _, c := range []struct {
+		filters []string
+		want    eventFilter
+	}{
+		{nil, eventFilter{}},
+		{[]string{"type=pod"}, eventFilter{"type": {"pod"}}},
+		{[]string{"type=pod", "type=vm", "action=start"}, eventFilter{"type": {"pod", "vm"}, "action": {"start"}}},
+		// only the first = splits
+		{[]string{"id=a=b"}, eventFilter{"id": {"a=b"}}},
+	} {
+		f, err := parseEventFilter(c.filters)
+		if err != nil {
+			t.Errorf("%v is not parsed: %v", c.filters, err)
+			continue
+		}
+		if !reflect.DeepEqual(f, c.want) {
+			t.Errorf("%v is parsed as %v, should be %v", c.filters, f, c.want)
+		}
+	}
+	for _, filters := range [][]string{
+		{"type"},
+		{"type="},
+		{"=pod"},
+		{"color=red"},
+		{"type=pod", "bad"},
+	} {
+		if _, err := parseEventFilter(filters); err == nil {
+			t.Errorf("%v is parsed, it is invalid", filters)
+		}
+	}
+}
+
+func TestMatchEvent(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+	daemon.registry.AddPod(&Pod{Id: "pod-aaaaaaaaaa", Name: "web"})
+
+	ev := &Event{Type: "container", Action: "start", ID: "c1", Pod: "pod-aaaaaaaaaa", Vm: "vm-bbbbbbbbbb"}
+	for _, c := range []struct {
+		filters []string
+		match   bool
+	}{
+		{nil, true},
+		{[]string{"type=container"}, true},
+		{[]string{"type=pod"}, false},
+		{[]string{"type=pod", "type=container"}, true},
+		{[]string{"type=container", "action=stop"}, false},
+		{[]string{"type=container", "action=start", "id=c1"}, true},
+		{[]string{"vm=vm-bbbbbbbbbb"}, true},
+		{[]string{"pod=pod-aaaaaaaaaa"}, true},
+		// a pod could be given by its name
+		{[]string{"pod=web"}, true},
+		{[]string{"pod=db"}, false},
+	} {
+		f, err := parseEventFilter(c.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if daemon.matchEvent(f, ev) != c.match {
+			t.Errorf("filter %v matches %v, should be %v", c.filters, !c.match, c.match)
+		}
+	}
+
+	// an event without pod does not match a pod name
+	f, _ := parseEventFilter([]string{"pod=web"})
+	if daemon.matchEvent(f, &Event{Type: "vm", Action: "boot", ID: "vm-b"}) {
+		t.Error("an event without pod matches a pod filter")
+	}
+}
+
+func TestEventHistory(t *testing.T) {
+	l := newEventLog()
+	for i := 1; i <= eventHistory+2; i++ {
+		l.publish(&Event{Time: int64(i), Type: "pod", Action: "start"})
+	}
+
+	// the oldest ones are dropped
+	past, ch := l.subscribe(0)
+	l.unsubscribe(ch)
+	if len(past) != eventHistory || past[0].Time != 3 || past[len(past)-1].Time != eventHistory+2 {
+		t.Errorf("%d events are kept, from %d", len(past), past[0].Time)
+	}
+
+	for _, c := range []struct {
+		since int64
+		count int
+	}{
+		// since is inclusive
+		{eventHistory + 2, 1},
+		{eventHistory + 1, 2},
+		{eventHistory + 3, 0},
+		{3, eventHistory},
+	} {
+		past, ch := l.subscribe(c.since)
+		l.unsubscribe(ch)
+		if len(past) != c.count {
+			t.Errorf("%d events since %d, should be %d", len(past), c.since, c.count)
+		}
+	}
+
+	// the following events go to the subscribers
+	past, ch = l.subscribe(eventHistory + 3)
+	l.publish(&Event{Time: eventHistory + 3, Type: "pod", Action: "stop"})
+	if ev := <-ch; len(past) != 0 || ev.Action != "stop" {
+		t.Errorf("the subscriber gets %v", ev)
+	}
+	l.unsubscribe(ch)
+	l.publish(&Event{Time: eventHistory + 4, Type: "pod", Action: "start"})
+	select {
+	case ev := <-ch:
+		t.Errorf("an unsubscribed chan gets %v", ev)
+	default:
+	}
+}
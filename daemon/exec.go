@@ -3,6 +3,8 @@ package daemon
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"hyper/engine"
 	"hyper/hypervisor"
@@ -10,6 +12,10 @@ import (
 	"hyper/types"
 )
 
+// exitCodeKeep is how long the exit code of a finished exec waits for its
+// client, the code of a client which never asks is dropped then
+var exitCodeKeep = 5 * time.Minute
+
 func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not execute 'exec' command without any container ID!")
@@ -36,12 +42,11 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 	if typeKey == "pod" {
 		vmId = typeVal
 	} else {
-		container := typeVal
-		glog.V(1).Infof("Get container id is %s", container)
-		podId, err = daemon.GetPodByContainer(container)
+		typeVal, podId, err = daemon.resolveContainer(typeVal)
 		if err != nil {
 			return
 		}
+		glog.V(1).Infof("Get container id is %s", typeVal)
 		vmId, err = daemon.GetPodVmByName(podId)
 	}
 
@@ -51,6 +56,10 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 
 	execCmd := &hypervisor.ExecCommand{
 		Command: command,
+		Env:     job.GetenvList("env"),
+		Workdir: job.Getenv("workdir"),
+		User:    job.Getenv("user"),
+		Tty:     job.GetenvBool("tty"),
 		Streams: &hypervisor.TtyIO{
 			Stdin:     job.Stdin,
 			Stdout:    job.Stdout,
@@ -59,22 +68,121 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 		},
 	}
 
+	if job.GetenvBool("multiplexed") {
+		execCmd.Streams.Stdout, execCmd.Streams.Stderr = multiplexStreams(job.Stdout)
+	}
+	if execCmd.Streams.DetachKeys, err = hypervisor.ParseDetachKeys(job.Getenv("detachKeys")); err != nil {
+		return err
+	}
+	if podId == "" {
+		if vm := daemon.registry.Vm(vmId); vm != nil && vm.Pod != nil {
+			podId = vm.Pod.Id
+		}
+	}
+	execCmd.Streams.Recorder, err = daemon.sessionRecorder(podId, "exec "+typeVal+" "+strings.Join(command, " "))
+	if err != nil {
+		return err
+	}
+
 	if typeKey == "pod" {
 		execCmd.Container = ""
 	} else {
 		execCmd.Container = typeVal
 	}
 
-	qemuEvent, _, _, err := daemon.GetQemuChan(vmId)
+	qemuChan, err := daemon.GetQemuChan(vmId)
 	if err != nil {
 		return err
 	}
 
-	qemuEvent.(chan hypervisor.VmEvent) <- execCmd
+	daemon.RegisterExec(tag)
+	qemuChan.Event <- execCmd
+	daemon.LogEvent("exec", "start", typeVal, podId, vmId, strings.Join(command, " "))
 
-	<-execCmd.Streams.Callback
+	res := <-execCmd.Streams.Callback
+	code, err := execExitCode(res)
+	daemon.SetExitCode(tag, code)
+	daemon.LogEvent("exec", "finish", typeVal, podId, vmId, fmt.Sprintf("exit code %d", code))
 	defer func() {
 		glog.V(2).Info("Defer function for exec!")
 	}()
+	return err
+}
+
+// execExitCode returns the exit code of an exec from its result. An exec
+// which failed, or whose code the init could not report, never looks
+// successful to its client, it gets ExecStartFailed.
+func execExitCode(res *types.QemuResponse) (int, error) {
+	if res.Code != types.E_EXEC_FINISH {
+		return hypervisor.ExecStartFailed, fmt.Errorf("Exec failed: %s", res.Cause)
+	}
+	code, ok := res.Data.(int)
+	if !ok {
+		return hypervisor.ExecStartFailed, nil
+	}
+	return code, nil
+}
+
+func (daemon *Daemon) CmdExitCode(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not get exit code without the client tag!")
+	}
+	code, err := daemon.GetExitCode(job.Args[0])
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.SetInt("ExitCode", code)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
 	return nil
 }
+
+// The exit code of an exec is kept until its client fetches it. The exec
+// stream is closed before the code is known, so the client may come to ask
+// a bit early and has to wait for it. A code nobody fetched is dropped
+// exitCodeKeep after the exec finishes.
+func (daemon *Daemon) RegisterExec(tag string) {
+	daemon.exitLock.Lock()
+	daemon.exitCodes[tag] = make(chan int, 1)
+	daemon.exitLock.Unlock()
+}
+
+func (daemon *Daemon) SetExitCode(tag string, code int) {
+	daemon.exitLock.Lock()
+	if ch, ok := daemon.exitCodes[tag]; ok {
+		ch <- code
+		time.AfterFunc(exitCodeKeep, func() {
+			daemon.exitLock.Lock()
+			if daemon.exitCodes[tag] == ch {
+				delete(daemon.exitCodes, tag)
+			}
+			daemon.exitLock.Unlock()
+		})
+	}
+	daemon.exitLock.Unlock()
+}
+
+func (daemon *Daemon) GetExitCode(tag string) (int, error) {
+	daemon.exitLock.Lock()
+	ch, ok := daemon.exitCodes[tag]
+	daemon.exitLock.Unlock()
+	if !ok {
+		return -1, fmt.Errorf("Can not find the exit code of %s", tag)
+	}
+
+	defer func() {
+		daemon.exitLock.Lock()
+		delete(daemon.exitCodes, tag)
+		daemon.exitLock.Unlock()
+	}()
+
+	select {
+	case code := <-ch:
+		return code, nil
+	case <-time.After(10 * time.Second):
+		return -1, fmt.Errorf("Timeout to wait the exit code of %s", tag)
+	}
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"hyper/hypervisor"
+	"hyper/types"
+)
+
+func TestExitCode(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	if _, err := daemon.GetExitCode("unknown"); err == nil {
+		t.Error("got the exit code of an unknown tag")
+	}
+
+	daemon.RegisterExec("tag1")
+	daemon.SetExitCode("tag1", 3)
+	if code, err := daemon.GetExitCode("tag1"); err != nil || code != 3 {
+		t.Errorf("the exit code is %d %v, should be 3", code, err)
+	}
+	if _, err := daemon.GetExitCode("tag1"); err == nil {
+		t.Error("the exit code is fetched twice")
+	}
+
+	// the code is set after the client comes
+	daemon.RegisterExec("tag2")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		daemon.SetExitCode("tag2", 5)
+	}()
+	if code, err := daemon.GetExitCode("tag2"); err != nil || code != 5 {
+		t.Errorf("the exit code is %d %v, should be 5", code, err)
+	}
+}
+
+func TestExitCodeExpire(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	keep := exitCodeKeep
+	exitCodeKeep = 10 * time.Millisecond
+	defer func() { exitCodeKeep = keep }()
+
+	daemon.RegisterExec("tag")
+	daemon.SetExitCode("tag", 1)
+	time.Sleep(50 * time.Millisecond)
+	daemon.exitLock.Lock()
+	_, ok := daemon.exitCodes["tag"]
+	daemon.exitLock.Unlock()
+	if ok {
+		t.Error("the exit code nobody fetched is kept")
+	}
+}
+
+func TestExecExitCode(t *testing.T) {
+	for _, c := range []struct {
+		res  *types.QemuResponse
+		code int
+		fail bool
+	}{
+		{&types.QemuResponse{Code: types.E_EXEC_FINISH, Data: 0}, 0, false},
+		{&types.QemuResponse{Code: types.E_EXEC_FINISH, Data: 2}, 2, false},
+		// the init could not report the code
+		{&types.QemuResponse{Code: types.E_EXEC_FINISH}, hypervisor.ExecStartFailed, false},
+		// the command could not be sent, the data is the session
+		{&types.QemuResponse{Code: types.E_JSON_PARSE_FAIL, Data: uint64(3)}, hypervisor.ExecStartFailed, true},
+	} {
+		code, err := execExitCode(c.res)
+		if code != c.code || (err != nil) != c.fail {
+			t.Errorf("%v gives %d %v, should be %d, failed %v", c.res, code, err, c.code, c.fail)
+		}
+	}
+}
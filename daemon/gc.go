@@ -0,0 +1,469 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"hyper/docker"
+	"hyper/engine"
+	"hyper/hypervisor"
+	"hyper/lib/glog"
+	"hyper/network"
+	dm "hyper/storage/devicemapper"
+)
+
+const (
+	// how often the orphans are collected once hyperd is up
+	gcInterval = 10 * time.Minute
+	// the dirs and the containers younger than this are left alone, they
+	// may belong to a VM or a pod being set up
+	gcGrace = 2 * time.Minute
+	// the dm devices of the volumes are named after their pods
+	volumeDevicePrefix = "hyper-volume-pool-"
+)
+
+// orphan is a resource left by a VM or a pod which is gone
+type orphan struct {
+	Kind   string
+	Id     string
+	remove func() error
+}
+
+func (o *orphan) String() string {
+	return o.Kind + " " + o.Id
+}
+
+// collector finds and removes the orphans, one collection at a time
+type collector struct {
+	sync.Mutex
+	// the orphans found by the last collection, a collection only removes
+	// the orphans found twice in a row, as the resources of a VM or a pod
+	// are not set up all at once
+	suspects map[string]bool
+	// the pods whose records could not be restored, their records and
+	// resources are not orphans
+	unrestored map[string]bool
+}
+
+func newCollector() *collector {
+	return &collector{
+		suspects:   make(map[string]bool),
+		unrestored: make(map[string]bool),
+	}
+}
+
+// keepPod keeps the records and the resources of a pod which could not be
+// restored from the collection
+func (gc *collector) keepPod(podId string) {
+	gc.Lock()
+	gc.unrestored[podId] = true
+	gc.Unlock()
+}
+
+// liveSet is what the daemon holds when the orphans are looked for
+type liveSet struct {
+	pods       map[string]bool
+	vms        map[string]bool
+	containers map[string]bool
+	ips        map[string]bool
+}
+
+// liveResources returns the live set, the caller must hold the lock of the
+// collector
+func (daemon *Daemon) liveResources() *liveSet {
+	live := &liveSet{
+		pods:       make(map[string]bool),
+		vms:        make(map[string]bool),
+		containers: make(map[string]bool),
+		ips:        make(map[string]bool),
+	}
+	for _, p := range daemon.registry.Pods() {
+		live.pods[p.Id] = true
+		if vmId := p.Vm(); vmId != "" {
+			live.vms[vmId] = true
+		}
+	}
+	for podId := range daemon.gc.unrestored {
+		live.pods[podId] = true
+	}
+	// a pod being restarted is out of the registry for a while
+	daemon.restarts.Lock()
+	for podId := range daemon.restarts.pods {
+		live.pods[podId] = true
+	}
+	daemon.restarts.Unlock()
+
+	for _, vm := range daemon.registry.Vms() {
+		live.vms[vm.Id] = true
+	}
+	// a VM being booted has its channels before it is in the registry
+	for _, vmId := range daemon.registry.QemuChanIds() {
+		live.vms[vmId] = true
+	}
+	for _, c := range daemon.registry.Containers() {
+		live.containers[c.Id] = true
+	}
+	// the addresses of the VMs associated at the startup are not known by
+	// the allocator, they are in the persist info of the VMs
+	for vmId := range live.vms {
+		rec, err := daemon.store.Vm(vmId)
+		if err != nil || len(rec.Data) == 0 {
+			continue
+		}
+		var info hypervisor.PersistInfo
+		if err := json.Unmarshal(rec.Data, &info); err != nil {
+			continue
+		}
+		for _, nic := range info.NetworkList {
+			live.ips[nic.IpAddr] = true
+		}
+	}
+	return live
+}
+
+// findOrphans looks for the resources of the VMs and the pods which are not
+// live, the mounts are listed before the dirs they are in
+func (daemon *Daemon) findOrphans(live *liveSet) ([]*orphan, []error) {
+	var (
+		orphans = []*orphan{}
+		errs    = []error{}
+	)
+	for _, find := range []func(*liveSet) ([]*orphan, error){
+		daemon.orphanMounts,
+		daemon.orphanVmDirs,
+		daemon.orphanVolumes,
+		daemon.orphanContainers,
+		daemon.orphanTaps,
+		daemon.orphanPortMaps,
+		daemon.orphanRecords,
+	} {
+		found, err := find(live)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		orphans = append(orphans, found...)
+	}
+	return orphans, errs
+}
+
+// vmOfPath returns the VM whose home dir a path is in, or ""
+func vmOfPath(p string) string {
+	rel := strings.TrimPrefix(p, hypervisor.BaseDir+"/")
+	if rel == p || !strings.HasPrefix(rel, "vm-") {
+		return ""
+	}
+	return strings.SplitN(rel, "/", 2)[0]
+}
+
+// mountsUnder lists the mount points in a dir, the deepest first
+func mountsUnder(dir string) ([]string, error) {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && strings.HasPrefix(fields[1], dir+"/") {
+			mounts = append(mounts, fields[1])
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+	return mounts, scanner.Err()
+}
+
+func (daemon *Daemon) orphanMounts(live *liveSet) ([]*orphan, error) {
+	mounts, err := mountsUnder(hypervisor.BaseDir)
+	if err != nil {
+		return nil, err
+	}
+	orphans := []*orphan{}
+	for _, mp := range mounts {
+		if vmId := vmOfPath(mp); vmId != "" && !live.vms[vmId] {
+			mp := mp
+			orphans = append(orphans, &orphan{Kind: "mount", Id: mp, remove: func() error {
+				return syscall.Unmount(mp, syscall.MNT_DETACH)
+			}})
+		}
+	}
+	return orphans, nil
+}
+
+func (daemon *Daemon) orphanVmDirs(live *liveSet) ([]*orphan, error) {
+	entries, err := ioutil.ReadDir(hypervisor.BaseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	orphans := []*orphan{}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), "vm-") || live.vms[e.Name()] ||
+			time.Since(e.ModTime()) < gcGrace {
+			continue
+		}
+		dir := path.Join(hypervisor.BaseDir, e.Name())
+		orphans = append(orphans, &orphan{Kind: "vm-dir", Id: dir, remove: func() error {
+			// never remove the files of a volume still bound in the dir
+			if mounts, err := mountsUnder(dir); err != nil || len(mounts) > 0 {
+				return fmt.Errorf("%s is still mounted in %s", strings.Join(mounts, ", "), dir)
+			}
+			return os.RemoveAll(dir)
+		}})
+	}
+	return orphans, nil
+}
+
+// orphanVolumes finds the dm devices of the volumes of the pods which are
+// gone, the thin devices in the pool are freed with the records of the pods
+func (daemon *Daemon) orphanVolumes(live *liveSet) ([]*orphan, error) {
+	entries, err := ioutil.ReadDir("/dev/mapper")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	orphans := []*orphan{}
+	for _, e := range entries {
+		name := e.Name()
+		rest := strings.TrimPrefix(name, volumeDevicePrefix)
+		if rest == name || !strings.HasPrefix(rest, "pod-") || len(rest) < len("pod-")+10 {
+			continue
+		}
+		if live.pods[rest[:len("pod-")+10]] {
+			continue
+		}
+		orphans = append(orphans, &orphan{Kind: "volume", Id: name, remove: func() error {
+			return dm.RemoveDevice(name)
+		}})
+	}
+	return orphans, nil
+}
+
+// containerNameReg matches the names createContainer gives, the pod id and
+// a random suffix
+var containerNameReg = regexp.MustCompile(`^(pod-[a-zA-Z]{10})-[a-zA-Z0-9]{10}$`)
+
+// containerPod returns the pod a docker container is created for by hyperd,
+// or "" if the container is not one of hyperd. It must be named and labeled
+// by createContainer, the containers of the users could have the like names.
+func containerPod(c docker.ContainerSummary) string {
+	podId := c.Labels[docker.PodLabel]
+	if podId == "" {
+		return ""
+	}
+	for _, name := range c.Names {
+		m := containerNameReg.FindStringSubmatch(strings.TrimPrefix(name, "/"))
+		if m != nil && m[1] == podId {
+			return podId
+		}
+	}
+	return ""
+}
+
+// orphanContainers finds the docker containers created for the pods which
+// are gone
+func (daemon *Daemon) orphanContainers(live *liveSet) ([]*orphan, error) {
+	containers, err := daemon.dockerCli.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	orphans := []*orphan{}
+	for _, c := range containers {
+		if live.containers[c.Id] || time.Since(time.Unix(c.Created, 0)) < gcGrace {
+			continue
+		}
+		if podId := containerPod(c); podId == "" || live.pods[podId] {
+			continue
+		}
+		id := c.Id
+		orphans = append(orphans, &orphan{Kind: "container", Id: id, remove: func() error {
+			return daemon.removeContainer(id)
+		}})
+	}
+	return orphans, nil
+}
+
+// removeContainer removes a docker container, and the dm device activated
+// for it to be passed to a VM
+func (daemon *Daemon) removeContainer(containerId string) error {
+	if daemon.Storage != nil && daemon.Storage.StorageType == "devicemapper" {
+		poolName := daemon.Storage.PoolName
+		if i := strings.Index(poolName, "-pool"); i > 0 {
+			device := fmt.Sprintf("%s-%s", poolName[:i], containerId)
+			if _, err := os.Stat(path.Join("/dev/mapper", device)); err == nil {
+				if err := dm.RemoveDevice(device); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	_, _, err := daemon.dockerCli.SendCmdDelete(containerId)
+	return err
+}
+
+func (daemon *Daemon) orphanTaps(live *liveSet) ([]*orphan, error) {
+	taps, err := network.OrphanTaps()
+	if err != nil {
+		return nil, err
+	}
+	orphans := []*orphan{}
+	for _, tap := range taps {
+		tap := tap
+		orphans = append(orphans, &orphan{Kind: "tap", Id: tap, remove: func() error {
+			return network.DeleteTap(tap)
+		}})
+	}
+	return orphans, nil
+}
+
+// orphanPortMaps finds the port maps to the addresses which no VM has
+func (daemon *Daemon) orphanPortMaps(live *liveSet) ([]*orphan, error) {
+	rules, err := network.PortMapRules()
+	if err != nil {
+		return nil, err
+	}
+	orphans := []*orphan{}
+	for _, r := range rules {
+		if live.ips[r.IP] || network.IPAllocated(r.IP) {
+			continue
+		}
+		r := r
+		orphans = append(orphans, &orphan{Kind: "portmap", Id: r.String(), remove: func() error {
+			return network.RemovePortMapRule(r)
+		}})
+	}
+	return orphans, nil
+}
+
+// orphanRecords finds the records of the pods and the VMs which are gone, a
+// pod record is removed with its VM and volume records
+func (daemon *Daemon) orphanRecords(live *liveSet) ([]*orphan, error) {
+	pods, err := daemon.store.Pods()
+	if err != nil {
+		return nil, err
+	}
+	orphans := []*orphan{}
+	recorded := make(map[string]bool)
+	for _, rec := range pods {
+		recorded[rec.Id] = true
+		if live.pods[rec.Id] {
+			continue
+		}
+		podId := rec.Id
+		orphans = append(orphans, &orphan{Kind: "record", Id: podId, remove: func() error {
+			if daemon.registry.Pod(podId) != nil {
+				return fmt.Errorf("pod %s is back", podId)
+			}
+			return daemon.DeletePodFromDB(podId)
+		}})
+	}
+	// the records of the volumes of the pods without records are dangling
+	volumes, err := daemon.store.Volumes("")
+	if err != nil {
+		return orphans, err
+	}
+	for _, v := range volumes {
+		if recorded[v.Pod] || live.pods[v.Pod] {
+			continue
+		}
+		v := v
+		orphans = append(orphans, &orphan{Kind: "record", Id: string(volumeKey(v.Pod, v.DevId)), remove: func() error {
+			b := &storeBatch{}
+			b.DeleteVolume(v)
+			return daemon.store.Write(b)
+		}})
+	}
+	return orphans, nil
+}
+
+// CollectGarbage looks for the orphans and removes them unless dryRun is
+// set. It only removes the orphans the last collection found too, the others
+// are left to the next one. It returns the orphans found, the ones removed
+// and the errors met.
+func (daemon *Daemon) CollectGarbage(dryRun bool) ([]string, []string, []string) {
+	daemon.gc.Lock()
+	defer daemon.gc.Unlock()
+
+	live := daemon.liveResources()
+	orphans, errs := daemon.findOrphans(live)
+	found := []string{}
+	for _, o := range orphans {
+		found = append(found, o.String())
+	}
+	removed, failed := daemon.gc.remove(orphans, dryRun)
+	for _, err := range errs {
+		failed = append(failed, err.Error())
+	}
+	return found, removed, failed
+}
+
+// remove removes the orphans found by the last collection too and keeps the
+// others as the suspects of the next one, nothing is done if dryRun is set.
+// The caller holds the lock.
+func (gc *collector) remove(orphans []*orphan, dryRun bool) ([]string, []string) {
+	removed, failed := []string{}, []string{}
+	if dryRun {
+		return removed, failed
+	}
+	suspects := make(map[string]bool)
+	for _, o := range orphans {
+		if !gc.suspects[o.String()] {
+			suspects[o.String()] = true
+			continue
+		}
+		if err := o.remove(); err != nil {
+			glog.Warningf("Remove the orphan %s failed: %s", o.String(), err.Error())
+			failed = append(failed, fmt.Sprintf("%s: %s", o.String(), err.Error()))
+			continue
+		}
+		glog.Infof("Removed the orphan %s", o.String())
+		removed = append(removed, o.String())
+	}
+	gc.suspects = suspects
+	return removed, failed
+}
+
+// StartGC collects the orphans in the background now and every gcInterval
+// after, it is started once the pods are restored
+func (daemon *Daemon) StartGC() {
+	go func() {
+		ticker := time.NewTicker(gcInterval)
+		for {
+			found, _, failed := daemon.CollectGarbage(false)
+			if len(found) > 0 || len(failed) > 0 {
+				glog.V(1).Infof("GC found %d orphans, %d errors", len(found), len(failed))
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func (daemon *Daemon) CmdGC(job *engine.Job) error {
+	dryRun := len(job.Args) > 0 && job.Args[0] == "yes"
+	found, removed, failed := daemon.CollectGarbage(dryRun)
+
+	v := &engine.Env{}
+	v.SetList("Orphans", found)
+	v.SetList("Removed", removed)
+	v.SetList("Errors", failed)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
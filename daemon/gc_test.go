@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"hyper/docker"
+	"hyper/hypervisor"
+)
+
+func TestVmOfPath(t *testing.T) {
+	for p, vmId := range map[string]string{
+		hypervisor.BaseDir + "/vm-aaaaaaaaaa":                "vm-aaaaaaaaaa",
+		hypervisor.BaseDir + "/vm-aaaaaaaaaa/share_dir/data": "vm-aaaaaaaaaa",
+		hypervisor.BaseDir + "/kernels/vm-aaaaaaaaaa":        "",
+		"/tmp/vm-aaaaaaaaaa":                                 "",
+	} {
+		if got := vmOfPath(p); got != vmId {
+			t.Errorf("the VM of %s is %q, should be %q", p, got, vmId)
+		}
+	}
+}
+
+func TestOrphanRecords(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	b := &storeBatch{}
+	b.PutPod(&podRecord{Id: "pod-live", Spec: []byte(storeTestSpec)})
+	b.PutPod(&podRecord{Id: "pod-kept", Spec: []byte(storeTestSpec)})
+	b.PutPod(&podRecord{Id: "pod-gone", Spec: []byte(storeTestSpec)})
+	b.PutVolume(&volumeRecord{Pod: "pod-live", Name: "data", DevId: 1})
+	b.PutVolume(&volumeRecord{Pod: "pod-none", Name: "data", DevId: 2})
+	if err := daemon.store.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	daemon.registry.AddPod(&Pod{Id: "pod-live"})
+	// the records of a pod which failed to be restored are kept
+	daemon.gc.keepPod("pod-kept")
+
+	orphans, err := daemon.orphanRecords(daemon.liveResources())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := []string{}
+	for _, o := range orphans {
+		found = append(found, o.String())
+	}
+	if expect := []string{"record pod-gone", "record " + string(volumeKey("pod-none", 2))}; !reflect.DeepEqual(found, expect) {
+		t.Errorf("found %v, should be %v", found, expect)
+	}
+}
+
+func TestCollectorRemove(t *testing.T) {
+	gc := newCollector()
+	removed := []string{}
+	newOrphan := func(id string) *orphan {
+		return &orphan{Kind: "tap", Id: id, remove: func() error {
+			removed = append(removed, id)
+			return nil
+		}}
+	}
+
+	// a dry run removes nothing and suspects nothing
+	gc.remove([]*orphan{newOrphan("tap0")}, true)
+	if len(gc.suspects) != 0 {
+		t.Errorf("a dry run suspects %v", gc.suspects)
+	}
+
+	// an orphan found once is only suspected
+	if r, _ := gc.remove([]*orphan{newOrphan("tap0"), newOrphan("tap1")}, false); len(r) != 0 || len(removed) != 0 {
+		t.Errorf("%v are removed at the first time they are found", removed)
+	}
+
+	// an orphan found twice in a row is removed, the new ones are suspected
+	r, failed := gc.remove([]*orphan{newOrphan("tap1"), newOrphan("tap2")}, false)
+	if !reflect.DeepEqual(removed, []string{"tap1"}) || !reflect.DeepEqual(r, []string{"tap tap1"}) || len(failed) != 0 {
+		t.Errorf("%v are removed, reported as %v, %v", removed, r, failed)
+	}
+	if !reflect.DeepEqual(gc.suspects, map[string]bool{"tap tap2": true}) {
+		t.Errorf("the suspects are %v", gc.suspects)
+	}
+
+	// an orphan failed to be removed is not suspected again
+	bad := &orphan{Kind: "tap", Id: "tap2", remove: func() error { return fmt.Errorf("busy") }}
+	if _, failed := gc.remove([]*orphan{bad}, false); len(failed) != 1 || len(gc.suspects) != 0 {
+		t.Errorf("the failures are %v, the suspects are %v", failed, gc.suspects)
+	}
+}
+
+func TestContainerPod(t *testing.T) {
+	for _, c := range []struct {
+		names  []string
+		labels map[string]string
+		podId  string
+	}{
+		{[]string{"/pod-aaaaaaaaaa-0a1b2c3d4e"}, map[string]string{docker.PodLabel: "pod-aaaaaaaaaa"}, "pod-aaaaaaaaaa"},
+		// the containers of the users
+		{[]string{"/pod-webserver1"}, nil, ""},
+		{[]string{"/pod-aaaaaaaaaa-0a1b2c3d4e"}, nil, ""},
+		{[]string{"/pod-aaaaaaaaaa-0a1b2c3d4e-copy"}, map[string]string{docker.PodLabel: "pod-aaaaaaaaaa"}, ""},
+		{[]string{"/pod-webserver1"}, map[string]string{docker.PodLabel: "pod-webserver1"}, ""},
+		// the label must name the pod of the name
+		{[]string{"/pod-aaaaaaaaaa-0a1b2c3d4e"}, map[string]string{docker.PodLabel: "pod-bbbbbbbbbb"}, ""},
+	} {
+		if podId := containerPod(docker.ContainerSummary{Names: c.names, Labels: c.labels}); podId != c.podId {
+			t.Errorf("container %v %v is of pod %q, should be %q", c.names, c.labels, podId, c.podId)
+		}
+	}
+}
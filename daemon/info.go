@@ -33,6 +33,7 @@ func (daemon *Daemon) CmdInfo(job *engine.Job) error {
 	osinfo, err := sysinfo.GetOSInfo()
 	v.SetInt64("MemTotal", int64(meminfo.MemTotal))
 	v.SetInt64("Pods", daemon.GetPodNum())
+	daemon.setCommitted(v)
 	v.Set("Operating System", osinfo.PrettyName)
 	if hostname, err := os.Hostname(); err == nil {
 		v.SetJson("Name", hostname)
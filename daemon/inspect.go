@@ -0,0 +1,260 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"hyper/engine"
+	"hyper/hypervisor"
+	"hyper/pod"
+	"hyper/types"
+)
+
+// PodInspect is all the daemon knows about a pod, the times are nil if
+// hyperd does not know them, like the start of a pod started by a previous
+// hyperd
+type PodInspect struct {
+	Id            string             `json:"id"`
+	Name          string             `json:"name"`
+	Spec          json.RawMessage    `json:"spec"`
+	Labels        map[string]string  `json:"labels"`
+	Status        string             `json:"status"`
+	Created       *time.Time         `json:"created,omitempty"`
+	Started       *time.Time         `json:"started,omitempty"`
+	Finished      *time.Time         `json:"finished,omitempty"`
+	RestartPolicy string             `json:"restartPolicy"`
+	Restarts      int                `json:"restarts"`
+	LastExits     []string           `json:"lastExits"`
+	Containers    []ContainerInspect `json:"containers"`
+	Vm            *VmInspect         `json:"vm,omitempty"`
+	Networks      []NetworkInspect   `json:"networks"`
+	Ports         []PortInspect      `json:"ports"`
+	Volumes       []VolumeInspect    `json:"volumes"`
+}
+
+type ContainerInspect struct {
+	Id       string     `json:"id"`
+	Name     string     `json:"name"`
+	Image    string     `json:"image"`
+	Status   string     `json:"status"`
+	Restarts int        `json:"restarts"`
+	ExitCode *int       `json:"exitCode,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type VmInspect struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+	Driver string `json:"driver"`
+	// the pid of a qemu VM, the domid of a xen one
+	Pid        int                    `json:"pid,omitempty"`
+	DriverInfo map[string]interface{} `json:"driverInfo,omitempty"`
+	Cpu        int                    `json:"cpu"`
+	Mem        int                    `json:"memory"`
+	Boot       *hypervisor.BootConfig `json:"boot,omitempty"`
+}
+
+type NetworkInspect struct {
+	Network string `json:"network"`
+	Ip      string `json:"ip"`
+	Device  string `json:"device"`
+}
+
+type PortInspect struct {
+	Container     string `json:"container"`
+	Protocol      string `json:"protocol"`
+	HostPort      int    `json:"hostPort"`
+	ContainerPort int    `json:"containerPort"`
+}
+
+type VolumeInspect struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Driver string `json:"driver"`
+	// the file or the device passed to the VM, and the device in the VM
+	Filename   string `json:"filename,omitempty"`
+	Format     string `json:"format,omitempty"`
+	Fstype     string `json:"fstype,omitempty"`
+	DeviceName string `json:"deviceName,omitempty"`
+	// the dm thin device of a volume created for the pod
+	DmDevice string `json:"dmDevice,omitempty"`
+	DmDevId  int    `json:"dmDevId,omitempty"`
+}
+
+func statusName(status uint) string {
+	switch status {
+	case types.S_POD_RUNNING:
+		return "running"
+	case types.S_POD_CREATED:
+		return "pending"
+	case types.S_POD_FAILED:
+		return "failed"
+	case types.S_POD_SUCCEEDED:
+		return "succeeded"
+	case types.S_POD_BACKOFF:
+		return "crashLoopBackOff"
+	}
+	return ""
+}
+
+// vmStatusName names the status of a VM as hyper list does
+func vmStatusName(status uint) string {
+	switch status {
+	case types.S_VM_ASSOCIATED:
+		return "associated"
+	case types.S_VM_IDLE:
+		return "idle"
+	}
+	return ""
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// InspectPod gathers the spec, the state, the VM, the network and the
+// volumes of a pod
+func (daemon *Daemon) InspectPod(ref string) (*PodInspect, error) {
+	mypod, err := daemon.registry.LookupPod(ref)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := daemon.store.Pod(mypod.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Can not read the record of pod %s, %s", mypod.Id, err.Error())
+	}
+	userPod, err := pod.ProcessPodBytes(rec.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	created, started, finished := mypod.Times()
+	restarts, lastExits := daemon.PodRestarts(mypod.Id)
+	info := &PodInspect{
+		Id:            mypod.Id,
+		Name:          mypod.Name,
+		Spec:          rec.Spec,
+		Labels:        userPod.Labels,
+		Status:        statusName(mypod.Status()),
+		Created:       timeOrNil(created),
+		Started:       timeOrNil(started),
+		Finished:      timeOrNil(finished),
+		RestartPolicy: mypod.RestartPolicy(),
+		Restarts:      restarts,
+		LastExits:     lastExits,
+		Containers:    []ContainerInspect{},
+		Networks:      []NetworkInspect{},
+		Ports:         []PortInspect{},
+		Volumes:       []VolumeInspect{},
+	}
+	if info.Labels == nil {
+		info.Labels = map[string]string{}
+	}
+
+	for i, c := range mypod.Containers() {
+		ci := ContainerInspect{
+			Id:       c.Id,
+			Status:   statusName(c.Status()),
+			Restarts: c.Restarts(),
+		}
+		if code, at := c.Exit(); !at.IsZero() {
+			ci.ExitCode = &code
+			ci.Finished = &at
+		}
+		// the containers are in the order of the spec
+		if i < len(userPod.Containers) {
+			uc := userPod.Containers[i]
+			ci.Name, ci.Image = uc.Name, uc.Image
+			for _, p := range uc.Ports {
+				info.Ports = append(info.Ports, PortInspect{
+					Container:     uc.Name,
+					Protocol:      p.Protocol,
+					HostPort:      p.HostPort,
+					ContainerPort: p.ContainerPort,
+				})
+			}
+		}
+		info.Containers = append(info.Containers, ci)
+	}
+
+	var pinfo *hypervisor.PersistInfo
+	if vmId := mypod.Vm(); vmId != "" {
+		info.Vm = &VmInspect{Id: vmId}
+		if vm := daemon.registry.Vm(vmId); vm != nil {
+			info.Vm.Status = vmStatusName(vm.Status())
+			info.Vm.Cpu, info.Vm.Mem = vm.Cpu, vm.Mem
+		}
+		if data, err := daemon.GetVmData(vmId); err == nil && len(data) > 0 {
+			if pinfo, err = hypervisor.LoadPersistInfo(data); err != nil {
+				return nil, fmt.Errorf("Can not read the persist info of VM %s, %s", vmId, err.Error())
+			}
+			info.Vm.DriverInfo = pinfo.DriverInfo
+			info.Vm.Driver, _ = pinfo.DriverInfo["hypervisor"].(string)
+			for _, key := range []string{"pid", "domid"} {
+				if id, ok := pinfo.DriverInfo[key].(float64); ok {
+					info.Vm.Pid = int(id)
+				}
+			}
+			info.Vm.Boot = pinfo.Boot
+			if b := pinfo.Boot; b != nil && info.Vm.Cpu == 0 {
+				info.Vm.Cpu, info.Vm.Mem = b.CPU, b.Memory
+			}
+		}
+	}
+	if pinfo != nil {
+		for _, nic := range pinfo.NetworkList {
+			info.Networks = append(info.Networks, NetworkInspect{
+				Network: nic.Network,
+				Ip:      nic.IpAddr,
+				Device:  nic.DeviceName,
+			})
+		}
+	}
+
+	dmVolumes, err := daemon.store.Volumes(mypod.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range userPod.Volumes {
+		vi := VolumeInspect{Name: v.Name, Source: v.Source, Driver: v.Driver}
+		if pinfo != nil {
+			for _, pv := range pinfo.VolumeList {
+				if pv.Name == v.Name {
+					vi.Filename, vi.Format, vi.Fstype, vi.DeviceName = pv.Filename, pv.Format, pv.Fstype, pv.DeviceName
+					break
+				}
+			}
+		}
+		dmName := fmt.Sprintf("%s%s-%s", volumeDevicePrefix, mypod.Id, v.Name)
+		for _, dv := range dmVolumes {
+			if dv.Name == dmName {
+				vi.DmDevice, vi.DmDevId = dv.Name, dv.DevId
+				break
+			}
+		}
+		info.Volumes = append(info.Volumes, vi)
+	}
+	return info, nil
+}
+
+func (daemon *Daemon) CmdPodInspect(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not inspect a pod without its name or id")
+	}
+	info, err := daemon.InspectPod(job.Args[0])
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if _, err := job.Stdout.Write(data); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"encoding/json"
+	"testing"
+
+	"hyper/hypervisor"
+	"hyper/types"
+)
+
+func TestInspectPod(t *testing.T) {
+	daemon, eng, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	spec := `{
+	"id": "web",
+	"labels": {"app": "web"},
+	"containers": [{"name": "c1", "image": "busybox", "ports": [{"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}]}],
+	"volumes": [{"name": "data", "source": "/tmp/data", "driver": "vfs"}]
+}`
+	dat, err := runJob(eng, "podCreate", spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podId := dat["ID"].(string)
+
+	// the pod runs in a VM associated again, whose boot config is unknown
+	pinfo := &hypervisor.PersistInfo{
+		Version:     hypervisor.PersistVersion,
+		Id:          "vm-test",
+		DriverInfo:  map[string]interface{}{"hypervisor": "qemu", "pid": 1234},
+		NetworkList: []*hypervisor.PersistNetworkInfo{{IpAddr: "192.168.123.2", DeviceName: "eth0", Network: "default"}},
+		VolumeList:  []*hypervisor.PersistVolumeInfo{{Name: "data", Filename: "/tmp/data", Fstype: "dir"}},
+	}
+	data, err := json.Marshal(pinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &storeBatch{}
+	b.PutVm(&vmRecord{Id: "vm-test", Pod: podId, Data: data})
+	if err := daemon.store.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	mypod := daemon.registry.Pod(podId)
+	mypod.SetVm("vm-test")
+	mypod.SetStatus(types.S_POD_RUNNING)
+	daemon.registry.AddVm(&Vm{Id: "vm-test", Pod: mypod, Cpu: 1, Mem: 128, status: types.S_VM_ASSOCIATED})
+	mypod.Containers()[0].Exited(3)
+
+	info, err := daemon.InspectPod("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Id != podId || info.Name != "web" || info.Labels["app"] != "web" || info.Status != "running" {
+		t.Errorf("pod is inspected as %+v", info)
+	}
+	if info.Created == nil || info.Started == nil || info.Finished != nil {
+		t.Errorf("the times of the running pod are %v %v %v", info.Created, info.Started, info.Finished)
+	}
+	if len(info.Containers) != 1 || info.Containers[0].Name != "c1" || info.Containers[0].ExitCode == nil || *info.Containers[0].ExitCode != 3 {
+		t.Errorf("containers are inspected as %+v", info.Containers)
+	}
+	if info.Vm == nil || info.Vm.Id != "vm-test" || info.Vm.Status != "associated" || info.Vm.Cpu != 1 || info.Vm.Mem != 128 ||
+		info.Vm.Driver != "qemu" || info.Vm.Pid != 1234 {
+		t.Errorf("VM is inspected as %+v", info.Vm)
+	}
+	if len(info.Networks) != 1 || info.Networks[0].Ip != "192.168.123.2" {
+		t.Errorf("networks are inspected as %+v", info.Networks)
+	}
+	if len(info.Ports) != 1 || info.Ports[0].HostPort != 8080 || info.Ports[0].Container != "c1" {
+		t.Errorf("ports are inspected as %+v", info.Ports)
+	}
+	if len(info.Volumes) != 1 || info.Volumes[0].Fstype != "dir" || info.Volumes[0].Source != "/tmp/data" {
+		t.Errorf("volumes are inspected as %+v", info.Volumes)
+	}
+
+	mypod.SetStatus(types.S_POD_SUCCEEDED)
+	if info, err = daemon.InspectPod(podId[:6]); err != nil {
+		t.Fatal(err)
+	}
+	if info.Finished == nil {
+		t.Error("the finished pod has no finish time")
+	}
+}
@@ -0,0 +1,241 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"sync"
+
+	"hyper/engine"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/utils"
+)
+
+// the kernels and initrds of the catalog are copied here, one directory
+// for each kernel
+var kernelRoot = "/var/lib/hyper/kernels"
+
+var kernelNameReg = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Kernel is a guest kernel of the catalog, a pod chooses it by name. The
+// initrd of the daemon is used if Initrd is empty. It is the record of the
+// kernel in the db too.
+type Kernel struct {
+	Name   string `json:"name"`
+	Kernel string `json:"kernel"`
+	Initrd string `json:"initrd"`
+}
+
+type kernelCatalog struct {
+	sync.Mutex
+	kernels map[string]*Kernel
+}
+
+func newKernelCatalog() *kernelCatalog {
+	return &kernelCatalog{
+		kernels: make(map[string]*Kernel),
+	}
+}
+
+func (c *kernelCatalog) get(name string) (*Kernel, error) {
+	c.Lock()
+	defer c.Unlock()
+	k, ok := c.kernels[name]
+	if !ok {
+		return nil, fmt.Errorf("Can not find kernel %s", name)
+	}
+	return k, nil
+}
+
+func (c *kernelCatalog) add(k *Kernel) {
+	c.Lock()
+	c.kernels[k.Name] = k
+	c.Unlock()
+}
+
+func (c *kernelCatalog) remove(name string) {
+	c.Lock()
+	delete(c.kernels, name)
+	c.Unlock()
+}
+
+func (c *kernelCatalog) list() []*Kernel {
+	c.Lock()
+	defer c.Unlock()
+	names := make([]string, 0, len(c.kernels))
+	for name := range c.kernels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]*Kernel, 0, len(names))
+	for _, name := range names {
+		list = append(list, c.kernels[name])
+	}
+	return list
+}
+
+// bootFiles returns the kernel and the initrd a pod boots with
+func (daemon *Daemon) bootFiles(userPod *pod.UserPod) (string, string, error) {
+	cfg := daemon.config()
+	if userPod.Kernel == "" {
+		return cfg.Kernel, cfg.Initrd, nil
+	}
+	if cfg.Cbfs != "" {
+		return "", "", fmt.Errorf("The kernel is built in the cbfs, pod %s could not choose kernel %s", userPod.Name, userPod.Kernel)
+	}
+	k, err := daemon.kernels.get(userPod.Kernel)
+	if err != nil {
+		return "", "", err
+	}
+	if k.Initrd == "" {
+		return k.Kernel, cfg.Initrd, nil
+	}
+	return k.Kernel, k.Initrd, nil
+}
+
+// CmdKernelAdd adds the kernel named by the argument to the catalog, the
+// kernel and the optional initrd given in the env are copied into it.
+func (daemon *Daemon) CmdKernelAdd(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not add a kernel without name!")
+	}
+	name := job.Args[0]
+	if !kernelNameReg.MatchString(name) {
+		return fmt.Errorf("Invalid kernel name %s", name)
+	}
+	if _, err := daemon.kernels.get(name); err == nil {
+		return fmt.Errorf("Kernel %s already exists", name)
+	}
+	kernel := job.Getenv("kernel")
+	if kernel == "" {
+		return fmt.Errorf("Can not add kernel %s without the kernel file!", name)
+	}
+
+	dir := path.Join(kernelRoot, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	k := &Kernel{
+		Name:   name,
+		Kernel: path.Join(dir, "kernel"),
+	}
+	if err := utils.CopyFile(kernel, k.Kernel, 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if initrd := job.Getenv("initrd"); initrd != "" {
+		k.Initrd = path.Join(dir, "initrd.img")
+		if err := utils.CopyFile(initrd, k.Initrd, 0644); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+	}
+	if err := daemon.WriteKernelToDB(k); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	daemon.kernels.add(k)
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdKernelRm removes the kernel named by the argument from the catalog, it
+// must not be chosen by any pod.
+func (daemon *Daemon) CmdKernelRm(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not remove a kernel without name!")
+	}
+	name := job.Args[0]
+	if _, err := daemon.kernels.get(name); err != nil {
+		return err
+	}
+
+	for _, p := range daemon.registry.Pods() {
+		podId := p.Id
+		data, err := daemon.GetPodByName(podId)
+		if err != nil {
+			continue
+		}
+		userPod, err := pod.ProcessPodBytes(data)
+		if err != nil {
+			continue
+		}
+		if userPod.Kernel == name {
+			return fmt.Errorf("Kernel %s is used by pod %s", name, podId)
+		}
+	}
+
+	if err := daemon.DeleteKernelFromDB(name); err != nil {
+		return err
+	}
+	daemon.kernels.remove(name)
+	if err := os.RemoveAll(path.Join(kernelRoot, name)); err != nil {
+		glog.Warningf("Fail to remove the files of kernel %s: %s", name, err.Error())
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) CmdKernelList(job *engine.Job) error {
+	var kernelJsonResponse = []string{}
+	for _, k := range daemon.kernels.list() {
+		kernelJsonResponse = append(kernelJsonResponse, k.Name+":"+k.Kernel+":"+k.Initrd)
+	}
+
+	v := &engine.Env{}
+	v.SetList("kernelData", kernelJsonResponse)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) WriteKernelToDB(k *Kernel) error {
+	b := &storeBatch{}
+	if err := b.PutKernel(k); err != nil {
+		return err
+	}
+	return daemon.store.Write(b)
+}
+
+func (daemon *Daemon) DeleteKernelFromDB(name string) error {
+	b := &storeBatch{}
+	b.DeleteKernel(name)
+	return daemon.store.Write(b)
+}
+
+// restoreKernels loads the catalog, a kernel whose files are gone is kept,
+// the pods choosing it fail to start with the error of the VM.
+func (daemon *Daemon) restoreKernels() error {
+	recs, err := daemon.store.Kernels()
+	if err != nil {
+		return err
+	}
+	for _, k := range recs {
+		if _, err := os.Stat(k.Kernel); err != nil {
+			glog.Warningf("The file of kernel %s is gone: %s", k.Name, err.Error())
+		}
+		daemon.kernels.add(k)
+	}
+	return nil
+}
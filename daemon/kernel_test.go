@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestKernelCatalog(t *testing.T) {
+	daemon, eng, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "hyper-kernel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := kernelRoot
+	kernelRoot = path.Join(dir, "kernels")
+	defer func() { kernelRoot = root }()
+
+	src := path.Join(dir, "vmlinuz")
+	if err := ioutil.WriteFile(src, []byte("kernel"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	addKernel := func(name, kernel string) error {
+		job := eng.Job("kernelAdd", name)
+		job.Setenv("kernel", kernel)
+		return job.Run()
+	}
+	if err := addKernel("k1", src); err != nil {
+		t.Fatal(err)
+	}
+	for name, kernel := range map[string]string{
+		"k1":    src,                    // already there
+		"../k2": src,                    // invalid name
+		"k3":    "",                     // no kernel
+		"k4":    path.Join(dir, "none"), // missing file
+	} {
+		if err := addKernel(name, kernel); err == nil {
+			t.Errorf("kernel %s of %q is added", name, kernel)
+		}
+	}
+	if _, err := os.Stat(path.Join(kernelRoot, "k4")); err == nil {
+		t.Error("the files of a kernel failed to be added are left")
+	}
+
+	k, err := daemon.kernels.get("k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(k.Kernel); err != nil || string(data) != "kernel" {
+		t.Errorf("the kernel is copied as %q, %v", data, err)
+	}
+	dat, err := runJob(eng, "kernelList")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := dat["kernelData"].([]interface{})
+	if len(list) != 1 || list[0] != "k1:"+k.Kernel+":" {
+		t.Errorf("the kernels are listed as %v", list)
+	}
+
+	// the catalog is restored from the db
+	daemon.kernels = newKernelCatalog()
+	if err := daemon.restoreKernels(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := daemon.kernels.get("k1"); err != nil {
+		t.Error("the kernel is not restored")
+	}
+
+	// a kernel chosen by a pod stays
+	dat, err = runJob(eng, "podCreate", `{"id": "web", "kernel": "k1", "containers": [{"name": "c1", "image": "busybox"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runJob(eng, "kernelRm", "k1"); err == nil {
+		t.Error("the kernel of a pod is removed")
+	}
+	if _, err := runJob(eng, "podRm", dat["ID"].(string)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runJob(eng, "kernelRm", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := daemon.kernels.get("k1"); err == nil {
+		t.Error("the kernel is left in the catalog")
+	}
+	if _, err := os.Stat(path.Join(kernelRoot, "k1")); err == nil {
+		t.Error("the files of the kernel are left")
+	}
+	daemon.kernels = newKernelCatalog()
+	daemon.restoreKernels()
+	if _, err := daemon.kernels.get("k1"); err == nil {
+		t.Error("the kernel is left in the db")
+	}
+	if _, err := runJob(eng, "kernelRm", "k1"); err == nil {
+		t.Error("a missing kernel is removed")
+	}
+}
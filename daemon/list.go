@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"hyper/engine"
 	"hyper/types"
+	"strconv"
 )
 
 func (daemon *Daemon) CmdList(job *engine.Job) error {
@@ -29,8 +30,8 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 	v := &engine.Env{}
 	v.Set("item", item)
 	if item == "vm" {
-		for vm, v := range daemon.vmList {
-			switch v.Status {
+		for _, v := range daemon.registry.Vms() {
+			switch v.Status() {
 			case types.S_VM_ASSOCIATED:
 				status = "associated"
 				break
@@ -44,14 +45,15 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 			if v.Pod != nil {
 				podId = v.Pod.Id
 			}
-			vmJsonResponse = append(vmJsonResponse, vm+":"+podId+":"+status)
+			vmJsonResponse = append(vmJsonResponse, v.Id+":"+podId+":"+status)
 		}
 		v.SetList("vmData", vmJsonResponse)
 	}
 
 	if item == "pod" {
-		for p, v := range daemon.podList {
-			switch v.Status {
+		for _, v := range daemon.registry.Pods() {
+			p := v.Id
+			switch v.Status() {
 			case types.S_POD_RUNNING:
 				status = "running"
 				break
@@ -70,18 +72,22 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 					status = "succeeded(kubernetes)"
 				}
 				break
+			case types.S_POD_BACKOFF:
+				status = "crashLoopBackOff"
+				break
 			default:
 				status = ""
 				break
 			}
-			podJsonResponse = append(podJsonResponse, p+":"+v.Name+":"+v.Vm+":"+status)
+			restarts, _ := daemon.PodRestarts(p)
+			podJsonResponse = append(podJsonResponse, p+":"+v.Name+":"+v.Vm()+":"+status+":"+strconv.Itoa(restarts))
 		}
 		v.SetList("podData", podJsonResponse)
 	}
 
 	if item == "container" {
-		for _, c := range daemon.containerList {
-			switch c.Status {
+		for _, c := range daemon.registry.Containers() {
+			switch c.Status() {
 			case types.S_POD_RUNNING:
 				status = "running"
 				break
@@ -97,7 +103,7 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 			default:
 				status = ""
 			}
-			containerJsonResponse = append(containerJsonResponse, c.Id+":"+c.PodId+":"+status)
+			containerJsonResponse = append(containerJsonResponse, c.Id+":"+c.PodId+":"+status+":"+strconv.Itoa(c.Restarts()))
 		}
 		v.SetList("cData", containerJsonResponse)
 	}
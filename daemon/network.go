@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"fmt"
+
+	"hyper/engine"
+	"hyper/network"
+	"hyper/pod"
+)
+
+// CmdNetworkCreate creates the network named by the argument, with the
+// subnet and the bridge given in the env.
+func (daemon *Daemon) CmdNetworkCreate(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not create a network without name!")
+	}
+	name := job.Args[0]
+	subnet := job.Getenv("subnet")
+	if subnet == "" {
+		return fmt.Errorf("Can not create network %s without subnet!", name)
+	}
+
+	nw, err := network.CreateNetwork(name, subnet, job.Getenv("bridge"))
+	if err != nil {
+		return err
+	}
+	if err := daemon.WriteNetworkToDB(nw); err != nil {
+		network.DeleteNetwork(name)
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdNetworkRm removes the network named by the argument, it must not be
+// used by any pod.
+func (daemon *Daemon) CmdNetworkRm(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not remove a network without name!")
+	}
+	name := job.Args[0]
+	if _, err := network.GetNetwork(name); err != nil {
+		return err
+	}
+
+	for _, p := range daemon.registry.Pods() {
+		podId := p.Id
+		data, err := daemon.GetPodByName(podId)
+		if err != nil {
+			continue
+		}
+		userPod, err := pod.ProcessPodBytes(data)
+		if err != nil {
+			continue
+		}
+		for _, inf := range userPod.Networks {
+			if inf.Network == name {
+				return fmt.Errorf("Network %s is used by pod %s", name, podId)
+			}
+		}
+	}
+
+	if err := network.DeleteNetwork(name); err != nil {
+		return err
+	}
+	if err := daemon.DeleteNetworkFromDB(name); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) CmdNetworkList(job *engine.Job) error {
+	var networkJsonResponse = []string{}
+	for _, nw := range network.ListNetworks() {
+		networkJsonResponse = append(networkJsonResponse, nw.Name+":"+nw.Bridge+":"+nw.Subnet)
+	}
+
+	v := &engine.Env{}
+	v.SetList("networkData", networkJsonResponse)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (daemon *Daemon) WriteNetworkToDB(nw *network.Network) error {
+	b := &storeBatch{}
+	if err := b.PutNetwork(&networkRecord{Name: nw.Name, Bridge: nw.Bridge, Subnet: nw.Subnet}); err != nil {
+		return err
+	}
+	return daemon.store.Write(b)
+}
+
+func (daemon *Daemon) DeleteNetworkFromDB(name string) error {
+	b := &storeBatch{}
+	b.DeleteNetwork(name)
+	return daemon.store.Write(b)
+}
+
+// restoreNetworks sets up the networks created before the daemon restarted,
+// the pods on them are restored later. The daemon does not start without a
+// network it could not set up, the pods on it would fail later.
+func (daemon *Daemon) restoreNetworks() error {
+	recs, err := daemon.store.Networks()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if _, err := network.CreateNetwork(rec.Name, rec.Subnet, rec.Bridge); err != nil {
+			return fmt.Errorf("Fail to restore network %s: %s", rec.Name, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package daemon
+
+import (
+	"testing"
+
+	"hyper/network"
+)
+
+func TestRestoreNetworkFailure(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	// the network could not be set up again, its pods would fail later
+	if err := daemon.WriteNetworkToDB(&network.Network{Name: "back", Subnet: "10.10.0.1/33", Bridge: "hyper-back"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := daemon.restoreNetworks(); err == nil {
+		t.Error("a network failed to be restored is ignored")
+	}
+}
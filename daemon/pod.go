@@ -1,6 +1,7 @@
 package daemon
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -9,17 +10,21 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"hyper/docker"
 	"hyper/engine"
 	"hyper/hypervisor"
 	"hyper/lib/glog"
+	"hyper/network"
 	"hyper/pod"
 	"hyper/storage/aufs"
 	dm "hyper/storage/devicemapper"
 	"hyper/storage/overlay"
 	"hyper/types"
 	"hyper/utils"
+
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 func (daemon *Daemon) CmdPodCreate(job *engine.Job) error {
@@ -35,10 +40,7 @@ func (daemon *Daemon) CmdPodCreate(job *engine.Job) error {
 	if err != nil {
 		return err
 	}
-	if err := daemon.WritePodAndContainers(podId); err != nil {
-		glog.V(1).Info("Found an error while saveing the Containers info")
-		return err
-	}
+	daemon.LogPodEvent(podId, "create", "")
 
 	// Prepare the qemu status to client
 	v := &engine.Env{}
@@ -57,22 +59,26 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 	if daemon.GetRunningPodNum() >= 1024 {
 		return fmt.Errorf("Pod full, the maximum Pod is 1024!")
 	}
-	podId := job.Args[0]
+	podId, err := daemon.ResolvePod(job.Args[0])
+	if err != nil {
+		return err
+	}
 	vmId := job.Args[1]
 
 	glog.Info("pod:%s, vm:%s", podId, vmId)
 	// Do the status check for the given pod
-	if pod, ok := daemon.podList[podId]; ok {
-		if pod.Status == types.S_POD_RUNNING {
-			return fmt.Errorf("The pod(%s) is running, can not start it", podId)
-		} else {
-			if pod.Type == "kubernetes" && pod.Status != types.S_POD_CREATED {
-				return fmt.Errorf("The pod(%s) is finished with kubernetes type, can not start it again", podId)
-			}
-		}
-	} else {
+	mypod := daemon.lockPod(podId)
+	if mypod == nil {
 		return fmt.Errorf("The pod(%s) can not be found, please create it first", podId)
 	}
+	defer mypod.Unlock()
+	if mypod.Status() == types.S_POD_RUNNING {
+		return fmt.Errorf("The pod(%s) is running, can not start it", podId)
+	} else {
+		if mypod.Type == "kubernetes" && mypod.Status() != types.S_POD_CREATED {
+			return fmt.Errorf("The pod(%s) is finished with kubernetes type, can not start it again", podId)
+		}
+	}
 	data, err := daemon.GetPodByName(podId)
 	if err != nil {
 		return err
@@ -84,18 +90,19 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 	if vmId == "" {
 		vmId = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
 	} else {
-		if _, ok := daemon.vmList[vmId]; !ok {
+		vm := daemon.registry.Vm(vmId)
+		if vm == nil {
 			return fmt.Errorf("The VM %s doesn't exist", vmId)
 		}
-		if userPod.Resource.Vcpu != daemon.vmList[vmId].Cpu {
+		if userPod.Resource.Vcpu != vm.Cpu {
 			return fmt.Errorf("The new pod's cpu setting is different the current VM's cpu")
 		}
-		if userPod.Resource.Memory != daemon.vmList[vmId].Mem {
+		if userPod.Resource.Memory != vm.Mem {
 			return fmt.Errorf("The new pod's memory setting is different the current VM's memory")
 		}
 	}
 
-	code, cause, err := daemon.StartPod(podId, vmId, "")
+	vmId, code, cause, err := daemon.bootPod(podId, vmId, "")
 	if err != nil {
 		daemon.KillVm(vmId)
 		glog.Error(err.Error())
@@ -104,13 +111,14 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 
 	vm := &Vm{
 		Id:     vmId,
-		Pod:    daemon.podList[podId],
-		Status: types.S_VM_ASSOCIATED,
+		Pod:    mypod,
 		Cpu:    userPod.Resource.Vcpu,
 		Mem:    userPod.Resource.Memory,
+		status: types.S_VM_ASSOCIATED,
 	}
-	daemon.podList[podId].Vm = vmId
+	mypod.SetVm(vmId)
 	daemon.AddVm(vm)
+	daemon.LogPodEvent(podId, "start", "")
 
 	// Prepare the qemu status to client
 	v := &engine.Env{}
@@ -136,16 +144,12 @@ func (daemon *Daemon) CmdPodRun(job *engine.Job) error {
 
 	glog.Info(podArgs)
 
-	code, cause, err := daemon.StartPod(podId, vmId, podArgs)
+	vmId, code, cause, err := daemon.bootPod(podId, vmId, podArgs)
 	if err != nil {
 		daemon.KillVm(vmId)
 		glog.Error(err.Error())
 		return err
 	}
-	if err := daemon.WritePodAndContainers(podId); err != nil {
-		glog.V(1).Info("Found an error while saveing the Containers info")
-		return err
-	}
 	data, err := daemon.GetPodByName(podId)
 	if err != nil {
 		return err
@@ -155,15 +159,20 @@ func (daemon *Daemon) CmdPodRun(job *engine.Job) error {
 		return err
 	}
 
+	mypod := daemon.registry.Pod(podId)
+	if mypod == nil {
+		return fmt.Errorf("Can not find the POD instance of %s", podId)
+	}
 	vm := &Vm{
 		Id:     vmId,
-		Pod:    daemon.podList[podId],
-		Status: types.S_VM_ASSOCIATED,
+		Pod:    mypod,
 		Cpu:    userPod.Resource.Vcpu,
 		Mem:    userPod.Resource.Memory,
+		status: types.S_VM_ASSOCIATED,
 	}
-	daemon.podList[podId].Vm = vmId
+	mypod.SetVm(vmId)
 	daemon.AddVm(vm)
+	daemon.LogPodEvent(podId, "start", "")
 
 	// Prepare the qemu status to client
 	v := &engine.Env{}
@@ -177,6 +186,56 @@ func (daemon *Daemon) CmdPodRun(job *engine.Job) error {
 	return nil
 }
 
+// bootPod starts the pod with StartPod, if the VM fails to boot, the pod is
+// tried on fresh VMs up to BootRetries times. It returns the VM the pod is
+// started on.
+func (daemon *Daemon) bootPod(podId, vmId, podArgs string) (string, int, string, error) {
+	for retry := 0; ; retry++ {
+		code, cause, err := daemon.StartPod(podId, vmId, podArgs)
+		if err == nil {
+			return vmId, code, cause, err
+		}
+		retries := daemon.config().BootRetries
+		if code != types.E_BOOT_FAILED || retry >= retries {
+			if mypod := daemon.registry.Pod(podId); mypod != nil && code == types.E_BOOT_FAILED {
+				mypod.SetStatus(types.S_POD_FAILED)
+				daemon.SetContainerStatus(podId, types.S_POD_FAILED)
+			}
+			daemon.LogPodEvent(podId, "fail", err.Error())
+			return vmId, code, cause, err
+		}
+
+		glog.Warningf("VM %s failed to boot, retry pod %s on another VM (%d/%d)",
+			vmId, podId, retry+1, retries)
+		daemon.waitPodReleased(podId, bootReleaseTimeout)
+		vmId = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+		// the pod has been created by the first try
+		podArgs = ""
+	}
+}
+
+// bootReleaseTimeout is how long to wait for a VM failed to boot releasing
+// the resources of its pod
+const bootReleaseTimeout = 30 * time.Second
+
+func (daemon *Daemon) waitPodReleased(podId string, timeout time.Duration) {
+	mypod := daemon.registry.Pod(podId)
+	if mypod == nil || mypod.Wg == nil {
+		return
+	}
+	wg := mypod.Wg
+	released := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(timeout):
+		glog.Warningf("resources of pod %s are not released in %s", podId, timeout)
+	}
+}
+
 func (daemon *Daemon) CreatePod(podArgs, podId string, wg *sync.WaitGroup) error {
 	userPod, err := pod.ProcessPodBytes([]byte(podArgs))
 	if err != nil {
@@ -186,93 +245,112 @@ func (daemon *Daemon) CreatePod(podArgs, podId string, wg *sync.WaitGroup) error
 	if err := userPod.Validate(); err != nil {
 		return err
 	}
-	// store the UserPod into the db
-	if err := daemon.WritePodToDB(podId, []byte(podArgs)); err != nil {
-		glog.V(1).Info("Found an error while saveing the POD file")
+	// a pod restored keeps its containers
+	rec, err := daemon.store.Pod(podId)
+	if err == leveldb.ErrNotFound {
+		rec = &podRecord{Id: podId}
+	} else if err != nil {
 		return err
 	}
-	containerIds, err := daemon.GetPodContainersByName(podId)
-	if err != nil {
-		glog.V(1).Info(err.Error())
+	// the name is given once, a random one must not change on restore
+	if rec.Name == "" {
+		rec.Name = userPod.Name
 	}
-	if containerIds != nil {
-		for _, id := range containerIds {
-			daemon.SetPodByContainer(id, podId, "", "", []string{}, types.S_POD_CREATED)
-		}
-	} else {
+	if rec.Created == 0 {
+		rec.Created = time.Now().UnixNano()
+	}
+	// the pod is added before its containers are created to take its name,
+	// the jobs on it wait for the creation
+	mypod := &Pod{
+		Id:            podId,
+		Name:          rec.Name,
+		Wg:            wg,
+		Type:          userPod.Type,
+		MaxRetries:    userPod.MaxRetries,
+		status:        types.S_POD_CREATED,
+		restartPolicy: userPod.Containers[0].RestartPolicy,
+		created:       time.Unix(0, rec.Created),
+	}
+	mypod.Lock()
+	defer mypod.Unlock()
+	if err := daemon.AddPod(mypod); err != nil {
+		return err
+	}
+	if err := daemon.createPodContainers(podArgs, userPod, rec); err != nil {
+		daemon.RemovePod(podId)
+		return err
+	}
+	mypod.SetContainers(daemon.registry.PodContainers(podId))
+	return nil
+}
+
+// createPodContainers creates the containers of a pod unless it is restored
+// with them, and stores the pod
+func (daemon *Daemon) createPodContainers(podArgs string, userPod *pod.UserPod, rec *podRecord) error {
+	podId := rec.Id
+	if len(rec.Containers) == 0 {
 		// Process the 'Containers' section
 		glog.V(1).Info("Process the Containers section in POD SPEC\n")
 		for _, c := range userPod.Containers {
-			imgName := c.Image
-			body, _, err := daemon.dockerCli.SendCmdCreate(imgName)
-			if err != nil {
-				glog.Error(err.Error())
-				daemon.DeletePodFromDB(podId)
-				return err
-			}
-			out := engine.NewOutput()
-			remoteInfo, err := out.AddEnv()
+			containerId, err := daemon.createContainer(podId, c.Image)
 			if err != nil {
-				daemon.DeletePodFromDB(podId)
 				return err
 			}
-			if _, err := out.Write(body); err != nil {
-				daemon.DeletePodFromDB(podId)
-				return fmt.Errorf("Error while reading remote info!\n")
-			}
-			out.Close()
-
-			containerId := remoteInfo.Get("Id")
-			daemon.SetPodByContainer(containerId, podId, "", "", []string{}, types.S_POD_CREATED)
+			rec.Containers = append(rec.Containers, containerId)
 		}
 	}
-	containers := []*Container{}
-	for _, v := range daemon.containerList {
-		if v.PodId == podId {
-			containers = append(containers, v)
-		}
+	// store the UserPod with its containers into the db
+	rec.Spec = json.RawMessage(podArgs)
+	b := &storeBatch{}
+	if err := b.PutPod(rec); err != nil {
+		return err
 	}
-	mypod := &Pod{
-		Id:            podId,
-		Name:          userPod.Name,
-		Vm:            "",
-		Wg:            wg,
-		Containers:    containers,
-		Status:        types.S_POD_CREATED,
-		Type:          userPod.Type,
-		RestartPolicy: userPod.Containers[0].RestartPolicy,
+	if err := daemon.store.Write(b); err != nil {
+		glog.V(1).Info("Found an error while saveing the POD file")
+		return err
+	}
+	for _, id := range rec.Containers {
+		daemon.SetPodByContainer(id, podId, "", "", []string{}, types.S_POD_CREATED)
 	}
-	daemon.AddPod(mypod)
-
 	return nil
 }
 
+// createContainer creates the docker container of an image for a pod and
+// returns its id. The container is named after the pod and labeled with it,
+// so it could be found if the pod is lost.
+func (daemon *Daemon) createContainer(podId, imgName string) (string, error) {
+	name := fmt.Sprintf("%s-%s", podId, pod.RandStr(10, "alphanum"))
+	body, _, err := daemon.dockerCli.SendCmdCreate(imgName, name, podId)
+	if err != nil {
+		glog.Error(err.Error())
+		return "", err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return "", err
+	}
+	if _, err := out.Write(body); err != nil {
+		return "", fmt.Errorf("Error while reading remote info!\n")
+	}
+	out.Close()
+
+	return remoteInfo.Get("Id"), nil
+}
+
 func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error) {
 	var (
-		fstype            string
-		poolName          string
-		volPoolName       string
-		devPrefix         string
-		storageDriver     string
-		rootPath          string
-		devFullName       string
-		rootfs            string
 		containerInfoList = []*hypervisor.ContainerInfo{}
 		volumuInfoList    = []*hypervisor.VolumeInfo{}
-		cli               = daemon.dockerCli
-		qemuPodEvent      = make(chan hypervisor.VmEvent, 128)
-		qemuStatus        = make(chan *types.QemuResponse, 128)
-		subQemuStatus     = make(chan *types.QemuResponse, 128)
+		qemuChan          = newQemuChan()
 		sharedDir         = path.Join(hypervisor.BaseDir, vmId, hypervisor.ShareDirTag)
 		podData           []byte
 		mypod             *Pod
 		wg                *sync.WaitGroup
 		err               error
-		uid               string
-		gid               string
 	)
 	if podArgs == "" {
-		mypod = daemon.podList[podId]
+		mypod = daemon.registry.Pod(podId)
 		if mypod == nil {
 			return -1, "", fmt.Errorf("Can not find the POD instance of %s", podId)
 		}
@@ -288,13 +366,22 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 	if err != nil {
 		return -1, "", err
 	}
+	for _, inf := range userPod.Networks {
+		if _, err := network.GetNetwork(inf.Network); err != nil {
+			return -1, "", err
+		}
+	}
 
-	vm := daemon.vmList[vmId]
+	vm := daemon.registry.Vm(vmId)
 	if vm == nil {
-		glog.V(1).Infof("The config: kernel=%s, initrd=%s", daemon.kernel, daemon.initrd)
+		kernel, initrd, err := daemon.bootFiles(userPod)
+		if err != nil {
+			return -1, "", err
+		}
+		glog.V(1).Infof("The config: kernel=%s, initrd=%s", kernel, initrd)
 		var (
-			cpu = 1
-			mem = 128
+			cpu = defaultVmCpu
+			mem = defaultVmMem
 		)
 		if userPod.Resource.Vcpu > 0 {
 			cpu = userPod.Resource.Vcpu
@@ -302,28 +389,36 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 		if userPod.Resource.Memory > 0 {
 			mem = userPod.Resource.Memory
 		}
+		if code, cause, err := daemon.admitVm(vmId, cpu, mem); err != nil {
+			return code, cause, err
+		}
+		cfg := daemon.config()
 		b := &hypervisor.BootConfig{
 			CPU:    cpu,
 			Memory: mem,
-			Kernel: daemon.kernel,
-			Initrd: daemon.initrd,
-			Bios:   daemon.bios,
-			Cbfs:   daemon.cbfs,
+			Kernel: kernel,
+			Initrd: initrd,
+			Bios:   cfg.Bios,
+			Cbfs:   cfg.Cbfs,
+			Params: userPod.KernelParams,
+
+			BootTimeout: cfg.BootTimeout,
 		}
-		go hypervisor.VmLoop(hypervisorDriver, vmId, qemuPodEvent, qemuStatus, b)
-		if err := daemon.SetQemuChan(vmId, qemuPodEvent, qemuStatus, subQemuStatus); err != nil {
+		go hypervisor.VmLoop(hypervisorDriver, vmId, qemuChan.Event, qemuChan.Status, b)
+		if err := daemon.SetQemuChan(vmId, qemuChan); err != nil {
 			glog.V(1).Infof("SetQemuChan error: %s", err.Error())
+			daemon.releaseVm(vmId)
 			return -1, "", err
 		}
 
 	} else {
-		ret1, ret2, ret3, err := daemon.GetQemuChan(vmId)
+		if userPod.Kernel != "" || len(userPod.KernelParams) > 0 {
+			return -1, "", fmt.Errorf("Pod %s chooses the kernel of its VM, it could not run in VM %s", podId, vmId)
+		}
+		qemuChan, err = daemon.GetQemuChan(vmId)
 		if err != nil {
 			return -1, "", err
 		}
-		qemuPodEvent = ret1.(chan hypervisor.VmEvent)
-		qemuStatus = ret2.(chan *types.QemuResponse)
-		subQemuStatus = ret3.(chan *types.QemuResponse)
 	}
 	if podArgs != "" {
 		wg = new(sync.WaitGroup)
@@ -331,25 +426,8 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 			glog.Error(err.Error())
 			return -1, "", err
 		}
-		mypod = daemon.podList[podId]
-	}
-
-	storageDriver = daemon.Storage.StorageType
-	if storageDriver == "devicemapper" {
-		poolName = daemon.Storage.PoolName
-		fstype = daemon.Storage.Fstype
-		volPoolName = "hyper-volume-pool"
-		devPrefix = poolName[:strings.Index(poolName, "-pool")]
-		rootPath = "/var/lib/docker/devicemapper"
-		rootfs = "/rootfs"
-	} else if storageDriver == "aufs" {
-		rootPath = daemon.Storage.RootPath
-		fstype = daemon.Storage.Fstype
-		rootfs = ""
-	} else if storageDriver == "overlay" {
-		rootPath = daemon.Storage.RootPath
-		fstype = daemon.Storage.Fstype
-		rootfs = ""
+		mypod = daemon.registry.Pod(podId)
+		daemon.LogPodEvent(podId, "create", "")
 	}
 
 	// Process the 'Files' section
@@ -358,276 +436,87 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 		files[v.Name] = v
 	}
 
-	for i, c := range mypod.Containers {
-		var jsonResponse *docker.ConfigJSON
-		if jsonResponse, err = cli.GetContainerInfo(c.Id); err != nil {
-			glog.Error("got error when get container Info ", err.Error())
+	for i, c := range mypod.Containers() {
+		containerInfo, err := daemon.prepareContainer(c.Id, sharedDir, &userPod.Containers[i], files)
+		if err != nil {
 			return -1, "", err
 		}
-
-		if storageDriver == "devicemapper" {
-			if err := dm.CreateNewDevice(c.Id, devPrefix, rootPath); err != nil {
-				return -1, "", err
-			}
-			devFullName, err = dm.MountContainerToSharedDir(c.Id, sharedDir, devPrefix)
-			if err != nil {
-				glog.Error("got error when mount container to share dir ", err.Error())
-				return -1, "", err
-			}
-			fstype, err = dm.ProbeFsType(devFullName)
-			if err != nil {
-				fstype = "ext4"
-			}
-		} else if storageDriver == "aufs" {
-			devFullName, err = aufs.MountContainerToSharedDir(c.Id, rootPath, sharedDir, "")
-			if err != nil {
-				glog.Error("got error when mount container to share dir ", err.Error())
-				return -1, "", err
-			}
-			devFullName = "/" + c.Id + "/rootfs"
-		} else if storageDriver == "overlay" {
-			devFullName, err = overlay.MountContainerToSharedDir(c.Id, rootPath, sharedDir, "")
-			if err != nil {
-				glog.Error("got error when mount container to share dir ", err.Error())
-				return -1, "", err
-			}
-			devFullName = "/" + c.Id + "/rootfs"
-		}
-
-		for _, f := range userPod.Containers[i].Files {
-			targetPath := f.Path
-			file, ok := files[f.Filename]
-			if !ok {
-				continue
-			}
-			var fromFile = "/tmp/" + file.Name
-			defer os.RemoveAll(fromFile)
-			if file.Uri != "" {
-				err = utils.DownloadFile(file.Uri, fromFile)
-				if err != nil {
-					return -1, "", err
-				}
-			} else if file.Contents != "" {
-				err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
-				if err != nil {
-					return -1, "", err
-				}
-			} else {
-				continue
-			}
-			// we need to decode the content
-			fi, err := os.Open(fromFile)
-			if err != nil {
-				return -1, "", err
-			}
-			defer fi.Close()
-			fileContent, err := ioutil.ReadAll(fi)
-			if err != nil {
-				return -1, "", err
-			}
-			if file.Encoding == "base64" {
-				newContent, err := utils.Base64Decode(string(fileContent))
-				if err != nil {
-					return -1, "", err
-				}
-				err = ioutil.WriteFile(fromFile, []byte(newContent), 0666)
-				if err != nil {
-					return -1, "", err
-				}
-			} else {
-				err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
-				if err != nil {
-					return -1, "", err
-				}
-			}
-			// get the uid and gid for that attached file
-			fileUser := f.User
-			fileGroup := f.Group
-			u, _ := user.Current()
-			if fileUser == "" {
-				uid = u.Uid
-			} else {
-				u, _ = user.Lookup(fileUser)
-				uid = u.Uid
-				gid = u.Gid
-			}
-			if fileGroup == "" {
-				gid = u.Gid
-			}
-
-			if storageDriver == "devicemapper" {
-				err := dm.AttachFiles(c.Id, devPrefix, fromFile, targetPath, rootPath, f.Perm, uid, gid)
-				if err != nil {
-					glog.Error("got error when attach files ", err.Error())
-					return -1, "", err
-				}
-			} else if storageDriver == "aufs" {
-				err := aufs.AttachFiles(c.Id, fromFile, targetPath, sharedDir, f.Perm, uid, gid)
-				if err != nil {
-					glog.Error("got error when attach files ", err.Error())
-					return -1, "", err
-				}
-			} else if storageDriver == "overlay" {
-				err := overlay.AttachFiles(c.Id, fromFile, targetPath, sharedDir, f.Perm, uid, gid)
-				if err != nil {
-					glog.Error("got error when attach files ", err.Error())
-					return -1, "", err
-				}
-			}
-		}
-
-		env := make(map[string]string)
-		for _, v := range jsonResponse.Config.Env {
-			env[v[:strings.Index(v, "=")]] = v[strings.Index(v, "=")+1:]
-		}
-		for _, e := range userPod.Containers[i].Envs {
-			env[e.Env] = e.Value
-		}
-		glog.V(1).Infof("Parsing envs for container %d: %d Evs", i, len(env))
-		glog.V(1).Infof("The fs type is %s", fstype)
-		glog.V(1).Infof("WorkingDir is %s", string(jsonResponse.Config.WorkingDir))
-		glog.V(1).Infof("Image is %s", string(devFullName))
-		containerInfo := &hypervisor.ContainerInfo{
-			Id:         c.Id,
-			Rootfs:     rootfs,
-			Image:      devFullName,
-			Fstype:     fstype,
-			Workdir:    jsonResponse.Config.WorkingDir,
-			Entrypoint: jsonResponse.Config.Entrypoint,
-			Cmd:        jsonResponse.Config.Cmd,
-			Envs:       env,
-		}
-		glog.V(1).Infof("Container Info is \n%v", containerInfo)
 		containerInfoList = append(containerInfoList, containerInfo)
-		glog.V(1).Infof("container %d created %s, workdir %s, env: %v", i, c.Id, jsonResponse.Config.WorkingDir, env)
 	}
 
 	// Process the 'Volumes' section
 	for _, v := range userPod.Volumes {
-		if v.Source == "" {
-			if storageDriver == "devicemapper" {
-				volName := fmt.Sprintf("%s-%s-%s", volPoolName, podId, v.Name)
-				dev_id, _ := daemon.GetVolumeId(podId, volName)
-				glog.Error("DeviceID is %d", dev_id)
-				if dev_id < 1 {
-					dev_id, _ = daemon.GetMaxDeviceId()
-					err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id+1), false)
-					if err != nil {
-						return -1, "", err
-					}
-				} else {
-					err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id), true)
-					if err != nil {
-						return -1, "", err
-					}
-				}
-
-				fstype, err = dm.ProbeFsType("/dev/mapper/" + volName)
-				if err != nil {
-					fstype = "ext4"
-				}
-				myVol := &hypervisor.VolumeInfo{
-					Name:     v.Name,
-					Filepath: path.Join("/dev/mapper/", volName),
-					Fstype:   fstype,
-					Format:   "raw",
-				}
-				volumuInfoList = append(volumuInfoList, myVol)
-				glog.V(1).Infof("volume %s created with dm as %s", v.Name, volName)
-				continue
-
-			} else {
-				// Make sure the v.Name is given
-				v.Source = path.Join("/var/tmp/hyper/", v.Name)
-				if _, err := os.Stat(v.Source); err != nil && os.IsNotExist(err) {
-					if err := os.MkdirAll(v.Source, os.FileMode(0777)); err != nil {
-						return -1, "", err
-					}
-				}
-				v.Driver = "vfs"
-			}
-		}
-
-		if v.Driver != "vfs" {
-			glog.V(1).Infof("bypass %s volume %s", v.Driver, v.Name)
-			continue
-		}
-
-		// Process the situation if the source is not NULL, we need to bind that dir to sharedDir
-		var flags uintptr = syscall.MS_BIND
-
-		mountSharedDir := pod.RandStr(10, "alpha")
-		targetDir := path.Join(sharedDir, mountSharedDir)
-		glog.V(1).Infof("trying to bind dir %s to %s", v.Source, targetDir)
-
-		if err := os.MkdirAll(targetDir, 0755); err != nil && !os.IsExist(err) {
-			glog.Errorf("error to create dir %s for volume %s", targetDir, v.Name)
-			return -1, "", err
-		}
-
-		if err := syscall.Mount(v.Source, targetDir, "dir", flags, "--bind"); err != nil {
-			glog.Errorf("bind dir %s failed: %s", v.Source, err.Error())
+		myVol, err := daemon.prepareVolume(podId, sharedDir, v)
+		if err != nil {
 			return -1, "", err
 		}
-		myVol := &hypervisor.VolumeInfo{
-			Name:     v.Name,
-			Filepath: mountSharedDir,
-			Fstype:   "dir",
-			Format:   "",
+		if myVol != nil {
+			volumuInfoList = append(volumuInfoList, myVol)
 		}
-		glog.V(1).Infof("dir %s is bound to %s", v.Source, targetDir)
-		volumuInfoList = append(volumuInfoList, myVol)
 	}
 
-	go func(interface{}) {
+	go func(mypod *Pod, qemuStatus, subQemuStatus chan *types.QemuResponse) {
+		bootFailed := false
 		for {
 			qemuResponse := <-qemuStatus
+			if qemuResponse.Code == types.E_CONTAINER_EXITED {
+				daemon.containerExited(podId, qemuResponse.Data.(*hypervisor.ContainerExit))
+				continue
+			}
 			subQemuStatus <- qemuResponse
-			if qemuResponse.Code == types.E_POD_FINISHED {
+			if qemuResponse.Code == types.E_VM_RUNNING {
+				daemon.LogEvent("vm", "boot", vmId, podId, vmId, "")
+			} else if qemuResponse.Code == types.E_BOOT_FAILED {
+				bootFailed = true
+				daemon.LogEvent("vm", "boot-fail", vmId, podId, vmId, strings.SplitN(qemuResponse.Cause, "\n", 2)[0])
+			} else if qemuResponse.Code == types.E_VM_SHUTDOWN && bootFailed {
+				// the pod is going to be retried on another VM or failed
+				// by bootPod, leave its status alone
+				daemon.LogEvent("vm", "shutdown", vmId, podId, vmId, "")
+				daemon.RemoveVm(vmId)
+				daemon.DeleteQemuChan(vmId)
+				break
+			} else if qemuResponse.Code == types.E_POD_FINISHED {
 				data := qemuResponse.Data.([]uint32)
 				daemon.SetPodContainerStatus(podId, data)
-				daemon.podList[podId].Vm = ""
+				mypod.SetVm("")
 			} else if qemuResponse.Code == types.E_VM_SHUTDOWN {
-				if daemon.podList[podId].Status == types.S_POD_RUNNING {
-					daemon.podList[podId].Status = types.S_POD_SUCCEEDED
+				daemon.LogEvent("vm", "shutdown", vmId, podId, vmId, "")
+				if mypod.Transit(types.S_POD_SUCCEEDED, types.S_POD_RUNNING) {
+					daemon.podExited(podId, "vm shutdown")
 					daemon.SetContainerStatus(podId, types.S_POD_SUCCEEDED)
 				}
-				daemon.podList[podId].Vm = ""
+				mypod.SetVm("")
 				daemon.RemoveVm(vmId)
 				daemon.DeleteQemuChan(vmId)
-				mypod = daemon.podList[podId]
 				if mypod.Type == "kubernetes" {
-					switch mypod.Status {
+					switch mypod.Status() {
 					case types.S_POD_SUCCEEDED:
-						if mypod.RestartPolicy == "always" {
-							daemon.RestartPod(mypod)
+						if mypod.RestartPolicy() == "always" {
+							daemon.ScheduleRestart(mypod)
 						} else {
 							daemon.DeletePodFromDB(podId)
-							for _, c := range daemon.podList[podId].Containers {
+							for _, c := range mypod.Containers() {
 								glog.V(1).Infof("Ready to rm container: %s", c.Id)
 								if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 									glog.V(1).Infof("Error to rm container: %s", err.Error())
 								}
 							}
 							//							daemon.RemovePod(podId)
-							daemon.DeletePodContainerFromDB(podId)
-							daemon.DeleteVolumeId(podId)
 						}
 						break
 					case types.S_POD_FAILED:
-						if mypod.RestartPolicy != "never" {
-							daemon.RestartPod(mypod)
+						if mypod.RestartPolicy() != "never" {
+							daemon.ScheduleRestart(mypod)
 						} else {
 							daemon.DeletePodFromDB(podId)
-							for _, c := range daemon.podList[podId].Containers {
+							for _, c := range mypod.Containers() {
 								glog.V(1).Infof("Ready to rm container: %s", c.Id)
 								if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 									glog.V(1).Infof("Error to rm container: %s", err.Error())
 								}
 							}
 							//							daemon.RemovePod(podId)
-							daemon.DeletePodContainerFromDB(podId)
-							daemon.DeleteVolumeId(podId)
 						}
 						break
 					default:
@@ -637,9 +526,9 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 				break
 			}
 		}
-	}(subQemuStatus)
+	}(mypod, qemuChan.Status, qemuChan.SubStatus)
 
-	if daemon.podList[podId].Type == "kubernetes" {
+	if mypod.Type == "kubernetes" {
 		for _, c := range userPod.Containers {
 			c.RestartPolicy = "never"
 		}
@@ -652,15 +541,16 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 		Volumes:    volumuInfoList,
 		Wg:         wg,
 	}
-	qemuPodEvent <- runPodEvent
-	daemon.podList[podId].Status = types.S_POD_RUNNING
+	qemuChan.Event <- runPodEvent
+	mypod.SetStatus(types.S_POD_RUNNING)
+	daemon.podStarted(podId)
 	// Set the container status to online
 	daemon.SetContainerStatus(podId, types.S_POD_RUNNING)
 
 	// wait for the qemu response
 	var qemuResponse *types.QemuResponse
 	for {
-		qemuResponse = <-subQemuStatus
+		qemuResponse = <-qemuChan.SubStatus
 		glog.V(1).Infof("Get the response from QEMU, VM id is %s!", qemuResponse.VmId)
 		if qemuResponse.Code == types.E_VM_RUNNING {
 			continue
@@ -669,13 +559,15 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 			break
 		}
 	}
+	if qemuResponse.Code == types.E_BOOT_FAILED {
+		return qemuResponse.Code, qemuResponse.Cause, fmt.Errorf("%s", qemuResponse.Cause)
+	}
 	if qemuResponse.Data == nil {
 		return qemuResponse.Code, qemuResponse.Cause, fmt.Errorf("QEMU response data is nil")
 	}
 	data := qemuResponse.Data.([]byte)
-	daemon.UpdateVmData(vmId, data)
 	// add or update the Vm info for POD
-	if err := daemon.UpdateVmByPod(podId, vmId); err != nil {
+	if err := daemon.UpdateVmByPod(podId, vmId, data); err != nil {
 		glog.Error(err.Error())
 	}
 
@@ -683,11 +575,269 @@ func (daemon *Daemon) StartPod(podId, vmId, podArgs string) (int, string, error)
 	return qemuResponse.Code, qemuResponse.Cause, nil
 }
 
+// prepareContainer mounts the rootfs of a container to the share dir of the
+// VM, or creates its dm device, attaches the files of its spec and gathers
+// the info the VM needs to run it. The rootfs is released if it fails.
+func (daemon *Daemon) prepareContainer(id, sharedDir string, spec *pod.UserContainer, files map[string]pod.UserFile) (_ *hypervisor.ContainerInfo, err error) {
+	var (
+		fstype        string
+		devPrefix     string
+		rootPath      string
+		devFullName   string
+		rootfs        string
+		uid           string
+		gid           string
+		cli           = daemon.dockerCli
+		storageDriver = daemon.Storage.StorageType
+	)
+	defer func() {
+		if err != nil && devFullName != "" {
+			releaseRootfs(sharedDir, &hypervisor.ContainerInfo{Id: id, Image: devFullName, Fstype: fstype})
+		}
+	}()
+	if storageDriver == "devicemapper" {
+		poolName := daemon.Storage.PoolName
+		fstype = daemon.Storage.Fstype
+		devPrefix = poolName[:strings.Index(poolName, "-pool")]
+		rootPath = "/var/lib/docker/devicemapper"
+		rootfs = "/rootfs"
+	} else if storageDriver == "aufs" || storageDriver == "overlay" {
+		rootPath = daemon.Storage.RootPath
+		fstype = daemon.Storage.Fstype
+		rootfs = ""
+	}
+
+	var jsonResponse *docker.ConfigJSON
+	if jsonResponse, err = cli.GetContainerInfo(id); err != nil {
+		glog.Error("got error when get container Info ", err.Error())
+		return nil, err
+	}
+
+	if storageDriver == "devicemapper" {
+		if err := dm.CreateNewDevice(id, devPrefix, rootPath); err != nil {
+			return nil, err
+		}
+		devFullName, err = dm.MountContainerToSharedDir(id, sharedDir, devPrefix)
+		if err != nil {
+			glog.Error("got error when mount container to share dir ", err.Error())
+			return nil, err
+		}
+		fstype, err = dm.ProbeFsType(devFullName)
+		if err != nil {
+			fstype = "ext4"
+		}
+	} else if storageDriver == "aufs" {
+		devFullName, err = aufs.MountContainerToSharedDir(id, rootPath, sharedDir, "")
+		if err != nil {
+			glog.Error("got error when mount container to share dir ", err.Error())
+			return nil, err
+		}
+		devFullName = "/" + id + "/rootfs"
+	} else if storageDriver == "overlay" {
+		devFullName, err = overlay.MountContainerToSharedDir(id, rootPath, sharedDir, "")
+		if err != nil {
+			glog.Error("got error when mount container to share dir ", err.Error())
+			return nil, err
+		}
+		devFullName = "/" + id + "/rootfs"
+	}
+
+	for _, f := range spec.Files {
+		targetPath := f.Path
+		file, ok := files[f.Filename]
+		if !ok {
+			continue
+		}
+		var fromFile = "/tmp/" + file.Name
+		defer os.RemoveAll(fromFile)
+		if file.Uri != "" {
+			err = utils.DownloadFile(file.Uri, fromFile)
+			if err != nil {
+				return nil, err
+			}
+		} else if file.Contents != "" {
+			err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			continue
+		}
+		// we need to decode the content
+		fi, err := os.Open(fromFile)
+		if err != nil {
+			return nil, err
+		}
+		defer fi.Close()
+		fileContent, err := ioutil.ReadAll(fi)
+		if err != nil {
+			return nil, err
+		}
+		if file.Encoding == "base64" {
+			newContent, err := utils.Base64Decode(string(fileContent))
+			if err != nil {
+				return nil, err
+			}
+			err = ioutil.WriteFile(fromFile, []byte(newContent), 0666)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			err = ioutil.WriteFile(fromFile, []byte(file.Contents), 0666)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// get the uid and gid for that attached file
+		fileUser := f.User
+		fileGroup := f.Group
+		u, _ := user.Current()
+		if fileUser == "" {
+			uid = u.Uid
+		} else {
+			u, _ = user.Lookup(fileUser)
+			uid = u.Uid
+			gid = u.Gid
+		}
+		if fileGroup == "" {
+			gid = u.Gid
+		}
+
+		if storageDriver == "devicemapper" {
+			err := dm.AttachFiles(id, devPrefix, fromFile, targetPath, rootPath, f.Perm, uid, gid)
+			if err != nil {
+				glog.Error("got error when attach files ", err.Error())
+				return nil, err
+			}
+		} else if storageDriver == "aufs" {
+			err := aufs.AttachFiles(id, fromFile, targetPath, sharedDir, f.Perm, uid, gid)
+			if err != nil {
+				glog.Error("got error when attach files ", err.Error())
+				return nil, err
+			}
+		} else if storageDriver == "overlay" {
+			err := overlay.AttachFiles(id, fromFile, targetPath, sharedDir, f.Perm, uid, gid)
+			if err != nil {
+				glog.Error("got error when attach files ", err.Error())
+				return nil, err
+			}
+		}
+	}
+
+	env := make(map[string]string)
+	for _, v := range jsonResponse.Config.Env {
+		env[v[:strings.Index(v, "=")]] = v[strings.Index(v, "=")+1:]
+	}
+	for _, e := range spec.Envs {
+		env[e.Env] = e.Value
+	}
+	glog.V(1).Infof("Parsing envs for container %s: %d Evs", id, len(env))
+	glog.V(1).Infof("The fs type is %s", fstype)
+	glog.V(1).Infof("WorkingDir is %s", string(jsonResponse.Config.WorkingDir))
+	glog.V(1).Infof("Image is %s", string(devFullName))
+	containerInfo := &hypervisor.ContainerInfo{
+		Id:         id,
+		Rootfs:     rootfs,
+		Image:      devFullName,
+		Fstype:     fstype,
+		Workdir:    jsonResponse.Config.WorkingDir,
+		Entrypoint: jsonResponse.Config.Entrypoint,
+		Cmd:        jsonResponse.Config.Cmd,
+		Envs:       env,
+	}
+	glog.V(1).Infof("Container Info is \n%v", containerInfo)
+	glog.V(1).Infof("container %s created, workdir %s, env: %v", id, jsonResponse.Config.WorkingDir, env)
+
+	return containerInfo, nil
+}
+
+// prepareVolume creates the dm device of a volume without source, or binds
+// the dir of a vfs volume to the share dir of the VM. Volumes of the other
+// drivers are passed to the VM as they are, and get no info.
+func (daemon *Daemon) prepareVolume(podId, sharedDir string, v pod.UserVolume) (*hypervisor.VolumeInfo, error) {
+	var (
+		storageDriver = daemon.Storage.StorageType
+		volPoolName   = "hyper-volume-pool"
+	)
+	if v.Source == "" {
+		if storageDriver == "devicemapper" {
+			volName := fmt.Sprintf("%s-%s-%s", volPoolName, podId, v.Name)
+			dev_id, _ := daemon.GetVolumeId(podId, volName)
+			glog.Error("DeviceID is %d", dev_id)
+			if dev_id < 1 {
+				dev_id, _ = daemon.GetMaxDeviceId()
+				err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id+1), false)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				err := daemon.CreateVolume(podId, volName, fmt.Sprintf("%d", dev_id), true)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			fstype, err := dm.ProbeFsType("/dev/mapper/" + volName)
+			if err != nil {
+				fstype = "ext4"
+			}
+			myVol := &hypervisor.VolumeInfo{
+				Name:     v.Name,
+				Filepath: path.Join("/dev/mapper/", volName),
+				Fstype:   fstype,
+				Format:   "raw",
+			}
+			glog.V(1).Infof("volume %s created with dm as %s", v.Name, volName)
+			return myVol, nil
+
+		} else {
+			// Make sure the v.Name is given
+			v.Source = path.Join("/var/tmp/hyper/", v.Name)
+			if _, err := os.Stat(v.Source); err != nil && os.IsNotExist(err) {
+				if err := os.MkdirAll(v.Source, os.FileMode(0777)); err != nil {
+					return nil, err
+				}
+			}
+			v.Driver = "vfs"
+		}
+	}
+
+	if v.Driver != "vfs" {
+		glog.V(1).Infof("bypass %s volume %s", v.Driver, v.Name)
+		return nil, nil
+	}
+
+	// Process the situation if the source is not NULL, we need to bind that dir to sharedDir
+	var flags uintptr = syscall.MS_BIND
+
+	mountSharedDir := pod.RandStr(10, "alpha")
+	targetDir := path.Join(sharedDir, mountSharedDir)
+	glog.V(1).Infof("trying to bind dir %s to %s", v.Source, targetDir)
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil && !os.IsExist(err) {
+		glog.Errorf("error to create dir %s for volume %s", targetDir, v.Name)
+		return nil, err
+	}
+
+	if err := syscall.Mount(v.Source, targetDir, "dir", flags, "--bind"); err != nil {
+		glog.Errorf("bind dir %s failed: %s", v.Source, err.Error())
+		return nil, err
+	}
+	myVol := &hypervisor.VolumeInfo{
+		Name:     v.Name,
+		Filepath: mountSharedDir,
+		Fstype:   "dir",
+		Format:   "",
+	}
+	glog.V(1).Infof("dir %s is bound to %s", v.Source, targetDir)
+	return myVol, nil
+}
+
 // The caller must make sure that the restart policy and the status is right to restart
 func (daemon *Daemon) RestartPod(mypod *Pod) error {
 	// Remove the pod
 	// The pod is stopped, the vm is gone
-	for _, c := range mypod.Containers {
+	for _, c := range mypod.Containers() {
 		glog.V(1).Infof("Ready to rm container: %s", c.Id)
 		if _, _, err := daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 			glog.V(1).Infof("Error to rm container: %s", err.Error())
@@ -699,30 +849,32 @@ func (daemon *Daemon) RestartPod(mypod *Pod) error {
 	podData, err := daemon.GetPodByName(mypod.Id)
 	vmId := fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
 	// Start the pod
-	_, _, err = daemon.StartPod(mypod.Id, vmId, string(podData))
+	vmId, _, _, err = daemon.bootPod(mypod.Id, vmId, string(podData))
 	if err != nil {
 		daemon.KillVm(vmId)
 		glog.Error(err.Error())
 		return err
 	}
-	if err := daemon.WritePodAndContainers(mypod.Id); err != nil {
-		glog.Error("Found an error while saving the Containers info")
-		return err
-	}
 	userPod, err := pod.ProcessPodBytes(podData)
 	if err != nil {
 		return err
 	}
 
+	// the pod is created again by bootPod
+	newPod := daemon.registry.Pod(mypod.Id)
+	if newPod == nil {
+		return fmt.Errorf("Can not find the POD instance of %s", mypod.Id)
+	}
 	vm := &Vm{
 		Id:     vmId,
-		Pod:    daemon.podList[mypod.Id],
-		Status: types.S_VM_ASSOCIATED,
+		Pod:    newPod,
 		Cpu:    userPod.Resource.Vcpu,
 		Mem:    userPod.Resource.Memory,
+		status: types.S_VM_ASSOCIATED,
 	}
-	daemon.podList[mypod.Id].Vm = vmId
+	newPod.SetVm(vmId)
 	daemon.AddVm(vm)
+	daemon.LogPodEvent(mypod.Id, "restart", "")
 
 	return nil
 }
@@ -734,13 +886,15 @@ func (daemon *Daemon) CmdPodInfo(job *engine.Job) error {
 	podName := job.Args[0]
 	vmId := ""
 	// We need to find the VM which running the POD
-	pod, ok := daemon.podList[podName]
-	if ok {
-		vmId = pod.Vm
+	if pod := daemon.registry.Pod(podName); pod != nil {
+		vmId = pod.Vm()
 	}
 	glog.V(1).Infof("Process POD %s: VM ID is %s", podName, vmId)
+	restarts, lastExits := daemon.PodRestarts(podName)
 	v := &engine.Env{}
 	v.Set("hostname", vmId)
+	v.SetInt("restarts", restarts)
+	v.SetList("lastExits", lastExits)
 	if _, err := v.WriteTo(job.Stdout); err != nil {
 		return err
 	}
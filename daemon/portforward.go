@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+
+	"hyper/engine"
+	"hyper/hypervisor"
+	"hyper/lib/glog"
+	"hyper/types"
+)
+
+// CmdPortForward relays the hijacked client stream to a TCP port inside a
+// running pod. The connection goes through the VM channel, no iptables rule
+// or HostPort is involved.
+func (daemon *Daemon) CmdPortForward(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not forward port without pod and port!")
+	}
+	var (
+		podName = job.Args[0]
+		tag     = ""
+	)
+	if len(job.Args) > 2 {
+		tag = job.Args[2]
+	}
+	port, err := strconv.Atoi(job.Args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("Invalid port %s", job.Args[1])
+	}
+
+	mypod := daemon.registry.Pod(podName)
+	if mypod == nil {
+		return fmt.Errorf("Can not find the POD instance of %s", podName)
+	}
+	if mypod.Status() != types.S_POD_RUNNING || mypod.Vm() == "" {
+		return fmt.Errorf("The POD %s is not running", podName)
+	}
+
+	cmd := &hypervisor.PortForwardCommand{
+		Port: port,
+		Streams: &hypervisor.TtyIO{
+			Stdin:     job.Stdin,
+			ClientTag: tag,
+			Callback:  make(chan *types.QemuResponse, 1),
+		},
+	}
+	cmd.Streams.Stdout, cmd.Streams.Stderr = multiplexStreams(job.Stdout)
+
+	qemuChan, err := daemon.GetQemuChan(mypod.Vm())
+	if err != nil {
+		return err
+	}
+	glog.V(1).Infof("forward connection to port %d of pod %s", port, podName)
+	qemuChan.Event <- cmd
+
+	res := <-cmd.Streams.Callback
+	if res.Code != types.E_EXEC_FINISH {
+		return fmt.Errorf("Fail to forward port %d: %s", port, res.Cause)
+	}
+	return nil
+}
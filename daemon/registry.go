@@ -0,0 +1,423 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"hyper/hypervisor"
+	"hyper/types"
+)
+
+// QemuChan holds the channels of the loop of a VM. Event takes the commands
+// to the VM, Status gets its reports, and SubStatus gets the reports passed
+// on by the status goroutine of the pod running in the VM.
+type QemuChan struct {
+	Event     chan hypervisor.VmEvent
+	Status    chan *types.QemuResponse
+	SubStatus chan *types.QemuResponse
+}
+
+func newQemuChan() *QemuChan {
+	return &QemuChan{
+		Event:     make(chan hypervisor.VmEvent, 128),
+		Status:    make(chan *types.QemuResponse, 128),
+		SubStatus: make(chan *types.QemuResponse, 128),
+	}
+}
+
+// registry holds the pods, the VMs and the containers of the daemon, and
+// the channels of the VMs. It is used by the jobs and by the status
+// goroutines of the pods at the same time.
+type registry struct {
+	lock       sync.RWMutex
+	pods       map[string]*Pod
+	vms        map[string]*Vm
+	containers []*Container
+	chans      map[string]*QemuChan
+}
+
+func newRegistry() *registry {
+	return &registry{
+		pods:       make(map[string]*Pod),
+		vms:        make(map[string]*Vm),
+		containers: []*Container{},
+		chans:      make(map[string]*QemuChan),
+	}
+}
+
+// Pod returns the pod of the id, or nil
+func (r *registry) Pod(podId string) *Pod {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.pods[podId]
+}
+
+// Pods returns the pods at the moment
+func (r *registry) Pods() []*Pod {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	pods := make([]*Pod, 0, len(r.pods))
+	for _, p := range r.pods {
+		pods = append(pods, p)
+	}
+	return pods
+}
+
+// AddPod adds a pod, it fails if there is already a pod of the same id or
+// of the same name
+func (r *registry) AddPod(p *Pod) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.pods[p.Id]; ok {
+		return fmt.Errorf("The pod %s already exists", p.Id)
+	}
+	if other := r.podByName(p.Name); other != nil {
+		return fmt.Errorf("The pod name %s is already used by pod %s", p.Name, other.Id)
+	}
+	r.pods[p.Id] = p
+	return nil
+}
+
+// podByName returns the pod of the name, or nil, the caller must hold the
+// lock
+func (r *registry) podByName(name string) *Pod {
+	if name == "" {
+		return nil
+	}
+	for _, p := range r.pods {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// PodByName returns the pod of the name, or nil
+func (r *registry) PodByName(name string) *Pod {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.podByName(name)
+}
+
+// LookupPod returns the pod a user refers to, by its id, its name or a
+// prefix of its id matching no other pod, in this order
+func (r *registry) LookupPod(ref string) (*Pod, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if p, ok := r.pods[ref]; ok {
+		return p, nil
+	}
+	if p := r.podByName(ref); p != nil {
+		return p, nil
+	}
+	var found *Pod
+	if ref != "" {
+		for id, p := range r.pods {
+			if !strings.HasPrefix(id, ref) {
+				continue
+			}
+			if found != nil {
+				return nil, fmt.Errorf("The pod %s is ambiguous, it matches %s and %s", ref, found.Id, p.Id)
+			}
+			found = p
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("Can not find that Pod(%s)", ref)
+	}
+	return found, nil
+}
+
+// RemovePod removes a pod and its containers
+func (r *registry) RemovePod(podId string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	containers := []*Container{}
+	for _, c := range r.containers {
+		if c.PodId != podId {
+			containers = append(containers, c)
+		}
+	}
+	r.containers = containers
+	delete(r.pods, podId)
+}
+
+// Vm returns the VM of the id, or nil
+func (r *registry) Vm(vmId string) *Vm {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.vms[vmId]
+}
+
+// Vms returns the VMs at the moment
+func (r *registry) Vms() []*Vm {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	vms := make([]*Vm, 0, len(r.vms))
+	for _, v := range r.vms {
+		vms = append(vms, v)
+	}
+	return vms
+}
+
+func (r *registry) AddVm(vm *Vm) {
+	r.lock.Lock()
+	r.vms[vm.Id] = vm
+	r.lock.Unlock()
+}
+
+func (r *registry) RemoveVm(vmId string) {
+	r.lock.Lock()
+	delete(r.vms, vmId)
+	r.lock.Unlock()
+}
+
+// Containers returns the containers at the moment
+func (r *registry) Containers() []*Container {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]*Container{}, r.containers...)
+}
+
+// PodContainers returns the containers of a pod
+func (r *registry) PodContainers(podId string) []*Container {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	containers := []*Container{}
+	for _, c := range r.containers {
+		if c.PodId == podId {
+			containers = append(containers, c)
+		}
+	}
+	return containers
+}
+
+func (r *registry) AddContainer(c *Container) {
+	r.lock.Lock()
+	r.containers = append(r.containers, c)
+	r.lock.Unlock()
+}
+
+func (r *registry) RemoveContainer(containerId string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, c := range r.containers {
+		if c.Id == containerId {
+			r.containers = append(r.containers[:i], r.containers[i+1:]...)
+			break
+		}
+	}
+}
+
+// Container returns the container of the id, or nil
+func (r *registry) Container(containerId string) *Container {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, c := range r.containers {
+		if c.Id == containerId {
+			return c
+		}
+	}
+	return nil
+}
+
+// QemuChan returns the channels of a VM
+func (r *registry) QemuChan(vmId string) (*QemuChan, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if c, ok := r.chans[vmId]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("Can not find the Qemu chan for pod: %s!", vmId)
+}
+
+// SetQemuChan sets the channels of a VM, it fails if the VM has some
+func (r *registry) SetQemuChan(vmId string, c *QemuChan) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.chans[vmId]; ok {
+		return fmt.Errorf("Already find a Qemu chan for vm: %s!", vmId)
+	}
+	r.chans[vmId] = c
+	return nil
+}
+
+func (r *registry) DeleteQemuChan(vmId string) {
+	r.lock.Lock()
+	delete(r.chans, vmId)
+	r.lock.Unlock()
+}
+
+// QemuChanIds returns the VMs having channels, the ones being booted too
+func (r *registry) QemuChanIds() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ids := make([]string, 0, len(r.chans))
+	for vmId := range r.chans {
+		ids = append(ids, vmId)
+	}
+	return ids
+}
+
+// lockPod locks the pod of the id for a job, it returns nil if there is no
+// such pod, or it has been removed while waiting for the lock.
+func (daemon *Daemon) lockPod(podId string) *Pod {
+	mypod := daemon.registry.Pod(podId)
+	if mypod == nil {
+		return nil
+	}
+	mypod.Lock()
+	if daemon.registry.Pod(podId) != mypod {
+		mypod.Unlock()
+		return nil
+	}
+	return mypod
+}
+
+// Lock serializes the jobs changing the pod, like start, stop and rm
+func (p *Pod) Lock() {
+	p.busy.Lock()
+}
+
+func (p *Pod) Unlock() {
+	p.busy.Unlock()
+}
+
+func (p *Pod) Status() uint {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.status
+}
+
+func (p *Pod) SetStatus(status uint) {
+	p.lock.Lock()
+	p.setStatus(status)
+	p.lock.Unlock()
+}
+
+// setStatus changes the status and notes when the pod starts and finishes
+// running, the caller must hold the lock
+func (p *Pod) setStatus(status uint) {
+	if status == types.S_POD_RUNNING && p.status != types.S_POD_RUNNING {
+		p.started = time.Now()
+		p.finished = time.Time{}
+	} else if status != types.S_POD_RUNNING && p.status == types.S_POD_RUNNING {
+		p.finished = time.Now()
+	}
+	p.status = status
+}
+
+// Times returns when the pod was created, and last started and finished
+// running, the zero time for the ones hyperd does not know
+func (p *Pod) Times() (created, started, finished time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.created, p.started, p.finished
+}
+
+// Transit changes the status of the pod to `to` if it is one of `from`, it
+// returns false and leaves the status alone otherwise.
+func (p *Pod) Transit(to uint, from ...uint) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, f := range from {
+		if p.status == f {
+			p.setStatus(to)
+			return true
+		}
+	}
+	return false
+}
+
+// Vm returns the id of the VM running the pod, or ""
+func (p *Pod) Vm() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.vm
+}
+
+func (p *Pod) SetVm(vmId string) {
+	p.lock.Lock()
+	p.vm = vmId
+	p.lock.Unlock()
+}
+
+func (p *Pod) RestartPolicy() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.restartPolicy
+}
+
+func (p *Pod) SetRestartPolicy(policy string) {
+	p.lock.Lock()
+	p.restartPolicy = policy
+	p.lock.Unlock()
+}
+
+// Containers returns the containers of the pod, in the order of its spec
+func (p *Pod) Containers() []*Container {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*Container{}, p.containers...)
+}
+
+func (p *Pod) SetContainers(containers []*Container) {
+	p.lock.Lock()
+	p.containers = containers
+	p.lock.Unlock()
+}
+
+func (vm *Vm) Status() uint {
+	vm.lock.Lock()
+	defer vm.lock.Unlock()
+	return vm.status
+}
+
+func (vm *Vm) SetStatus(status uint) {
+	vm.lock.Lock()
+	vm.status = status
+	vm.lock.Unlock()
+}
+
+func (c *Container) Status() uint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.status
+}
+
+func (c *Container) SetStatus(status uint) {
+	c.lock.Lock()
+	c.status = status
+	c.lock.Unlock()
+}
+
+func (c *Container) Restarts() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.restarts
+}
+
+// Restarted counts a restart of the container
+func (c *Container) Restarted() {
+	c.lock.Lock()
+	c.restarts++
+	c.lock.Unlock()
+}
+
+// Exited records the exit code of the container
+func (c *Container) Exited(code int) {
+	c.lock.Lock()
+	c.exitCode = code
+	c.finished = time.Now()
+	c.lock.Unlock()
+}
+
+// Exit returns the last exit code of the container and when it exited, the
+// zero time if it has not exited yet
+func (c *Container) Exit() (int, time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.exitCode, c.finished
+}
@@ -0,0 +1,286 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"hyper/docker"
+	"hyper/engine"
+	"hyper/types"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// the pods of the tests join a network which does not exist, so they could
+// be created and removed, while starting them fails before any VM is booted,
+// the names of the pods must be unique
+const testPodSpec = `{
+	"id": "%s",
+	"containers": [{"name": "c1", "image": "busybox"}, {"name": "c2", "image": "busybox"}],
+	"networks": [{"network": "no-such-network"}]
+}`
+
+// fakeDocker answers the calls the daemon makes to docker to create and
+// remove the containers of the pods
+func fakeDocker(t *testing.T, sock string) net.Listener {
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var next int64
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/containers/create"):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"Id":"container-%d"}`, atomic.AddInt64(&next, 1))
+		case r.Method == "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return l
+}
+
+func newTestDaemon(t *testing.T) (*Daemon, *engine.Engine, func()) {
+	dir, err := ioutil.TempDir("", "hyper-daemon-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := fakeDocker(t, path.Join(dir, "docker.sock"))
+	db, err := leveldb.OpenFile(path.Join(dir, "hyper.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newStore(db)
+	if err := store.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.New("")
+	daemon := &Daemon{
+		ID:        "test",
+		db:        db,
+		store:     store,
+		eng:       eng,
+		dockerCli: docker.NewDockerCli("", "unix", path.Join(dir, "docker.sock"), nil),
+		registry:  newRegistry(),
+		admission: newAdmission(),
+		gc:        newCollector(),
+		crons:     newCronScheduler(),
+		cfg:       &daemonConfig{},
+		exitCodes: map[string]chan int{},
+		events:    newEventLog(),
+		kernels:   newKernelCatalog(),
+		restarts:  newRestartStates(),
+	}
+	if err := daemon.Install(eng); err != nil {
+		t.Fatal(err)
+	}
+	return daemon, eng, func() {
+		l.Close()
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// runJob runs a job of the engine and returns the env it wrote
+func runJob(eng *engine.Engine, name string, args ...string) (map[string]interface{}, error) {
+	job := eng.Job(name, args...)
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return nil, err
+	}
+	dat := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return nil, err
+	}
+	return dat, nil
+}
+
+func TestRegistryAddPod(t *testing.T) {
+	r := newRegistry()
+	if err := r.AddPod(&Pod{Id: "pod-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddPod(&Pod{Id: "pod-a"}); err == nil {
+		t.Error("a pod could be added twice")
+	}
+	r.AddContainer(&Container{Id: "c1", PodId: "pod-a"})
+	r.AddContainer(&Container{Id: "c2", PodId: "pod-b"})
+	r.RemovePod("pod-a")
+	if r.Pod("pod-a") != nil || r.Container("c1") != nil {
+		t.Error("the pod or its container is left after removing the pod")
+	}
+	if r.Container("c2") == nil {
+		t.Error("the container of another pod is removed")
+	}
+}
+
+func TestRegistryLookupPod(t *testing.T) {
+	r := newRegistry()
+	r.AddPod(&Pod{Id: "pod-abcdefghij", Name: "web"})
+	r.AddPod(&Pod{Id: "pod-abxxxxxxxx", Name: "db"})
+	if err := r.AddPod(&Pod{Id: "pod-zzzzzzzzzz", Name: "web"}); err == nil {
+		t.Error("a pod name could be used twice")
+	}
+
+	for ref, podId := range map[string]string{
+		"pod-abcdefghij": "pod-abcdefghij",
+		"web":            "pod-abcdefghij",
+		"db":             "pod-abxxxxxxxx",
+		"pod-abc":        "pod-abcdefghij",
+		"pod-abx":        "pod-abxxxxxxxx",
+	} {
+		p, err := r.LookupPod(ref)
+		if err != nil {
+			t.Errorf("look up %s: %s", ref, err.Error())
+		} else if p.Id != podId {
+			t.Errorf("%s is looked up as %s, should be %s", ref, p.Id, podId)
+		}
+	}
+	for _, ref := range []string{"pod-ab", "pod-zz", "", "we"} {
+		if p, err := r.LookupPod(ref); err == nil {
+			t.Errorf("%q is looked up as %s", ref, p.Id)
+		}
+	}
+}
+
+func TestCreatePodName(t *testing.T) {
+	daemon, eng, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	dat, err := runJob(eng, "podCreate", fmt.Sprintf(testPodSpec, "web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	podId := dat["ID"].(string)
+	if _, err := runJob(eng, "podCreate", fmt.Sprintf(testPodSpec, "web")); err == nil {
+		t.Error("a pod of a name in use is created")
+	}
+	if n := len(daemon.registry.Pods()); n != 1 {
+		t.Errorf("%d pods after creating a pod of a name in use", n)
+	}
+	// the pod is removed by its name, and the name could be used again
+	if _, err := runJob(eng, "podRm", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if daemon.registry.Pod(podId) != nil {
+		t.Errorf("pod %s is left after removing it by name", podId)
+	}
+	if _, err := runJob(eng, "podCreate", fmt.Sprintf(testPodSpec, "web")); err != nil {
+		t.Errorf("create a pod of a name freed: %s", err.Error())
+	}
+}
+
+func TestPodTransit(t *testing.T) {
+	p := &Pod{Id: "pod-a", status: types.S_POD_RUNNING}
+	if p.Transit(types.S_POD_FAILED, types.S_POD_BACKOFF) {
+		t.Error("a running pod transits from backoff")
+	}
+	if !p.Transit(types.S_POD_SUCCEEDED, types.S_POD_CREATED, types.S_POD_RUNNING) {
+		t.Error("a running pod does not transit from running")
+	}
+	if p.Status() != types.S_POD_SUCCEEDED {
+		t.Errorf("status is %d after the transition, should be %d", p.Status(), types.S_POD_SUCCEEDED)
+	}
+}
+
+// TestConcurrentPodJobs drives create, start, stop, rm and list of pods at
+// the same time, it is meant to be run with the race detector.
+func TestConcurrentPodJobs(t *testing.T) {
+	daemon, eng, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	const pods = 8
+	var (
+		wg     sync.WaitGroup
+		ids    = make(chan string, pods)
+		done   = make(chan bool)
+		listed = make(chan bool)
+	)
+	for i := 0; i < pods; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			dat, err := runJob(eng, "podCreate", fmt.Sprintf(testPodSpec, fmt.Sprintf("test-%d", i)))
+			if err != nil {
+				t.Errorf("create: %s", err.Error())
+				return
+			}
+			ids <- dat["ID"].(string)
+		}(i)
+	}
+	// list the pods, the VMs and the containers all along
+	go func() {
+		defer close(listed)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, item := range []string{"pod", "container", "vm"} {
+				if _, err := runJob(eng, "list", item); err != nil {
+					t.Errorf("list %s: %s", item, err.Error())
+				}
+			}
+		}
+	}()
+	wg.Wait()
+	close(ids)
+
+	var removed int64
+	for podId := range ids {
+		for _, c := range daemon.registry.PodContainers(podId) {
+			if c.Status() != types.S_POD_CREATED {
+				t.Errorf("container %s of pod %s is in %d", c.Id, podId, c.Status())
+			}
+		}
+		for i := 0; i < 3; i++ {
+			wg.Add(3)
+			go func(podId string) {
+				defer wg.Done()
+				if _, err := runJob(eng, "podStart", podId, ""); err == nil {
+					t.Errorf("pod %s started without its network", podId)
+				}
+			}(podId)
+			go func(podId string) {
+				defer wg.Done()
+				if _, err := runJob(eng, "podStop", podId, "yes"); err == nil {
+					t.Errorf("pod %s stopped while not running", podId)
+				}
+			}(podId)
+			go func(podId string) {
+				defer wg.Done()
+				if _, err := runJob(eng, "podRm", podId); err == nil {
+					atomic.AddInt64(&removed, 1)
+				}
+			}(podId)
+		}
+	}
+	wg.Wait()
+	close(done)
+	<-listed
+
+	if removed != pods {
+		t.Errorf("%d pods are removed, should be %d", removed, pods)
+	}
+	if n := len(daemon.registry.Pods()); n != 0 {
+		t.Errorf("%d pods are left", n)
+	}
+	if n := len(daemon.registry.Containers()); n != 0 {
+		t.Errorf("%d containers are left", n)
+	}
+}
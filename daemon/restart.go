@@ -0,0 +1,208 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"hyper/hypervisor"
+	"hyper/lib/glog"
+	"hyper/types"
+)
+
+const (
+	// the first restart of a pod is immediate, the following ones wait
+	// for a delay doubling from restartBackoffBase up to restartBackoffMax
+	restartBackoffBase = 10 * time.Second
+	restartBackoffMax  = 5 * time.Minute
+	// a pod running this long before it exits is not crash looping, the
+	// delay starts over
+	restartBackoffReset = 10 * time.Minute
+	// the exit reasons kept for each pod
+	restartHistory = 5
+)
+
+// restartState is the restart history of a pod, it is kept out of the Pod
+// as restarting a pod re-creates it
+type restartState struct {
+	Count     int
+	LastExits []string
+	backoff   time.Duration
+	startedAt time.Time
+	timer     *time.Timer
+}
+
+type restartStates struct {
+	sync.Mutex
+	pods map[string]*restartState
+}
+
+func newRestartStates() *restartStates {
+	return &restartStates{
+		pods: make(map[string]*restartState),
+	}
+}
+
+// get returns the restart state of a pod, the caller must hold the lock
+func (rs *restartStates) get(podId string) *restartState {
+	st, ok := rs.pods[podId]
+	if !ok {
+		st = &restartState{}
+		rs.pods[podId] = st
+	}
+	return st
+}
+
+// podStarted records the time a pod starts to run
+func (daemon *Daemon) podStarted(podId string) {
+	daemon.restarts.Lock()
+	daemon.restarts.get(podId).startedAt = time.Now()
+	daemon.restarts.Unlock()
+}
+
+// podExited records why a pod exited, the oldest reasons are dropped
+func (daemon *Daemon) podExited(podId, reason string) {
+	daemon.restarts.Lock()
+	defer daemon.restarts.Unlock()
+	st := daemon.restarts.get(podId)
+	exit := fmt.Sprintf("%s %s", time.Now().Format(time.RFC3339), reason)
+	if len(st.LastExits) >= restartHistory {
+		st.LastExits = st.LastExits[1:]
+	}
+	st.LastExits = append(st.LastExits, exit)
+}
+
+// PodRestarts returns the restart count and the last exit reasons of a pod
+func (daemon *Daemon) PodRestarts(podId string) (int, []string) {
+	daemon.restarts.Lock()
+	defer daemon.restarts.Unlock()
+	st, ok := daemon.restarts.pods[podId]
+	if !ok {
+		return 0, []string{}
+	}
+	return st.Count, append([]string{}, st.LastExits...)
+}
+
+// cancelRestart stops the pending restart of a pod, it returns false if
+// there is none
+func (daemon *Daemon) cancelRestart(podId string) bool {
+	daemon.restarts.Lock()
+	defer daemon.restarts.Unlock()
+	st, ok := daemon.restarts.pods[podId]
+	if !ok || st.timer == nil {
+		return false
+	}
+	st.timer.Stop()
+	st.timer = nil
+	return true
+}
+
+// forgetRestarts drops the restart history of a removed pod
+func (daemon *Daemon) forgetRestarts(podId string) {
+	daemon.cancelRestart(podId)
+	daemon.restarts.Lock()
+	delete(daemon.restarts.pods, podId)
+	daemon.restarts.Unlock()
+}
+
+// nextBackoff returns the delay before the next restart of a pod, and
+// doubles the one after it
+func (st *restartState) nextBackoff(now time.Time) time.Duration {
+	if !st.startedAt.IsZero() && now.Sub(st.startedAt) >= restartBackoffReset {
+		st.backoff = 0
+	}
+	delay := st.backoff
+	if st.backoff == 0 {
+		st.backoff = restartBackoffBase
+	} else if st.backoff *= 2; st.backoff > restartBackoffMax {
+		st.backoff = restartBackoffMax
+	}
+	return delay
+}
+
+// ScheduleRestart restarts an exited pod after its backoff delay, the pod
+// is in S_POD_BACKOFF meanwhile. The caller must make sure that the restart
+// policy and the status is right to restart.
+func (daemon *Daemon) ScheduleRestart(mypod *Pod) {
+	podId := mypod.Id
+	locked := daemon.lockPod(podId)
+	if locked == nil {
+		return
+	}
+	defer locked.Unlock()
+	// the pod may have been stopped, or removed and created again meanwhile
+	if locked != mypod || mypod.RestartPolicy() == "never" {
+		return
+	}
+	daemon.restarts.Lock()
+	st := daemon.restarts.get(podId)
+	if mypod.MaxRetries > 0 && st.Count >= mypod.MaxRetries {
+		daemon.restarts.Unlock()
+		glog.Infof("Pod %s has been restarted %d times, give up", podId, mypod.MaxRetries)
+		daemon.LogPodEvent(podId, "restart-limit", fmt.Sprintf("%d restarts", mypod.MaxRetries))
+		return
+	}
+	delay := st.nextBackoff(time.Now())
+	if delay == 0 {
+		st.Count++
+		daemon.restarts.Unlock()
+		daemon.RestartPod(mypod)
+		return
+	}
+	mypod.SetStatus(types.S_POD_BACKOFF)
+	st.timer = time.AfterFunc(delay, func() {
+		daemon.restartAfterBackoff(podId)
+	})
+	daemon.restarts.Unlock()
+	glog.Infof("Pod %s is crash looping, restart it in %s", podId, delay.String())
+	daemon.LogPodEvent(podId, "backoff", delay.String())
+}
+
+func (daemon *Daemon) restartAfterBackoff(podId string) {
+	mypod := daemon.lockPod(podId)
+	if mypod == nil {
+		return
+	}
+	defer mypod.Unlock()
+	daemon.restarts.Lock()
+	st := daemon.restarts.get(podId)
+	st.timer = nil
+	// the pod may have been started by hand meanwhile
+	if mypod.Status() != types.S_POD_BACKOFF {
+		daemon.restarts.Unlock()
+		return
+	}
+	st.Count++
+	daemon.restarts.Unlock()
+	if err := daemon.RestartPod(mypod); err != nil {
+		daemon.podExited(podId, "restart failed: "+err.Error())
+	}
+}
+
+// containerExited records the exit of a container in a running pod, the
+// container may be restarted in place by its restart policy. The exits of
+// the containers which stay down are logged when the pod finishes.
+func (daemon *Daemon) containerExited(podId string, exit *hypervisor.ContainerExit) {
+	mypod := daemon.registry.Pod(podId)
+	if mypod == nil {
+		return
+	}
+	for _, c := range mypod.Containers() {
+		if c.Id != exit.Id {
+			continue
+		}
+		c.Exited(exit.ExitCode)
+		if !exit.Restart {
+			if exit.ExitCode != 0 {
+				c.SetStatus(types.S_POD_FAILED)
+			} else {
+				c.SetStatus(types.S_POD_SUCCEEDED)
+			}
+			break
+		}
+		c.Restarted()
+		daemon.LogEvent("container", "die", c.Id, podId, mypod.Vm(), fmt.Sprintf("exit code %d", exit.ExitCode))
+		daemon.LogEvent("container", "restart", c.Id, podId, mypod.Vm(), fmt.Sprintf("in %s", exit.Delay.String()))
+		break
+	}
+}
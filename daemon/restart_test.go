@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"hyper/types"
+)
+
+func TestPodBackoff(t *testing.T) {
+	st := &restartState{}
+	now := time.Now()
+	expected := []time.Duration{0, restartBackoffBase, 2 * restartBackoffBase, 4 * restartBackoffBase}
+	for i, e := range expected {
+		if d := st.nextBackoff(now); d != e {
+			t.Fatalf("restart %d: delay is %s, should be %s", i, d, e)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		st.nextBackoff(now)
+	}
+	if d := st.nextBackoff(now); d != restartBackoffMax {
+		t.Errorf("delay is %s, should be capped to %s", d, restartBackoffMax)
+	}
+
+	st.startedAt = now.Add(-restartBackoffReset)
+	if d := st.nextBackoff(now); d != 0 {
+		t.Errorf("delay after a long run is %s, should be 0", d)
+	}
+}
+
+func TestScheduleRestart(t *testing.T) {
+	daemon, _, cleanup := newTestDaemon(t)
+	defer cleanup()
+
+	mypod := &Pod{Id: "pod-crash", Name: "crash", restartPolicy: "always"}
+	mypod.SetStatus(types.S_POD_FAILED)
+	daemon.registry.AddPod(mypod)
+	// the first restart is done, the next one waits
+	daemon.restarts.Lock()
+	daemon.restarts.get(mypod.Id).backoff = time.Hour
+	daemon.restarts.Unlock()
+
+	daemon.ScheduleRestart(mypod)
+	if mypod.Status() != types.S_POD_BACKOFF {
+		t.Fatalf("the pod is in %s, should be backing off", statusName(mypod.Status()))
+	}
+
+	// stopping the pod cancels the restart only
+	if _, _, err := daemon.StopPod(mypod.Id, "yes"); err != nil {
+		t.Fatal(err)
+	}
+	if mypod.Status() != types.S_POD_FAILED {
+		t.Errorf("the stopped pod is in %s, should be failed", statusName(mypod.Status()))
+	}
+	if mypod.RestartPolicy() != "always" {
+		t.Errorf("the restart policy is changed to %s", mypod.RestartPolicy())
+	}
+	if daemon.cancelRestart(mypod.Id) {
+		t.Error("the restart is still pending")
+	}
+
+	// the pod gives up after its max retries
+	mypod.MaxRetries = 2
+	daemon.restarts.Lock()
+	daemon.restarts.get(mypod.Id).Count = 2
+	daemon.restarts.Unlock()
+	daemon.ScheduleRestart(mypod)
+	if mypod.Status() != types.S_POD_FAILED || daemon.cancelRestart(mypod.Id) {
+		t.Error("the pod is restarted beyond its max retries")
+	}
+
+	// nor is a pod which never restarts
+	mypod.MaxRetries = 0
+	mypod.SetRestartPolicy("never")
+	daemon.ScheduleRestart(mypod)
+	if mypod.Status() != types.S_POD_FAILED || daemon.cancelRestart(mypod.Id) {
+		t.Error("a pod which never restarts is restarted")
+	}
+}
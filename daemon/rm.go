@@ -9,33 +9,35 @@ import (
 )
 
 func (daemon *Daemon) CmdPodRm(job *engine.Job) (err error) {
+	podId, err := daemon.ResolvePod(job.Args[0])
+	if err != nil {
+		return err
+	}
 	var (
-		podId = job.Args[0]
-		pod   = daemon.podList[podId]
+		pod   = daemon.lockPod(podId)
 		code  = 0
 		cause = ""
 	)
 	if pod == nil {
 		return fmt.Errorf("Can not find that Pod(%s)", podId)
 	}
+	defer pod.Unlock()
 
-	if daemon.podList[podId].Status != types.S_POD_RUNNING {
+	if pod.Status() != types.S_POD_RUNNING {
 		// If the pod type is kubernetes, we just remove the pod from the pod list.
 		// The persistent data has been removed since we got the E_VM_SHUTDOWN event.
-		if daemon.podList[podId].Type == "kubernetes" {
+		if pod.Type == "kubernetes" {
 			daemon.RemovePod(podId)
 			code = types.E_OK
 		} else {
 			daemon.DeletePodFromDB(podId)
-			for _, c := range pod.Containers {
+			for _, c := range pod.Containers() {
 				glog.V(1).Infof("Ready to rm container: %s", c.Id)
 				if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 					glog.V(1).Infof("Error to rm container: %s", err.Error())
 				}
 			}
 			daemon.RemovePod(podId)
-			daemon.DeletePodContainerFromDB(podId)
-			daemon.DeleteVolumeId(podId)
 			code = types.E_OK
 		}
 	} else {
@@ -45,18 +47,19 @@ func (daemon *Daemon) CmdPodRm(job *engine.Job) (err error) {
 		}
 		if code == types.E_VM_SHUTDOWN {
 			daemon.DeletePodFromDB(podId)
-			for _, c := range pod.Containers {
+			for _, c := range pod.Containers() {
 				glog.V(1).Infof("Ready to rm container: %s", c.Id)
 				if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 					glog.V(1).Infof("Error to rm container: %s", err.Error())
 				}
 			}
 			daemon.RemovePod(podId)
-			daemon.DeletePodContainerFromDB(podId)
-			daemon.DeleteVolumeId(podId)
 		}
 		code = types.E_OK
 	}
+	if daemon.registry.Pod(podId) == nil {
+		daemon.forgetRestarts(podId)
+	}
 
 	// Prepare the qemu status to client
 	v := &engine.Env{}
@@ -0,0 +1,164 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"hyper/engine"
+	"hyper/hypervisor"
+	"hyper/lib/glog"
+	"hyper/pod"
+)
+
+// the attach and exec sessions of a pod are recorded in its own directory,
+// the recordings are kept after the pod is removed
+const sessionRoot = "/var/lib/hyper/sessions"
+
+var sessionNameReg = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// podRecordsSessions reports whether the sessions of a pod are recorded,
+// by the config of the daemon or by the spec of the pod
+func (daemon *Daemon) podRecordsSessions(podId string) bool {
+	if daemon.config().RecordSessions {
+		return true
+	}
+	data, err := daemon.GetPodByName(podId)
+	if err != nil {
+		return false
+	}
+	userPod, err := pod.ProcessPodBytes(data)
+	if err != nil {
+		return false
+	}
+	return userPod.RecordSessions
+}
+
+// sessionRecorder creates the recorder of a session of the pod, it returns
+// nil if the sessions of the pod are not recorded. A session which should
+// be recorded but could not be is refused.
+func (daemon *Daemon) sessionRecorder(podId, title string) (*hypervisor.SessionRecorder, error) {
+	if podId == "" || !daemon.podRecordsSessions(podId) {
+		return nil, nil
+	}
+	dir := path.Join(sessionRoot, podId)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	id := fmt.Sprintf("session-%s", pod.RandStr(10, "alpha"))
+	f, err := os.OpenFile(path.Join(dir, id+".cast"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r, err := hypervisor.NewSessionRecorder(f, title)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	glog.V(1).Infof("Record session %s of pod %s: %s", id, podId, title)
+	daemon.LogEvent("session", "record", id, podId, "", title)
+	return r, nil
+}
+
+type session struct {
+	id     string
+	header hypervisor.CastHeader
+	size   int64
+}
+
+type byStartTime []session
+
+func (s byStartTime) Len() int           { return len(s) }
+func (s byStartTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStartTime) Less(i, j int) bool { return s[i].header.Timestamp < s[j].header.Timestamp }
+
+// CmdSessionList lists the recorded sessions of the pod named by the
+// argument, the oldest first.
+func (daemon *Daemon) CmdSessionList(job *engine.Job) error {
+	if len(job.Args) == 0 || !sessionNameReg.MatchString(job.Args[0]) {
+		return fmt.Errorf("Can not list the sessions without a valid pod ID!")
+	}
+	podId := job.Args[0]
+
+	infos, err := ioutil.ReadDir(path.Join(sessionRoot, podId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sessions := byStartTime{}
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".cast") {
+			continue
+		}
+		s := session{
+			id:   strings.TrimSuffix(info.Name(), ".cast"),
+			size: info.Size(),
+		}
+		if err := readCastHeader(path.Join(sessionRoot, podId, info.Name()), &s.header); err != nil {
+			glog.Warningf("Got a broken recording %s: %s", info.Name(), err.Error())
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	sort.Sort(sessions)
+
+	var sessionJsonResponse = []string{}
+	for _, s := range sessions {
+		sessionJsonResponse = append(sessionJsonResponse,
+			s.id+":"+strconv.FormatInt(s.header.Timestamp, 10)+":"+strconv.FormatInt(s.size, 10)+":"+s.header.Title)
+	}
+
+	v := &engine.Env{}
+	v.SetList("sessionData", sessionJsonResponse)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdSessionPlay writes the recording of the session named by the argument
+func (daemon *Daemon) CmdSessionPlay(job *engine.Job) error {
+	if len(job.Args) == 0 || !sessionNameReg.MatchString(job.Args[0]) {
+		return fmt.Errorf("Can not play a session without a valid session ID!")
+	}
+	id := job.Args[0]
+
+	matches, err := filepath.Glob(path.Join(sessionRoot, "*", id+".cast"))
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("Can not find session %s", id)
+	}
+	f, err := os.Open(matches[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(job.Stdout, f)
+	return err
+}
+
+func readCastHeader(file string, header *hypervisor.CastHeader) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, header)
+}
@@ -12,8 +12,16 @@ func (daemon *Daemon) CmdPodStop(job *engine.Job) error {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not execute 'stop' command without any pod name!")
 	}
-	podId := job.Args[0]
+	podId, err := daemon.ResolvePod(job.Args[0])
+	if err != nil {
+		return err
+	}
 	stopVm := job.Args[1]
+	mypod := daemon.lockPod(podId)
+	if mypod == nil {
+		return fmt.Errorf("Can not find that Pod(%s)", podId)
+	}
+	defer mypod.Unlock()
 	code, cause, err := daemon.StopPod(podId, stopVm)
 	if err != nil {
 		return err
@@ -33,8 +41,22 @@ func (daemon *Daemon) CmdPodStop(job *engine.Job) error {
 
 func (daemon *Daemon) StopPod(podId, stopVm string) (int, string, error) {
 	glog.V(1).Infof("Prepare to stop the POD: %s", podId)
+	mypod := daemon.registry.Pod(podId)
+	if mypod == nil {
+		return -1, "", fmt.Errorf("Can not find that Pod(%s)", podId)
+	}
+	// a crash looping pod is stopped by cancelling its restart, its restart
+	// policy applies again once it is started
+	if mypod.Status() == types.S_POD_BACKOFF && daemon.cancelRestart(podId) {
+		if mypod.Type == "kubernetes" {
+			mypod.SetRestartPolicy("never")
+		}
+		mypod.Transit(types.S_POD_FAILED, types.S_POD_BACKOFF)
+		daemon.LogPodEvent(podId, "stop", "restart cancelled")
+		return types.E_VM_SHUTDOWN, "", nil
+	}
 	// find the vm id which running POD, and stop it
-	if daemon.podList[podId].Status != types.S_POD_RUNNING {
+	if mypod.Status() != types.S_POD_RUNNING {
 		return -1, "", fmt.Errorf("The POD %s has aleady stopped, can not stop again!", podId)
 	}
 	vmid, err := daemon.GetPodVmByName(podId)
@@ -43,39 +65,39 @@ func (daemon *Daemon) StopPod(podId, stopVm string) (int, string, error) {
 	}
 	// we need to set the 'RestartPolicy' of the pod to 'never' if stop command is invoked
 	// for kubernetes
-	if daemon.podList[podId].Type == "kubernetes" {
-		daemon.podList[podId].RestartPolicy = "never"
-		if daemon.podList[podId].Vm == "" {
+	if mypod.Type == "kubernetes" {
+		mypod.SetRestartPolicy("never")
+		if mypod.Vm() == "" {
 			return types.E_VM_SHUTDOWN, "", nil
 		}
 	}
-	qemuPodEvent, _, qemuStatus, err := daemon.GetQemuChan(vmid)
+	qemuChan, err := daemon.GetQemuChan(vmid)
 	if err != nil {
 		return -1, "", err
 	}
 
 	var qemuResponse *types.QemuResponse
 	if stopVm == "yes" {
-		daemon.podList[podId].Wg.Add(1)
+		mypod.Wg.Add(1)
 		shutdownPodEvent := &hypervisor.ShutdownCommand{Wait: true}
-		qemuPodEvent.(chan hypervisor.VmEvent) <- shutdownPodEvent
+		qemuChan.Event <- shutdownPodEvent
 		// wait for the qemu response
 		for {
-			qemuResponse = <-qemuStatus.(chan *types.QemuResponse)
+			qemuResponse = <-qemuChan.SubStatus
 			glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
 			if qemuResponse.Code == types.E_VM_SHUTDOWN {
 				break
 			}
 		}
-		close(qemuStatus.(chan *types.QemuResponse))
+		close(qemuChan.SubStatus)
 		// wait for goroutines exit
-		daemon.podList[podId].Wg.Wait()
+		mypod.Wg.Wait()
 	} else {
 		stopPodEvent := &hypervisor.StopPodCommand{}
-		qemuPodEvent.(chan hypervisor.VmEvent) <- stopPodEvent
+		qemuChan.Event <- stopPodEvent
 		// wait for the qemu response
 		for {
-			qemuResponse = <-qemuStatus.(chan *types.QemuResponse)
+			qemuResponse = <-qemuChan.SubStatus
 			glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
 			if qemuResponse.Code == types.E_POD_STOPPED || qemuResponse.Code == types.E_BAD_REQUEST || qemuResponse.Code == types.E_FAILED {
 				break
@@ -87,15 +109,18 @@ func (daemon *Daemon) StopPod(podId, stopVm string) (int, string, error) {
 	daemon.DeleteVmByPod(podId)
 
 	if qemuResponse.Code == types.E_VM_SHUTDOWN {
-		daemon.podList[podId].Vm = ""
+		mypod.SetVm("")
 		daemon.RemoveVm(vmid)
 		daemon.DeleteQemuChan(vmid)
 	}
 	if qemuResponse.Code == types.E_POD_STOPPED {
-		daemon.podList[podId].Vm = ""
-		daemon.vmList[vmid].Status = types.S_VM_IDLE
+		mypod.SetVm("")
+		if vm := daemon.registry.Vm(vmid); vm != nil {
+			vm.SetStatus(types.S_VM_IDLE)
+		}
 	}
-	daemon.podList[podId].Status = types.S_POD_FAILED
+	mypod.SetStatus(types.S_POD_FAILED)
 	daemon.SetContainerStatus(podId, types.S_POD_FAILED)
+	daemon.LogEvent("pod", "stop", podId, podId, vmid, "")
 	return qemuResponse.Code, qemuResponse.Cause, nil
 }
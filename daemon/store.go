@@ -0,0 +1,563 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"hyper/lib/glog"
+	"hyper/pod"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// The records of the pods, the VMs, the volumes, the crons, the networks
+// and the kernels are kept in the db as JSON, under these prefixes.
+const (
+	podPrefix     = "pod-"
+	vmPrefix      = "vm-"
+	volumePrefix  = "vol-"
+	cronPrefix    = "cron-"
+	networkPrefix = "network-"
+	kernelPrefix  = "kernel-"
+	versionKey    = "meta-version"
+)
+
+// migrations[i] moves the records from the schema version i to i+1, the
+// version of the db is the number of migrations done.
+var migrations = []func(s *store, b *storeBatch) error{
+	migrateAdHocKeys,
+}
+
+// podRecord is a pod in the db, Spec is the pod file as the user gave it.
+type podRecord struct {
+	Id         string          `json:"id"`
+	Name       string          `json:"name,omitempty"`
+	Created    int64           `json:"created,omitempty"` //unix time in nanoseconds
+	Spec       json.RawMessage `json:"spec"`
+	Containers []string        `json:"containers,omitempty"`
+	Vm         string          `json:"vm,omitempty"`
+}
+
+// vmRecord is the persist info of the VM running a pod, the daemon needs it
+// to associate with the VM again after it restarts.
+type vmRecord struct {
+	Id   string `json:"id"`
+	Pod  string `json:"pod"`
+	Data []byte `json:"data"`
+}
+
+// volumeRecord is a dm volume created for a pod
+type volumeRecord struct {
+	Pod   string `json:"pod"`
+	Name  string `json:"name"`
+	DevId int    `json:"devId"`
+}
+
+// networkRecord is a network created by the user, it is set up again when
+// the daemon restarts. The kernels of the catalog are kept as Kernel.
+type networkRecord struct {
+	Name   string `json:"name"`
+	Bridge string `json:"bridge"`
+	Subnet string `json:"subnet"`
+}
+
+func podKey(podId string) []byte {
+	return []byte(podPrefix + podId)
+}
+
+func vmKey(vmId string) []byte {
+	return []byte(vmPrefix + vmId)
+}
+
+func cronKey(cronId string) []byte {
+	return []byte(cronPrefix + cronId)
+}
+
+func networkKey(name string) []byte {
+	return []byte(networkPrefix + name)
+}
+
+func kernelKey(name string) []byte {
+	return []byte(kernelPrefix + name)
+}
+
+func volumeKey(podId string, devId int) []byte {
+	return []byte(fmt.Sprintf("%s%s-%d", volumePrefix, podId, devId))
+}
+
+// storeBatch gathers the changes of the records done by one operation,
+// they are written to the db at once.
+type storeBatch struct {
+	leveldb.Batch
+}
+
+func (b *storeBatch) put(key []byte, rec interface{}) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b.Put(key, data)
+	return nil
+}
+
+func (b *storeBatch) PutPod(rec *podRecord) error {
+	return b.put(podKey(rec.Id), rec)
+}
+
+func (b *storeBatch) DeletePod(podId string) {
+	b.Delete(podKey(podId))
+}
+
+func (b *storeBatch) PutVm(rec *vmRecord) error {
+	return b.put(vmKey(rec.Id), rec)
+}
+
+func (b *storeBatch) DeleteVm(vmId string) {
+	b.Delete(vmKey(vmId))
+}
+
+func (b *storeBatch) PutVolume(rec *volumeRecord) error {
+	return b.put(volumeKey(rec.Pod, rec.DevId), rec)
+}
+
+func (b *storeBatch) DeleteVolume(rec *volumeRecord) {
+	b.Delete(volumeKey(rec.Pod, rec.DevId))
+}
+
+func (b *storeBatch) PutCron(rec *cronRecord) error {
+	return b.put(cronKey(rec.Id), rec)
+}
+
+func (b *storeBatch) DeleteCron(cronId string) {
+	b.Delete(cronKey(cronId))
+}
+
+func (b *storeBatch) PutNetwork(rec *networkRecord) error {
+	return b.put(networkKey(rec.Name), rec)
+}
+
+func (b *storeBatch) DeleteNetwork(name string) {
+	b.Delete(networkKey(name))
+}
+
+func (b *storeBatch) PutKernel(rec *Kernel) error {
+	return b.put(kernelKey(rec.Name), rec)
+}
+
+func (b *storeBatch) DeleteKernel(name string) {
+	b.Delete(kernelKey(name))
+}
+
+// store reads and writes the records of the daemon in leveldb
+type store struct {
+	db *leveldb.DB
+}
+
+func newStore(db *leveldb.DB) *store {
+	return &store{db: db}
+}
+
+func (s *store) get(key []byte, rec interface{}) error {
+	data, err := s.db.Get(key, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, rec)
+}
+
+// list calls fn with the raw value of each record under prefix
+func (s *store) list(prefix string, fn func(key, value []byte) error) error {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := fn(iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// Write applies the changes of a batch at once
+func (s *store) Write(b *storeBatch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	return s.db.Write(&b.Batch, nil)
+}
+
+// Pod returns the record of a pod, or leveldb.ErrNotFound
+func (s *store) Pod(podId string) (*podRecord, error) {
+	rec := &podRecord{}
+	if err := s.get(podKey(podId), rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Pods returns the records of all pods, the broken ones are skipped
+func (s *store) Pods() ([]*podRecord, error) {
+	pods := []*podRecord{}
+	err := s.list(podPrefix, func(key, value []byte) error {
+		rec := &podRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			glog.Warningf("Got a broken pod item %s: %s", key, err.Error())
+			return nil
+		}
+		pods = append(pods, rec)
+		return nil
+	})
+	return pods, err
+}
+
+// updatePod changes the record of a pod and writes it, with the other
+// changes update puts in the batch.
+func (s *store) updatePod(podId string, update func(rec *podRecord, b *storeBatch) error) error {
+	rec, err := s.Pod(podId)
+	if err != nil {
+		return err
+	}
+	b := &storeBatch{}
+	if err := update(rec, b); err != nil {
+		return err
+	}
+	if err := b.PutPod(rec); err != nil {
+		return err
+	}
+	return s.Write(b)
+}
+
+// Vm returns the record of a VM, or leveldb.ErrNotFound
+func (s *store) Vm(vmId string) (*vmRecord, error) {
+	rec := &vmRecord{}
+	if err := s.get(vmKey(vmId), rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Volumes returns the volume records of a pod, or of all pods if podId is
+// empty
+func (s *store) Volumes(podId string) ([]*volumeRecord, error) {
+	volumes := []*volumeRecord{}
+	err := s.list(volumePrefix, func(key, value []byte) error {
+		rec := &volumeRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			glog.Warningf("Got a broken volume item %s: %s", key, err.Error())
+			return nil
+		}
+		if podId == "" || rec.Pod == podId {
+			volumes = append(volumes, rec)
+		}
+		return nil
+	})
+	return volumes, err
+}
+
+// Crons returns the records of all crons, the broken ones are skipped
+func (s *store) Crons() ([]*cronRecord, error) {
+	crons := []*cronRecord{}
+	err := s.list(cronPrefix, func(key, value []byte) error {
+		rec := &cronRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			glog.Warningf("Got a broken cron item %s: %s", key, err.Error())
+			return nil
+		}
+		crons = append(crons, rec)
+		return nil
+	})
+	return crons, err
+}
+
+// Networks returns the records of all networks, the broken ones are skipped
+func (s *store) Networks() ([]*networkRecord, error) {
+	networks := []*networkRecord{}
+	err := s.list(networkPrefix, func(key, value []byte) error {
+		rec := &networkRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			glog.Warningf("Got a broken network item %s: %s", key, err.Error())
+			return nil
+		}
+		networks = append(networks, rec)
+		return nil
+	})
+	return networks, err
+}
+
+// Kernels returns the records of all kernels, the broken ones are skipped
+func (s *store) Kernels() ([]*Kernel, error) {
+	kernels := []*Kernel{}
+	err := s.list(kernelPrefix, func(key, value []byte) error {
+		rec := &Kernel{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			glog.Warningf("Got a broken kernel item %s: %s", key, err.Error())
+			return nil
+		}
+		kernels = append(kernels, rec)
+		return nil
+	})
+	return kernels, err
+}
+
+func (s *store) version() (int, error) {
+	data, err := s.db.Get([]byte(versionKey), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(string(data))
+}
+
+// migrate brings the records to the schema version of the daemon, each
+// migration is written at once with the version it reaches.
+func (s *store) migrate() error {
+	version, err := s.version()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("The schema version %d of the db is newer than %d of this hyperd", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		b := &storeBatch{}
+		if err := migrations[version](s, b); err != nil {
+			return fmt.Errorf("Fail to migrate the db to schema version %d: %s", version+1, err.Error())
+		}
+		b.Put([]byte(versionKey), []byte(strconv.Itoa(version+1)))
+		if err := s.Write(b); err != nil {
+			return err
+		}
+		glog.Infof("The db is migrated to schema version %d", version+1)
+	}
+	return nil
+}
+
+// migrateAdHocKeys moves the records of the first layout to JSON. There the
+// spec of a pod was under pod-POD, its containers under pod-container-POD
+// joined by ':', its VM under vm-POD, the data of the VM under vmdata-VM,
+// and its volumes under vol-POD-DEVID as NAME:DEVID.
+func migrateAdHocKeys(s *store, b *storeBatch) error {
+	var (
+		pods    = map[string]*podRecord{}
+		vmPods  = map[string]string{}
+		vmDatas = map[string][]byte{}
+	)
+	podOf := func(podId string) *podRecord {
+		if pods[podId] == nil {
+			pods[podId] = &podRecord{Id: podId}
+		}
+		return pods[podId]
+	}
+	err := s.list("pod-container-", func(key, value []byte) error {
+		rec := podOf(string(key)[len("pod-container-"):])
+		for _, id := range strings.Split(string(value), ":") {
+			if id != "" {
+				rec.Containers = append(rec.Containers, id)
+			}
+		}
+		b.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = s.list(podPrefix, func(key, value []byte) error {
+		if strings.HasPrefix(string(key), "pod-container-") {
+			return nil
+		}
+		rec := podOf(string(key)[len(podPrefix):])
+		rec.Spec = append(json.RawMessage{}, value...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = s.list(vmPrefix, func(key, value []byte) error {
+		podId := string(key)[len(vmPrefix):]
+		podOf(podId).Vm = string(value)
+		vmPods[string(value)] = podId
+		b.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = s.list("vmdata-", func(key, value []byte) error {
+		vmDatas[string(key)[len("vmdata-"):]] = append([]byte{}, value...)
+		b.Delete(key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = s.list(volumePrefix, func(key, value []byte) error {
+		fields := strings.Split(string(value), ":")
+		devId, err := strconv.Atoi(fields[len(fields)-1])
+		if len(fields) != 2 || err != nil {
+			glog.Warningf("Drop the broken volume item %s: %s", key, value)
+			b.Delete(key)
+			return nil
+		}
+		podId := strings.TrimSuffix(string(key)[len(volumePrefix):], "-"+fields[1])
+		b.Delete(key)
+		return b.PutVolume(&volumeRecord{Pod: podId, Name: fields[0], DevId: devId})
+	})
+	if err != nil {
+		return err
+	}
+
+	for vmId, data := range vmDatas {
+		if err := b.PutVm(&vmRecord{Id: vmId, Pod: vmPods[vmId], Data: data}); err != nil {
+			return err
+		}
+	}
+	for podId, rec := range pods {
+		if rec.Spec == nil {
+			glog.Warningf("Drop the items of pod %s without its spec", podId)
+			b.DeletePod(podId)
+			continue
+		}
+		if err := b.PutPod(rec); err != nil {
+			glog.Warningf("Drop the broken pod item %s: %s", podId, err.Error())
+			b.DeletePod(podId)
+		}
+	}
+	return nil
+}
+
+// check finds the records which are broken or refer to records that do not
+// exist, and puts their repair in the batch.
+func (s *store) check(b *storeBatch) ([]string, error) {
+	var (
+		problems = []string{}
+		pods     = map[string]*podRecord{}
+		vms      = map[string]bool{}
+	)
+	err := s.list(podPrefix, func(key, value []byte) error {
+		rec := &podRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			problems = append(problems, fmt.Sprintf("broken pod record %s: %s", key, err.Error()))
+			b.Delete(key)
+		} else if _, err := pod.ProcessPodBytes(rec.Spec); err != nil {
+			problems = append(problems, fmt.Sprintf("pod %s has a broken spec: %s", rec.Id, err.Error()))
+			b.Delete(key)
+		} else {
+			pods[rec.Id] = rec
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.list(vmPrefix, func(key, value []byte) error {
+		rec := &vmRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			problems = append(problems, fmt.Sprintf("broken vm record %s: %s", key, err.Error()))
+			b.Delete(key)
+		} else if p := pods[rec.Pod]; p == nil || p.Vm != rec.Id {
+			problems = append(problems, fmt.Sprintf("dangling vm %s of pod %s", rec.Id, rec.Pod))
+			b.Delete(key)
+		} else {
+			vms[rec.Id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.list(volumePrefix, func(key, value []byte) error {
+		rec := &volumeRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			problems = append(problems, fmt.Sprintf("broken volume record %s: %s", key, err.Error()))
+			b.Delete(key)
+		} else if pods[rec.Pod] == nil {
+			problems = append(problems, fmt.Sprintf("dangling volume %s (device %d) of pod %s", rec.Name, rec.DevId, rec.Pod))
+			b.Delete(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.list(networkPrefix, func(key, value []byte) error {
+		rec := &networkRecord{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			problems = append(problems, fmt.Sprintf("broken network record %s: %s", key, err.Error()))
+			b.Delete(key)
+		} else if _, _, err := net.ParseCIDR(rec.Subnet); err != nil {
+			problems = append(problems, fmt.Sprintf("network %s has a broken subnet: %s", rec.Name, err.Error()))
+			b.Delete(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = s.list(kernelPrefix, func(key, value []byte) error {
+		rec := &Kernel{}
+		if err := json.Unmarshal(value, rec); err != nil {
+			problems = append(problems, fmt.Sprintf("broken kernel record %s: %s", key, err.Error()))
+			b.Delete(key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range pods {
+		if rec.Vm != "" && !vms[rec.Vm] {
+			problems = append(problems, fmt.Sprintf("pod %s refers to the missing vm %s", rec.Id, rec.Vm))
+			rec.Vm = ""
+			if err := b.PutPod(rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return problems, nil
+}
+
+// CheckDB checks the db of hyperd for broken and dangling records, they are
+// removed if repair is true. It returns the problems found. The db can not
+// be checked while hyperd runs.
+func CheckDB(repair bool) ([]string, error) {
+	db, err := leveldb.OpenFile(path.Join(hyperRoot, "hyper.db"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Can not open the db, is hyperd running? %s", err.Error())
+	}
+	defer db.Close()
+	return checkStore(newStore(db), repair)
+}
+
+// checkStore does the work of CheckDB. The records of an older schema are
+// only checked once migrated, and the db is migrated by a repair only.
+func checkStore(s *store, repair bool) ([]string, error) {
+	version, err := s.version()
+	if err != nil {
+		return nil, err
+	}
+	if version > len(migrations) {
+		return nil, fmt.Errorf("The schema version %d of the db is newer than %d of this hyperd", version, len(migrations))
+	}
+	if version < len(migrations) && !repair {
+		return []string{fmt.Sprintf("the db is at schema version %d and needs a migration to %d", version, len(migrations))}, nil
+	}
+	if err := s.migrate(); err != nil {
+		return nil, err
+	}
+	b := &storeBatch{}
+	problems, err := s.check(b)
+	if err != nil {
+		return nil, err
+	}
+	if repair {
+		if err := s.Write(b); err != nil {
+			return problems, err
+		}
+	}
+	return problems, nil
+}
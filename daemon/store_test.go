@@ -0,0 +1,224 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const storeTestSpec = `{"id":"test","containers":[{"name":"c1","image":"busybox"}]}`
+
+func newTestStore(t *testing.T) (*store, func()) {
+	dir, err := ioutil.TempDir("", "hyper-store-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := leveldb.OpenFile(path.Join(dir, "hyper.db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newStore(db), func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMigrateAdHocKeys(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for k, v := range map[string]string{
+		"pod-pod-aaaaaaaaaa":           storeTestSpec,
+		"pod-container-pod-aaaaaaaaaa": "c1:c2",
+		"vm-pod-aaaaaaaaaa":            "vm-bbbbbbbbbb",
+		"vmdata-vm-bbbbbbbbbb":         "persist",
+		"vol-pod-aaaaaaaaaa-3":         "hyper-volume-pool-pod-aaaaaaaaaa-data:3",
+		"pod-container-pod-cccccccccc": "c3",
+		"network-default":              `{"Name":"default"}`,
+	} {
+		if err := s.db.Put([]byte(k), []byte(v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.version(); v != len(migrations) {
+		t.Fatalf("schema version is %d, should be %d", v, len(migrations))
+	}
+
+	pods, err := s.Pods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 {
+		t.Fatalf("%d pods are migrated, should be 1", len(pods))
+	}
+	rec := pods[0]
+	if rec.Id != "pod-aaaaaaaaaa" || string(rec.Spec) != storeTestSpec || rec.Vm != "vm-bbbbbbbbbb" ||
+		!reflect.DeepEqual(rec.Containers, []string{"c1", "c2"}) {
+		t.Errorf("pod is migrated to %+v", rec)
+	}
+	vm, err := s.Vm("vm-bbbbbbbbbb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.Pod != "pod-aaaaaaaaaa" || string(vm.Data) != "persist" {
+		t.Errorf("vm is migrated to %+v", vm)
+	}
+	volumes, err := s.Volumes("pod-aaaaaaaaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 || volumes[0].DevId != 3 || volumes[0].Name != "hyper-volume-pool-pod-aaaaaaaaaa-data" {
+		t.Errorf("volumes are migrated to %+v", volumes)
+	}
+
+	keys := []string{}
+	iter := s.db.NewIterator(nil, nil)
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Release()
+	sort.Strings(keys)
+	expected := []string{"meta-version", "network-default", "pod-pod-aaaaaaaaaa", "vm-vm-bbbbbbbbbb", "vol-pod-aaaaaaaaaa-3"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("keys after the migration are %v, should be %v", keys, expected)
+	}
+
+	// migrating again changes nothing
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if pods, _ := s.Pods(); len(pods) != 1 || pods[0].Vm != "vm-bbbbbbbbbb" {
+		t.Errorf("pods after migrating again are %+v", pods)
+	}
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	s.db.Put([]byte(versionKey), []byte("1000"), nil)
+	if err := s.migrate(); err == nil {
+		t.Error("a db of a newer schema version is migrated")
+	}
+}
+
+func TestCheckStore(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &storeBatch{}
+	b.PutPod(&podRecord{Id: "pod-ok", Spec: []byte(storeTestSpec), Vm: "vm-ok"})
+	b.PutVm(&vmRecord{Id: "vm-ok", Pod: "pod-ok"})
+	b.PutPod(&podRecord{Id: "pod-novm", Spec: []byte(storeTestSpec), Vm: "vm-gone"})
+	b.PutVm(&vmRecord{Id: "vm-dangling", Pod: "pod-gone"})
+	b.PutVolume(&volumeRecord{Pod: "pod-gone", Name: "data", DevId: 2})
+	b.Put(podKey("pod-broken"), []byte("{"))
+	b.PutNetwork(&networkRecord{Name: "back", Bridge: "hyper-back", Subnet: "10.10.0.1/24"})
+	b.PutNetwork(&networkRecord{Name: "front", Bridge: "hyper-front", Subnet: "10.20.0.1/33"})
+	b.PutKernel(&Kernel{Name: "k1", Kernel: "/var/lib/hyper/kernels/k1/kernel"})
+	b.Put(kernelKey("k2"), []byte("{"))
+	if err := s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	b = &storeBatch{}
+	problems, err := s.check(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 6 {
+		t.Errorf("found %d problems, should be 6: %v", len(problems), problems)
+	}
+	if err := s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+
+	b = &storeBatch{}
+	if problems, _ := s.check(b); len(problems) != 0 {
+		t.Errorf("problems are left after the repair: %v", problems)
+	}
+	if rec, err := s.Pod("pod-novm"); err != nil || rec.Vm != "" {
+		t.Errorf("the pod of a missing vm is not repaired: %+v, %v", rec, err)
+	}
+	if rec, err := s.Pod("pod-ok"); err != nil || rec.Vm != "vm-ok" {
+		t.Errorf("a good pod is changed: %+v, %v", rec, err)
+	}
+	if recs, err := s.Networks(); err != nil || len(recs) != 1 || recs[0].Name != "back" {
+		t.Errorf("the networks left are %v, %v", recs, err)
+	}
+	if recs, err := s.Kernels(); err != nil || len(recs) != 1 || recs[0].Name != "k1" {
+		t.Errorf("the kernels left are %v, %v", recs, err)
+	}
+}
+
+func TestStoreNetworks(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	// the networks written before their record type are read as they are
+	if err := s.db.Put([]byte("network-back"), []byte(`{"Name":"back","Bridge":"hyper-back","Subnet":"10.10.0.1/24"}`), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := &storeBatch{}
+	b.PutNetwork(&networkRecord{Name: "front", Bridge: "hyper-front", Subnet: "10.20.0.1/24"})
+	if err := s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := s.Networks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || *recs[0] != (networkRecord{"back", "hyper-back", "10.10.0.1/24"}) || recs[1].Name != "front" {
+		t.Errorf("the networks are %+v", recs)
+	}
+
+	b = &storeBatch{}
+	b.DeleteNetwork("back")
+	if err := s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if recs, _ := s.Networks(); len(recs) != 1 || recs[0].Name != "front" {
+		t.Errorf("the networks left are %+v", recs)
+	}
+}
+
+func TestCheckStoreOlderVersion(t *testing.T) {
+	s, cleanup := newTestStore(t)
+	defer cleanup()
+
+	if err := s.db.Put([]byte("pod-pod-aaaaaaaaaa"), []byte(storeTestSpec), nil); err != nil {
+		t.Fatal(err)
+	}
+	// a check only reports the migration, the db is left as it is
+	problems, err := checkStore(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Errorf("found %v, should be the migration", problems)
+	}
+	if v, _ := s.version(); v != 0 {
+		t.Errorf("the db is migrated to %d by a check", v)
+	}
+	if data, err := s.db.Get([]byte("pod-pod-aaaaaaaaaa"), nil); err != nil || string(data) != storeTestSpec {
+		t.Errorf("the record is changed by a check: %q, %v", data, err)
+	}
+
+	if _, err := checkStore(s, true); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.version(); v != len(migrations) {
+		t.Errorf("the db is at %d after a repair, should be %d", v, len(migrations))
+	}
+}
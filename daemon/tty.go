@@ -13,26 +13,18 @@ func (daemon *Daemon) CmdTty(job *engine.Job) (err error) {
 		return nil
 	}
 	var (
-		podID     = job.Args[0]
-		tag       = job.Args[1]
-		h         = job.Args[2]
-		w         = job.Args[3]
-		container string
-		vmid      string
+		podID = job.Args[0]
+		tag   = job.Args[1]
+		h     = job.Args[2]
+		w     = job.Args[3]
+		vmid  string
 	)
 
-	if strings.Contains(podID, "pod-") {
-		container = ""
-		vmid, err = daemon.GetPodVmByName(podID)
-		if err != nil {
-			return err
-		}
-	} else if strings.Contains(podID, "vm-") {
+	// the tty is the one of a VM, or of a pod given by one of its containers
+	if strings.HasPrefix(podID, "vm-") {
 		vmid = podID
 	} else {
-		container = podID
-		podID, err = daemon.GetPodByContainer(container)
-		if err != nil {
+		if _, podID, err = daemon.resolveContainer(podID); err != nil {
 			return err
 		}
 		vmid, err = daemon.GetPodVmByName(podID)
@@ -54,11 +46,11 @@ func (daemon *Daemon) CmdTty(job *engine.Job) (err error) {
 		Size:      &hypervisor.WindowSize{Row: uint16(row), Column: uint16(column)},
 	}
 
-	qemuEvent, _, _, err := daemon.GetQemuChan(vmid)
+	qemuChan, err := daemon.GetQemuChan(vmid)
 	if err != nil {
 		return err
 	}
-	qemuEvent.(chan hypervisor.VmEvent) <- ttySizeCommand
+	qemuChan.Event <- ttySizeCommand
 	glog.V(1).Infof("Success to resize the tty!")
 	return nil
 }
@@ -37,12 +37,10 @@ func DriversProbe() hypervisor.HypervisorDriver {
 
 func (daemon *Daemon) CmdVmCreate(job *engine.Job) (err error) {
 	var (
-		vmId          = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
-		qemuPodEvent  = make(chan hypervisor.VmEvent, 128)
-		qemuStatus    = make(chan *types.QemuResponse, 128)
-		subQemuStatus = make(chan *types.QemuResponse, 128)
-		cpu           = 1
-		mem           = 128
+		vmId     = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
+		qemuChan = newQemuChan()
+		cpu      = defaultVmCpu
+		mem      = defaultVmMem
 	)
 	if job.Args[0] != "" {
 		cpu, err = strconv.Atoi(job.Args[0])
@@ -56,28 +54,36 @@ func (daemon *Daemon) CmdVmCreate(job *engine.Job) (err error) {
 			return err
 		}
 	}
+	if _, _, err := daemon.admitVm(vmId, cpu, mem); err != nil {
+		return err
+	}
+	cfg := daemon.config()
 	b := &hypervisor.BootConfig{
 		CPU:    cpu,
 		Memory: mem,
-		Kernel: daemon.kernel,
-		Initrd: daemon.initrd,
-		Bios:   daemon.bios,
-		Cbfs:   daemon.cbfs,
+		Kernel: cfg.Kernel,
+		Initrd: cfg.Initrd,
+		Bios:   cfg.Bios,
+		Cbfs:   cfg.Cbfs,
+
+		BootTimeout: cfg.BootTimeout,
 	}
-	go hypervisor.VmLoop(hypervisorDriver, vmId, qemuPodEvent, qemuStatus, b)
-	if err := daemon.SetQemuChan(vmId, qemuPodEvent, qemuStatus, subQemuStatus); err != nil {
+	go hypervisor.VmLoop(hypervisorDriver, vmId, qemuChan.Event, qemuChan.Status, b)
+	if err := daemon.SetQemuChan(vmId, qemuChan); err != nil {
 		glog.V(1).Infof("SetQemuChan error: %s", err.Error())
+		daemon.releaseVm(vmId)
 		return err
 	}
 
 	vm := &Vm{
 		Id:     vmId,
 		Pod:    nil,
-		Status: types.S_VM_IDLE,
 		Cpu:    cpu,
 		Mem:    mem,
+		status: types.S_VM_IDLE,
 	}
 	daemon.AddVm(vm)
+	daemon.LogEvent("vm", "create", vmId, "", vmId, "")
 
 	// Prepare the qemu status to client
 	v := &engine.Env{}
@@ -93,7 +99,7 @@ func (daemon *Daemon) CmdVmCreate(job *engine.Job) (err error) {
 
 func (daemon *Daemon) CmdVmKill(job *engine.Job) error {
 	vmId := job.Args[0]
-	if _, ok := daemon.vmList[vmId]; !ok {
+	if daemon.registry.Vm(vmId) == nil {
 		return fmt.Errorf("Can not find the VM(%s)", vmId)
 	}
 	code, cause, err := daemon.KillVm(vmId)
@@ -113,24 +119,49 @@ func (daemon *Daemon) CmdVmKill(job *engine.Job) error {
 	return nil
 }
 
+// CmdVmTrace lists the events and the state transitions of a VM, the VMs
+// quit lately could be traced as well.
+func (daemon *Daemon) CmdVmTrace(job *engine.Job) error {
+	if len(job.Args) == 0 || job.Args[0] == "" {
+		return fmt.Errorf("Can not trace a VM without its ID!")
+	}
+	entries, err := hypervisor.VmTrace(job.Args[0])
+	if err != nil {
+		return err
+	}
+	trace := make([]string, len(entries))
+	for i, e := range entries {
+		trace[i] = e.String()
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", job.Args[0])
+	v.SetList("traceData", trace)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (daemon *Daemon) KillVm(vmId string) (int, string, error) {
-	qemuPodEvent, qemuStatus, subQemuStatus, err := daemon.GetQemuChan(vmId)
+	qemuChan, err := daemon.GetQemuChan(vmId)
 	if err != nil {
 		return -1, "", err
 	}
 	var qemuResponse *types.QemuResponse
 	shutdownPodEvent := &hypervisor.ShutdownCommand{Wait: false}
-	qemuPodEvent.(chan hypervisor.VmEvent) <- shutdownPodEvent
+	qemuChan.Event <- shutdownPodEvent
 	// wait for the qemu response
 	for {
 		stop := 0
 		select {
-		case qemuResponse = <-qemuStatus.(chan *types.QemuResponse):
+		case qemuResponse = <-qemuChan.Status:
 			glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
 			if qemuResponse.Code == types.E_VM_SHUTDOWN {
 				stop = 1
 			}
-		case qemuResponse = <-subQemuStatus.(chan *types.QemuResponse):
+		case qemuResponse = <-qemuChan.SubStatus:
 			glog.V(1).Infof("Got response: %d: %s", qemuResponse.Code, qemuResponse.Cause)
 			if qemuResponse.Code == types.E_VM_SHUTDOWN {
 				stop = 1
@@ -140,18 +171,19 @@ func (daemon *Daemon) KillVm(vmId string) (int, string, error) {
 			break
 		}
 	}
-	close(qemuStatus.(chan *types.QemuResponse))
-	close(subQemuStatus.(chan *types.QemuResponse))
+	close(qemuChan.Status)
+	close(qemuChan.SubStatus)
 	daemon.RemoveVm(vmId)
 	daemon.DeleteQemuChan(vmId)
+	daemon.LogEvent("vm", "kill", vmId, "", vmId, "")
 
 	return qemuResponse.Code, qemuResponse.Cause, nil
 }
 
 // This function will only be invoked during daemon start
 func (daemon *Daemon) AssociateAllVms() error {
-	for _, mypod := range daemon.podList {
-		if mypod.Vm == "" {
+	for _, mypod := range daemon.registry.Pods() {
+		if mypod.Vm() == "" {
 			continue
 		}
 		data, err := daemon.GetPodByName(mypod.Id)
@@ -162,86 +194,83 @@ func (daemon *Daemon) AssociateAllVms() error {
 		if err != nil {
 			continue
 		}
-		glog.V(1).Infof("Associate the POD(%s) with VM(%s)", mypod.Id, mypod.Vm)
-		var (
-			qemuPodEvent  = make(chan hypervisor.VmEvent, 128)
-			qemuStatus    = make(chan *types.QemuResponse, 128)
-			subQemuStatus = make(chan *types.QemuResponse, 128)
-		)
-		data, err = daemon.GetVmData(mypod.Vm)
+		glog.V(1).Infof("Associate the POD(%s) with VM(%s)", mypod.Id, mypod.Vm())
+		qemuChan := newQemuChan()
+		data, err = daemon.GetVmData(mypod.Vm())
 		if err != nil {
 			continue
 		}
-		glog.V(1).Infof("The data for vm(%s) is %v", mypod.Vm, data)
-		go hypervisor.VmAssociate(hypervisorDriver, mypod.Vm, qemuPodEvent,
-			qemuStatus, mypod.Wg, data)
-		ass := <-qemuStatus
+		glog.V(1).Infof("The data for vm(%s) is %v", mypod.Vm(), data)
+		go hypervisor.VmAssociate(hypervisorDriver, mypod.Vm(), qemuChan.Event,
+			qemuChan.Status, mypod.Wg, data)
+		ass := <-qemuChan.Status
 		if ass.Code != types.E_OK {
-			glog.Errorf("cannot associate with vm: %s, error status %d (%s)", mypod.Vm, ass.Code, ass.Cause)
+			glog.Errorf("cannot associate with vm: %s, error status %d (%s)", mypod.Vm(), ass.Code, ass.Cause)
 			return errors.New("load vm status failed")
 		}
-		if err := daemon.SetQemuChan(mypod.Vm, qemuPodEvent, qemuStatus, subQemuStatus); err != nil {
+		if err := daemon.SetQemuChan(mypod.Vm(), qemuChan); err != nil {
 			glog.V(1).Infof("SetQemuChan error: %s", err.Error())
 			return err
 		}
 		vm := &Vm{
-			Id:     mypod.Vm,
+			Id:     mypod.Vm(),
 			Pod:    mypod,
-			Status: types.S_VM_ASSOCIATED,
 			Cpu:    userPod.Resource.Vcpu,
 			Mem:    userPod.Resource.Memory,
+			status: types.S_VM_ASSOCIATED,
 		}
 		daemon.AddVm(vm)
 		daemon.SetContainerStatus(mypod.Id, types.S_POD_RUNNING)
-		mypod.Status = types.S_POD_RUNNING
-		go func(interface{}) {
+		mypod.SetStatus(types.S_POD_RUNNING)
+		go func(mypod *Pod, vmId string, qemuStatus, subQemuStatus chan *types.QemuResponse) {
 			for {
 				podId := mypod.Id
 				qemuResponse := <-qemuStatus
+				if qemuResponse.Code == types.E_CONTAINER_EXITED {
+					daemon.containerExited(podId, qemuResponse.Data.(*hypervisor.ContainerExit))
+					continue
+				}
 				subQemuStatus <- qemuResponse
 				if qemuResponse.Code == types.E_POD_FINISHED {
 					data := qemuResponse.Data.([]uint32)
 					daemon.SetPodContainerStatus(podId, data)
 				} else if qemuResponse.Code == types.E_VM_SHUTDOWN {
-					if daemon.podList[mypod.Id].Status == types.S_POD_RUNNING {
-						daemon.podList[mypod.Id].Status = types.S_POD_SUCCEEDED
+					daemon.LogEvent("vm", "shutdown", vmId, podId, vmId, "")
+					if mypod.Transit(types.S_POD_SUCCEEDED, types.S_POD_RUNNING) {
+						daemon.podExited(podId, "vm shutdown")
 						daemon.SetContainerStatus(podId, types.S_POD_SUCCEEDED)
 					}
-					daemon.podList[mypod.Id].Vm = ""
-					daemon.RemoveVm(mypod.Vm)
-					daemon.DeleteQemuChan(mypod.Vm)
+					mypod.SetVm("")
+					daemon.RemoveVm(vmId)
+					daemon.DeleteQemuChan(vmId)
 					if mypod.Type == "kubernetes" {
-						switch mypod.Status {
+						switch mypod.Status() {
 						case types.S_POD_SUCCEEDED:
-							if mypod.RestartPolicy == "always" {
-								daemon.RestartPod(mypod)
+							if mypod.RestartPolicy() == "always" {
+								daemon.ScheduleRestart(mypod)
 							} else {
 								daemon.DeletePodFromDB(podId)
-								for _, c := range mypod.Containers {
+								for _, c := range mypod.Containers() {
 									glog.V(1).Infof("Ready to rm container: %s", c.Id)
 									if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 										glog.V(1).Infof("Error to rm container: %s", err.Error())
 									}
 								}
 								//								daemon.RemovePod(podId)
-								daemon.DeletePodContainerFromDB(podId)
-								daemon.DeleteVolumeId(podId)
 							}
 							break
 						case types.S_POD_FAILED:
-							if mypod.RestartPolicy != "never" {
-								daemon.RestartPod(mypod)
+							if mypod.RestartPolicy() != "never" {
+								daemon.ScheduleRestart(mypod)
 							} else {
 								daemon.DeletePodFromDB(podId)
-								for _, c := range mypod.Containers {
+								for _, c := range mypod.Containers() {
 									glog.V(1).Infof("Ready to rm container: %s", c.Id)
 									if _, _, err = daemon.dockerCli.SendCmdDelete(c.Id); err != nil {
 										glog.V(1).Infof("Error to rm container: %s", err.Error())
 									}
 								}
 								//								daemon.RemovePod(podId)
-								daemon.DeletePodContainerFromDB(podId)
-								daemon.DeleteVolumeId(podId)
 							}
 							break
 						default:
@@ -251,33 +280,33 @@ func (daemon *Daemon) AssociateAllVms() error {
 					break
 				}
 			}
-		}(subQemuStatus)
+		}(mypod, mypod.Vm(), qemuChan.Status, qemuChan.SubStatus)
 	}
 	return nil
 }
 
 func (daemon *Daemon) ReleaseAllVms() (int, error) {
 	var qemuResponse *types.QemuResponse
-	for vmId, vm := range daemon.vmList {
-		qemuPodEvent, _, qemuStatus, err := daemon.GetQemuChan(vmId)
+	for _, vm := range daemon.registry.Vms() {
+		qemuChan, err := daemon.GetQemuChan(vm.Id)
 		if err != nil {
 			return -1, err
 		}
-		if vm.Status == types.S_VM_IDLE {
+		if vm.Status() == types.S_VM_IDLE {
 			shutdownPodEvent := &hypervisor.ShutdownCommand{Wait: false}
-			qemuPodEvent.(chan hypervisor.VmEvent) <- shutdownPodEvent
+			qemuChan.Event <- shutdownPodEvent
 			for {
-				qemuResponse = <-qemuStatus.(chan *types.QemuResponse)
+				qemuResponse = <-qemuChan.SubStatus
 				if qemuResponse.Code == types.E_VM_SHUTDOWN {
 					break
 				}
 			}
-			close(qemuStatus.(chan *types.QemuResponse))
+			close(qemuChan.SubStatus)
 		} else {
 			releasePodEvent := &hypervisor.ReleaseVMCommand{}
-			qemuPodEvent.(chan hypervisor.VmEvent) <- releasePodEvent
+			qemuChan.Event <- releasePodEvent
 			for {
-				qemuResponse = <-qemuStatus.(chan *types.QemuResponse)
+				qemuResponse = <-qemuChan.SubStatus
 				if qemuResponse.Code == types.E_VM_SHUTDOWN ||
 					qemuResponse.Code == types.E_OK {
 					break
@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"fmt"
+	"path"
+	"syscall"
+
+	"hyper/engine"
+	"hyper/hypervisor"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+)
+
+// CmdVolumeAttach mounts a volume to a path of a container in a running pod,
+// the args are pod, volume, container and path. A volume the pod does not
+// have is added to it, with the source and driver given in the env.
+func (daemon *Daemon) CmdVolumeAttach(job *engine.Job) error {
+	if len(job.Args) < 4 {
+		return fmt.Errorf("Can not attach a volume without pod, volume, container and path!")
+	}
+	var (
+		podId    = job.Args[0]
+		volName  = job.Args[1]
+		cName    = job.Args[2]
+		mount    = job.Args[3]
+		readOnly = job.GetenvBool("readonly")
+	)
+	if !path.IsAbs(mount) {
+		return fmt.Errorf("The mount point %s should be an absolute path", mount)
+	}
+
+	mypod, userPod, err := daemon.runningPodSpec(podId)
+	if err != nil {
+		return err
+	}
+	defer mypod.Unlock()
+	idx, err := podContainer(mypod, userPod, cName)
+	if err != nil {
+		return err
+	}
+
+	var spec *pod.UserVolume
+	for i, v := range userPod.Volumes {
+		if v.Name == volName {
+			spec = &userPod.Volumes[i]
+			break
+		}
+	}
+
+	cmd := &hypervisor.AttachVolumeCommand{
+		Volume:    volName,
+		Container: mypod.Containers()[idx].Id,
+		Path:      mount,
+		ReadOnly:  readOnly,
+	}
+	sharedDir := path.Join(hypervisor.BaseDir, mypod.Vm(), hypervisor.ShareDirTag)
+	if spec == nil {
+		spec = &pod.UserVolume{
+			Name:   volName,
+			Source: job.Getenv("source"),
+			Driver: job.Getenv("driver"),
+		}
+		if spec.Source != "" && spec.Driver != "vfs" && spec.Driver != "raw" && spec.Driver != "qcow2" {
+			return fmt.Errorf("Unsupported driver %s of volume %s", spec.Driver, volName)
+		}
+		if cmd.Info, err = daemon.prepareVolume(podId, sharedDir, *spec); err != nil {
+			return err
+		}
+		cmd.Spec = spec
+		userPod.Volumes = append(userPod.Volumes, *spec)
+	}
+
+	glog.V(1).Infof("attach volume %s to %s of container %s in pod %s", volName, mount, cName, podId)
+	res, err := daemon.sendHotplug(mypod.Vm(), cmd)
+	if err != nil || res.Code != types.E_OK {
+		if err == nil {
+			if res.Code == types.E_BAD_REQUEST && cmd.Info != nil {
+				// the VM never took the volume over
+				releaseVolume(sharedDir, cmd.Info)
+			}
+			err = fmt.Errorf("Fail to attach volume %s: %s", volName, res.Cause)
+		}
+		return err
+	}
+
+	userPod.Containers[idx].Volumes = append(userPod.Containers[idx].Volumes, pod.UserVolumeReference{
+		Path:     mount,
+		Volume:   volName,
+		ReadOnly: readOnly,
+	})
+	if err := daemon.updatePodSpec(mypod, userPod, res.Data); err != nil {
+		return err
+	}
+	daemon.LogEvent("volume", "attach", volName, mypod.Id, mypod.Vm(), cName+":"+mount)
+
+	v := &engine.Env{}
+	v.Set("ID", volName)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CmdVolumeDetach unmounts a volume from a container in a running pod, the
+// args are pod, volume and container. A volume no container mounts any more
+// is removed from the pod, the VM releases its device or dir.
+func (daemon *Daemon) CmdVolumeDetach(job *engine.Job) error {
+	if len(job.Args) < 3 {
+		return fmt.Errorf("Can not detach a volume without pod, volume and container!")
+	}
+	var (
+		podId   = job.Args[0]
+		volName = job.Args[1]
+		cName   = job.Args[2]
+	)
+
+	mypod, userPod, err := daemon.runningPodSpec(podId)
+	if err != nil {
+		return err
+	}
+	defer mypod.Unlock()
+	idx, err := podContainer(mypod, userPod, cName)
+	if err != nil {
+		return err
+	}
+
+	glog.V(1).Infof("detach volume %s from container %s in pod %s", volName, cName, podId)
+	res, err := daemon.sendHotplug(mypod.Vm(), &hypervisor.DetachVolumeCommand{
+		Volume:    volName,
+		Container: mypod.Containers()[idx].Id,
+	})
+	if err != nil {
+		return err
+	}
+	if res.Code != types.E_OK {
+		return fmt.Errorf("Fail to detach volume %s: %s", volName, res.Cause)
+	}
+
+	refs := userPod.Containers[idx].Volumes
+	for i, v := range refs {
+		if v.Volume == volName {
+			userPod.Containers[idx].Volumes = append(refs[:i], refs[i+1:]...)
+			break
+		}
+	}
+	if !volumeMounted(userPod, volName) {
+		for i, v := range userPod.Volumes {
+			if v.Name == volName {
+				userPod.Volumes = append(userPod.Volumes[:i], userPod.Volumes[i+1:]...)
+				break
+			}
+		}
+	}
+	if err := daemon.updatePodSpec(mypod, userPod, res.Data); err != nil {
+		return err
+	}
+	daemon.LogEvent("volume", "detach", volName, mypod.Id, mypod.Vm(), cName)
+
+	v := &engine.Env{}
+	v.Set("ID", volName)
+	v.SetInt("Code", 0)
+	v.Set("Cause", "")
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func volumeMounted(userPod *pod.UserPod, volName string) bool {
+	for _, c := range userPod.Containers {
+		for _, v := range c.Volumes {
+			if v.Volume == volName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// releaseVolume undoes prepareVolume for a volume the VM did not take.
+func releaseVolume(sharedDir string, info *hypervisor.VolumeInfo) {
+	if info.Fstype == "dir" {
+		if err := syscall.Unmount(path.Join(sharedDir, info.Filepath), 0); err != nil {
+			glog.Warningf("Cannot umount volume %s: %s", info.Name, err.Error())
+		}
+		return
+	}
+	done := make(chan hypervisor.VmEvent, 1)
+	hypervisor.UmountDMDevice(info.Filepath, info.Name, done)
+	<-done
+}
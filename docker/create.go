@@ -79,6 +79,10 @@ type HostConfig struct {
 	CgroupParent    string // Parent cgroup.
 }
 
+// PodLabel is the label of the containers created for a pod, its value is
+// the id of the pod
+const PodLabel = "sh.hyper.pod"
+
 type ConfigAndHostConfig struct {
 	Config
 	HostConfig HostConfig
@@ -104,7 +108,13 @@ func (cli *DockerCli) SendCmdCreate(args ...string) ([]byte, int, error) {
 	v.Set("tag", tag)
 	imageAndTag := fmt.Sprintf("%s:%s", repos, tag)
 	containerValues := url.Values{}
+	if len(args) > 1 && args[1] != "" {
+		containerValues.Set("name", args[1])
+	}
 	config := initAndMergeConfigs(imageAndTag)
+	if len(args) > 2 && args[2] != "" {
+		config.Labels = map[string]string{PodLabel: args[2]}
+	}
 	glog.V(1).Infof("The Repository is %s, and the tag is %s\n", repos, tag)
 	body, statusCode, err := cli.Call("POST", "/containers/create?"+containerValues.Encode(), config, nil)
 	glog.V(1).Infof("The returned status code is %d!\n", statusCode)
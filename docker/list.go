@@ -0,0 +1,31 @@
+package docker
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// ContainerSummary is a container in the list of the docker daemon
+type ContainerSummary struct {
+	Id      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	Created int64             `json:"Created"`
+	Labels  map[string]string `json:"Labels"`
+}
+
+// ListContainers returns all the containers of the docker daemon, running
+// or not
+func (cli *DockerCli) ListContainers() ([]ContainerSummary, error) {
+	v := url.Values{}
+	v.Set("all", "1")
+	body, _, err := readBody(cli.Call("GET", "/containers/json?"+v.Encode(), nil, nil))
+	if err != nil {
+		return nil, err
+	}
+	var containers []ContainerSummary
+	if err := json.Unmarshal(body, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
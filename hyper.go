@@ -31,6 +31,12 @@ func main() {
 	}
 
 	if err := cli.Cmd(flag.Args()...); err != nil {
+		if sterr, ok := err.(client.StatusError); ok {
+			if sterr.Status != "" {
+				fmt.Printf("%s ERROR: %s\n", os.Args[0], sterr.Status)
+			}
+			os.Exit(sterr.StatusCode)
+		}
 		fmt.Printf("%s ERROR: %s\n", os.Args[0], err.Error())
 	}
 }
@@ -17,6 +17,8 @@ func main() {
 	flConfig := flag.String("config", "", "Config file for hyperd")
 	flHost := flag.String("host", "", "Host for hyperd")
 	flHelp := flag.Bool("help", false, "Print help message for Hyperd daemon")
+	flCheckDB := flag.Bool("check-db", false, "Check the db of hyperd and exit")
+	flRepair := flag.Bool("repair", false, "Migrate the db and remove the broken and dangling records found by --check-db")
 	glog.Init()
 	flag.Usage = func() { printHelp() }
 	flag.Parse()
@@ -24,6 +26,9 @@ func main() {
 		printHelp()
 		return
 	}
+	if *flCheckDB == true {
+		os.Exit(checkDB(*flRepair))
+	}
 	mainDaemon(*flConfig, *flHost)
 }
 
@@ -38,6 +43,13 @@ Application Options:
   --host                 host address and port for hyperd(such as --host=tcp://127.0.0.1:12345)
   --logtostderr          log to standard error instead of files
   --alsologtostderr      log to standard error as well as files
+  --check-db             check the db for broken and dangling records, hyperd must not be running
+  --repair               with --check-db, migrate the db and remove the records found
+
+Signals:
+  SIGHUP                 reload the configuration, the changes which need a restart are logged
+  SIGUSR1                release the VMs of the pods and exit, the VMs keep running
+  SIGINT, SIGTERM        destroy all VMs and exit
 
 Help Options:
   -h, --help             Show this help message
@@ -46,6 +58,26 @@ Help Options:
 	fmt.Printf(helpMessage, os.Args[0], os.Args[0])
 }
 
+func checkDB(repair bool) int {
+	problems, err := daemon.CheckDB(repair)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	if len(problems) == 0 {
+		fmt.Println("The db is consistent")
+	} else if repair {
+		fmt.Printf("%d problems are repaired\n", len(problems))
+	} else {
+		fmt.Printf("%d problems are found, run with --repair to remove the records\n", len(problems))
+		return 1
+	}
+	return 0
+}
+
 func mainDaemon(config, host string) {
 	glog.V(0).Infof("The config file is %s", config)
 	if config == "" {
@@ -62,7 +94,9 @@ func mainDaemon(config, host string) {
 	stopAll := make(chan os.Signal, 1)
 	signal.Notify(stopAll, syscall.SIGINT, syscall.SIGTERM)
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGHUP)
+	signal.Notify(stop, syscall.SIGUSR1)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
 	// Install the accepted jobs
 	if err := d.Install(eng); err != nil {
@@ -86,8 +120,8 @@ func mainDaemon(config, host string) {
 		defaultHost = append(defaultHost, host)
 	}
 	defaultHost = append(defaultHost, "unix:///var/run/hyper.sock")
-	if d.Host != "" {
-		defaultHost = append(defaultHost, d.Host)
+	if h := d.ApiHost(); h != "" {
+		defaultHost = append(defaultHost, h)
 	}
 
 	job := eng.Job("serveapi", defaultHost...)
@@ -111,25 +145,37 @@ func mainDaemon(config, host string) {
 		glog.Warningf("Fail to restore the previous VM")
 		return
 	}
+	d.StartGC()
+	if err := d.StartCrons(); err != nil {
+		glog.Warningf("Fail to start the crons: %s", err.Error())
+	}
 
 	// Daemon is fully initialized and handling API traffic
 	// Wait for serve API job to complete
-	select {
-	case errAPI := <-serveAPIWait:
-		// If we have an error here it is unique to API (as daemonErr would have
-		// exited the daemon process above)
-		eng.Shutdown()
-		if errAPI != nil {
-			glog.Warningf("Shutting down due to ServeAPI error: %v\n", errAPI)
+	for {
+		select {
+		case errAPI := <-serveAPIWait:
+			// If we have an error here it is unique to API (as daemonErr would have
+			// exited the daemon process above)
+			eng.Shutdown()
+			if errAPI != nil {
+				glog.Warningf("Shutting down due to ServeAPI error: %v\n", errAPI)
+			}
+			return
+		case <-reload:
+			if _, restart, err := d.Reload(); err != nil {
+				glog.Errorf("Reload the config failed, %s\n", err.Error())
+			} else if len(restart) > 0 {
+				glog.Warningf("The config of %v changed, restart hyperd to apply it\n", restart)
+			}
+		case <-stop:
+			d.DestroyAndKeepVm()
+			eng.Shutdown()
+			return
+		case <-stopAll:
+			d.DestroyAllVm()
+			eng.Shutdown()
+			return
 		}
-		break
-	case <-stop:
-		d.DestroyAndKeepVm()
-		eng.Shutdown()
-		break
-	case <-stopAll:
-		d.DestroyAllVm()
-		eng.Shutdown()
-		break
 	}
 }
@@ -0,0 +1,253 @@
+package hypervisor
+
+import (
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/types"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBootTimeout = 60 //seconds
+	BootConsoleLines   = 50 //console lines kept for the boot failure report
+
+	BootPhaseDevice = "device prep"
+	BootPhaseInit   = "init ready"
+)
+
+type bootPhase struct {
+	name string
+	cost time.Duration
+}
+
+// bootWatch follows a VM from the launch to the INIT_READY of its init, it
+// fires a VmBootTimeout if the init is not ready in time, and keeps the
+// timings and the console output needed to tell why a boot failed.
+type bootWatch struct {
+	lock     sync.Mutex
+	start    time.Time
+	begins   map[string]time.Time
+	phases   []bootPhase
+	timer    *time.Timer
+	ready    bool
+	failed   bool
+	reported bool
+
+	console []string
+	next    int
+}
+
+func newBootWatch() *bootWatch {
+	return &bootWatch{
+		start:   time.Now(),
+		begins:  make(map[string]time.Time),
+		console: make([]string, 0, BootConsoleLines),
+	}
+}
+
+// begin marks the start of a phase, the phases not begun explicitly are
+// measured from the launch of the VM
+func (b *bootWatch) begin(phase string) {
+	b.lock.Lock()
+	b.begins[phase] = time.Now()
+	b.lock.Unlock()
+}
+
+func (b *bootWatch) done(phase string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	start, ok := b.begins[phase]
+	if !ok {
+		start = b.start
+	}
+	delete(b.begins, phase)
+	b.phases = append(b.phases, bootPhase{name: phase, cost: time.Since(start)})
+}
+
+// end finishes a phase begun explicitly
+func (b *bootWatch) end(phase string) {
+	b.lock.Lock()
+	_, ok := b.begins[phase]
+	b.lock.Unlock()
+	if ok {
+		b.done(phase)
+	}
+}
+
+func (b *bootWatch) timings() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.phases) == 0 {
+		return "none"
+	}
+	ts := make([]string, len(b.phases))
+	for i, p := range b.phases {
+		ts[i] = fmt.Sprintf("%s %s", p.name, p.cost-p.cost%time.Millisecond)
+	}
+	return strings.Join(ts, ", ")
+}
+
+func (b *bootWatch) addConsole(line string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.console) < BootConsoleLines {
+		b.console = append(b.console, line)
+		return
+	}
+	b.console[b.next] = line
+	b.next = (b.next + 1) % BootConsoleLines
+}
+
+// consoleTail returns the kept console lines, the oldest first
+func (b *bootWatch) consoleTail() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	lines := make([]string, 0, len(b.console))
+	lines = append(lines, b.console[b.next:]...)
+	return append(lines, b.console[:b.next]...)
+}
+
+// watchBoot starts the boot deadline of the VM, it should be called just
+// before the VM is launched
+func (ctx *VmContext) watchBoot() {
+	seconds := DefaultBootTimeout
+	if ctx.Boot != nil && ctx.Boot.BootTimeout > 0 {
+		seconds = ctx.Boot.BootTimeout
+	}
+
+	ctx.boot = newBootWatch()
+	ctx.boot.timer = time.AfterFunc(time.Duration(seconds)*time.Second, func() {
+		ctx.Hub <- &VmBootTimeout{}
+	})
+}
+
+// BootPhaseDone is called by the drivers to record the time a boot phase
+// took, such as starting the hypervisor process.
+func (ctx *VmContext) BootPhaseDone(phase string) {
+	if ctx.boot != nil {
+		ctx.boot.done(phase)
+	}
+}
+
+func (ctx *VmContext) bootPhaseBegin(phase string) {
+	if ctx.boot != nil && !ctx.booted() {
+		ctx.boot.begin(phase)
+	}
+}
+
+func (ctx *VmContext) bootPhaseEnd(phase string) {
+	if ctx.boot != nil {
+		ctx.boot.end(phase)
+	}
+}
+
+// booted reports whether the init of the VM has been ready, the VMs which
+// are not launched by us are always booted
+func (ctx *VmContext) booted() bool {
+	if ctx.boot == nil {
+		return true
+	}
+	ctx.boot.lock.Lock()
+	defer ctx.boot.lock.Unlock()
+	return ctx.boot.ready
+}
+
+func (ctx *VmContext) bootReady() {
+	if ctx.booted() {
+		return
+	}
+	ctx.boot.done(BootPhaseInit)
+
+	ctx.boot.lock.Lock()
+	ctx.boot.ready = true
+	ctx.boot.timer.Stop()
+	ctx.boot.lock.Unlock()
+
+	glog.Infof("VM %s booted, timings: %s", ctx.Id, ctx.boot.timings())
+}
+
+// bootTimings returns the timings of the boot if they have not been
+// reported yet
+func (ctx *VmContext) bootTimings() string {
+	if ctx.boot == nil {
+		return ""
+	}
+	ctx.boot.lock.Lock()
+	reported := ctx.boot.reported
+	ctx.boot.reported = true
+	ctx.boot.lock.Unlock()
+
+	if reported {
+		return ""
+	}
+	return ctx.boot.timings()
+}
+
+func (ctx *VmContext) stopBootWatch() {
+	if ctx.boot != nil {
+		ctx.boot.timer.Stop()
+	}
+}
+
+// bootFailure describes a failed boot with all we know about it
+func (ctx *VmContext) bootFailure(reason string) string {
+	cause := []string{
+		fmt.Sprintf("VM %s failed to boot: %s", ctx.Id, reason),
+		"boot timings: " + ctx.boot.timings(),
+	}
+	if dc, ok := ctx.DCtx.(DiagnosticContext); ok {
+		if diag := dc.Diagnostics(); diag != "" {
+			cause = append(cause, diag)
+		}
+	}
+	if lines := ctx.boot.consoleTail(); len(lines) > 0 {
+		cause = append(cause, fmt.Sprintf("last %d lines of the console:", len(lines)))
+		cause = append(cause, lines...)
+	}
+	return strings.Join(cause, "\n")
+}
+
+// reportBootFailed reports the daemon a VM failed to boot, only once. If a
+// pod is on the VM, the daemon could wait on its WaitGroup for the resources
+// of the VM being released before trying another VM.
+func (ctx *VmContext) reportBootFailed(reason string) {
+	if ctx.boot == nil {
+		return
+	}
+	ctx.boot.lock.Lock()
+	failed := ctx.boot.failed
+	ctx.boot.failed = true
+	ctx.boot.timer.Stop()
+	ctx.boot.lock.Unlock()
+	if failed {
+		return
+	}
+
+	cause := ctx.bootFailure(reason)
+	glog.Error(cause)
+
+	if ctx.wg != nil && !ctx.wait {
+		ctx.wg.Add(1)
+		ctx.wait = true
+	}
+
+	ctx.client <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_BOOT_FAILED,
+		Cause: cause,
+	}
+}
+
+// bootFailed gives up a VM which could not boot
+func (ctx *VmContext) bootFailed(reason string, hasPod bool) {
+	ctx.reportBootFailed(reason)
+	ctx.unsetTimeout()
+	ctx.DCtx.Kill(ctx)
+	if hasPod {
+		ctx.Become(stateTerminating, "TERMINATING")
+	} else {
+		ctx.Become(stateDestroying, "DESTROYING")
+	}
+}
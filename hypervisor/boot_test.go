@@ -0,0 +1,75 @@
+package hypervisor
+
+import (
+	"fmt"
+	"hyper/types"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBootConsoleTail(t *testing.T) {
+	b := newBootWatch()
+	for i := 0; i < BootConsoleLines+5; i++ {
+		b.addConsole(fmt.Sprintf("line %d", i))
+	}
+
+	lines := b.consoleTail()
+	if len(lines) != BootConsoleLines {
+		t.Fatalf("should keep %d lines, but got %d", BootConsoleLines, len(lines))
+	}
+	if lines[0] != "line 5" || lines[len(lines)-1] != fmt.Sprintf("line %d", BootConsoleLines+4) {
+		t.Errorf("the oldest lines should be dropped: %s ... %s", lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestBootTimings(t *testing.T) {
+	b := newBootWatch()
+	b.end(BootPhaseDevice)
+	if b.timings() != "none" {
+		t.Error("a phase not begun should not be recorded: ", b.timings())
+	}
+
+	b.begin(BootPhaseDevice)
+	b.end(BootPhaseDevice)
+	b.done(BootPhaseInit)
+	timings := b.timings()
+	if !strings.HasPrefix(timings, BootPhaseDevice) || !strings.Contains(timings, ", "+BootPhaseInit) {
+		t.Error("wrong timings: ", timings)
+	}
+}
+
+func TestReportBootFailed(t *testing.T) {
+	dr := &EmptyDriver{}
+	dr.Initialize()
+
+	client := make(chan *types.QemuResponse, 8)
+	ctx, err := InitContext(dr, "vmbootfail", make(chan VmEvent, 8), client, nil, &BootConfig{BootTimeout: 600})
+	if err != nil {
+		t.Fatal("init context failed ", err.Error())
+	}
+	ctx.watchBoot()
+	ctx.wg = &sync.WaitGroup{}
+	ctx.BootPhaseDone("qemu start")
+	ctx.boot.addConsole("Kernel panic")
+
+	ctx.reportBootFailed("boot timeout")
+	ctx.reportBootFailed("VM exited before the init was ready")
+
+	res := <-client
+	if res.Code != types.E_BOOT_FAILED {
+		t.Fatal("should report boot failed, but got ", res.Code)
+	}
+	for _, s := range []string{"boot timeout", "qemu start", "Kernel panic"} {
+		if !strings.Contains(res.Cause, s) {
+			t.Errorf("cause should contain %q: %s", s, res.Cause)
+		}
+	}
+	if len(client) != 0 {
+		t.Error("the boot failure should be reported only once")
+	}
+
+	// the waiter of the pod is released when the context is closed
+	ctx.Close()
+	ctx.wg.Wait()
+}
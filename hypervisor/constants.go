@@ -10,7 +10,6 @@ const (
 	DefaultInitrd   = "/var/lib/hyper/hyper-initrd.img"
 	PciAddrFrom     = 0x05
 	ExitChar        = 4
-	InterfaceCount  = 1
 )
 
 const (
@@ -34,6 +33,11 @@ const (
 	EVENT_SERIAL_DELETE
 	EVENT_TTY_OPEN
 	EVENT_TTY_CLOSE
+	EVENT_EXEC_FINISH
+	EVENT_CONTAINER_RELEASED
+	EVENT_VM_BOOT_TIMEOUT
+	EVENT_CONTAINER_EXIT
+	EVENT_VOLUME_RELEASED
 	COMMAND_RUN_POD
 	COMMAND_REPLACE_POD
 	COMMAND_STOP_POD
@@ -41,6 +45,13 @@ const (
 	COMMAND_RELEASE
 	COMMAND_EXEC
 	COMMAND_ATTACH
+	COMMAND_FILE_TRANSFER
+	COMMAND_PORT_FORWARD
+	COMMAND_NEW_CONTAINER
+	COMMAND_REMOVE_CONTAINER
+	COMMAND_ATTACH_VOLUME
+	COMMAND_DETACH_VOLUME
+	COMMAND_RESTART_CONTAINER
 	COMMAND_DETACH
 	COMMAND_WINDOWSIZE
 	COMMAND_ACK
@@ -65,8 +76,50 @@ const (
 	INIT_WINSIZE
 	INIT_PING
 	INIT_FINISHPOD
+	INIT_FILETRANSFER
+	INIT_PORTFORWARD
+	INIT_REMOVECONTAINER
+	INIT_ATTACHVOLUME
+	INIT_DETACHVOLUME
+	INIT_CONTAINEREXIT
+	INIT_RESTARTCONTAINER
 )
 
+// Versions of the host/init wire protocol. A legacy init sends an empty
+// INIT_READY payload and speaks the 8 bytes (code, length) framing. A
+// versioned init announces its version and capabilities in INIT_READY, and
+// once the host has echoed the agreed version back, every message carries a
+// 12 bytes (code, length, seq) header so replies may arrive out of order.
+const (
+	INIT_PROTOCOL_LEGACY = iota
+	INIT_PROTOCOL_V1
+)
+
+const INIT_PROTOCOL_VERSION = INIT_PROTOCOL_V1
+
+// Capabilities a guest init may announce in INIT_READY.
+const (
+	// the init reports the exit code of exec'd processes with INIT_FINISHCMD
+	INIT_CAP_EXITCODE = "exitcode"
+	// the init handles INIT_FILETRANSFER
+	INIT_CAP_FILETRANSFER = "filetransfer"
+	// the init handles INIT_PORTFORWARD
+	INIT_CAP_PORTFORWARD = "portforward"
+	// the init handles INIT_NEWCONTAINER and INIT_REMOVECONTAINER
+	INIT_CAP_HOTPLUG = "hotplug"
+	// the init handles INIT_ATTACHVOLUME and INIT_DETACHVOLUME
+	INIT_CAP_VOLUME = "volume"
+	// the init applies the sysctls of the pod before starting the containers
+	INIT_CAP_SYSCTL = "sysctl"
+	// the init reports the exit of each container with INIT_CONTAINEREXIT
+	// instead of finishing the pod, and handles INIT_RESTARTCONTAINER
+	INIT_CAP_RESTART = "restart"
+)
+
+// Exit code reported for an exec whose command could not be started, or
+// whose exit code the init could not report.
+const ExecStartFailed = 126
+
 const (
 	PREPARING_CONTAINER = iota
 	PREPARING_VOLUME
@@ -116,6 +169,16 @@ func EventString(ev int) string {
 		return "EVENT_TTY_OPEN"
 	case EVENT_TTY_CLOSE:
 		return "EVENT_TTY_CLOSE"
+	case EVENT_EXEC_FINISH:
+		return "EVENT_EXEC_FINISH"
+	case EVENT_CONTAINER_RELEASED:
+		return "EVENT_CONTAINER_RELEASED"
+	case EVENT_VM_BOOT_TIMEOUT:
+		return "EVENT_VM_BOOT_TIMEOUT"
+	case EVENT_CONTAINER_EXIT:
+		return "EVENT_CONTAINER_EXIT"
+	case EVENT_VOLUME_RELEASED:
+		return "EVENT_VOLUME_RELEASED"
 	case COMMAND_RUN_POD:
 		return "COMMAND_RUN_POD"
 	case COMMAND_REPLACE_POD:
@@ -130,6 +193,20 @@ func EventString(ev int) string {
 		return "COMMAND_EXEC"
 	case COMMAND_ATTACH:
 		return "COMMAND_ATTACH"
+	case COMMAND_FILE_TRANSFER:
+		return "COMMAND_FILE_TRANSFER"
+	case COMMAND_PORT_FORWARD:
+		return "COMMAND_PORT_FORWARD"
+	case COMMAND_NEW_CONTAINER:
+		return "COMMAND_NEW_CONTAINER"
+	case COMMAND_REMOVE_CONTAINER:
+		return "COMMAND_REMOVE_CONTAINER"
+	case COMMAND_ATTACH_VOLUME:
+		return "COMMAND_ATTACH_VOLUME"
+	case COMMAND_DETACH_VOLUME:
+		return "COMMAND_DETACH_VOLUME"
+	case COMMAND_RESTART_CONTAINER:
+		return "COMMAND_RESTART_CONTAINER"
 	case COMMAND_DETACH:
 		return "COMMAND_DETACH"
 	case COMMAND_WINDOWSIZE:
@@ -48,6 +48,13 @@ type VmContext struct {
 
 	ptys        *pseudoTtys
 	ttySessions map[string]uint64
+	hotplug     map[string]*hotplugRequest //containers being added to or removed from the running pod
+	volumeOps   map[string]*volumeRequest  //volumes being attached or detached in the running pod
+	containers  map[string]*containerState //exits and restarts of the containers of the running pod
+	restarting  map[string]*restartRequest //containers being restarted
+
+	initVersion int      //negotiated init protocol version
+	initCaps    []string //capabilities announced by the guest init
 
 	// Specification
 	userSpec *pod.UserPod
@@ -56,10 +63,13 @@ type VmContext struct {
 
 	progress *processingList
 
+	boot *bootWatch //nil if the VM is not launched by us
+
 	// Internal Helper
 	handler stateHandler
 	current string
 	timer   *time.Timer
+	trace   *vmTrace
 
 	lock *sync.Mutex //protect update of context
 	wg   *sync.WaitGroup
@@ -108,6 +118,10 @@ func InitContext(dr HypervisorDriver, id string, hub chan VmEvent, client chan *
 		vm:              vmChannel,
 		ptys:            newPts(),
 		ttySessions:     make(map[string]uint64),
+		hotplug:         make(map[string]*hotplugRequest),
+		volumeOps:       make(map[string]*volumeRequest),
+		containers:      make(map[string]*containerState),
+		restarting:      make(map[string]*restartRequest),
 		HomeDir:         homeDir,
 		HyperSockName:   hyperSockName,
 		TtySockName:     ttySockName,
@@ -115,6 +129,8 @@ func InitContext(dr HypervisorDriver, id string, hub chan VmEvent, client chan *
 		ShareDir:        shareDir,
 		timer:           nil,
 		handler:         stateInit,
+		current:         "INIT",
+		trace:           newTrace(id),
 		userSpec:        nil,
 		vmSpec:          nil,
 		devices:         newDeviceMap(),
@@ -179,6 +195,38 @@ func (ctx *VmContext) nextAttachId() uint64 {
 	return id
 }
 
+func (ctx *VmContext) setInitProtocol(version int, caps []string) {
+	ctx.lock.Lock()
+	ctx.initVersion = version
+	ctx.initCaps = caps
+	ctx.lock.Unlock()
+}
+
+func (ctx *VmContext) initProtocol() int {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	return ctx.initVersion
+}
+
+func (ctx *VmContext) initCapabilities() []string {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	return ctx.initCaps
+}
+
+// InitHasCapability reports whether the guest init announced the named
+// capability during the INIT_READY handshake. Legacy inits announce none.
+func (ctx *VmContext) InitHasCapability(name string) bool {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	for _, c := range ctx.initCaps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (ctx *VmContext) clientReg(tag string, session uint64) {
 	ctx.lock.Lock()
 	ctx.ttySessions[tag] = session
@@ -214,11 +262,17 @@ func (ctx *VmContext) Close() {
 	ctx.lock.Lock()
 	defer ctx.lock.Unlock()
 	ctx.unsetTimeout()
+	ctx.stopBootWatch()
+	if ctx.wait {
+		ctx.wg.Done()
+		ctx.wait = false
+	}
 	ctx.DCtx.Close()
 	close(ctx.vm)
 	os.Remove(ctx.ShareDir)
 	ctx.handler = nil
 	ctx.current = "None"
+	closeTrace(ctx.Id)
 }
 
 func (ctx *VmContext) tryClose() bool {
@@ -236,6 +290,7 @@ func (ctx *VmContext) Become(handler stateHandler, desc string) {
 	ctx.handler = handler
 	ctx.current = desc
 	ctx.lock.Unlock()
+	ctx.traceTransition(orig, desc)
 	glog.V(1).Infof("VM %s: state change from %s to '%s'", ctx.Id, orig, desc)
 }
 
@@ -246,7 +301,7 @@ func (ctx *VmContext) InitDeviceContext(spec *pod.UserPod, wg *sync.WaitGroup,
 	ctx.lock.Lock()
 	defer ctx.lock.Unlock()
 
-	for i := 0; i < InterfaceCount; i++ {
+	for i := range podInterfaces(spec) {
 		ctx.progress.adding.networks[i] = true
 	}
 
@@ -281,6 +336,11 @@ func (ctx *VmContext) InitDeviceContext(spec *pod.UserPod, wg *sync.WaitGroup,
 			containers[i].Tty = ctx.attachId
 			ctx.attachId++
 			ctx.ptys.ttys[containers[i].Tty] = newAttachments(i, true)
+		} else {
+			containers[i].Stdio = ctx.attachId
+			containers[i].Stderr = ctx.attachId + 1
+			ctx.attachId += 2
+			ctx.ptys.stdioSessions(i, true, containers[i].Stdio, containers[i].Stderr)
 		}
 	}
 
@@ -290,6 +350,7 @@ func (ctx *VmContext) InitDeviceContext(spec *pod.UserPod, wg *sync.WaitGroup,
 		Interfaces: nil,
 		Routes:     nil,
 		ShareDir:   ShareDirTag,
+		Sysctl:     spec.Sysctl,
 	}
 
 	for _, vol := range vInfo {
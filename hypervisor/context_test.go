@@ -3,6 +3,8 @@ package hypervisor
 import (
 	"encoding/json"
 	"hyper/pod"
+	"hyper/types"
+	"sync"
 	"testing"
 )
 
@@ -24,10 +26,10 @@ func TestInitContext(t *testing.T) {
 		t.Error("id should be vmid, but is ", ctx.Id)
 	}
 	if ctx.Boot.CPU != 3 {
-		t.Error("cpu should be 3, but is ", string(ctx.Boot.CPU))
+		t.Error("cpu should be 3, but is ", ctx.Boot.CPU)
 	}
 	if ctx.Boot.Memory != 202 {
-		t.Error("memory should be 202, but is ", string(ctx.Boot.Memory))
+		t.Error("memory should be 202, but is ", ctx.Boot.Memory)
 	}
 
 	t.Log("id check finished.")
@@ -58,7 +60,7 @@ func TestParseSpec(t *testing.T) {
 		t.Error("parse json failed ", err.Error())
 	}
 
-	ctx.InitDeviceContext(&spec, cs, nil)
+	ctx.InitDeviceContext(&spec, &sync.WaitGroup{}, cs, nil)
 
 	if ctx.userSpec != &spec {
 		t.Error("user pod assignment fail")
@@ -110,7 +112,7 @@ func TestParseVolumes(t *testing.T) {
 		&ContainerInfo{},
 	}
 
-	ctx.InitDeviceContext(&spec, cs, nil)
+	ctx.InitDeviceContext(&spec, &sync.WaitGroup{}, cs, nil)
 
 	res, err := json.MarshalIndent(*ctx.vmSpec, "    ", "    ")
 	if err != nil {
@@ -248,3 +250,56 @@ func testJson(key string) string {
 
 	return jsons[key]
 }
+
+func TestDetachLastVolume(t *testing.T) {
+	dr := &EmptyDriver{}
+	dr.Initialize()
+
+	b := &BootConfig{
+		CPU:    1,
+		Memory: 128,
+		Kernel: "somekernel",
+		Initrd: "someinitrd",
+	}
+
+	ctx, _ := InitContext(dr, "vmid", nil, nil, nil, b)
+
+	spec := pod.UserPod{}
+	if err := json.Unmarshal([]byte(testJson("with_volumes")), &spec); err != nil {
+		t.Fatal("parse json failed ", err.Error())
+	}
+	cs := []*ContainerInfo{
+		&ContainerInfo{Id: "c1"},
+	}
+	ctx.InitDeviceContext(&spec, &sync.WaitGroup{}, cs, nil)
+
+	callback := make(chan *types.QemuResponse, 1)
+	ctx.volumeOps["vol1"] = &volumeRequest{container: "c1", path: "/var/dir1", callback: callback}
+	msg, _ := json.Marshal(&volumeMount{Name: "vol1", Container: "c1"})
+	ctx.volumeAcked(INIT_DETACHVOLUME, msg, true)
+
+	res := <-callback
+	if res.Code != types.E_OK {
+		t.Fatalf("detach failed: %s", res.Cause)
+	}
+	if _, ok := ctx.devices.volumeMap["vol1"]; ok {
+		t.Error("the detached volume is still in the device map")
+	}
+	for _, v := range ctx.userSpec.Volumes {
+		if v.Name == "vol1" {
+			t.Error("the detached volume is still in the pod spec")
+		}
+	}
+	if _, ok := ctx.devices.volumeMap["vol2"]; !ok {
+		t.Error("the volume still mounted is released")
+	}
+	pinfo, err := LoadPersistInfo(res.Data.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range pinfo.VolumeList {
+		if v.Name == "vol1" {
+			t.Error("the detached volume is still persisted")
+		}
+	}
+}
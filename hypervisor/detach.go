@@ -0,0 +1,82 @@
+package hypervisor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseDetachKeys parses a detach key sequence like "ctrl-p,ctrl-q", a key
+// is a single character or ctrl- with one of a-z, @, [, \, ], ^ and _.
+// An empty sequence means the default one, ExitChar.
+func ParseDetachKeys(spec string) ([]byte, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	keys := []byte{}
+	for _, key := range strings.Split(spec, ",") {
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case len(key) == 6 && strings.HasPrefix(strings.ToLower(key), "ctrl-"):
+			c := key[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				keys = append(keys, c-'a'+1)
+			case c >= 'A' && c <= 'Z':
+				keys = append(keys, c-'A'+1)
+			case c == '@' || (c >= '[' && c <= '_'):
+				keys = append(keys, c-'@')
+			default:
+				return nil, fmt.Errorf("Invalid detach key %q", key)
+			}
+		default:
+			return nil, fmt.Errorf("Invalid detach key %q", key)
+		}
+	}
+	return keys, nil
+}
+
+// detachMatcher looks for the detach keys in the input of a client, the
+// input matching the beginning of the keys is held until it turns out not
+// to be the keys. The default key, ExitChar, only detaches when it is read
+// alone, so that it could still be pasted in the input.
+type detachMatcher struct {
+	keys    []byte
+	matched int
+	lone    bool
+}
+
+func newDetachMatcher(keys []byte) *detachMatcher {
+	if len(keys) == 0 {
+		return &detachMatcher{keys: []byte{ExitChar}, lone: true}
+	}
+	return &detachMatcher{keys: keys}
+}
+
+// feed returns the input to pass to the session, and whether the client
+// typed the detach keys
+func (m *detachMatcher) feed(data []byte) ([]byte, bool) {
+	if m.lone {
+		if len(data) == 1 && data[0] == m.keys[0] {
+			return []byte{}, true
+		}
+		return data, false
+	}
+	out := []byte{}
+	for _, c := range data {
+		if c != m.keys[m.matched] && m.matched > 0 {
+			out = append(out, m.keys[:m.matched]...)
+			m.matched = 0
+		}
+		if c != m.keys[m.matched] {
+			out = append(out, c)
+			continue
+		}
+		m.matched++
+		if m.matched == len(m.keys) {
+			m.matched = 0
+			return out, true
+		}
+	}
+	return out, false
+}
@@ -0,0 +1,92 @@
+package hypervisor
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseDetachKeys(t *testing.T) {
+	keys, err := ParseDetachKeys("ctrl-p,ctrl-q")
+	if err != nil || !bytes.Equal(keys, []byte{16, 17}) {
+		t.Errorf("wrong keys of ctrl-p,ctrl-q: %v %v", keys, err)
+	}
+	keys, err = ParseDetachKeys("ctrl-],x")
+	if err != nil || !bytes.Equal(keys, []byte{29, 'x'}) {
+		t.Errorf("wrong keys of ctrl-],x: %v %v", keys, err)
+	}
+	if keys, err := ParseDetachKeys(""); err != nil || keys != nil {
+		t.Errorf("empty keys should be the default one: %v %v", keys, err)
+	}
+	for _, spec := range []string{"ctrl-1", "alt-p", "ctrl-p,,ctrl-q"} {
+		if _, err := ParseDetachKeys(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
+
+func TestDetachMatcher(t *testing.T) {
+	m := newDetachMatcher([]byte{16, 17})
+	// the held ctrl-p is passed once it turns out not to be the keys
+	if out, detached := m.feed([]byte("ls\x10")); detached || string(out) != "ls" {
+		t.Errorf("wrong input %q, detached %v", out, detached)
+	}
+	if out, detached := m.feed([]byte("\x10a")); detached || string(out) != "\x10\x10a" {
+		t.Errorf("wrong input %q, detached %v", out, detached)
+	}
+	// the keys typed across reads
+	m.feed([]byte("\x10"))
+	if out, detached := m.feed([]byte("\x11rest")); !detached || len(out) != 0 {
+		t.Errorf("should detach, got input %q, detached %v", out, detached)
+	}
+
+	m = newDetachMatcher(nil)
+	if out, detached := m.feed([]byte{ExitChar}); !detached || len(out) != 0 {
+		t.Errorf("should detach by ExitChar, got input %q, detached %v", out, detached)
+	}
+	// the default key in a longer read is pasted input
+	if out, detached := m.feed([]byte{'q', ExitChar}); detached || string(out) != "q\x04" {
+		t.Errorf("should not detach by a pasted ExitChar, got input %q, detached %v", out, detached)
+	}
+	// a key the user chose detaches anywhere in the input
+	m = newDetachMatcher([]byte{ExitChar})
+	if out, detached := m.feed([]byte{'q', ExitChar}); !detached || string(out) != "q" {
+		t.Errorf("should detach by the chosen key, got input %q, detached %v", out, detached)
+	}
+}
+
+func TestOutputRing(t *testing.T) {
+	r := newOutputRing(8)
+	r.Write([]byte("abc"))
+	if string(r.Bytes()) != "abc" {
+		t.Errorf("wrong output %q", r.Bytes())
+	}
+	r.Write([]byte("defgh"))
+	r.Write([]byte("ij"))
+	if string(r.Bytes()) != "cdefghij" {
+		t.Errorf("should keep the last bytes, got %q", r.Bytes())
+	}
+	r.Write([]byte("0123456789"))
+	if string(r.Bytes()) != "23456789" {
+		t.Errorf("should keep the last bytes of a long write, got %q", r.Bytes())
+	}
+}
+
+func TestReplayAttach(t *testing.T) {
+	ta := newAttachments(0, true)
+	ta.history.Write([]byte("before attach"))
+
+	out := &bytes.Buffer{}
+	tty := &TtyIO{Stdout: nopWriteCloser{out}, ClientTag: "watcher", ReadOnly: true}
+	ta.replay(tty)
+	ta.attach(tty)
+	if out.String() != "before attach" {
+		t.Errorf("the kept output should be replayed, got %q", out.String())
+	}
+	if !ta.readOnly("watcher") || ta.readOnly("other") {
+		t.Error("wrong read only client")
+	}
+
+	if newAttachments(0, false).history != nil {
+		t.Error("the output of an exec session should not be kept")
+	}
+}
@@ -23,7 +23,7 @@ func TestCreateIf(t *testing.T) {
 	}
 
 	res := make(chan VmEvent, 2)
-	interfaceGot(0, 3, "eth0", true, res, nw)
+	interfaceGot(0, 3, "eth0", "", true, res, nw)
 
 	rsp := <-res
 	if rsp.Event() != EVENT_INTERFACE_ADD {
@@ -227,10 +227,16 @@ func (ctx *VmContext) allocateNetworks() {
 		}
 	}
 
+	infs := podInterfaces(ctx.userSpec)
 	for i, _ := range ctx.progress.adding.networks {
 		name := fmt.Sprintf("eth%d", i)
 		addr := ctx.nextPciAddr()
-		go CreateInterface(i, addr, name, i == 0, ctx.DCtx.BuildinNetwork(), maps, ctx.Hub)
+		if i == 0 {
+			// ports are mapped to the nic with the default route
+			go CreateInterface(i, addr, name, infs[i], true, ctx.DCtx.BuildinNetwork(), maps, ctx.Hub)
+		} else {
+			go CreateInterface(i, addr, name, infs[i], false, ctx.DCtx.BuildinNetwork(), nil, ctx.Hub)
+		}
 	}
 }
 
@@ -454,9 +460,16 @@ func (ctx *VmContext) releaseNetwork() {
 	for idx, nic := range ctx.devices.networkMap {
 		glog.V(1).Infof("remove network card %d: %s", idx, nic.IpAddr)
 		ctx.progress.deleting.networks[idx] = true
-		go ReleaseInterface(idx, nic.IpAddr, nic.Fd, maps, ctx.Hub)
-		maps = nil
+		go ReleaseInterface(idx, nic.Network, nic.IpAddr, nic.Fd, nicPortMaps(idx, maps), ctx.Hub)
+	}
+}
+
+// nicPortMaps returns the port maps of a nic, only the first one has them
+func nicPortMaps(idx int, maps []pod.UserContainerPort) []pod.UserContainerPort {
+	if idx != 0 {
+		return nil
 	}
+	return maps
 }
 
 func (ctx *VmContext) removeInterface() {
@@ -471,8 +484,7 @@ func (ctx *VmContext) removeInterface() {
 	for idx, nic := range ctx.devices.networkMap {
 		glog.V(1).Infof("remove network card %d: %s", idx, nic.IpAddr)
 		ctx.progress.deleting.networks[idx] = true
-		go ReleaseInterface(idx, nic.IpAddr, nic.Fd, maps, ctx.Hub)
+		go ReleaseInterface(idx, nic.Network, nic.IpAddr, nic.Fd, nicPortMaps(idx, maps), ctx.Hub)
 		ctx.DCtx.RemoveNic(ctx, nic.DeviceName, nic.MacAddr, &NetDevRemovedEvent{Index: idx})
-		maps = nil
 	}
 }
@@ -1,6 +1,9 @@
 package hypervisor
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 type BootConfig struct {
 	CPU    int
@@ -9,6 +12,16 @@ type BootConfig struct {
 	Initrd string
 	Bios   string
 	Cbfs   string
+
+	Params []string //kernel parameters appended to the ones of the driver
+
+	BootTimeout int //seconds to wait for the init to be ready, 0 for DefaultBootTimeout
+}
+
+// KernelCmdline returns the kernel command line of the VM, the parameters of
+// the boot config follow the ones the driver relies on.
+func (boot *BootConfig) KernelCmdline(base string) string {
+	return strings.Join(append([]string{base}, boot.Params...), " ")
 }
 
 type HostNicInfo struct {
@@ -52,6 +65,12 @@ type DriverContext interface {
 	Close()
 }
 
+// DiagnosticContext is implemented by the driver contexts which could tell
+// more about a VM failed to boot, e.g. the exit status of the hypervisor
+type DiagnosticContext interface {
+	Diagnostics() string
+}
+
 type EmptyDriver struct{}
 
 type EmptyContext struct{}
@@ -2,6 +2,7 @@ package hypervisor
 
 import (
 	"hyper/pod"
+	"hyper/types"
 	"net"
 	"os"
 	"sync"
@@ -27,6 +28,8 @@ type PodFinished struct {
 
 type VmTimeout struct{}
 
+type VmBootTimeout struct{}
+
 type InitFailedEvent struct {
 	Reason string
 }
@@ -48,9 +51,90 @@ type ExecCommand struct {
 	Container string   `json:"container,omitempty"`
 	Sequence  uint64   `json:"seq"`
 	Command   []string `json:"cmd"`
+	Env       []string `json:"env,omitempty"`
+	Workdir   string   `json:"workdir,omitempty"`
+	User      string   `json:"user,omitempty"`
+	Tty       bool     `json:"tty"`
+	Stderr    uint64   `json:"stderr,omitempty"`
 	Streams   *TtyIO   `json:"-"`
 }
 
+// FileTransferCommand copies a tar archive of Path out of a container, or
+// extracts one into it, through a tty session. The init treats Path like
+// utils.ExtractArchive does, and reports the result with INIT_FINISHCMD.
+type FileTransferCommand struct {
+	Container string `json:"container"`
+	Sequence  uint64 `json:"seq"`
+	Path      string `json:"path"`
+	Direction string `json:"direction"` // "in" or "out"
+	Streams   *TtyIO `json:"-"`
+}
+
+// PortForwardCommand relays a TCP connection to Port on the loopback of
+// the pod through a tty session. The init connects when it gets the command
+// and closes the session when the connection ends. An empty message from
+// the host shuts down the writing side of the connection.
+type PortForwardCommand struct {
+	Sequence uint64 `json:"seq"`
+	Port     int    `json:"port"`
+	Streams  *TtyIO `json:"-"`
+}
+
+// NewContainerCommand adds a container to the running pod. The rootfs
+// described by Info is prepared by the caller, as for RunPodCommand.
+type NewContainerCommand struct {
+	Spec     *pod.UserContainer
+	Info     *ContainerInfo
+	Callback chan *types.QemuResponse
+}
+
+// RemoveContainerCommand stops a container of the running pod and releases
+// its rootfs.
+type RemoveContainerCommand struct {
+	Id       string
+	Callback chan *types.QemuResponse
+}
+
+// AttachVolumeCommand mounts a volume to Path in a container of the running
+// pod. A volume the pod does not have yet is described by Spec, and by Info
+// as for RunPodCommand, and is hot plugged first.
+type AttachVolumeCommand struct {
+	Volume    string
+	Container string
+	Path      string
+	ReadOnly  bool
+	Spec      *pod.UserVolume
+	Info      *VolumeInfo
+	Callback  chan *types.QemuResponse
+}
+
+// DetachVolumeCommand unmounts a volume from a container of the running pod,
+// the volume is removed from the pod once no container mounts it.
+type DetachVolumeCommand struct {
+	Volume    string
+	Container string
+	Callback  chan *types.QemuResponse
+}
+
+// RestartContainerCommand restarts a container of the running pod in place,
+// keeping the network and the volumes of the pod.
+type RestartContainerCommand struct {
+	Id       string
+	Callback chan *types.QemuResponse
+}
+
+// ContainerExited is sent when init reports the exit of a container of the
+// running pod.
+type ContainerExited struct {
+	Id       string
+	ExitCode int
+}
+
+type ExecFinished struct {
+	Seq      uint64
+	ExitCode int
+}
+
 type StopPodCommand struct{}
 type ShutdownCommand struct {
 	Wait bool
@@ -64,8 +148,9 @@ type AttachCommand struct {
 }
 
 type CommandAck struct {
-	reply uint32
-	msg   []byte
+	reply   uint32
+	context *DecodedMessage
+	msg     []byte
 }
 
 type CommandError struct {
@@ -108,6 +193,24 @@ type ContainerUnmounted struct {
 	Success bool
 }
 
+// ContainerReleased is sent once the rootfs of a container removed from a
+// running pod has been detached from the VM and unmounted. Device is the dm
+// device of an ejected image, which still has to be removed.
+type ContainerReleased struct {
+	Id      string
+	Device  string
+	Success bool
+}
+
+// VolumeReleased is sent once a volume no container mounts any more has been
+// detached from the VM, or unbound from the share dir. Device is the dm
+// device of the volume, which still has to be removed.
+type VolumeReleased struct {
+	Name    string
+	Device  string
+	Success bool
+}
+
 type VolumeReadyEvent struct {
 	Name     string //volumen name in spec
 	Filepath string //block dev absolute path, or dir path relative to share dir
@@ -142,6 +245,7 @@ type BlockdevRemovedEvent struct {
 type InterfaceCreated struct {
 	Index      int
 	PCIAddr    int
+	Network    string
 	Fd         *os.File
 	Bridge     string
 	HostDevice string
@@ -181,32 +285,44 @@ type Interrupted struct {
 	Reason string
 }
 
-func (qe *VmStartFailEvent) Event() int      { return EVENT_VM_START_FAILED }
-func (qe *VmExit) Event() int                { return EVENT_VM_EXIT }
-func (qe *VmKilledEvent) Event() int         { return EVENT_VM_KILL }
-func (qe *VmTimeout) Event() int             { return EVENT_VM_TIMEOUT }
-func (qe *PodFinished) Event() int           { return EVENT_POD_FINISH }
-func (qe *InitConnectedEvent) Event() int    { return EVENT_INIT_CONNECTED }
-func (qe *ContainerCreatedEvent) Event() int { return EVENT_CONTAINER_ADD }
-func (qe *ContainerUnmounted) Event() int    { return EVENT_CONTAINER_DELETE }
-func (qe *VolumeUnmounted) Event() int       { return EVENT_BLOCK_EJECTED }
-func (qe *VolumeReadyEvent) Event() int      { return EVENT_VOLUME_ADD }
-func (qe *BlockdevInsertedEvent) Event() int { return EVENT_BLOCK_INSERTED }
-func (qe *BlockdevRemovedEvent) Event() int  { return EVENT_VOLUME_DELETE }
-func (qe *InterfaceCreated) Event() int      { return EVENT_INTERFACE_ADD }
-func (qe *InterfaceReleased) Event() int     { return EVENT_INTERFACE_DELETE }
-func (qe *NetDevInsertedEvent) Event() int   { return EVENT_INTERFACE_INSERTED }
-func (qe *NetDevRemovedEvent) Event() int    { return EVENT_INTERFACE_EJECTED }
-func (qe *RunPodCommand) Event() int         { return COMMAND_RUN_POD }
-func (qe *StopPodCommand) Event() int        { return COMMAND_STOP_POD }
-func (qe *ReplacePodCommand) Event() int     { return COMMAND_REPLACE_POD }
-func (qe *ExecCommand) Event() int           { return COMMAND_EXEC }
-func (qe *AttachCommand) Event() int         { return COMMAND_ATTACH }
-func (qe *WindowSizeCommand) Event() int     { return COMMAND_WINDOWSIZE }
-func (qe *ShutdownCommand) Event() int       { return COMMAND_SHUTDOWN }
-func (qe *ReleaseVMCommand) Event() int      { return COMMAND_RELEASE }
-func (qe *CommandAck) Event() int            { return COMMAND_ACK }
-func (qe *InitFailedEvent) Event() int       { return ERROR_INIT_FAIL }
-func (qe *DeviceFailed) Event() int          { return ERROR_QMP_FAIL }
-func (qe *Interrupted) Event() int           { return ERROR_INTERRUPTED }
-func (qe *CommandError) Event() int          { return ERROR_CMD_FAIL }
+func (qe *VmStartFailEvent) Event() int        { return EVENT_VM_START_FAILED }
+func (qe *VmExit) Event() int                  { return EVENT_VM_EXIT }
+func (qe *VmKilledEvent) Event() int           { return EVENT_VM_KILL }
+func (qe *VmTimeout) Event() int               { return EVENT_VM_TIMEOUT }
+func (qe *VmBootTimeout) Event() int           { return EVENT_VM_BOOT_TIMEOUT }
+func (qe *PodFinished) Event() int             { return EVENT_POD_FINISH }
+func (qe *InitConnectedEvent) Event() int      { return EVENT_INIT_CONNECTED }
+func (qe *ExecFinished) Event() int            { return EVENT_EXEC_FINISH }
+func (qe *ContainerCreatedEvent) Event() int   { return EVENT_CONTAINER_ADD }
+func (qe *ContainerUnmounted) Event() int      { return EVENT_CONTAINER_DELETE }
+func (qe *ContainerReleased) Event() int       { return EVENT_CONTAINER_RELEASED }
+func (qe *VolumeReleased) Event() int          { return EVENT_VOLUME_RELEASED }
+func (qe *ContainerExited) Event() int         { return EVENT_CONTAINER_EXIT }
+func (qe *VolumeUnmounted) Event() int         { return EVENT_BLOCK_EJECTED }
+func (qe *VolumeReadyEvent) Event() int        { return EVENT_VOLUME_ADD }
+func (qe *BlockdevInsertedEvent) Event() int   { return EVENT_BLOCK_INSERTED }
+func (qe *BlockdevRemovedEvent) Event() int    { return EVENT_VOLUME_DELETE }
+func (qe *InterfaceCreated) Event() int        { return EVENT_INTERFACE_ADD }
+func (qe *InterfaceReleased) Event() int       { return EVENT_INTERFACE_DELETE }
+func (qe *NetDevInsertedEvent) Event() int     { return EVENT_INTERFACE_INSERTED }
+func (qe *NetDevRemovedEvent) Event() int      { return EVENT_INTERFACE_EJECTED }
+func (qe *RunPodCommand) Event() int           { return COMMAND_RUN_POD }
+func (qe *StopPodCommand) Event() int          { return COMMAND_STOP_POD }
+func (qe *ReplacePodCommand) Event() int       { return COMMAND_REPLACE_POD }
+func (qe *ExecCommand) Event() int             { return COMMAND_EXEC }
+func (qe *AttachCommand) Event() int           { return COMMAND_ATTACH }
+func (qe *FileTransferCommand) Event() int     { return COMMAND_FILE_TRANSFER }
+func (qe *PortForwardCommand) Event() int      { return COMMAND_PORT_FORWARD }
+func (qe *NewContainerCommand) Event() int     { return COMMAND_NEW_CONTAINER }
+func (qe *RemoveContainerCommand) Event() int  { return COMMAND_REMOVE_CONTAINER }
+func (qe *AttachVolumeCommand) Event() int     { return COMMAND_ATTACH_VOLUME }
+func (qe *DetachVolumeCommand) Event() int     { return COMMAND_DETACH_VOLUME }
+func (qe *RestartContainerCommand) Event() int { return COMMAND_RESTART_CONTAINER }
+func (qe *WindowSizeCommand) Event() int       { return COMMAND_WINDOWSIZE }
+func (qe *ShutdownCommand) Event() int         { return COMMAND_SHUTDOWN }
+func (qe *ReleaseVMCommand) Event() int        { return COMMAND_RELEASE }
+func (qe *CommandAck) Event() int              { return COMMAND_ACK }
+func (qe *InitFailedEvent) Event() int         { return ERROR_INIT_FAIL }
+func (qe *DeviceFailed) Event() int            { return ERROR_QMP_FAIL }
+func (qe *Interrupted) Event() int             { return ERROR_INTERRUPTED }
+func (qe *CommandError) Event() int            { return ERROR_CMD_FAIL }
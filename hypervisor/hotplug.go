@@ -0,0 +1,607 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/pod"
+	"hyper/types"
+	"strings"
+)
+
+type hotplugRequest struct {
+	callback chan *types.QemuResponse
+	cause    string // set when a failed addition is being rolled back
+}
+
+type containerTarget struct {
+	Container string `json:"container"`
+}
+
+// newContainerCmd appends a container to the spec of the running pod. Init
+// is asked to start it as soon as its rootfs is in place, that is at once
+// for a directory and after the insertion for a block device.
+func (ctx *VmContext) newContainerCmd(cmd *NewContainerCommand) {
+	cause := ""
+	if !ctx.InitHasCapability(INIT_CAP_HOTPLUG) {
+		cause = "adding containers is not supported by the init of the vm"
+	} else if ctx.Lookup(cmd.Info.Id) >= 0 {
+		cause = fmt.Sprintf("container %s is already in the pod", cmd.Info.Id)
+	} else {
+		for _, v := range cmd.Spec.Volumes {
+			if _, ok := ctx.devices.volumeMap[v.Volume]; !ok {
+				cause = fmt.Sprintf("volume %s is not defined in the pod", v.Volume)
+				break
+			}
+		}
+	}
+	if cause != "" {
+		cmd.Callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  types.E_BAD_REQUEST,
+			Cause: cause,
+		}
+		return
+	}
+
+	ctx.lock.Lock()
+	idx := len(ctx.vmSpec.Containers)
+	container := VmContainer{}
+	ctx.initContainerInfo(idx, &container, cmd.Spec)
+	ctx.setContainerInfo(idx, &container, cmd.Info)
+
+	// the volumes of the pod are already in place
+	for _, v := range cmd.Spec.Volumes {
+		vol := ctx.devices.volumeMap[v.Volume]
+		if vol.info.fstype == "" && vol.info.filename != "" {
+			container.Fsmap = append(container.Fsmap, VmFsmapDescriptor{
+				Source:   vol.info.filename,
+				Path:     v.Path,
+				ReadOnly: v.ReadOnly,
+			})
+		} else if vol.info.deviceName != "" {
+			container.Volumes = append(container.Volumes, VmVolumeDescriptor{
+				Device:   vol.info.deviceName,
+				Mount:    v.Path,
+				Fstype:   vol.info.fstype,
+				ReadOnly: v.ReadOnly,
+			})
+		}
+	}
+
+	if ctx.userSpec.Tty {
+		container.Tty = ctx.attachId
+		ctx.attachId++
+		ctx.ptys.ttys[container.Tty] = newAttachments(idx, true)
+	} else {
+		container.Stdio = ctx.attachId
+		container.Stderr = ctx.attachId + 1
+		ctx.attachId += 2
+		ctx.ptys.stdioSessions(idx, true, container.Stdio, container.Stderr)
+	}
+
+	ctx.vmSpec.Containers = append(ctx.vmSpec.Containers, container)
+	ctx.userSpec.Containers = append(ctx.userSpec.Containers, *cmd.Spec)
+	ctx.hotplug[container.Id] = &hotplugRequest{callback: cmd.Callback}
+	ctx.lock.Unlock()
+
+	if container.Fstype == "" {
+		ctx.startContainer(idx)
+		return
+	}
+	image := ctx.devices.imageMap[cmd.Info.Image]
+	glog.V(1).Infof("insert image %s of container %s", image.info.filename, container.Id)
+	ctx.DCtx.AddDisk(ctx, image.info.name, "image", image.info.filename, image.info.format, ctx.nextScsiId())
+}
+
+func (ctx *VmContext) startContainer(idx int) {
+	msg, err := json.Marshal(ctx.vmSpec.Containers[idx])
+	if err != nil {
+		id := ctx.vmSpec.Containers[idx].Id
+		ctx.releaseContainer(id, "Generated wrong container profile "+err.Error())
+		return
+	}
+	ctx.vm <- &DecodedMessage{
+		code:    INIT_NEWCONTAINER,
+		message: msg,
+	}
+}
+
+// hotplugInserted starts the container whose image, or mounts the volume
+// which, has been hot plugged.
+func (ctx *VmContext) hotplugInserted(info *BlockdevInsertedEvent) {
+	ctx.blockdevInserted(info)
+	if image, ok := ctx.devices.imageMap[info.Name]; ok && info.SourceType == "image" {
+		ctx.startContainer(image.pos)
+	} else if info.SourceType == "volume" {
+		ctx.mountVolume(info.Name)
+	}
+}
+
+// removeContainerCmd asks init to stop and forget a container, the rootfs is
+// released once init acknowledged it.
+func (ctx *VmContext) removeContainerCmd(cmd *RemoveContainerCommand) {
+	cause := ""
+	if !ctx.InitHasCapability(INIT_CAP_HOTPLUG) {
+		cause = "removing containers is not supported by the init of the vm"
+	} else if ctx.Lookup(cmd.Id) < 0 {
+		cause = fmt.Sprintf("can not find container %s", cmd.Id)
+	} else if _, ok := ctx.hotplug[cmd.Id]; ok {
+		cause = fmt.Sprintf("container %s is being added or removed", cmd.Id)
+	} else if len(ctx.vmSpec.Containers) == 1 {
+		cause = "can not remove the last container of the pod"
+	}
+	msg, err := json.Marshal(&containerTarget{Container: cmd.Id})
+	if cause == "" && err != nil {
+		cause = fmt.Sprintf("command remove %s parse failed", cmd.Id)
+	}
+	if cause != "" {
+		cmd.Callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  types.E_BAD_REQUEST,
+			Cause: cause,
+		}
+		return
+	}
+
+	ctx.lock.Lock()
+	ctx.hotplug[cmd.Id] = &hotplugRequest{callback: cmd.Callback}
+	ctx.lock.Unlock()
+	ctx.vm <- &DecodedMessage{
+		code:    INIT_REMOVECONTAINER,
+		message: msg,
+	}
+}
+
+// hotplugAcked handles the reply of init to INIT_NEWCONTAINER and
+// INIT_REMOVECONTAINER, message is the one the host sent.
+func (ctx *VmContext) hotplugAcked(code uint32, message []byte, success bool) {
+	target := &containerTarget{}
+	if code == INIT_NEWCONTAINER {
+		c := &VmContainer{}
+		json.Unmarshal(message, c)
+		target.Container = c.Id
+	} else {
+		json.Unmarshal(message, target)
+	}
+
+	switch {
+	case code == INIT_NEWCONTAINER && success:
+		glog.Infof("container %s added", target.Container)
+		ctx.reportHotplug(target.Container, "")
+	case code == INIT_NEWCONTAINER:
+		ctx.releaseContainer(target.Container, "Start container failed")
+	case success:
+		glog.Infof("container %s stopped, release its rootfs", target.Container)
+		ctx.releaseContainer(target.Container, "")
+	default:
+		ctx.reportHotplug(target.Container, "Stop container failed")
+	}
+}
+
+// releaseContainer drops a container from the spec of the running pod, then
+// ejects its image or unmounts its directory. With a cause, the addition of
+// the container is being rolled back and reported as failed.
+func (ctx *VmContext) releaseContainer(id, cause string) {
+	idx := ctx.Lookup(id)
+	if idx < 0 {
+		ctx.reportHotplug(id, fmt.Sprintf("can not find container %s", id))
+		return
+	}
+
+	ctx.lock.Lock()
+	if req, ok := ctx.hotplug[id]; ok {
+		req.cause = cause
+	}
+	container := ctx.vmSpec.Containers[idx]
+	var image *imageInfo
+	for name, img := range ctx.devices.imageMap {
+		if img.pos == idx {
+			image = img
+			delete(ctx.devices.imageMap, name)
+			delete(ctx.progress.adding.blockdevs, name)
+		}
+	}
+	ctx.dropContainer(idx)
+	if st, ok := ctx.containers[id]; ok && st.timer != nil {
+		st.timer.Stop()
+	}
+	delete(ctx.containers, id)
+	ctx.lock.Unlock()
+
+	for _, session := range []uint64{container.Tty, container.Stdio} {
+		if session != 0 {
+			ctx.ptys.Close(ctx, session)
+		}
+	}
+
+	if image == nil {
+		glog.V(1).Info("need unmount container dir ", container.Image)
+		go func() {
+			ctx.Hub <- &ContainerReleased{Id: id, Success: UmountContainerDir(ctx.ShareDir, container.Image)}
+		}()
+	} else if image.info.deviceName == "" {
+		// never inserted
+		ctx.containerReleased(&ContainerReleased{Id: id, Device: image.info.filename, Success: true})
+	} else {
+		glog.V(1).Infof("need eject image block device %s of container %s", image.info.deviceName, id)
+		ctx.DCtx.RemoveDisk(ctx, image.info.filename, image.info.format, image.info.scsiId,
+			&ContainerReleased{Id: id, Device: image.info.filename, Success: true})
+	}
+}
+
+// dropContainer removes the container at idx from the specs and shifts the
+// device positions of the following ones, the caller holds the lock.
+func (ctx *VmContext) dropContainer(idx int) {
+	ctx.vmSpec.Containers = append(ctx.vmSpec.Containers[:idx], ctx.vmSpec.Containers[idx+1:]...)
+	if idx < len(ctx.userSpec.Containers) {
+		ctx.userSpec.Containers = append(ctx.userSpec.Containers[:idx], ctx.userSpec.Containers[idx+1:]...)
+	}
+
+	for _, img := range ctx.devices.imageMap {
+		if img.pos > idx {
+			img.pos--
+		}
+	}
+	for _, vol := range ctx.devices.volumeMap {
+		pos := make(map[int]string)
+		ro := make(map[int]bool)
+		for i, mp := range vol.pos {
+			if i == idx {
+				continue
+			} else if i > idx {
+				pos[i-1], ro[i-1] = mp, vol.readOnly[i]
+			} else {
+				pos[i], ro[i] = mp, vol.readOnly[i]
+			}
+		}
+		vol.pos, vol.readOnly = pos, ro
+	}
+}
+
+// containerReleased removes the dm device of an ejected image, and reports
+// the end of the removal to the caller.
+func (ctx *VmContext) containerReleased(ev *ContainerReleased) {
+	if ev.Device != "" {
+		go func() {
+			done := make(chan VmEvent, 1)
+			UmountDMDevice(ev.Device, ev.Id, done)
+			ctx.Hub <- &ContainerReleased{Id: ev.Id, Success: (<-done).(*BlockdevRemovedEvent).Success}
+		}()
+		return
+	}
+
+	cause := ""
+	if req, ok := ctx.hotplug[ev.Id]; ok {
+		cause = req.cause
+	}
+	if cause == "" && !ev.Success {
+		cause = "fail to release the rootfs of container " + ev.Id
+	}
+	ctx.reportHotplug(ev.Id, cause)
+}
+
+// reportHotplug answers the caller of a container addition or removal.
+func (ctx *VmContext) reportHotplug(id, cause string) {
+	ctx.lock.Lock()
+	req, ok := ctx.hotplug[id]
+	delete(ctx.hotplug, id)
+	ctx.lock.Unlock()
+	if ok {
+		ctx.replyHotplug(req.callback, cause)
+	}
+}
+
+// replyHotplug answers the caller of a change of the running pod, with the
+// updated persist info of the VM on success.
+func (ctx *VmContext) replyHotplug(callback chan *types.QemuResponse, cause string) {
+	if cause != "" {
+		glog.Error(cause)
+		callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  types.E_FAILED,
+			Cause: cause,
+		}
+		return
+	}
+
+	var pinfo []byte = []byte{}
+	persist, err := ctx.dump()
+	if err == nil {
+		buf, err := persist.serialize()
+		if err == nil {
+			pinfo = buf
+		}
+	}
+	callback <- &types.QemuResponse{
+		VmId: ctx.Id,
+		Code: types.E_OK,
+		Data: pinfo,
+	}
+}
+
+type volumeRequest struct {
+	container string
+	path      string
+	readOnly  bool
+	callback  chan *types.QemuResponse
+}
+
+// volumeMount is the message of INIT_ATTACHVOLUME and INIT_DETACHVOLUME,
+// with the descriptor of the mount to add to or remove from the container.
+type volumeMount struct {
+	Name      string              `json:"name"`
+	Container string              `json:"container"`
+	Volume    *VmVolumeDescriptor `json:"volume,omitempty"`
+	Fsmap     *VmFsmapDescriptor  `json:"fsmap,omitempty"`
+}
+
+// attachVolumeCmd mounts a volume in a container of the running pod. A new
+// volume is added to the pod first, and mounted once inserted if it is a
+// block device.
+func (ctx *VmContext) attachVolumeCmd(cmd *AttachVolumeCommand) {
+	cause := ""
+	idx := ctx.Lookup(cmd.Container)
+	vol, exist := ctx.devices.volumeMap[cmd.Volume]
+	if !ctx.InitHasCapability(INIT_CAP_VOLUME) {
+		cause = "attaching volumes is not supported by the init of the vm"
+	} else if idx < 0 {
+		cause = fmt.Sprintf("can not find container %s", cmd.Container)
+	} else if _, ok := ctx.volumeOps[cmd.Volume]; ok {
+		cause = fmt.Sprintf("volume %s is being attached or detached", cmd.Volume)
+	} else if !exist && cmd.Spec == nil {
+		cause = fmt.Sprintf("volume %s is not defined in the pod", cmd.Volume)
+	} else if exist && vol.pos[idx] != "" {
+		cause = fmt.Sprintf("volume %s is already mounted to %s", cmd.Volume, vol.pos[idx])
+	} else if ctx.vmSpec.Containers[idx].volLookup(cmd.Path) != nil ||
+		ctx.vmSpec.Containers[idx].mapLookup(cmd.Path) != nil {
+		cause = fmt.Sprintf("%s is already a mount point of %s", cmd.Path, cmd.Container)
+	}
+	if cause != "" {
+		cmd.Callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  types.E_BAD_REQUEST,
+			Cause: cause,
+		}
+		return
+	}
+
+	ctx.lock.Lock()
+	ctx.volumeOps[cmd.Volume] = &volumeRequest{
+		container: cmd.Container,
+		path:      cmd.Path,
+		readOnly:  cmd.ReadOnly,
+		callback:  cmd.Callback,
+	}
+	if !exist {
+		ctx.initVolumeMap(&pod.UserPod{Volumes: []pod.UserVolume{*cmd.Spec}})
+		ctx.userSpec.Volumes = append(ctx.userSpec.Volumes, *cmd.Spec)
+	}
+	ctx.lock.Unlock()
+
+	if !exist {
+		if cmd.Info != nil {
+			ctx.setVolumeInfo(cmd.Info)
+		}
+		if vol, ok := ctx.devices.volumeMap[cmd.Volume]; ok && ctx.progress.adding.blockdevs[cmd.Volume] {
+			glog.V(1).Infof("insert volume %s (%s)", cmd.Volume, vol.info.filename)
+			ctx.DCtx.AddDisk(ctx, vol.info.name, "volume", vol.info.filename, vol.info.format, ctx.nextScsiId())
+			return
+		}
+	}
+	ctx.mountVolume(cmd.Volume)
+}
+
+func (ctx *VmContext) mountVolume(name string) {
+	req, ok := ctx.volumeOps[name]
+	if !ok {
+		return
+	}
+	vol, ok := ctx.devices.volumeMap[name]
+	if !ok || (vol.info.fstype == "" && vol.info.filename == "") {
+		ctx.reportVolume(name, fmt.Sprintf("volume %s is not available in the vm", name))
+		return
+	}
+
+	m := &volumeMount{Name: name, Container: req.container}
+	if vol.info.fstype == "" {
+		m.Fsmap = &VmFsmapDescriptor{
+			Source:   vol.info.filename,
+			Path:     req.path,
+			ReadOnly: req.readOnly,
+		}
+	} else {
+		m.Volume = &VmVolumeDescriptor{
+			Device:   vol.info.deviceName,
+			Mount:    req.path,
+			Fstype:   vol.info.fstype,
+			ReadOnly: req.readOnly,
+		}
+	}
+	ctx.sendVolumeMount(INIT_ATTACHVOLUME, m)
+}
+
+// detachVolumeCmd unmounts a volume from a container of the running pod. A
+// volume no container mounts any more is then released, see volumeAcked.
+func (ctx *VmContext) detachVolumeCmd(cmd *DetachVolumeCommand) {
+	cause := ""
+	idx := ctx.Lookup(cmd.Container)
+	vol, exist := ctx.devices.volumeMap[cmd.Volume]
+	if !ctx.InitHasCapability(INIT_CAP_VOLUME) {
+		cause = "detaching volumes is not supported by the init of the vm"
+	} else if idx < 0 {
+		cause = fmt.Sprintf("can not find container %s", cmd.Container)
+	} else if !exist || vol.pos[idx] == "" {
+		cause = fmt.Sprintf("volume %s is not mounted in container %s", cmd.Volume, cmd.Container)
+	} else if _, ok := ctx.volumeOps[cmd.Volume]; ok {
+		cause = fmt.Sprintf("volume %s is being attached or detached", cmd.Volume)
+	}
+	if cause != "" {
+		cmd.Callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  types.E_BAD_REQUEST,
+			Cause: cause,
+		}
+		return
+	}
+
+	container := &ctx.vmSpec.Containers[idx]
+	m := &volumeMount{
+		Name:      cmd.Volume,
+		Container: cmd.Container,
+		Volume:    container.volLookup(vol.pos[idx]),
+		Fsmap:     container.mapLookup(vol.pos[idx]),
+	}
+	ctx.lock.Lock()
+	ctx.volumeOps[cmd.Volume] = &volumeRequest{
+		container: cmd.Container,
+		path:      vol.pos[idx],
+		callback:  cmd.Callback,
+	}
+	ctx.lock.Unlock()
+	ctx.sendVolumeMount(INIT_DETACHVOLUME, m)
+}
+
+func (ctx *VmContext) sendVolumeMount(code uint32, m *volumeMount) {
+	msg, err := json.Marshal(m)
+	if err != nil {
+		ctx.reportVolume(m.Name, fmt.Sprintf("command mount %s parse failed", m.Name))
+		return
+	}
+	ctx.vm <- &DecodedMessage{
+		code:    code,
+		message: msg,
+	}
+}
+
+// volumeAcked records the mount, or its removal, in the specs once init
+// has done it. The last unmount of a volume removes it from the pod.
+func (ctx *VmContext) volumeAcked(code uint32, message []byte, success bool) {
+	m := &volumeMount{}
+	json.Unmarshal(message, m)
+	if !success {
+		ctx.reportVolume(m.Name, fmt.Sprintf("init failed to mount or unmount volume %s", m.Name))
+		return
+	}
+
+	idx := ctx.Lookup(m.Container)
+	vol, ok := ctx.devices.volumeMap[m.Name]
+	if idx < 0 || !ok {
+		ctx.reportVolume(m.Name, fmt.Sprintf("container %s or volume %s is gone", m.Container, m.Name))
+		return
+	}
+
+	ctx.lock.Lock()
+	container := &ctx.vmSpec.Containers[idx]
+	spec := &ctx.userSpec.Containers[idx]
+	if code == INIT_ATTACHVOLUME {
+		if m.Volume != nil {
+			container.Volumes = append(container.Volumes, *m.Volume)
+			vol.pos[idx], vol.readOnly[idx] = m.Volume.Mount, m.Volume.ReadOnly
+		} else if m.Fsmap != nil {
+			container.Fsmap = append(container.Fsmap, *m.Fsmap)
+			vol.pos[idx], vol.readOnly[idx] = m.Fsmap.Path, m.Fsmap.ReadOnly
+		}
+		spec.Volumes = append(spec.Volumes, pod.UserVolumeReference{
+			Path:     vol.pos[idx],
+			Volume:   m.Name,
+			ReadOnly: vol.readOnly[idx],
+		})
+	} else {
+		mount := vol.pos[idx]
+		for i, v := range container.Volumes {
+			if v.Mount == mount {
+				container.Volumes = append(container.Volumes[:i], container.Volumes[i+1:]...)
+				break
+			}
+		}
+		for i, f := range container.Fsmap {
+			if f.Path == mount {
+				container.Fsmap = append(container.Fsmap[:i], container.Fsmap[i+1:]...)
+				break
+			}
+		}
+		for i, v := range spec.Volumes {
+			if v.Volume == m.Name {
+				spec.Volumes = append(spec.Volumes[:i], spec.Volumes[i+1:]...)
+				break
+			}
+		}
+		delete(vol.pos, idx)
+		delete(vol.readOnly, idx)
+		if len(vol.pos) == 0 {
+			delete(ctx.devices.volumeMap, m.Name)
+			delete(ctx.progress.adding.blockdevs, m.Name)
+			for i, v := range ctx.userSpec.Volumes {
+				if v.Name == m.Name {
+					ctx.userSpec.Volumes = append(ctx.userSpec.Volumes[:i], ctx.userSpec.Volumes[i+1:]...)
+					break
+				}
+			}
+			ctx.lock.Unlock()
+			glog.Infof("volume %s detached from container %s, release it", m.Name, m.Container)
+			ctx.releaseVolume(m.Name, vol)
+			return
+		}
+	}
+	ctx.lock.Unlock()
+
+	glog.Infof("volume %s attached or detached in container %s", m.Name, m.Container)
+	ctx.reportVolume(m.Name, "")
+}
+
+// releaseVolume ejects the device of a volume removed from the running pod,
+// or unbinds its dir from the share dir. The detachment is reported once
+// the volume is released.
+func (ctx *VmContext) releaseVolume(name string, vol *volumeInfo) {
+	device := ""
+	if strings.HasPrefix(vol.info.filename, "/dev/mapper/") {
+		device = vol.info.filename
+	}
+	switch {
+	case vol.info.fstype == "" && vol.info.filename != "":
+		glog.V(1).Info("need umount dir ", vol.info.filename)
+		go func() {
+			done := make(chan VmEvent, 1)
+			UmountVolume(ctx.ShareDir, vol.info.filename, name, done)
+			ctx.Hub <- &VolumeReleased{Name: name, Success: (<-done).(*VolumeUnmounted).Success}
+		}()
+	case vol.info.deviceName == "":
+		// never inserted, or not a device
+		ctx.volumeReleased(&VolumeReleased{Name: name, Device: device, Success: true})
+	default:
+		glog.V(1).Infof("need eject volume block device %s (%s)", name, vol.info.deviceName)
+		ctx.DCtx.RemoveDisk(ctx, vol.info.filename, vol.info.format, vol.info.scsiId,
+			&VolumeReleased{Name: name, Device: device, Success: true})
+	}
+}
+
+// volumeReleased removes the dm device of an ejected volume, and reports the
+// end of the detachment to the caller.
+func (ctx *VmContext) volumeReleased(ev *VolumeReleased) {
+	if ev.Device != "" {
+		go func() {
+			done := make(chan VmEvent, 1)
+			UmountDMDevice(ev.Device, ev.Name, done)
+			ctx.Hub <- &VolumeReleased{Name: ev.Name, Success: (<-done).(*BlockdevRemovedEvent).Success}
+		}()
+		return
+	}
+
+	cause := ""
+	if !ev.Success {
+		cause = "fail to release volume " + ev.Name
+	}
+	ctx.reportVolume(ev.Name, cause)
+}
+
+// reportVolume answers the caller of a volume attachment or detachment.
+func (ctx *VmContext) reportVolume(name, cause string) {
+	ctx.lock.Lock()
+	req, ok := ctx.volumeOps[name]
+	delete(ctx.volumeOps, name)
+	ctx.lock.Unlock()
+	if ok {
+		ctx.replyHotplug(req.callback, cause)
+	}
+}
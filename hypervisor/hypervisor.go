@@ -17,6 +17,7 @@ func (ctx *VmContext) loop() {
 			continue
 		}
 		glog.V(1).Infof("main event loop got message %d(%s)", ev.Event(), EventString(ev.Event()))
+		ctx.traceEvent(ev)
 		ctx.handler(ctx, ev)
 	}
 }
@@ -34,11 +35,10 @@ func VmLoop(driver HypervisorDriver, vmId string, hub chan VmEvent, client chan
 	}
 
 	//launch routines
+	context.watchBoot()
 	go waitInitReady(context)
 	go waitPts(context)
-	if glog.V(1) {
-		go waitConsoleOutput(context)
-	}
+	go waitConsoleOutput(context)
 	context.DCtx.Launch(context)
 
 	context.loop()
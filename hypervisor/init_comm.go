@@ -2,6 +2,7 @@ package hypervisor
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"hyper/lib/glog"
 	"hyper/lib/telnet"
@@ -12,11 +13,21 @@ import (
 // Message
 type DecodedMessage struct {
 	code    uint32
+	seq     uint32 // only meaningful with versioned framing
 	message []byte
 }
 
+// InitHandshake is the payload of INIT_READY. The guest announces the
+// highest protocol version it speaks and what it can do, the host answers
+// with the version both sides will use from then on.
+type InitHandshake struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
 type FinishCmd struct {
-	Seq uint64 `json:"seq"`
+	Seq      uint64 `json:"seq"`
+	ExitCode int    `json:"code"`
 }
 
 func waitConsoleOutput(ctx *VmContext) {
@@ -42,6 +53,9 @@ func waitConsoleOutput(ctx *VmContext) {
 	for {
 		line, ok := <-cout
 		if ok {
+			if ctx.boot != nil {
+				ctx.boot.addConsole(line)
+			}
 			glog.V(1).Info("[console] ", line)
 		} else {
 			glog.Info("console output end")
@@ -50,17 +64,29 @@ func waitConsoleOutput(ctx *VmContext) {
 	}
 }
 
-func newVmMessage(m *DecodedMessage) []byte {
-	length := len(m.message) + 8
+func headerSize(version int) int {
+	if version >= INIT_PROTOCOL_V1 {
+		return 12
+	}
+	return 8
+}
+
+func newVmMessage(m *DecodedMessage, version int) []byte {
+	hlen := headerSize(version)
+	length := len(m.message) + hlen
 	msg := make([]byte, length)
 	binary.BigEndian.PutUint32(msg[:], uint32(m.code))
 	binary.BigEndian.PutUint32(msg[4:], uint32(length))
-	copy(msg[8:], m.message)
+	if hlen > 8 {
+		binary.BigEndian.PutUint32(msg[8:], m.seq)
+	}
+	copy(msg[hlen:], m.message)
 	return msg
 }
 
-func readVmMessage(conn *net.UnixConn) (*DecodedMessage, error) {
-	needRead := 8
+func readVmMessage(conn *net.UnixConn, version int) (*DecodedMessage, error) {
+	hlen := headerSize(version)
+	needRead := hlen
 	length := 0
 	read := 0
 	buf := make([]byte, 512)
@@ -82,19 +108,55 @@ func readVmMessage(conn *net.UnixConn) (*DecodedMessage, error) {
 
 		glog.V(1).Infof("read %d/%d [length = %d]", read, needRead, length)
 
-		if length == 0 && read >= 8 {
+		if length == 0 && read >= hlen {
 			length = int(binary.BigEndian.Uint32(res[4:8]))
 			glog.V(1).Infof("data length is %d", length)
-			if length > 8 {
+			if length > hlen {
 				needRead = length
 			}
 		}
 	}
 
-	return &DecodedMessage{
+	msg := &DecodedMessage{
 		code:    binary.BigEndian.Uint32(res[:4]),
-		message: res[8:],
-	}, nil
+		message: res[hlen:],
+	}
+	if hlen > 8 {
+		msg.seq = binary.BigEndian.Uint32(res[8:12])
+	}
+	return msg, nil
+}
+
+// negotiateInit parses the INIT_READY payload. An empty or unparsable
+// payload comes from a legacy init, which keeps the original framing. For
+// a versioned init the agreed version is written back, still in the legacy
+// framing, before both sides switch to the sequenced header.
+func negotiateInit(conn *net.UnixConn, payload []byte) (int, []string, error) {
+	if len(payload) == 0 {
+		return INIT_PROTOCOL_LEGACY, nil, nil
+	}
+
+	hs := &InitHandshake{}
+	if err := json.Unmarshal(payload, hs); err != nil || hs.Version <= INIT_PROTOCOL_LEGACY {
+		glog.Warningf("cannot understand init ready payload '%s', use legacy protocol", string(payload))
+		return INIT_PROTOCOL_LEGACY, nil, nil
+	}
+
+	version := hs.Version
+	if version > INIT_PROTOCOL_VERSION {
+		version = INIT_PROTOCOL_VERSION
+	}
+
+	reply, err := json.Marshal(&InitHandshake{Version: version})
+	if err != nil {
+		return INIT_PROTOCOL_LEGACY, nil, err
+	}
+	_, err = conn.Write(newVmMessage(&DecodedMessage{code: INIT_READY, message: reply}, INIT_PROTOCOL_LEGACY))
+	if err != nil {
+		return INIT_PROTOCOL_LEGACY, nil, err
+	}
+
+	return version, hs.Capabilities, nil
 }
 
 func waitInitReady(ctx *VmContext) {
@@ -109,7 +171,7 @@ func waitInitReady(ctx *VmContext) {
 
 	glog.Info("Wating for init messages...")
 
-	msg, err := readVmMessage(conn.(*net.UnixConn))
+	msg, err := readVmMessage(conn.(*net.UnixConn), INIT_PROTOCOL_LEGACY)
 	if err != nil {
 		glog.Error("read init message failed... ", err.Error())
 		ctx.Hub <- &InitFailedEvent{
@@ -118,6 +180,17 @@ func waitInitReady(ctx *VmContext) {
 		conn.Close()
 	} else if msg.code == INIT_READY {
 		glog.Info("Get init ready message")
+		version, caps, err := negotiateInit(conn.(*net.UnixConn), msg.message)
+		if err != nil {
+			glog.Error("init protocol handshake failed ", err.Error())
+			ctx.Hub <- &InitFailedEvent{
+				Reason: "init protocol handshake failed " + err.Error(),
+			}
+			conn.Close()
+			return
+		}
+		glog.Infof("init protocol version %d, capabilities %v", version, caps)
+		ctx.setInitProtocol(version, caps)
 		ctx.Hub <- &InitConnectedEvent{conn: conn.(*net.UnixConn)}
 		go waitCmdToInit(ctx, conn.(*net.UnixConn))
 	} else {
@@ -142,14 +215,33 @@ func connectToInit(ctx *VmContext) {
 	go waitCmdToInit(ctx, conn.(*net.UnixConn))
 }
 
+// pendingIndex finds the command a reply belongs to. Legacy inits reply
+// strictly in order, versioned inits echo the sequence of the command.
+func pendingIndex(cmds []*DecodedMessage, reply *DecodedMessage, version int) int {
+	if len(cmds) == 0 {
+		return -1
+	}
+	if version < INIT_PROTOCOL_V1 {
+		return 0
+	}
+	for i, c := range cmds {
+		if c.seq == reply.seq {
+			return i
+		}
+	}
+	return -1
+}
+
 func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 	looping := true
 	cmds := []*DecodedMessage{}
+	version := ctx.initProtocol()
+	var seq uint32 = 0
 
 	var pingTimer *time.Timer = nil
 	var pongTimer *time.Timer = nil
 
-	go waitInitAck(ctx, init)
+	go waitInitAck(ctx, init, version)
 
 	for looping {
 		cmd, ok := <-ctx.vm
@@ -158,25 +250,27 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 			break
 		}
 		if cmd.code == INIT_ACK || cmd.code == INIT_ERROR {
-			if len(cmds) > 0 {
-				if cmds[0].code == INIT_DESTROYPOD {
+			if idx := pendingIndex(cmds, cmd, version); idx >= 0 {
+				origin := cmds[idx]
+				if origin.code == INIT_DESTROYPOD {
 					glog.Info("got response of shutdown command, last round of command to init")
 					looping = false
 				}
 				if cmd.code == INIT_ACK {
-					if cmds[0].code != INIT_PING {
+					if origin.code != INIT_PING {
 						ctx.Hub <- &CommandAck{
-							reply: cmds[0].code,
-							msg:   cmd.message,
+							reply:   origin.code,
+							context: origin,
+							msg:     cmd.message,
 						}
 					}
 				} else {
 					ctx.Hub <- &CommandError{
-						context: cmds[0],
+						context: origin,
 						msg:     cmd.message,
 					}
 				}
-				cmds = cmds[1:]
+				cmds = append(cmds[:idx], cmds[idx+1:]...)
 
 				if pongTimer != nil {
 					glog.V(1).Info("ack got, clear pong timer")
@@ -197,7 +291,7 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 					pingTimer.Reset(30 * time.Second)
 				}
 			} else {
-				glog.Error("got ack but no command in queue")
+				glog.Errorf("got ack (seq %d) but no matched command in queue", cmd.seq)
 			}
 		} else if cmd.code == INIT_FINISHPOD {
 			num := len(cmd.message) / 4
@@ -206,11 +300,11 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 				results[i] = binary.BigEndian.Uint32(cmd.message[i*4 : i*4+4])
 			}
 
-			for _,c := range cmds {
+			for _, c := range cmds {
 				if c.code == INIT_DESTROYPOD {
 					glog.Info("got pod finish message after having send destroy message")
 					looping = false
-					ctx.Hub <- &CommandAck {
+					ctx.Hub <- &CommandAck{
 						reply: c.code,
 					}
 					break
@@ -222,11 +316,35 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 			ctx.Hub <- &PodFinished{
 				result: results,
 			}
+		} else if cmd.code == INIT_FINISHCMD {
+			finish := &FinishCmd{}
+			if err := json.Unmarshal(cmd.message, finish); err != nil {
+				glog.Errorf("cannot parse finish command message '%s'", string(cmd.message))
+				continue
+			}
+			glog.V(1).Infof("command on session %d finished with %d", finish.Seq, finish.ExitCode)
+			ctx.Hub <- &ExecFinished{
+				Seq:      finish.Seq,
+				ExitCode: finish.ExitCode,
+			}
+		} else if cmd.code == INIT_CONTAINEREXIT {
+			exit := &containerExitMsg{}
+			if err := json.Unmarshal(cmd.message, exit); err != nil {
+				glog.Errorf("cannot parse container exit message '%s'", string(cmd.message))
+				continue
+			}
+			glog.V(1).Infof("container %s exited with %d", exit.Container, exit.ExitCode)
+			ctx.Hub <- &ContainerExited{
+				Id:       exit.Container,
+				ExitCode: exit.ExitCode,
+			}
 		} else {
 			if glog.V(1) {
 				glog.Infof("send command %d to init, payload: '%s'.", cmd.code, string(cmd.message))
 			}
-			init.Write(newVmMessage(cmd))
+			seq++
+			cmd.seq = seq
+			init.Write(newVmMessage(cmd, version))
 			cmds = append(cmds, cmd)
 			if pongTimer == nil {
 				glog.V(1).Info("message sent, set pong timer")
@@ -245,13 +363,14 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 	}
 }
 
-func waitInitAck(ctx *VmContext, init *net.UnixConn) {
+func waitInitAck(ctx *VmContext, init *net.UnixConn, version int) {
 	for {
-		res, err := readVmMessage(init)
+		res, err := readVmMessage(init, version)
 		if err != nil {
 			ctx.Hub <- &Interrupted{Reason: "init socket failed " + err.Error()}
 			return
-		} else if res.code == INIT_ACK || res.code == INIT_ERROR || res.code == INIT_FINISHPOD {
+		} else if res.code == INIT_ACK || res.code == INIT_ERROR || res.code == INIT_FINISHPOD || res.code == INIT_FINISHCMD ||
+			res.code == INIT_CONTAINEREXIT {
 			ctx.vm <- res
 		}
 	}
@@ -0,0 +1,115 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// unixPair returns the two ends of a connected unix socket
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestVmMessageFraming(t *testing.T) {
+	a, b := unixPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	for _, version := range []int{INIT_PROTOCOL_LEGACY, INIT_PROTOCOL_V1} {
+		m := &DecodedMessage{code: INIT_EXECCMD, seq: 7, message: []byte("payload")}
+		data := newVmMessage(m, version)
+		if len(data) != headerSize(version)+len(m.message) {
+			t.Errorf("v%d message is %d bytes, should be %d", version, len(data), headerSize(version)+len(m.message))
+		}
+		go a.Write(data)
+		got, err := readVmMessage(b, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.code != m.code || string(got.message) != string(m.message) {
+			t.Errorf("v%d message is read as %d %q", version, got.code, got.message)
+		}
+		if version == INIT_PROTOCOL_LEGACY && got.seq != 0 {
+			t.Errorf("legacy message carries sequence %d", got.seq)
+		}
+		if version == INIT_PROTOCOL_V1 && got.seq != m.seq {
+			t.Errorf("v1 message has sequence %d, should be %d", got.seq, m.seq)
+		}
+	}
+}
+
+func TestNegotiateInit(t *testing.T) {
+	a, b := unixPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	for _, payload := range []string{"", "garbage", `{"version":0}`} {
+		version, caps, err := negotiateInit(a, []byte(payload))
+		if err != nil || version != INIT_PROTOCOL_LEGACY || caps != nil {
+			t.Errorf("payload %q negotiates %d %v %v, should be legacy", payload, version, caps, err)
+		}
+	}
+
+	version, caps, err := negotiateInit(a, []byte(`{"version":99,"capabilities":["a","b"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != INIT_PROTOCOL_VERSION {
+		t.Errorf("a newer init negotiates %d, should be %d", version, INIT_PROTOCOL_VERSION)
+	}
+	if !reflect.DeepEqual(caps, []string{"a", "b"}) {
+		t.Errorf("capabilities are %v", caps)
+	}
+	// the answer is in the legacy framing
+	reply, err := readVmMessage(b, INIT_PROTOCOL_LEGACY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &InitHandshake{}
+	if err := json.Unmarshal(reply.message, hs); err != nil {
+		t.Fatal(err)
+	}
+	if reply.code != INIT_READY || hs.Version != INIT_PROTOCOL_VERSION {
+		t.Errorf("the answer is %d %v", reply.code, hs)
+	}
+}
+
+func TestPendingIndex(t *testing.T) {
+	cmds := []*DecodedMessage{{seq: 1}, {seq: 2}, {seq: 3}}
+	for _, c := range []struct {
+		cmds    []*DecodedMessage
+		seq     uint32
+		version int
+		index   int
+	}{
+		{nil, 1, INIT_PROTOCOL_V1, -1},
+		// legacy inits ack in order, whatever is echoed
+		{cmds, 3, INIT_PROTOCOL_LEGACY, 0},
+		{cmds, 1, INIT_PROTOCOL_V1, 0},
+		{cmds, 3, INIT_PROTOCOL_V1, 2},
+		{cmds, 2, INIT_PROTOCOL_V1, 1},
+		{cmds, 9, INIT_PROTOCOL_V1, -1},
+	} {
+		if i := pendingIndex(c.cmds, &DecodedMessage{seq: c.seq}, c.version); i != c.index {
+			t.Errorf("ack %d of v%d matches %d, should be %d", c.seq, c.version, i, c.index)
+		}
+	}
+}
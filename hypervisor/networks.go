@@ -9,9 +9,18 @@ import (
 	"os"
 )
 
-func CreateInterface(index int, pciAddr int, name string, isDefault bool, addrOnly bool,
+// podInterfaces returns the networks a pod joins, one for each nic, a pod
+// without networks gets a nic on the default network
+func podInterfaces(spec *pod.UserPod) []pod.UserInterface {
+	if len(spec.Networks) == 0 {
+		return []pod.UserInterface{{}}
+	}
+	return spec.Networks
+}
+
+func CreateInterface(index int, pciAddr int, name string, nw pod.UserInterface, isDefault bool, addrOnly bool,
 	maps []pod.UserContainerPort, callback chan VmEvent) {
-	inf, err := network.Allocate("", addrOnly, maps)
+	inf, err := network.Allocate(nw.Network, nw.Ip, addrOnly, maps)
 	if err != nil {
 		glog.Error("interface creating failed: ", err.Error())
 		callback <- &DeviceFailed{
@@ -20,13 +29,13 @@ func CreateInterface(index int, pciAddr int, name string, isDefault bool, addrOn
 		return
 	}
 
-	interfaceGot(index, pciAddr, name, isDefault, callback, inf)
+	interfaceGot(index, pciAddr, name, nw.Network, isDefault, callback, inf)
 }
 
-func ReleaseInterface(index int, ipAddr string, file *os.File,
+func ReleaseInterface(index int, nw string, ipAddr string, file *os.File,
 	maps []pod.UserContainerPort, callback chan VmEvent) {
 	success := true
-	err := network.Release(ipAddr, maps, file)
+	err := network.Release(nw, ipAddr, maps, file)
 	if err != nil {
 		glog.Warning("Unable to release network interface, address: ", ipAddr, err)
 		success = false
@@ -34,7 +43,7 @@ func ReleaseInterface(index int, ipAddr string, file *os.File,
 	callback <- &InterfaceReleased{Index: index, Success: success}
 }
 
-func interfaceGot(index int, pciAddr int, name string, isDefault bool, callback chan VmEvent, inf *network.Settings) {
+func interfaceGot(index int, pciAddr int, name, netName string, isDefault bool, callback chan VmEvent, inf *network.Settings) {
 
 	ip, nw, err := net.ParseCIDR(fmt.Sprintf("%s/%d", inf.IPAddress, inf.IPPrefixLen))
 	if err != nil {
@@ -63,6 +72,7 @@ func interfaceGot(index int, pciAddr int, name string, isDefault bool, callback
 	event := &InterfaceCreated{
 		Index:      index,
 		PCIAddr:    pciAddr,
+		Network:    netName,
 		Bridge:     inf.Bridge,
 		HostDevice: inf.Device,
 		DeviceName: name,
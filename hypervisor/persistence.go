@@ -3,21 +3,29 @@ package hypervisor
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"hyper/lib/glog"
 	"hyper/pod"
 	"hyper/types"
 	"sync"
 )
 
+// PersistVersion is the version of the PersistInfo format written by this
+// release, the info written before the format had a version is version 1.
+// Any change of the format the older releases could not read needs a new
+// version and a migration from the previous one in persistMigrations, an
+// optional field only needs omitempty.
+const PersistVersion = 2
+
 type PersistVolumeInfo struct {
-	Name       string
-	Filename   string
-	Format     string
-	Fstype     string
-	DeviceName string
-	ScsiId     int
-	Containers []int
-	MontPoints []string
+	Name        string
+	Filename    string
+	Format      string
+	Fstype      string
+	DeviceName  string
+	ScsiId      int
+	Containers  []int
+	MountPoints []string
 }
 
 type PersistNetworkInfo struct {
@@ -25,9 +33,11 @@ type PersistNetworkInfo struct {
 	PciAddr    int
 	DeviceName string
 	IpAddr     string
+	Network    string `json:",omitempty"`
 }
 
 type PersistInfo struct {
+	Version     int
 	Id          string
 	DriverInfo  map[string]interface{}
 	UserSpec    *pod.UserPod
@@ -35,6 +45,9 @@ type PersistInfo struct {
 	HwStat      *VmHwStatus
 	VolumeList  []*PersistVolumeInfo
 	NetworkList []*PersistNetworkInfo
+	InitVersion int         `json:",omitempty"`
+	InitCaps    []string    `json:",omitempty"`
+	Boot        *BootConfig `json:",omitempty"`
 }
 
 func (ctx *VmContext) dump() (*PersistInfo, error) {
@@ -44,6 +57,7 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 	}
 
 	info := &PersistInfo{
+		Version:     PersistVersion,
 		Id:          ctx.Id,
 		DriverInfo:  dr,
 		UserSpec:    ctx.userSpec,
@@ -51,13 +65,16 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 		HwStat:      ctx.dumpHwInfo(),
 		VolumeList:  make([]*PersistVolumeInfo, len(ctx.devices.imageMap)+len(ctx.devices.volumeMap)),
 		NetworkList: make([]*PersistNetworkInfo, len(ctx.devices.networkMap)),
+		InitVersion: ctx.initProtocol(),
+		InitCaps:    ctx.initCapabilities(),
+		Boot:        ctx.Boot,
 	}
 
 	vid := 0
 	for _, image := range ctx.devices.imageMap {
 		info.VolumeList[vid] = image.info.dump()
 		info.VolumeList[vid].Containers = []int{image.pos}
-		info.VolumeList[vid].MontPoints = []string{"/"}
+		info.VolumeList[vid].MountPoints = []string{"/"}
 		vid++
 	}
 
@@ -65,11 +82,11 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 		info.VolumeList[vid] = vol.info.dump()
 		mps := len(vol.pos)
 		info.VolumeList[vid].Containers = make([]int, mps)
-		info.VolumeList[vid].MontPoints = make([]string, mps)
+		info.VolumeList[vid].MountPoints = make([]string, mps)
 		i := 0
 		for idx, mp := range vol.pos {
 			info.VolumeList[vid].Containers[i] = idx
-			info.VolumeList[vid].MontPoints[i] = mp
+			info.VolumeList[vid].MountPoints[i] = mp
 			i++
 		}
 		vid++
@@ -82,6 +99,7 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 			PciAddr:    nic.PCIAddr,
 			DeviceName: nic.DeviceName,
 			IpAddr:     nic.IpAddr,
+			Network:    nic.Network,
 		}
 		nid++
 	}
@@ -153,7 +171,68 @@ func (cr *VmContainer) volLookup(mpoint string) *VmVolumeDescriptor {
 	return nil
 }
 
+// persistMigrations upgrade the raw persist info of a version to the next
+// one, so the VMs started by an older release can be associated.
+var persistMigrations = map[int]func(raw map[string]interface{}) error{
+	1: migratePersistV1,
+}
+
+// migratePersistV1 renames the MontPoints of the volumes to MountPoints
+func migratePersistV1(raw map[string]interface{}) error {
+	vols, ok := raw["VolumeList"].([]interface{})
+	if !ok {
+		return nil
+	}
+	for _, v := range vols {
+		vol, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.New("persistent data corrupt, wrong volume info")
+		}
+		if mps, ok := vol["MontPoints"]; ok {
+			vol["MountPoints"] = mps
+			delete(vol, "MontPoints")
+		}
+	}
+	return nil
+}
+
+// LoadPersistInfo decodes the persist info of a VM of any version
+func LoadPersistInfo(data []byte) (*PersistInfo, error) {
+	return vmDeserialize(data)
+}
+
 func vmDeserialize(s []byte) (*PersistInfo, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(s, &raw); err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if v, ok := raw["Version"]; ok {
+		f, ok := v.(float64)
+		if !ok || f < 1 {
+			return nil, fmt.Errorf("wrong persist info version %v", v)
+		}
+		version = int(f)
+	}
+	if version > PersistVersion {
+		return nil, fmt.Errorf("persist info version %d is newer than the supported version %d", version, PersistVersion)
+	}
+	if version < PersistVersion {
+		for ; version < PersistVersion; version++ {
+			glog.V(1).Infof("migrate persist info from version %d", version)
+			if err := persistMigrations[version](raw); err != nil {
+				return nil, err
+			}
+		}
+		raw["Version"] = PersistVersion
+
+		var err error
+		if s, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+
 	info := &PersistInfo{}
 	err := json.Unmarshal(s, info)
 	return info, err
@@ -174,7 +253,11 @@ func (pinfo *PersistInfo) vmContext(driver HypervisorDriver,
 		return nil, err
 	}
 
-	ctx, err := InitContext(driver, pinfo.Id, hub, client, dc, &BootConfig{})
+	boot := pinfo.Boot
+	if boot == nil {
+		boot = &BootConfig{}
+	}
+	ctx, err := InitContext(driver, pinfo.Id, hub, client, dc, boot)
 	if err != nil {
 		return nil, err
 	}
@@ -184,17 +267,22 @@ func (pinfo *PersistInfo) vmContext(driver HypervisorDriver,
 	ctx.wg = wg
 
 	ctx.loadHwStatus(pinfo)
+	ctx.setInitProtocol(pinfo.InitVersion, pinfo.InitCaps)
 
 	for idx, container := range ctx.vmSpec.Containers {
-		ctx.ptys.ttys[container.Tty] = newAttachments(idx, true)
+		if container.Tty != 0 {
+			ctx.ptys.ttys[container.Tty] = newAttachments(idx, true)
+		} else if container.Stdio != 0 {
+			ctx.ptys.stdioSessions(idx, true, container.Stdio, container.Stderr)
+		}
 	}
 
 	for _, vol := range pinfo.VolumeList {
 		binfo := vol.blockInfo()
-		if len(vol.Containers) != len(vol.MontPoints) {
+		if len(vol.Containers) != len(vol.MountPoints) {
 			return nil, errors.New("persistent data corrupt, volume info mismatch")
 		}
-		if len(vol.MontPoints) == 1 && vol.MontPoints[0] == "/" {
+		if len(vol.MountPoints) == 1 && vol.MountPoints[0] == "/" {
 			img := &imageInfo{
 				info: binfo,
 				pos:  vol.Containers[0],
@@ -208,9 +296,10 @@ func (pinfo *PersistInfo) vmContext(driver HypervisorDriver,
 			}
 			for i := 0; i < len(vol.Containers); i++ {
 				idx := vol.Containers[i]
-				v.pos[idx] = vol.MontPoints[i]
-				v.readOnly[idx] = ctx.vmSpec.Containers[idx].roLookup(vol.MontPoints[i])
+				v.pos[idx] = vol.MountPoints[i]
+				v.readOnly[idx] = ctx.vmSpec.Containers[idx].roLookup(vol.MountPoints[i])
 			}
+			ctx.devices.volumeMap[vol.Name] = v
 		}
 	}
 
@@ -220,6 +309,7 @@ func (pinfo *PersistInfo) vmContext(driver HypervisorDriver,
 			PCIAddr:    nic.PciAddr,
 			DeviceName: nic.DeviceName,
 			IpAddr:     nic.IpAddr,
+			Network:    nic.Network,
 		}
 	}
 
@@ -0,0 +1,194 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"hyper/pod"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func testPersistContext(t *testing.T) *VmContext {
+	dr := &EmptyDriver{}
+	dr.Initialize()
+
+	ctx, err := InitContext(dr, "vmpersist", nil, nil, nil, &BootConfig{})
+	if err != nil {
+		t.Fatal("init context failed ", err.Error())
+	}
+
+	ctx.userSpec = &pod.UserPod{
+		Name:       "persist",
+		Containers: []pod.UserContainer{{Name: "web", Image: "busybox"}},
+		Volumes:    []pod.UserVolume{{Name: "data", Driver: "vfs"}},
+		Networks:   []pod.UserInterface{{Network: "front"}},
+	}
+	ctx.vmSpec = &VmPod{
+		Hostname: "persist",
+		Containers: []VmContainer{{
+			Id:    "c1",
+			Image: "sda",
+			Tty:   1,
+			Volumes: []VmVolumeDescriptor{
+				{Device: "sdb", Mount: "/data", Fstype: "ext4", ReadOnly: true},
+			},
+		}},
+		ShareDir: ShareDirTag,
+	}
+	ctx.setInitProtocol(1, []string{INIT_CAP_HOTPLUG})
+	ctx.scsiId = 2
+	ctx.pciAddr = PciAddrFrom + 1
+
+	ctx.devices.imageMap["c1"] = &imageInfo{
+		info: &blockDescriptor{name: "c1", filename: "/dev/mapper/c1", format: "raw", fstype: "ext4", deviceName: "sda"},
+		pos:  0,
+	}
+	ctx.devices.volumeMap["data"] = &volumeInfo{
+		info:     &blockDescriptor{name: "data", filename: "/dev/mapper/data", format: "raw", fstype: "ext4", deviceName: "sdb", scsiId: 1},
+		pos:      map[int]string{0: "/data"},
+		readOnly: map[int]bool{0: true},
+	}
+	ctx.devices.networkMap[0] = &InterfaceCreated{
+		Index:      0,
+		PCIAddr:    PciAddrFrom,
+		Network:    "front",
+		DeviceName: "eth0",
+		IpAddr:     "10.1.0.2",
+	}
+
+	return ctx
+}
+
+// persistJson serializes the info with its lists sorted, they are filled
+// from maps in random order
+func persistJson(t *testing.T, info *PersistInfo) string {
+	sort.Sort(byVolumeName(info.VolumeList))
+	sort.Sort(byNetworkIndex(info.NetworkList))
+	buf, err := info.serialize()
+	if err != nil {
+		t.Fatal("serialize failed ", err.Error())
+	}
+	return string(buf)
+}
+
+type byVolumeName []*PersistVolumeInfo
+
+func (v byVolumeName) Len() int           { return len(v) }
+func (v byVolumeName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byVolumeName) Less(i, j int) bool { return v[i].Name < v[j].Name }
+
+type byNetworkIndex []*PersistNetworkInfo
+
+func (n byNetworkIndex) Len() int           { return len(n) }
+func (n byNetworkIndex) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n byNetworkIndex) Less(i, j int) bool { return n[i].Index < n[j].Index }
+
+func TestPersistRoundTrip(t *testing.T) {
+	ctx := testPersistContext(t)
+	defer ctx.Close()
+
+	info, err := ctx.dump()
+	if err != nil {
+		t.Fatal("dump failed ", err.Error())
+	}
+	if info.Version != PersistVersion {
+		t.Error("dumped version should be ", PersistVersion, ", but is ", info.Version)
+	}
+	orig := persistJson(t, info)
+
+	pinfo, err := vmDeserialize([]byte(orig))
+	if err != nil {
+		t.Fatal("deserialize failed ", err.Error())
+	}
+	loaded, err := pinfo.vmContext(&EmptyDriver{}, nil, nil, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal("load context failed ", err.Error())
+	}
+	defer loaded.Close()
+
+	if vol := loaded.devices.volumeMap["data"]; vol == nil || !vol.readOnly[0] || vol.pos[0] != "/data" {
+		t.Errorf("volume is not restored: %v", vol)
+	}
+	if nic := loaded.devices.networkMap[0]; nic == nil || nic.Network != "front" {
+		t.Errorf("network is not restored: %v", nic)
+	}
+	if _, ok := loaded.ptys.ttys[1]; !ok {
+		t.Error("tty of the container is not restored")
+	}
+
+	info, err = loaded.dump()
+	if err != nil {
+		t.Fatal("dump of the loaded context failed ", err.Error())
+	}
+	if again := persistJson(t, info); again != orig {
+		t.Errorf("persist info changed after a round trip:\n%s\n%s", orig, again)
+	}
+}
+
+func TestPersistMigrateV1(t *testing.T) {
+	legacy := `{"Id":"vmold","DriverInfo":{"hypervisor":"empty"},` +
+		`"UserSpec":{"id":"old","containers":[{"name":"web","image":"busybox"}]},` +
+		`"VmSpec":{"hostname":"old","containers":[{"id":"c1","image":"sda","volumes":[{"device":"sdb","mount":"/data","fstype":"ext4","readOnly":true}]}],"shareDir":"share_dir"},` +
+		`"HwStat":{"PciAddr":6,"ScsiId":2,"AttachId":1},` +
+		`"VolumeList":[{"Name":"c1","Filename":"/dev/mapper/c1","Format":"raw","Fstype":"ext4","DeviceName":"sda","ScsiId":0,"Containers":[0],"MontPoints":["/"]},` +
+		`{"Name":"data","Filename":"/dev/mapper/data","Format":"raw","Fstype":"ext4","DeviceName":"sdb","ScsiId":1,"Containers":[0],"MontPoints":["/data"]}],` +
+		`"NetworkList":[{"Index":0,"PciAddr":5,"DeviceName":"eth0","IpAddr":"192.168.123.2"}]}`
+
+	pinfo, err := vmDeserialize([]byte(legacy))
+	if err != nil {
+		t.Fatal("deserialize legacy info failed ", err.Error())
+	}
+	if pinfo.Version != PersistVersion {
+		t.Error("migrated version should be ", PersistVersion, ", but is ", pinfo.Version)
+	}
+	for _, vol := range pinfo.VolumeList {
+		if len(vol.MountPoints) != 1 || len(vol.Containers) != 1 {
+			t.Errorf("mount points of %s are lost: %v", vol.Name, vol.MountPoints)
+		}
+	}
+
+	ctx, err := pinfo.vmContext(&EmptyDriver{}, nil, nil, &sync.WaitGroup{})
+	if err != nil {
+		t.Fatal("load migrated context failed ", err.Error())
+	}
+	defer ctx.Close()
+	if img := ctx.devices.imageMap["c1"]; img == nil || img.pos != 0 {
+		t.Errorf("image is not restored: %v", img)
+	}
+	if vol := ctx.devices.volumeMap["data"]; vol == nil || !vol.readOnly[0] {
+		t.Errorf("volume is not restored: %v", vol)
+	}
+}
+
+func TestPersistVersionCheck(t *testing.T) {
+	if _, err := vmDeserialize([]byte(`{"Version":99,"Id":"vmnew"}`)); err == nil {
+		t.Error("info of a newer version should be rejected")
+	}
+	if _, err := vmDeserialize([]byte(`{"Version":"2","Id":"vmbad"}`)); err == nil {
+		t.Error("info with a wrong version should be rejected")
+	}
+}
+
+func TestEmptyDriverPersist(t *testing.T) {
+	dr := &EmptyDriver{}
+	dc := dr.InitContext("/tmp/")
+
+	persisted, err := dc.Dump()
+	if err != nil {
+		t.Fatal("dump failed ", err.Error())
+	}
+	buf, err := json.Marshal(persisted)
+	if err != nil {
+		t.Fatal("marshal failed ", err.Error())
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal("unmarshal failed ", err.Error())
+	}
+	if _, err := dr.LoadContext(decoded); err != nil {
+		t.Error("load context failed ", err.Error())
+	}
+	if _, err := dr.LoadContext(map[string]interface{}{"hypervisor": "qemu"}); err == nil {
+		t.Error("context of another driver should be rejected")
+	}
+}
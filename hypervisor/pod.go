@@ -30,6 +30,8 @@ type VmContainer struct {
 	Volumes       []VmVolumeDescriptor `json:"volumes,omitempty"`
 	Fsmap         []VmFsmapDescriptor  `json:"fsmap,omitempty"`
 	Tty           uint64               `json:"tty,omitempty"`
+	Stdio         uint64               `json:"stdio,omitempty"`
+	Stderr        uint64               `json:"stderr,omitempty"`
 	Workdir       string               `json:"workdir"`
 	Entrypoint    []string             `json:"-"`
 	Cmd           []string             `json:"cmd"`
@@ -50,11 +52,12 @@ type VmRoute struct {
 }
 
 type VmPod struct {
-	Hostname   string         `json:"hostname"`
-	Containers []VmContainer  `json:"containers"`
-	Interfaces []VmNetworkInf `json:"interfaces"`
-	Routes     []VmRoute      `json:"routes"`
-	ShareDir   string         `json:"shareDir"`
+	Hostname   string            `json:"hostname"`
+	Containers []VmContainer     `json:"containers"`
+	Interfaces []VmNetworkInf    `json:"interfaces"`
+	Routes     []VmRoute         `json:"routes"`
+	ShareDir   string            `json:"shareDir"`
+	Sysctl     map[string]string `json:"sysctl,omitempty"`
 }
 
 type RunningContainer struct {
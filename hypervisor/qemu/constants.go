@@ -13,7 +13,11 @@ const (
 )
 
 const (
-	QmpSockName = "qmp.sock"
+	QmpSockName  = "qmp.sock"
+	QemuErrLog   = "qemu.err"
+	QemuExitFile = "qemu.exit"
+	// lines of the qemu stderr kept for the boot failure report
+	QemuErrLines = 20
 
 	QMP_EVENT_SHUTDOWN = "SHUTDOWN"
 )
@@ -3,9 +3,13 @@ package qemu
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"strconv"
+	"strings"
+	"syscall"
 
 	"hyper/hypervisor"
 	"hyper/lib/glog"
@@ -22,6 +26,8 @@ type QemuContext struct {
 	qmp         chan QmpInteraction
 	wdt         chan string
 	qmpSockName string
+	errLog      string
+	exitFile    string
 	process     *os.Process
 }
 
@@ -44,6 +50,8 @@ func (qd *QemuDriver) InitContext(homeDir string) hypervisor.DriverContext {
 		qmp:         make(chan QmpInteraction, 128),
 		wdt:         make(chan string, 16),
 		qmpSockName: homeDir + QmpSockName,
+		errLog:      homeDir + QemuErrLog,
+		exitFile:    homeDir + QemuExitFile,
 		process:     nil,
 	}
 }
@@ -74,14 +82,19 @@ func (qd *QemuDriver) LoadContext(persisted map[string]interface{}) (hypervisor.
 	if !ok {
 		return nil, errors.New("cannot read the pid info from persist info")
 	} else {
+		var pid int
 		switch p.(type) {
 		case int:
-			proc, err = os.FindProcess(p.(int))
-			if err != nil {
-				return nil, err
-			}
+			pid = p.(int)
+		case float64:
+			// the pid is a float64 once it is decoded from JSON
+			pid = (int)(p.(float64))
 		default:
-			return nil, errors.New("wrong sock name type in persist info")
+			return nil, errors.New("wrong pid type in persist info")
+		}
+		proc, err = os.FindProcess(pid)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -90,6 +103,8 @@ func (qd *QemuDriver) LoadContext(persisted map[string]interface{}) (hypervisor.
 		qmp:         make(chan QmpInteraction, 128),
 		wdt:         make(chan string, 16),
 		qmpSockName: sock,
+		errLog:      path.Join(path.Dir(sock), QemuErrLog),
+		exitFile:    path.Join(path.Dir(sock), QemuExitFile),
 		process:     proc,
 	}, nil
 }
@@ -130,6 +145,28 @@ func (qc *QemuContext) Kill(ctx *hypervisor.VmContext) {
 	qc.wdt <- "kill"
 }
 
+// Diagnostics tells how qemu quits and what it complains on the stderr
+func (qc *QemuContext) Diagnostics() string {
+	var diag []string
+	if status, err := ioutil.ReadFile(qc.exitFile); err == nil {
+		diag = append(diag, string(status))
+	} else if qc.process != nil && qc.process.Signal(syscall.Signal(0)) == nil {
+		diag = append(diag, "qemu is still running")
+	}
+
+	if out, err := ioutil.ReadFile(qc.errLog); err == nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) > QemuErrLines {
+			lines = lines[len(lines)-QemuErrLines:]
+		}
+		if len(lines) > 0 && lines[0] != "" {
+			diag = append(diag, "qemu stderr:")
+			diag = append(diag, lines...)
+		}
+	}
+	return strings.Join(diag, "\n")
+}
+
 func (qc *QemuContext) BuildinNetwork() bool { return false }
 
 func (qc *QemuContext) Close() {
@@ -179,13 +216,13 @@ func (qc *QemuContext) arguments(ctx *hypervisor.VmContext) []string {
 	} else if boot.Bios != "" {
 		params = append(params,
 			"-bios", boot.Bios,
-			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", "\"console=ttyS0 panic=1\"")
+			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", boot.KernelCmdline("console=ttyS0 panic=1"))
 	} else if boot.Cbfs != "" {
 		params = append(params,
 			"-drive", fmt.Sprintf("if=pflash,file=%s,readonly=on", boot.Cbfs))
 	} else {
 		params = append(params,
-			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", "\"console=ttyS0 panic=1\"")
+			"-kernel", boot.Kernel, "-initrd", boot.Initrd, "-append", boot.KernelCmdline("console=ttyS0 panic=1"))
 	}
 
 	return append(params,
@@ -4,11 +4,10 @@ import (
 	"hyper/hypervisor"
 	"hyper/lib/glog"
 
-	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"strconv"
+	"os/exec"
 	"strings"
 	"syscall"
 )
@@ -42,124 +41,53 @@ func watchDog(qc *QemuContext, hub chan hypervisor.VmEvent) {
 	}
 }
 
-func fork(exit bool) (uintptr, error) {
-	// fork off the parent process
-	ret, ret2, errno := syscall.RawSyscall(syscall.SYS_FORK, 0, 0, 0)
-	if errno != 0 {
-		return uintptr(errno), fmt.Errorf("fork failed with error %s", errno.Error())
-	}
-
-	// failure
-	if ret2 < 0 {
-		return ret2, fmt.Errorf("fork failed with pid %d", ret2)
-	}
-
-	// if we got a good PID, then we call exit the parent process.
-	if ret > 0 && exit {
-		glog.V(3).Infof("I am the parent, exit, ps: child %d", ret)
-		os.Exit(0)
-	}
-
-	return ret, nil
-}
-
-func listFd() []string {
-	files, err := ioutil.ReadDir("/proc/self/fd/")
-	if err != nil {
-		return []string{}
-	}
-
-	result := []string{}
-	for _, file := range files {
-		result = append(result, file.Name())
-	}
-
-	return result
-}
-
-func daemon(cmd string, argv []string, pipe int) error {
-
-	// create a subprocess
-	pid, err := fork(false)
-	if err != nil {
-		return err
-	} else if pid > 0 {
-		go func() {
-			wp, err := syscall.Wait4(int(pid), nil, 0, nil)
-			if err == nil {
-				glog.V(3).Infof("collect child %d", wp)
-			} else {
-				glog.Errorf("error during wait %d: %s", pid, err.Error())
-			}
-		}()
-		// return the parent
-		return nil
-	}
-
-	// exit the created one, create the daemon
-	_, err = fork(true)
-	if err != nil {
-		glog.Error("second fork failed: ", err.Error())
-		os.Exit(-1)
-	}
-
-	cur := os.Getpid()
-	glog.V(1).Infof("qemu daemon pid %d.", cur)
-	//Change the file mode mask
-	_ = syscall.Umask(0)
-
-	// create a new SID for the child process
-	s_ret, err := syscall.Setsid()
-	if err != nil {
-		glog.Info("Error: syscall.Setsid errno: ", err.Error())
-		os.Exit(-1)
-	}
-	if s_ret < 0 {
-		glog.Errorf("setsid return negative value: %d", s_ret)
-		os.Exit(-1)
-	}
-
-	os.Chdir("/")
-
-	f, e := os.OpenFile("/dev/null", os.O_RDWR, 0)
-	if e == nil {
-		fd := f.Fd()
-		syscall.Dup2(int(fd), int(os.Stdin.Fd()))
-		syscall.Dup2(int(fd), int(os.Stdout.Fd()))
-		syscall.Dup2(int(fd), int(os.Stderr.Fd()))
-	}
-
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(cur))
-	syscall.Write(pipe, buf)
-	syscall.Close(pipe)
-
-	fds := listFd()
-	for _, fd := range fds {
-		if f, err := strconv.Atoi(fd); err == nil && f > 2 {
-			glog.V(1).Infof("close fd %d", f)
-			syscall.Close(f)
+// startQemu starts qemu in a session of its own, so it is not hurt by the
+// signals to hyperd and keeps running when hyperd quits. Only its stderr is
+// kept, it tells why qemu quits. The exit status is written to exitFile by
+// hyperd once qemu quits, it is lost if hyperd restarted in between.
+func startQemu(cmd string, argv []string, errLog, exitFile string) (*os.Process, error) {
+	c := &exec.Cmd{
+		Path: cmd,
+		Args: argv,
+		Env:  []string{},
+		Dir:  "/",
+		SysProcAttr: &syscall.SysProcAttr{
+			Setsid: true,
+		},
+	}
+	if l, err := os.OpenFile(errLog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err == nil {
+		c.Stderr = l
+		defer l.Close()
+	}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		status := "qemu exit status is unknown"
+		c.Wait()
+		if ws, ok := c.ProcessState.Sys().(syscall.WaitStatus); ok {
+			status = exitStatus(ws)
 		}
-	}
+		glog.V(1).Infof("qemu %d quits: %s", c.Process.Pid, status)
+		ioutil.WriteFile(exitFile, []byte(status), 0644)
+	}()
+	return c.Process, nil
+}
 
-	err = syscall.Exec(cmd, argv, []string{})
-	if err != nil {
-		glog.Error("fail to exec qemu process")
-		os.Exit(-1)
+func exitStatus(ws syscall.WaitStatus) string {
+	switch {
+	case ws.Exited():
+		return fmt.Sprintf("qemu exited with status %d", ws.ExitStatus())
+	case ws.Signaled():
+		return fmt.Sprintf("qemu was killed by signal %d (%s)", ws.Signal(), ws.Signal().String())
 	}
-
-	return nil
+	return fmt.Sprintf("qemu quit with wait status 0x%x", uint32(ws))
 }
 
-func (qc *QemuContext) watchPid(pid int, hub chan hypervisor.VmEvent) error {
-	proc, err := os.FindProcess(pid)
-	if err != nil {
-		return err
-	}
+func (qc *QemuContext) watchProcess(proc *os.Process, hub chan hypervisor.VmEvent) {
 	qc.process = proc
 	go watchDog(qc, hub)
-
-	return nil
 }
 
 // launchQemu run qemu and wait it's quit, includes
@@ -176,41 +104,18 @@ func launchQemu(qc *QemuContext, ctx *hypervisor.VmContext) {
 		glog.Info("cmdline arguments: ", strings.Join(args, " "))
 	}
 
-	pipe := make([]int, 2)
-	err := syscall.Pipe(pipe)
+	os.Remove(qc.errLog)
+	os.Remove(qc.exitFile)
+	proc, err := startQemu(qemu, append([]string{"qemu-system-x86_64"}, args...), qc.errLog, qc.exitFile)
 	if err != nil {
-		glog.Error("fail to create pipe")
-		ctx.Hub <- &hypervisor.VmStartFailEvent{Message: "fail to create pipe"}
-		return
-	}
-
-	err = daemon(qemu, append([]string{"qemu-system-x86_64"}, args...), pipe[1])
-	if err != nil {
-		//fail to daemonize
-		glog.Error("try to start qemu failed")
-		ctx.Hub <- &hypervisor.VmStartFailEvent{Message: "try to start qemu failed"}
-		return
-	}
-
-	buf := make([]byte, 4)
-	nr, err := syscall.Read(pipe[0], buf)
-	if err != nil || nr != 4 {
-		glog.Error("try to start qemu failed")
+		glog.Error("try to start qemu failed: ", err.Error())
 		ctx.Hub <- &hypervisor.VmStartFailEvent{Message: "try to start qemu failed"}
 		return
 	}
-	syscall.Close(pipe[1])
-	syscall.Close(pipe[0])
-
-	pid := binary.BigEndian.Uint32(buf[:nr])
-	glog.V(1).Infof("starting daemon with pid: %d", pid)
+	glog.V(1).Infof("starting qemu with pid: %d", proc.Pid)
 
-	err = ctx.DCtx.(*QemuContext).watchPid(int(pid), ctx.Hub)
-	if err != nil {
-		glog.Error("watch qemu process failed")
-		ctx.Hub <- &hypervisor.VmStartFailEvent{Message: "watch qemu process failed"}
-		return
-	}
+	ctx.DCtx.(*QemuContext).watchProcess(proc, ctx.Hub)
+	ctx.BootPhaseDone("qemu start")
 }
 
 func associateQemu(ctx *hypervisor.VmContext) {
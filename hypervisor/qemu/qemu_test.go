@@ -0,0 +1,97 @@
+package qemu
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestQemuPersist(t *testing.T) {
+	qd := &QemuDriver{}
+	proc, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal("cannot find the process of the test ", err.Error())
+	}
+	qc := qd.InitContext("/tmp/vmqemu/").(*QemuContext)
+	qc.process = proc
+
+	persisted, err := qc.Dump()
+	if err != nil {
+		t.Fatal("dump failed ", err.Error())
+	}
+	buf, err := json.Marshal(persisted)
+	if err != nil {
+		t.Fatal("marshal failed ", err.Error())
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal("unmarshal failed ", err.Error())
+	}
+
+	dc, err := qd.LoadContext(decoded)
+	if err != nil {
+		t.Fatal("load context failed ", err.Error())
+	}
+	loaded := dc.(*QemuContext)
+	if loaded.qmpSockName != qc.qmpSockName || loaded.process.Pid != proc.Pid {
+		t.Errorf("context changed after a round trip: %s/%d", loaded.qmpSockName, loaded.process.Pid)
+	}
+
+	again, err := loaded.Dump()
+	if err != nil {
+		t.Fatal("dump of the loaded context failed ", err.Error())
+	}
+	if buf2, _ := json.Marshal(again); string(buf2) != string(buf) {
+		t.Errorf("persist info changed after a round trip:\n%s\n%s", buf, buf2)
+	}
+
+	// the context dumped in memory keeps the pid an int
+	if _, err := qd.LoadContext(persisted); err != nil {
+		t.Error("load undecoded context failed ", err.Error())
+	}
+}
+
+func TestQemuLoadWrongContext(t *testing.T) {
+	qd := &QemuDriver{}
+	for _, persisted := range []map[string]interface{}{
+		{"hypervisor": "xen", "domid": float64(1)},
+		{"hypervisor": "qemu", "pid": float64(1)},
+		{"hypervisor": "qemu", "qmpSock": "/tmp/qmp.sock"},
+		{"hypervisor": "qemu", "qmpSock": "/tmp/qmp.sock", "pid": "1"},
+	} {
+		if _, err := qd.LoadContext(persisted); err == nil {
+			t.Errorf("wrong persist info should be rejected: %v", persisted)
+		}
+	}
+}
+
+func TestStartQemu(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-qemu-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	errLog, exitFile := path.Join(dir, QemuErrLog), path.Join(dir, QemuExitFile)
+
+	proc, err := startQemu("/bin/sh", []string{"sh", "-c", "echo no kvm >&2; exit 3"}, errLog, exitFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the exit status is written once the process is waited for
+	var status []byte
+	for i := 0; i < 100; i++ {
+		if status, err = ioutil.ReadFile(exitFile); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(status) != "qemu exited with status 3" {
+		t.Errorf("the exit status of %d is %q, %v", proc.Pid, status, err)
+	}
+	if out, err := ioutil.ReadFile(errLog); err != nil || string(out) != "no kvm\n" {
+		t.Errorf("the stderr is %q, %v", out, err)
+	}
+}
@@ -0,0 +1,139 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	CastVersion = 2
+	// the size of the terminal until the client resizes it
+	CastWidth  = 80
+	CastHeight = 24
+)
+
+// CastHeader is the first line of a recording in the asciicast v2 format
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// SessionRecorder writes the input, the output and the window size changes
+// of an attach or exec session as asciicast v2 events.
+type SessionRecorder struct {
+	lock    sync.Mutex
+	w       io.WriteCloser
+	start   time.Time
+	pending map[string][]byte //incomplete utf-8 sequences of each stream
+	closed  bool
+}
+
+func NewSessionRecorder(w io.WriteCloser, title string) (*SessionRecorder, error) {
+	r := &SessionRecorder{
+		w:       w,
+		start:   time.Now(),
+		pending: make(map[string][]byte),
+	}
+	header, err := json.Marshal(&CastHeader{
+		Version:   CastVersion,
+		Width:     CastWidth,
+		Height:    CastHeight,
+		Timestamp: r.start.Unix(),
+		Title:     title,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(header, '\n')); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// splitUtf8 returns the complete utf-8 text of data, and the bytes of a
+// sequence cut at the end of it
+func splitUtf8(data []byte) (string, []byte) {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return string(data[:i]), data[i:]
+			}
+			break
+		}
+	}
+	return string(data), nil
+}
+
+func (r *SessionRecorder) event(kind string, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	text, rest := splitUtf8(append(r.pending[kind], data...))
+	r.pending[kind] = rest
+	if text == "" {
+		return
+	}
+	r.write(kind, text)
+}
+
+func (r *SessionRecorder) write(kind, text string) {
+	elapsed := time.Since(r.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, kind, text})
+	if err != nil {
+		return
+	}
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		r.closed = true
+		r.w.Close()
+	}
+}
+
+func (r *SessionRecorder) Output(data []byte) {
+	r.event("o", data)
+}
+
+func (r *SessionRecorder) Input(data []byte) {
+	r.event("i", data)
+}
+
+func (r *SessionRecorder) Resize(size *WindowSize) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.closed {
+		r.write("r", fmt.Sprintf("%dx%d", size.Column, size.Row))
+	}
+}
+
+func (r *SessionRecorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.w.Close()
+}
+
+// recordWriter records what is written to the stream of a session
+type recordWriter struct {
+	io.Writer
+	recorder *SessionRecorder
+}
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if n > 0 {
+		w.recorder.Output(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package hypervisor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestSessionRecorder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r, err := NewSessionRecorder(nopWriteCloser{buf}, "exec ls")
+	if err != nil {
+		t.Fatal("create recorder failed ", err.Error())
+	}
+	r.Output([]byte("hello\r\n"))
+	r.Input([]byte("ls\r"))
+	// a character cut between two reads is recorded once it is complete
+	r.Output([]byte("\xe4\xbd"))
+	r.Output([]byte("\xa0!"))
+	r.Resize(&WindowSize{Row: 40, Column: 120})
+	r.Close()
+	r.Output([]byte("after close"))
+
+	scanner := bufio.NewScanner(buf)
+	if !scanner.Scan() {
+		t.Fatal("no header is recorded")
+	}
+	var header CastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatal("broken header ", err.Error())
+	}
+	if header.Version != CastVersion || header.Width != CastWidth || header.Title != "exec ls" {
+		t.Errorf("wrong header %s", scanner.Text())
+	}
+
+	expected := [][2]string{{"o", "hello\r\n"}, {"i", "ls\r"}, {"o", "你!"}, {"r", "120x40"}}
+	for _, e := range expected {
+		if !scanner.Scan() {
+			t.Fatalf("event %v is not recorded", e)
+		}
+		var ev []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || len(ev) != 3 {
+			t.Fatalf("broken event %s", scanner.Text())
+		}
+		if ev[1].(string) != e[0] || ev[2].(string) != e[1] {
+			t.Errorf("should record %v, but got %s", e, scanner.Text())
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("nothing should be recorded after close, but got %s", scanner.Text())
+	}
+}
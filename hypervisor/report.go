@@ -1,6 +1,7 @@
 package hypervisor
 
 import (
+	"fmt"
 	"hyper/lib/glog"
 	"hyper/types"
 )
@@ -58,6 +59,15 @@ func (ctx *VmContext) reportPodFinished(result *PodFinished) {
 	}
 }
 
+func (ctx *VmContext) reportContainerExited(exit *ContainerExit) {
+	ctx.client <- &types.QemuResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_CONTAINER_EXITED,
+		Cause: fmt.Sprintf("container %s exited with %d", exit.Id, exit.ExitCode),
+		Data:  exit,
+	}
+}
+
 func (ctx *VmContext) reportSuccess(msg string, data interface{}) {
 	ctx.client <- &types.QemuResponse{
 		VmId:  ctx.Id,
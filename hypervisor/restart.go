@@ -0,0 +1,240 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/types"
+	"time"
+)
+
+const (
+	// an exited container is restarted at once the first time, then after
+	// a delay doubling from containerBackoffBase up to containerBackoffMax
+	containerBackoffBase = time.Second
+	containerBackoffMax  = 5 * time.Minute
+	// a container running this long before it exits is not crash looping
+	containerBackoffReset = 10 * time.Minute
+)
+
+// ContainerExit is the data of an E_CONTAINER_EXITED report, sent when a
+// container of a running pod exits. Restart tells whether the container is
+// going to be restarted by its restart policy, after Delay.
+type ContainerExit struct {
+	Id       string
+	ExitCode int
+	Restart  bool
+	Delay    time.Duration
+}
+
+// containerExitMsg is the message of INIT_CONTAINEREXIT
+type containerExitMsg struct {
+	Container string `json:"container"`
+	ExitCode  int    `json:"code"`
+}
+
+// containerState follows the exits and restarts of a container in the
+// running pod
+type containerState struct {
+	exited    bool
+	exitCode  int
+	backoff   time.Duration
+	startedAt time.Time
+	timer     *time.Timer
+}
+
+// shouldRestart applies the restart policy of a container to its exit code
+func shouldRestart(policy string, exitCode int) bool {
+	switch policy {
+	case "always":
+		return true
+	case "onFailure":
+		return exitCode != 0
+	}
+	return false
+}
+
+// nextBackoff returns the delay before the next restart of a container, and
+// doubles the one after it
+func (st *containerState) nextBackoff(now time.Time) time.Duration {
+	if !st.startedAt.IsZero() && now.Sub(st.startedAt) >= containerBackoffReset {
+		st.backoff = 0
+	}
+	delay := st.backoff
+	if st.backoff == 0 {
+		st.backoff = containerBackoffBase
+	} else if st.backoff *= 2; st.backoff > containerBackoffMax {
+		st.backoff = containerBackoffMax
+	}
+	return delay
+}
+
+func (ctx *VmContext) containerState(id string) *containerState {
+	st, ok := ctx.containers[id]
+	if !ok {
+		st = &containerState{}
+		ctx.containers[id] = st
+	}
+	return st
+}
+
+// containerExited applies the restart policy to a container which exited
+// in the running pod. The pod finishes once all of its containers exited
+// and none of them is going to be restarted.
+func (ctx *VmContext) containerExited(ev *ContainerExited) {
+	idx := ctx.Lookup(ev.Id)
+	if idx < 0 {
+		glog.Warningf("got the exit of unknown container %s", ev.Id)
+		return
+	}
+
+	st := ctx.containerState(ev.Id)
+	exit := &ContainerExit{
+		Id:       ev.Id,
+		ExitCode: ev.ExitCode,
+		Restart:  shouldRestart(ctx.vmSpec.Containers[idx].RestartPolicy, ev.ExitCode),
+	}
+	st.exited, st.exitCode = true, ev.ExitCode
+	if exit.Restart {
+		exit.Delay = st.nextBackoff(time.Now())
+		glog.Infof("container %s exited with %d, restart it in %s", ev.Id, ev.ExitCode, exit.Delay.String())
+	} else {
+		glog.Infof("container %s exited with %d", ev.Id, ev.ExitCode)
+	}
+	ctx.reportContainerExited(exit)
+
+	if !exit.Restart {
+		ctx.checkPodFinished()
+	} else if exit.Delay == 0 {
+		ctx.restartContainer(ev.Id, nil)
+	} else {
+		id := ev.Id
+		st.timer = time.AfterFunc(exit.Delay, func() {
+			defer func() { recover() }()
+			ctx.Hub <- &RestartContainerCommand{Id: id}
+		})
+	}
+}
+
+// checkPodFinished finishes the pod if none of its containers is running or
+// is going to be restarted.
+func (ctx *VmContext) checkPodFinished() {
+	result := make([]uint32, len(ctx.vmSpec.Containers))
+	for i, c := range ctx.vmSpec.Containers {
+		st, ok := ctx.containers[c.Id]
+		if !ok || !st.exited || st.timer != nil || ctx.restarting[c.Id] != nil {
+			return
+		}
+		result[i] = uint32(st.exitCode)
+	}
+	ctx.podFinished(&PodFinished{result: result})
+}
+
+// restartContainerCmd restarts a container of the running pod, the pending
+// restart of an exited container is done at once.
+func (ctx *VmContext) restartContainerCmd(cmd *RestartContainerCommand) {
+	cause := ""
+	if !ctx.InitHasCapability(INIT_CAP_RESTART) {
+		cause = "restarting containers is not supported by the init of the vm"
+	} else if ctx.Lookup(cmd.Id) < 0 {
+		cause = fmt.Sprintf("can not find container %s", cmd.Id)
+	} else if _, ok := ctx.hotplug[cmd.Id]; ok {
+		cause = fmt.Sprintf("container %s is being added or removed", cmd.Id)
+	} else if _, ok := ctx.restarting[cmd.Id]; ok {
+		cause = fmt.Sprintf("container %s is being restarted", cmd.Id)
+	}
+	if cause != "" {
+		if cmd.Callback != nil {
+			cmd.Callback <- &types.QemuResponse{
+				VmId:  ctx.Id,
+				Code:  types.E_BAD_REQUEST,
+				Cause: cause,
+			}
+		} else {
+			glog.Warning(cause)
+		}
+		return
+	}
+
+	st := ctx.containerState(cmd.Id)
+	if st.timer != nil {
+		st.timer.Stop()
+		st.timer = nil
+	} else if cmd.Callback == nil {
+		// the pending restart was cancelled
+		return
+	}
+	ctx.restartContainer(cmd.Id, cmd.Callback)
+}
+
+func (ctx *VmContext) restartContainer(id string, callback chan *types.QemuResponse) {
+	msg, err := json.Marshal(&containerTarget{Container: id})
+	if err != nil {
+		ctx.restartDone(id, fmt.Sprintf("command restart %s parse failed", id))
+		return
+	}
+	ctx.lock.Lock()
+	ctx.restarting[id] = &restartRequest{callback: callback}
+	ctx.lock.Unlock()
+	ctx.vm <- &DecodedMessage{
+		code:    INIT_RESTARTCONTAINER,
+		message: msg,
+	}
+}
+
+type restartRequest struct {
+	callback chan *types.QemuResponse
+}
+
+// restartAcked handles the reply of init to INIT_RESTARTCONTAINER, message
+// is the one the host sent.
+func (ctx *VmContext) restartAcked(message []byte, success bool) {
+	target := &containerTarget{}
+	json.Unmarshal(message, target)
+	if !success {
+		ctx.restartDone(target.Container, fmt.Sprintf("init failed to restart container %s", target.Container))
+		return
+	}
+	glog.Infof("container %s restarted", target.Container)
+	st := ctx.containerState(target.Container)
+	st.exited = false
+	st.startedAt = time.Now()
+	ctx.restartDone(target.Container, "")
+}
+
+// restartDone answers the caller of a restart. A container which failed to
+// restart stays exited, and may be the last one the pod was waiting for.
+func (ctx *VmContext) restartDone(id, cause string) {
+	ctx.lock.Lock()
+	req, ok := ctx.restarting[id]
+	delete(ctx.restarting, id)
+	ctx.lock.Unlock()
+
+	if cause != "" {
+		glog.Error(cause)
+	}
+	if ok && req.callback != nil {
+		code := types.E_OK
+		if cause != "" {
+			code = types.E_FAILED
+		}
+		req.callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  code,
+			Cause: cause,
+		}
+	}
+	if cause != "" {
+		ctx.checkPodFinished()
+	}
+}
+
+// stopRestarts cancels the pending restarts when the pod ends
+func (ctx *VmContext) stopRestarts() {
+	for _, st := range ctx.containers {
+		if st.timer != nil {
+			st.timer.Stop()
+			st.timer = nil
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package hypervisor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldRestart(t *testing.T) {
+	cases := []struct {
+		policy   string
+		exitCode int
+		restart  bool
+	}{
+		{"never", 0, false},
+		{"never", 1, false},
+		{"", 1, false},
+		{"onFailure", 0, false},
+		{"onFailure", 137, true},
+		{"always", 0, true},
+		{"always", 2, true},
+	}
+	for _, c := range cases {
+		if got := shouldRestart(c.policy, c.exitCode); got != c.restart {
+			t.Errorf("policy %q with exit code %d: restart is %v, should be %v", c.policy, c.exitCode, got, c.restart)
+		}
+	}
+}
+
+func TestContainerBackoff(t *testing.T) {
+	st := &containerState{}
+	now := time.Now()
+	expected := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second}
+	for i, e := range expected {
+		if d := st.nextBackoff(now); d != e {
+			t.Fatalf("restart %d: delay is %s, should be %s", i, d, e)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		st.nextBackoff(now)
+	}
+	if d := st.nextBackoff(now); d != containerBackoffMax {
+		t.Errorf("delay is %s, should be capped to %s", d, containerBackoffMax)
+	}
+
+	st.startedAt = now.Add(-containerBackoffReset)
+	if d := st.nextBackoff(now); d != 0 {
+		t.Errorf("delay after a long run is %s, should be 0", d)
+	}
+}
@@ -0,0 +1,109 @@
+package hypervisor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	TraceEntries   = 256 //entries kept in the trace of a VM
+	TraceClosedVms = 16  //traces kept after their VMs are gone
+)
+
+// TraceEntry is an event got by the state machine of a VM, or a transition
+// of its state
+type TraceEntry struct {
+	Time  time.Time
+	Event string //EventString of the event, empty for a transition
+	State string //the state handling the event, or the new state
+	From  string //the old state of a transition
+}
+
+func (e *TraceEntry) String() string {
+	t := e.Time.Format("15:04:05.000000")
+	if e.Event == "" {
+		return fmt.Sprintf("%s state %s -> %s", t, e.From, e.State)
+	}
+	return fmt.Sprintf("%s event %s in %s", t, e.Event, e.State)
+}
+
+type vmTrace struct {
+	lock    sync.Mutex
+	entries []TraceEntry
+	next    int
+}
+
+func (t *vmTrace) add(e TraceEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.entries) < TraceEntries {
+		t.entries = append(t.entries, e)
+		return
+	}
+	t.entries[t.next] = e
+	t.next = (t.next + 1) % TraceEntries
+}
+
+func (t *vmTrace) list() []TraceEntry {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	list := make([]TraceEntry, 0, len(t.entries))
+	list = append(list, t.entries[t.next:]...)
+	return append(list, t.entries[:t.next]...)
+}
+
+// the traces are kept out of the contexts, so the trace of a VM whose loop
+// is stuck, or which has been closed, could still be read
+var (
+	traces      = make(map[string]*vmTrace)
+	closedVms   = []string{}
+	tracesMutex sync.Mutex
+)
+
+func newTrace(vmId string) *vmTrace {
+	t := &vmTrace{}
+	tracesMutex.Lock()
+	traces[vmId] = t
+	tracesMutex.Unlock()
+	return t
+}
+
+// closeTrace keeps the trace of a closed VM until TraceClosedVms VMs have
+// been closed after it
+func closeTrace(vmId string) {
+	tracesMutex.Lock()
+	defer tracesMutex.Unlock()
+	closedVms = append(closedVms, vmId)
+	if len(closedVms) > TraceClosedVms {
+		delete(traces, closedVms[0])
+		closedVms = closedVms[1:]
+	}
+}
+
+// VmTrace returns the events and state transitions of a VM, the oldest first
+func VmTrace(vmId string) ([]TraceEntry, error) {
+	tracesMutex.Lock()
+	t, ok := traces[vmId]
+	tracesMutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("No trace of VM %s", vmId)
+	}
+	return t.list(), nil
+}
+
+func (ctx *VmContext) traceEvent(ev VmEvent) {
+	ctx.trace.add(TraceEntry{
+		Time:  time.Now(),
+		Event: EventString(ev.Event()),
+		State: ctx.current,
+	})
+}
+
+func (ctx *VmContext) traceTransition(from, to string) {
+	ctx.trace.add(TraceEntry{
+		Time:  time.Now(),
+		State: to,
+		From:  from,
+	})
+}
@@ -0,0 +1,57 @@
+package hypervisor
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTraceRing(t *testing.T) {
+	tr := &vmTrace{}
+	for i := 0; i < TraceEntries+3; i++ {
+		tr.add(TraceEntry{State: fmt.Sprintf("%d", i)})
+	}
+
+	list := tr.list()
+	if len(list) != TraceEntries {
+		t.Fatalf("should keep %d entries, but got %d", TraceEntries, len(list))
+	}
+	if list[0].State != "3" || list[len(list)-1].State != fmt.Sprintf("%d", TraceEntries+2) {
+		t.Errorf("the oldest entries should be dropped: %s ... %s", list[0].State, list[len(list)-1].State)
+	}
+}
+
+func TestVmTrace(t *testing.T) {
+	dr := &EmptyDriver{}
+	dr.Initialize()
+
+	ctx, err := InitContext(dr, "vmtrace", nil, nil, nil, &BootConfig{})
+	if err != nil {
+		t.Fatal("init context failed ", err.Error())
+	}
+	ctx.traceEvent(&VmTimeout{})
+	ctx.Become(stateStarting, "STARTING")
+	ctx.Close()
+
+	trace, err := VmTrace("vmtrace")
+	if err != nil {
+		t.Fatal("trace of a closed VM should be kept ", err.Error())
+	}
+	if len(trace) != 2 {
+		t.Fatalf("should trace 2 entries, but got %d", len(trace))
+	}
+	if trace[0].Event != "EVENT_VM_TIMEOUT" || trace[0].State != "INIT" {
+		t.Errorf("wrong event entry: %s", trace[0].String())
+	}
+	if trace[1].From != "INIT" || trace[1].State != "STARTING" {
+		t.Errorf("wrong transition entry: %s", trace[1].String())
+	}
+
+	for i := 0; i < TraceClosedVms; i++ {
+		id := fmt.Sprintf("vmtrace%d", i)
+		newTrace(id)
+		closeTrace(id)
+	}
+	if _, err := VmTrace("vmtrace"); err == nil {
+		t.Error("trace of an old closed VM should be dropped")
+	}
+}
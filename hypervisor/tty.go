@@ -9,6 +9,9 @@ import (
 	"sync"
 )
 
+// the recent output of a container kept for the clients attaching later
+const TtyHistorySize = 16 << 10
+
 type WindowSize struct {
 	Row    uint16 `json:"row"`
 	Column uint16 `json:"column"`
@@ -17,16 +20,73 @@ type WindowSize struct {
 type TtyIO struct {
 	Stdin     io.ReadCloser
 	Stdout    io.WriteCloser
+	Stderr    io.WriteCloser // nil if the client wants stderr merged into Stdout
 	ClientTag string
 	Callback  chan *types.QemuResponse
+	Recorder  *SessionRecorder // nil if the session is not recorded
+	// DetachKeys detach the client from the session, ExitChar if it is empty
+	DetachKeys []byte
+	// ReadOnly clients watch the session, their input and window size
+	// changes are dropped
+	ReadOnly bool
 }
 
 type ttyAttachments struct {
 	container   int
 	persistent  bool
+	waitFinish  bool   // closed by INIT_FINISHCMD instead of the empty message
+	stdio       bool   // the process has no pty, stdin EOF is passed to it
+	stderr      uint64 // session carrying the stderr of a stdio process
+	exitCode    int
+	finished    bool        // the exit code is reported by INIT_FINISHCMD
+	history     *outputRing // nil if the output is not kept
 	attachments []*TtyIO
 }
 
+// outputRing keeps the last bytes written to it
+type outputRing struct {
+	buf  []byte
+	next int
+	full bool
+}
+
+func newOutputRing(size int) *outputRing {
+	return &outputRing{buf: make([]byte, size)}
+}
+
+func (r *outputRing) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= len(r.buf) {
+		copy(r.buf, p[n-len(r.buf):])
+		r.next = 0
+		r.full = true
+		return n, nil
+	}
+	copied := copy(r.buf[r.next:], p)
+	if copied < n {
+		copy(r.buf, p[copied:])
+		r.full = true
+	}
+	if r.next+n >= len(r.buf) {
+		r.full = true
+	}
+	r.next = (r.next + n) % len(r.buf)
+	return n, nil
+}
+
+func (r *outputRing) Bytes() []byte {
+	if !r.full {
+		return append([]byte{}, r.buf[:r.next]...)
+	}
+	return append(append([]byte{}, r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
 type pseudoTtys struct {
 	channel chan *ttyMessage
 	ttys    map[uint64]*ttyAttachments
@@ -137,12 +197,27 @@ func waitPts(ctx *VmContext) {
 		}
 		if ta, ok := ctx.ptys.ttys[res.session]; ok {
 			if len(res.message) == 0 {
+				if ta.waitFinish {
+					glog.V(1).Infof("session %d output end, wait for the exit code", res.session)
+					continue
+				}
 				glog.V(1).Infof("session %d closed by peer, close pty", res.session)
 				ctx.ptys.Close(ctx, res.session)
 			} else {
-				for _, tty := range ta.attachments {
+				// the clients attaching after the output is kept get it
+				// replayed, the others get it here
+				ctx.ptys.lock.Lock()
+				if ta.history != nil {
+					ta.history.Write(res.message)
+				}
+				attachments := append([]*TtyIO{}, ta.attachments...)
+				ctx.ptys.lock.Unlock()
+				for _, tty := range attachments {
 					if tty.Stdout != nil {
 						_, err := tty.Stdout.Write(res.message)
+						if err == nil && tty.Recorder != nil {
+							tty.Recorder.Output(res.message)
+						}
 						if err != nil {
 							glog.V(1).Infof("fail to write session %d, close pty attachment", res.session)
 							ctx.ptys.Detach(ctx, res.session, tty)
@@ -155,11 +230,15 @@ func waitPts(ctx *VmContext) {
 }
 
 func newAttachments(idx int, persist bool) *ttyAttachments {
-	return &ttyAttachments{
+	ta := &ttyAttachments{
 		container:   idx,
 		persistent:  persist,
 		attachments: []*TtyIO{},
 	}
+	if persist {
+		ta.history = newOutputRing(TtyHistorySize)
+	}
+	return ta
 }
 
 func newAttachmentsWithTty(idx int, persist bool, tty *TtyIO) *ttyAttachments {
@@ -174,6 +253,29 @@ func (ta *ttyAttachments) attach(tty *TtyIO) {
 	ta.attachments = append(ta.attachments, tty)
 }
 
+// replay writes the kept output to a client about to attach
+func (ta *ttyAttachments) replay(tty *TtyIO) {
+	if ta.history == nil || tty.Stdout == nil {
+		return
+	}
+	data := ta.history.Bytes()
+	if len(data) == 0 {
+		return
+	}
+	if _, err := tty.Stdout.Write(data); err == nil && tty.Recorder != nil {
+		tty.Recorder.Output(data)
+	}
+}
+
+func (ta *ttyAttachments) readOnly(tag string) bool {
+	for _, t := range ta.attachments {
+		if t.ClientTag == tag {
+			return t.ReadOnly
+		}
+	}
+	return false
+}
+
 func (ta *ttyAttachments) detach(tty *TtyIO) {
 	at := []*TtyIO{}
 	detached := false
@@ -190,9 +292,13 @@ func (ta *ttyAttachments) detach(tty *TtyIO) {
 }
 
 func (ta *ttyAttachments) close() []string {
+	var code interface{}
+	if ta.finished {
+		code = ta.exitCode
+	}
 	tags := []string{}
 	for _, t := range ta.attachments {
-		tags = append(tags, t.Close())
+		tags = append(tags, t.close(code))
 	}
 	ta.attachments = []*TtyIO{}
 	return tags
@@ -202,17 +308,46 @@ func (ta *ttyAttachments) empty() bool {
 	return len(ta.attachments) == 0
 }
 
+// stderrTty is the attachment of tty to the stderr session of a stdio
+// process. The shared stream must survive the stderr session, so closing
+// it does nothing, and the stdout side reports the end of the command.
+func (tty *TtyIO) stderrTty() *TtyIO {
+	out := io.Writer(tty.Stderr)
+	if tty.Stderr == nil {
+		out = tty.Stdout
+	}
+	if tty.Recorder != nil {
+		out = recordWriter{out, tty.Recorder}
+	}
+	return &TtyIO{
+		Stdout:    nopWriteCloser{out},
+		ClientTag: tty.ClientTag,
+		ReadOnly:  tty.ReadOnly,
+	}
+}
+
 func (tty *TtyIO) Close() string {
+	return tty.close(nil)
+}
+
+// close releases the streams and reports the exit code of the command
+// the tty was attached to through the Callback, in the Data field. The
+// code is nil if it is not known, like when the init could not report it.
+func (tty *TtyIO) close(code interface{}) string {
 	if tty.Stdin != nil {
 		tty.Stdin.Close()
 	}
 	if tty.Stdout != nil {
 		tty.Stdout.Close()
 	}
+	if tty.Recorder != nil {
+		tty.Recorder.Close()
+	}
 	if tty.Callback != nil {
 		tty.Callback <- &types.QemuResponse{
 			Code:  types.E_EXEC_FINISH,
 			Cause: "Command finished",
+			Data:  code,
 		}
 	}
 	return tty.ClientTag
@@ -223,6 +358,9 @@ func (pts *pseudoTtys) Detach(ctx *VmContext, session uint64, tty *TtyIO) {
 		ctx.ptys.lock.Lock()
 		ta.detach(tty)
 		ctx.ptys.lock.Unlock()
+		if ta.stderr != 0 {
+			pts.Detach(ctx, ta.stderr, tty.stderrTty())
+		}
 		if !ta.persistent && ta.empty() {
 			ctx.ptys.Close(ctx, session)
 		}
@@ -239,13 +377,100 @@ func (pts *pseudoTtys) Close(ctx *VmContext, session uint64) {
 		for _, t := range tags {
 			ctx.clientDereg(t)
 		}
+		if ta.stderr != 0 {
+			pts.Close(ctx, ta.stderr)
+		}
 	}
 }
 
+// recordResize records the window size change of a session in the
+// recordings of the clients attached to it
+func (pts *pseudoTtys) recordResize(session uint64, size *WindowSize) {
+	pts.lock.Lock()
+	defer pts.lock.Unlock()
+	if ta, ok := pts.ttys[session]; ok {
+		for _, tty := range ta.attachments {
+			if tty.Recorder != nil {
+				tty.Recorder.Resize(size)
+			}
+		}
+	}
+}
+
+// readOnly reports whether the client of the tag watches the session only
+func (pts *pseudoTtys) readOnly(session uint64, tag string) bool {
+	pts.lock.Lock()
+	defer pts.lock.Unlock()
+	if ta, ok := pts.ttys[session]; ok {
+		return ta.readOnly(tag)
+	}
+	return false
+}
+
+func (pts *pseudoTtys) isStdio(session uint64) (stdio bool, persistent bool) {
+	pts.lock.Lock()
+	defer pts.lock.Unlock()
+	if ta, ok := pts.ttys[session]; ok {
+		return ta.stdio, ta.persistent
+	}
+	return false, false
+}
+
+// stdioSessions prepares the sessions of a process running without a pty,
+// the stdout (and stdin) one and the stderr one.
+func (pts *pseudoTtys) stdioSessions(container int, persist bool, session, stderr uint64) {
+	pts.lock.Lock()
+	ta, ok := pts.ttys[session]
+	if !ok {
+		ta = newAttachments(container, persist)
+		pts.ttys[session] = ta
+	}
+	ta.stdio = true
+	ta.stderr = stderr
+	if _, ok := pts.ttys[stderr]; !ok && stderr != 0 {
+		pts.ttys[stderr] = newAttachments(container, persist)
+	}
+	pts.lock.Unlock()
+}
+
+// stdioConnect connects a client to a process running without a pty, the
+// stderr of the process comes back on its own session.
+func (pts *pseudoTtys) stdioConnect(ctx *VmContext, container int, session, stderr uint64, tty *TtyIO) {
+	pts.stdioSessions(container, false, session, stderr)
+	if stderr != 0 {
+		pts.lock.Lock()
+		stderrTty := tty.stderrTty()
+		pts.ttys[stderr].replay(stderrTty)
+		pts.ttys[stderr].attach(stderrTty)
+		pts.lock.Unlock()
+	}
+	pts.ptyConnect(ctx, container, session, tty)
+}
+
+func (pts *pseudoTtys) waitFinish(session uint64) {
+	pts.lock.Lock()
+	if ta, ok := pts.ttys[session]; ok {
+		ta.waitFinish = true
+	}
+	pts.lock.Unlock()
+}
+
+// Finish closes an exec session once its process exited, reporting the
+// exit code to every attached client.
+func (pts *pseudoTtys) Finish(ctx *VmContext, session uint64, code int) {
+	pts.lock.Lock()
+	if ta, ok := pts.ttys[session]; ok {
+		ta.exitCode, ta.finished = code, true
+	}
+	pts.lock.Unlock()
+	pts.Close(ctx, session)
+}
+
 func (pts *pseudoTtys) ptyConnect(ctx *VmContext, container int, session uint64, tty *TtyIO) {
 
 	pts.lock.Lock()
 	if ta, ok := pts.ttys[session]; ok {
+		ta.replay(tty)
 		ta.attach(tty)
 	} else {
 		pts.ttys[session] = newAttachmentsWithTty(container, false, tty)
@@ -255,25 +480,50 @@ func (pts *pseudoTtys) ptyConnect(ctx *VmContext, container int, session uint64,
 	if tty.Stdin != nil {
 		go func() {
 			buf := make([]byte, 32)
-			defer pts.Detach(ctx, session, tty)
+			matcher := newDetachMatcher(tty.DetachKeys)
+			detach := true
+			defer func() {
+				if detach {
+					pts.Detach(ctx, session, tty)
+				}
+			}()
 			defer func() { recover() }()
 			for {
 				nr, err := tty.Stdin.Read(buf)
+				if err == io.EOF {
+					if stdio, persistent := pts.isStdio(session); stdio {
+						// the client may still be reading the output, only
+						// its stdin is finished
+						detach = false
+						if !persistent && !tty.ReadOnly {
+							glog.V(1).Infof("stdin of session %d closed, pass EOF to the process", session)
+							pts.channel <- &ttyMessage{
+								session: session,
+								message: []byte{},
+							}
+						}
+						return
+					}
+				}
 				if err != nil {
 					glog.Info("a stdin closed, ", err.Error())
 					return
-				} else if nr == 1 && buf[0] == ExitChar {
-					glog.Info("got stdin detach char, exit term")
-					return
 				}
 
-				glog.V(3).Infof("trying to input char: %d and %d chars", buf[0], nr)
-
-				mbuf := make([]byte, nr)
-				copy(mbuf, buf[:nr])
-				pts.channel <- &ttyMessage{
-					session: session,
-					message: mbuf[:nr],
+				input, detached := matcher.feed(buf[:nr])
+				if len(input) > 0 && !tty.ReadOnly {
+					glog.V(3).Infof("trying to input char: %d and %d chars", input[0], len(input))
+					if tty.Recorder != nil {
+						tty.Recorder.Input(input)
+					}
+					pts.channel <- &ttyMessage{
+						session: session,
+						message: input,
+					}
+				}
+				if detached {
+					glog.Info("got stdin detach keys, exit term")
+					return
 				}
 			}
 		}()
@@ -0,0 +1,33 @@
+package hypervisor
+
+import (
+	"testing"
+
+	"hyper/types"
+)
+
+func TestTtyExitCode(t *testing.T) {
+	for _, c := range []struct {
+		finished bool
+		code     int
+		data     interface{}
+	}{
+		{true, 0, 0},
+		{true, 3, 3},
+		// the init could not report the code, or the VM is gone
+		{false, 0, nil},
+	} {
+		tty := &TtyIO{ClientTag: "tag", Callback: make(chan *types.QemuResponse, 1)}
+		ta := &ttyAttachments{attachments: []*TtyIO{tty}}
+		if c.finished {
+			ta.exitCode, ta.finished = c.code, true
+		}
+		if tags := ta.close(); len(tags) != 1 || tags[0] != "tag" {
+			t.Errorf("the closed clients are %v", tags)
+		}
+		res := <-tty.Callback
+		if res.Code != types.E_EXEC_FINISH || res.Data != c.data {
+			t.Errorf("finished %v with %d: got %d %v, should be %v", c.finished, c.code, res.Code, res.Data, c.data)
+		}
+	}
+}
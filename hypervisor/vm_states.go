@@ -68,6 +68,10 @@ func (ctx *VmContext) prepareDevice(cmd *RunPodCommand) bool {
 
 func (ctx *VmContext) setWindowSize(tag string, size *WindowSize) {
 	if session, ok := ctx.ttySessions[tag]; ok {
+		if ctx.ptys.readOnly(session, tag) {
+			glog.V(1).Infof("drop the window size of read only client %s", tag)
+			return
+		}
 		cmd := map[string]interface{}{
 			"seq":    session,
 			"row":    size.Row,
@@ -82,6 +86,7 @@ func (ctx *VmContext) setWindowSize(tag string, size *WindowSize) {
 			code:    INIT_WINSIZE,
 			message: msg,
 		}
+		ctx.ptys.recordResize(session, size)
 	} else {
 		msg := fmt.Sprintf("cannot resolve client tag %s", tag)
 		ctx.reportBadRequest(msg)
@@ -91,6 +96,9 @@ func (ctx *VmContext) setWindowSize(tag string, size *WindowSize) {
 
 func (ctx *VmContext) execCmd(cmd *ExecCommand) {
 	cmd.Sequence = ctx.nextAttachId()
+	if !cmd.Tty {
+		cmd.Stderr = ctx.nextAttachId()
+	}
 	pkg, err := json.Marshal(*cmd)
 	if err != nil {
 		cmd.Streams.Callback <- &types.QemuResponse{
@@ -99,7 +107,14 @@ func (ctx *VmContext) execCmd(cmd *ExecCommand) {
 		}
 		return
 	}
-	ctx.ptys.ptyConnect(ctx, ctx.Lookup(cmd.Container), cmd.Sequence, cmd.Streams)
+	if cmd.Tty {
+		ctx.ptys.ptyConnect(ctx, ctx.Lookup(cmd.Container), cmd.Sequence, cmd.Streams)
+	} else {
+		ctx.ptys.stdioConnect(ctx, ctx.Lookup(cmd.Container), cmd.Sequence, cmd.Stderr, cmd.Streams)
+	}
+	if ctx.InitHasCapability(INIT_CAP_EXITCODE) {
+		ctx.ptys.waitFinish(cmd.Sequence)
+	}
 	ctx.clientReg(cmd.Streams.ClientTag, cmd.Sequence)
 	ctx.vm <- &DecodedMessage{
 		code:    INIT_EXECCMD,
@@ -107,9 +122,68 @@ func (ctx *VmContext) execCmd(cmd *ExecCommand) {
 	}
 }
 
+func (ctx *VmContext) fileTransferCmd(cmd *FileTransferCommand) {
+	idx := ctx.Lookup(cmd.Container)
+	if idx < 0 || !ctx.InitHasCapability(INIT_CAP_FILETRANSFER) {
+		cause := fmt.Sprintf("can not find container %s", cmd.Container)
+		if idx >= 0 {
+			cause = "file transfer is not supported by the init of the vm"
+		}
+		cmd.Streams.Callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  types.E_BAD_REQUEST,
+			Cause: cause,
+		}
+		return
+	}
+
+	cmd.Sequence = ctx.nextAttachId()
+	pkg, err := json.Marshal(*cmd)
+	if err != nil {
+		cmd.Streams.Callback <- &types.QemuResponse{
+			VmId: ctx.Id, Code: types.E_JSON_PARSE_FAIL,
+			Cause: fmt.Sprintf("file transfer %s parse failed", cmd.Path),
+		}
+		return
+	}
+	ctx.ptys.stdioConnect(ctx, idx, cmd.Sequence, 0, cmd.Streams)
+	ctx.ptys.waitFinish(cmd.Sequence)
+	ctx.vm <- &DecodedMessage{
+		code:    INIT_FILETRANSFER,
+		message: pkg,
+	}
+}
+
+func (ctx *VmContext) portForwardCmd(cmd *PortForwardCommand) {
+	if !ctx.InitHasCapability(INIT_CAP_PORTFORWARD) {
+		cmd.Streams.Callback <- &types.QemuResponse{
+			VmId:  ctx.Id,
+			Code:  types.E_BAD_REQUEST,
+			Cause: "port forwarding is not supported by the init of the vm",
+		}
+		return
+	}
+
+	cmd.Sequence = ctx.nextAttachId()
+	pkg, err := json.Marshal(*cmd)
+	if err != nil {
+		cmd.Streams.Callback <- &types.QemuResponse{
+			VmId: ctx.Id, Code: types.E_JSON_PARSE_FAIL,
+			Cause: fmt.Sprintf("port forward to %d parse failed", cmd.Port),
+		}
+		return
+	}
+	ctx.ptys.stdioConnect(ctx, -1, cmd.Sequence, 0, cmd.Streams)
+	ctx.vm <- &DecodedMessage{
+		code:    INIT_PORTFORWARD,
+		message: pkg,
+	}
+}
+
 func (ctx *VmContext) attachCmd(cmd *AttachCommand) {
 	idx := ctx.Lookup(cmd.Container)
-	if idx < 0 || idx > len(ctx.vmSpec.Containers) || ctx.vmSpec.Containers[idx].Tty == 0 {
+	if idx < 0 || idx > len(ctx.vmSpec.Containers) ||
+		(ctx.vmSpec.Containers[idx].Tty == 0 && ctx.vmSpec.Containers[idx].Stdio == 0) {
 		ctx.reportBadRequest(fmt.Sprintf("tty is not configured for %s", cmd.Container))
 		cmd.Streams.Callback <- &types.QemuResponse{
 			VmId:  ctx.Id,
@@ -119,9 +193,18 @@ func (ctx *VmContext) attachCmd(cmd *AttachCommand) {
 		}
 		return
 	}
-	session := ctx.vmSpec.Containers[idx].Tty
-	glog.V(1).Infof("Connecting tty for %s on session %d", cmd.Container, session)
-	ctx.ptys.ptyConnect(ctx, idx, session, cmd.Streams)
+	container := ctx.vmSpec.Containers[idx]
+	if container.Tty != 0 {
+		glog.V(1).Infof("Connecting tty for %s on session %d", cmd.Container, container.Tty)
+		ctx.ptys.ptyConnect(ctx, idx, container.Tty, cmd.Streams)
+	} else {
+		glog.V(1).Infof("Connecting stdio for %s on session %d/%d", cmd.Container, container.Stdio, container.Stderr)
+		ctx.ptys.stdioConnect(ctx, idx, container.Stdio, container.Stderr, cmd.Streams)
+	}
+	session := container.Tty
+	if session == 0 {
+		session = container.Stdio
+	}
 	ctx.clientReg(cmd.Streams.ClientTag, session)
 	if cmd.Size != nil {
 		ctx.setWindowSize(cmd.Streams.ClientTag, cmd.Size)
@@ -129,6 +212,12 @@ func (ctx *VmContext) attachCmd(cmd *AttachCommand) {
 }
 
 func (ctx *VmContext) startPod() {
+	if len(ctx.vmSpec.Sysctl) > 0 && !ctx.InitHasCapability(INIT_CAP_SYSCTL) {
+		ctx.Hub <- &InitFailedEvent{
+			Reason: "the init of the VM could not set sysctls",
+		}
+		return
+	}
 	pod, err := json.Marshal(*ctx.vmSpec)
 	if err != nil {
 		ctx.Hub <- &InitFailedEvent{
@@ -142,7 +231,17 @@ func (ctx *VmContext) startPod() {
 	}
 }
 
+// podFinished reports the exit codes of the containers and shuts down the
+// VM once all of them exited.
+func (ctx *VmContext) podFinished(result *PodFinished) {
+	ctx.stopRestarts()
+	ctx.reportPodFinished(result)
+	ctx.shutdownVM(false, "")
+	ctx.Become(stateTerminating, "TERMINATING")
+}
+
 func (ctx *VmContext) stopPod() {
+	ctx.stopRestarts()
 	ctx.setTimeout(30)
 	ctx.vm <- &DecodedMessage{
 		code:    INIT_STOPPOD,
@@ -186,6 +285,9 @@ func commonStateHandler(ctx *VmContext, ev VmEvent, hasPod bool) bool {
 	case EVENT_VM_EXIT:
 		glog.Info("Got VM shutdown event, go to cleaning up")
 		ctx.unsetTimeout()
+		if !ctx.booted() {
+			ctx.reportBootFailed("VM exited before the init was ready")
+		}
 		if closed := ctx.onQemuExit(hasPod); !closed {
 			ctx.Become(stateDestroying, "DESTROYING")
 		}
@@ -195,6 +297,11 @@ func commonStateHandler(ctx *VmContext, ev VmEvent, hasPod bool) bool {
 	case COMMAND_SHUTDOWN:
 		glog.Info("got shutdown command, shutting down")
 		ctx.exitVM(false, "", hasPod, ev.(*ShutdownCommand).Wait)
+	case EVENT_VM_BOOT_TIMEOUT:
+		if !ctx.booted() {
+			glog.Errorf("VM %s did not boot in time", ctx.Id)
+			ctx.bootFailed("boot timeout", hasPod)
+		}
 	default:
 		processed = false
 	}
@@ -261,7 +368,7 @@ func deviceRemoveHandler(ctx *VmContext, ev VmEvent) (bool, bool) {
 		}
 
 		glog.V(1).Infof("release %d interface: %s", n.Index, nic.IpAddr)
-		go ReleaseInterface(n.Index, nic.IpAddr, nic.Fd, maps, ctx.Hub)
+		go ReleaseInterface(n.Index, nic.Network, nic.IpAddr, nic.Fd, nicPortMaps(n.Index, maps), ctx.Hub)
 	default:
 		processed = false
 	}
@@ -289,6 +396,8 @@ func initFailureHandler(ctx *VmContext, ev VmEvent) bool {
 func stateInit(ctx *VmContext, ev VmEvent) {
 	if processed := commonStateHandler(ctx, ev, false); processed {
 		//processed by common
+	} else if ev.Event() == ERROR_INIT_FAIL && !ctx.booted() {
+		ctx.bootFailed(ev.(*InitFailedEvent).Reason, false)
 	} else if processed := initFailureHandler(ctx, ev); processed {
 		ctx.shutdownVM(true, "Fail during init environment")
 		ctx.Become(stateDestroying, "DESTROYING")
@@ -296,10 +405,15 @@ func stateInit(ctx *VmContext, ev VmEvent) {
 		switch ev.Event() {
 		case EVENT_VM_START_FAILED:
 			glog.Error("Qemu did not start up properly, go to cleaning up")
-			ctx.reportVmFault("Qemu did not start up properly, go to cleaning up")
+			if ctx.booted() {
+				ctx.reportVmFault("Qemu did not start up properly, go to cleaning up")
+			} else {
+				ctx.reportBootFailed(ev.(*VmStartFailEvent).Message)
+			}
 			ctx.Close()
 		case EVENT_INIT_CONNECTED:
 			glog.Info("begin to wait vm commands")
+			ctx.bootReady()
 			ctx.reportVmRun()
 		case COMMAND_RELEASE:
 			glog.Info("no pod on vm, got release, quit.")
@@ -313,8 +427,13 @@ func stateInit(ctx *VmContext, ev VmEvent) {
 			ctx.setWindowSize(cmd.ClientTag, cmd.Size)
 		case COMMAND_RUN_POD, COMMAND_REPLACE_POD:
 			glog.Info("got spec, prepare devices")
+			ctx.bootPhaseBegin(BootPhaseDevice)
 			if ok := ctx.prepareDevice(ev.(*RunPodCommand)); ok {
-				ctx.setTimeout(60)
+				// the pod start deadline counts from the init ready, the
+				// boot has a deadline of its own
+				if ctx.booted() {
+					ctx.setTimeout(60)
+				}
 				ctx.Become(stateStarting, "STARTING")
 			}
 		default:
@@ -329,8 +448,14 @@ func stateStarting(ctx *VmContext, ev VmEvent) {
 	} else if processed := deviceInitHandler(ctx, ev); processed {
 		if ctx.deviceReady() {
 			glog.V(1).Info("device ready, could run pod.")
-			ctx.startPod()
+			ctx.bootPhaseEnd(BootPhaseDevice)
+			// the pod is started once the capabilities of the init are known
+			if ctx.booted() {
+				ctx.startPod()
+			}
 		}
+	} else if ev.Event() == ERROR_INIT_FAIL && !ctx.booted() {
+		ctx.bootFailed(ev.(*InitFailedEvent).Reason, true)
 	} else if processed := initFailureHandler(ctx, ev); processed {
 		ctx.shutdownVM(true, "Fail during init pod running environment")
 		ctx.Become(stateTerminating, "TERMINATING")
@@ -338,12 +463,18 @@ func stateStarting(ctx *VmContext, ev VmEvent) {
 		switch ev.Event() {
 		case EVENT_VM_START_FAILED:
 			glog.Info("Qemu did not start up properly, go to cleaning up")
+			ctx.reportBootFailed(ev.(*VmStartFailEvent).Message)
 			if closed := ctx.onQemuExit(true); !closed {
 				ctx.Become(stateDestroying, "DESTROYING")
 			}
 		case EVENT_INIT_CONNECTED:
 			glog.Info("begin to wait vm commands")
+			ctx.bootReady()
+			ctx.setTimeout(60)
 			ctx.reportVmRun()
+			if ctx.deviceReady() {
+				ctx.startPod()
+			}
 		case COMMAND_RELEASE:
 			glog.Info("pod starting, got release, please wait")
 			ctx.reportBusy("")
@@ -367,7 +498,11 @@ func stateStarting(ctx *VmContext, ev VmEvent) {
 						pinfo = buf
 					}
 				}
-				ctx.reportSuccess("Start POD success", pinfo)
+				msg := "Start POD success"
+				if timings := ctx.bootTimings(); timings != "" {
+					msg += ", boot timings: " + timings
+				}
+				ctx.reportSuccess(msg, pinfo)
 				ctx.Become(stateRunning, "RUNNING")
 				glog.Info("pod start success ", string(ack.msg))
 			}
@@ -406,6 +541,24 @@ func stateRunning(ctx *VmContext, ev VmEvent) {
 			ctx.reportSuccess("", nil)
 		case COMMAND_EXEC:
 			ctx.execCmd(ev.(*ExecCommand))
+		case COMMAND_FILE_TRANSFER:
+			ctx.fileTransferCmd(ev.(*FileTransferCommand))
+		case COMMAND_PORT_FORWARD:
+			ctx.portForwardCmd(ev.(*PortForwardCommand))
+		case COMMAND_NEW_CONTAINER:
+			ctx.newContainerCmd(ev.(*NewContainerCommand))
+		case COMMAND_REMOVE_CONTAINER:
+			ctx.removeContainerCmd(ev.(*RemoveContainerCommand))
+		case COMMAND_ATTACH_VOLUME:
+			ctx.attachVolumeCmd(ev.(*AttachVolumeCommand))
+		case COMMAND_DETACH_VOLUME:
+			ctx.detachVolumeCmd(ev.(*DetachVolumeCommand))
+		case EVENT_BLOCK_INSERTED:
+			ctx.hotplugInserted(ev.(*BlockdevInsertedEvent))
+		case EVENT_CONTAINER_RELEASED:
+			ctx.containerReleased(ev.(*ContainerReleased))
+		case EVENT_VOLUME_RELEASED:
+			ctx.volumeReleased(ev.(*VolumeReleased))
 		case COMMAND_ATTACH:
 			ctx.attachCmd(ev.(*AttachCommand))
 		case COMMAND_WINDOWSIZE:
@@ -413,21 +566,48 @@ func stateRunning(ctx *VmContext, ev VmEvent) {
 			if ctx.userSpec.Tty {
 				ctx.setWindowSize(cmd.ClientTag, cmd.Size)
 			}
+		case EVENT_EXEC_FINISH:
+			finish := ev.(*ExecFinished)
+			ctx.ptys.Finish(ctx, finish.Seq, finish.ExitCode)
+		case COMMAND_RESTART_CONTAINER:
+			ctx.restartContainerCmd(ev.(*RestartContainerCommand))
+		case EVENT_CONTAINER_EXIT:
+			ctx.containerExited(ev.(*ContainerExited))
 		case EVENT_POD_FINISH:
-			result := ev.(*PodFinished)
-			ctx.reportPodFinished(result)
-			ctx.shutdownVM(false, "")
-			ctx.Become(stateTerminating, "TERMINATING")
+			ctx.podFinished(ev.(*PodFinished))
 		case COMMAND_ACK:
 			ack := ev.(*CommandAck)
 			glog.V(1).Infof("[running] got init ack to %d", ack.reply)
+			if ack.reply == INIT_NEWCONTAINER || ack.reply == INIT_REMOVECONTAINER {
+				ctx.hotplugAcked(ack.reply, ack.context.message, true)
+			} else if ack.reply == INIT_ATTACHVOLUME || ack.reply == INIT_DETACHVOLUME {
+				ctx.volumeAcked(ack.reply, ack.context.message, true)
+			} else if ack.reply == INIT_RESTARTCONTAINER {
+				ctx.restartAcked(ack.context.message, true)
+			}
 		case ERROR_CMD_FAIL:
 			ack := ev.(*CommandError)
 			if ack.context.code == INIT_EXECCMD {
 				cmd := ExecCommand{}
 				json.Unmarshal(ack.context.message, &cmd)
-				ctx.ptys.Close(ctx, cmd.Sequence)
+				ctx.ptys.Finish(ctx, cmd.Sequence, ExecStartFailed)
 				glog.V(0).Infof("Exec command %s on session %d failed", cmd.Command[0], cmd.Sequence)
+			} else if ack.context.code == INIT_FILETRANSFER {
+				cmd := FileTransferCommand{}
+				json.Unmarshal(ack.context.message, &cmd)
+				ctx.ptys.Finish(ctx, cmd.Sequence, ExecStartFailed)
+				glog.V(0).Infof("File transfer of %s on session %d failed", cmd.Path, cmd.Sequence)
+			} else if ack.context.code == INIT_PORTFORWARD {
+				cmd := PortForwardCommand{}
+				json.Unmarshal(ack.context.message, &cmd)
+				ctx.ptys.Finish(ctx, cmd.Sequence, ExecStartFailed)
+				glog.V(0).Infof("Port forward to %d on session %d failed", cmd.Port, cmd.Sequence)
+			} else if ack.context.code == INIT_NEWCONTAINER || ack.context.code == INIT_REMOVECONTAINER {
+				ctx.hotplugAcked(ack.context.code, ack.context.message, false)
+			} else if ack.context.code == INIT_ATTACHVOLUME || ack.context.code == INIT_DETACHVOLUME {
+				ctx.volumeAcked(ack.context.code, ack.context.message, false)
+			} else if ack.context.code == INIT_RESTARTCONTAINER {
+				ctx.restartAcked(ack.context.message, false)
 			}
 		default:
 			glog.Warning("got unexpected event during pod running")
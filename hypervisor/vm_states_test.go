@@ -0,0 +1,43 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"testing"
+
+	"hyper/types"
+)
+
+func TestPortForwardCmd(t *testing.T) {
+	dr := &EmptyDriver{}
+	dr.Initialize()
+	ctx, _ := InitContext(dr, "vmid", nil, nil, nil, &BootConfig{CPU: 1, Memory: 128})
+
+	// an init without the capability could not forward
+	cmd := &PortForwardCommand{Port: 80, Streams: &TtyIO{Callback: make(chan *types.QemuResponse, 1)}}
+	ctx.portForwardCmd(cmd)
+	select {
+	case res := <-cmd.Streams.Callback:
+		if res.Code != types.E_BAD_REQUEST {
+			t.Errorf("the forward is rejected with %d", res.Code)
+		}
+	default:
+		t.Fatal("the forward is not rejected")
+	}
+	select {
+	case msg := <-ctx.vm:
+		t.Errorf("%d is sent to the init", msg.code)
+	default:
+	}
+
+	ctx.setInitProtocol(INIT_PROTOCOL_V1, []string{INIT_CAP_PORTFORWARD})
+	cmd = &PortForwardCommand{Port: 80, Streams: &TtyIO{Callback: make(chan *types.QemuResponse, 1)}}
+	ctx.portForwardCmd(cmd)
+	msg := <-ctx.vm
+	var sent PortForwardCommand
+	if msg.code != INIT_PORTFORWARD || json.Unmarshal(msg.message, &sent) != nil || sent.Port != 80 {
+		t.Errorf("%d %s is sent to the init", msg.code, msg.message)
+	}
+	if _, ok := ctx.ptys.ttys[cmd.Sequence]; !ok {
+		t.Error("the stream is not connected to the session")
+	}
+}
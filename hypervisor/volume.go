@@ -134,3 +134,19 @@ func supportOverlay() bool {
 	}
 	return false
 }
+
+// UmountContainerDir unmounts the aufs or overlay rootfs of a single
+// container from the share dir, for containers removed from a running pod.
+func UmountContainerDir(shareDir, image string) bool {
+	done := make(chan VmEvent, 1)
+	success := true
+	if supportAufs() {
+		UmountAufsContainer(shareDir, image, -1, done)
+		success = (<-done).(*ContainerUnmounted).Success
+	}
+	if success && supportOverlay() {
+		UmountOverlayContainer(shareDir, image, -1, done)
+		success = (<-done).(*ContainerUnmounted).Success
+	}
+	return success
+}
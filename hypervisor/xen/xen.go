@@ -44,6 +44,9 @@ type DomainConfig struct {
 
 var globalDriver *XenDriver = nil
 
+// domainCheck tells whether a domain exists, 0 if it does
+var domainCheck = HyperxlDomainCheck
+
 func InitDriver() *XenDriver {
 	xd := &XenDriver{}
 	if err := xd.Initialize(); err == nil {
@@ -122,12 +125,16 @@ func (xd *XenDriver) LoadContext(persisted map[string]interface{}) (hypervisor.D
 		return nil, errors.New("cannot read the dom id info from persist info")
 	} else {
 		switch d.(type) {
-		case float64:
-			domid = (int)(d.(float64))
+		case int, float64:
+			if id, ok := d.(int); ok {
+				domid = id
+			} else {
+				domid = (int)(d.(float64))
+			}
 			if domid <= 0 {
 				return nil, fmt.Errorf("loaded wrong domid %d", domid)
 			}
-			if HyperxlDomainCheck(xd.Ctx, (uint32)(domid)) != 0 {
+			if domainCheck(xd.Ctx, (uint32)(domid)) != 0 {
 				return nil, fmt.Errorf("cannot load domain %d, not exist", domid)
 			}
 		default:
@@ -158,6 +165,7 @@ func (xc *XenContext) Launch(ctx *hypervisor.VmContext) {
 	xc.ev = ev
 	glog.Infof("Start VM as domain %d", domid)
 	xc.driver.domains[(uint32)(domid)] = ctx
+	ctx.BootPhaseDone("xen start")
 	//    }()
 }
 
@@ -228,7 +236,7 @@ func (xc *XenContext) AddNic(ctx *hypervisor.VmContext, host *hypervisor.HostNic
 
 				glog.V(1).Infof("nic %s insert succeeded", guest.Device)
 
-				err = network.UpAndAddToBridge(fmt.Sprintf("vif%d.%d", xc.domId, guest.Index))
+				err = network.UpAndAddToBridge(fmt.Sprintf("vif%d.%d", xc.domId, guest.Index), host.Bridge)
 				if err != nil {
 					glog.Error("fail to add vif to bridge: ", err.Error())
 					ctx.Hub <- &hypervisor.DeviceFailed{
@@ -313,7 +321,7 @@ func XlStartDomain(ctx LibxlCtxPtr, id string, boot *hypervisor.BootConfig, hype
 		Name:        id,
 		Kernel:      boot.Kernel,
 		Initrd:      boot.Initrd,
-		Cmdline:     "console=ttyS0 pci=nomsi",
+		Cmdline:     boot.KernelCmdline("console=ttyS0 pci=nomsi"),
 		MaxVcpus:    boot.CPU,
 		MaxMemory:   boot.Memory << 10,
 		ConsoleSock: fmt.Sprintf("unix:%s,server,nowait", consoleSock),
@@ -0,0 +1,85 @@
+// +build linux
+
+package xen
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// fakeDomains replaces the libxl domain check with the domains given
+func fakeDomains(domids ...uint32) func() {
+	check := domainCheck
+	domainCheck = func(ctx LibxlCtxPtr, domid uint32) int {
+		for _, id := range domids {
+			if id == domid {
+				return 0
+			}
+		}
+		return -1
+	}
+	return func() { domainCheck = check }
+}
+
+func TestXenPersist(t *testing.T) {
+	defer fakeDomains(5)()
+
+	xd := &XenDriver{}
+	xc := &XenContext{driver: xd, domId: 5}
+
+	persisted, err := xc.Dump()
+	if err != nil {
+		t.Fatal("dump failed ", err.Error())
+	}
+	buf, err := json.Marshal(persisted)
+	if err != nil {
+		t.Fatal("marshal failed ", err.Error())
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal("unmarshal failed ", err.Error())
+	}
+
+	dc, err := xd.LoadContext(decoded)
+	if err != nil {
+		t.Fatal("load context failed ", err.Error())
+	}
+	loaded := dc.(*XenContext)
+	if loaded.domId != xc.domId || loaded.driver != xd {
+		t.Errorf("context changed after a round trip: %d", loaded.domId)
+	}
+
+	again, err := loaded.Dump()
+	if err != nil {
+		t.Fatal("dump of the loaded context failed ", err.Error())
+	}
+	if buf2, _ := json.Marshal(again); string(buf2) != string(buf) {
+		t.Errorf("persist info changed after a round trip:\n%s\n%s", buf, buf2)
+	}
+
+	// the context dumped in memory keeps the domid an int
+	if _, err := xd.LoadContext(persisted); err != nil {
+		t.Error("load undecoded context failed ", err.Error())
+	}
+}
+
+func TestXenLoadWrongContext(t *testing.T) {
+	defer fakeDomains(5)()
+
+	xd := &XenDriver{}
+	for _, persisted := range []map[string]interface{}{
+		{"hypervisor": "qemu", "domid": float64(5)},
+		{"hypervisor": "xen"},
+		{"hypervisor": "xen", "domid": "5"},
+		{"hypervisor": "xen", "domid": float64(0)},
+		// the domain is gone
+		{"hypervisor": "xen", "domid": float64(6)},
+	} {
+		if _, err := xd.LoadContext(persisted); err == nil {
+			t.Errorf("wrong persist info should be rejected: %v", persisted)
+		}
+	}
+	if _, err := (&XenContext{driver: xd}).Dump(); err == nil {
+		t.Error("a context without domain is dumped")
+	}
+}
@@ -17,6 +17,9 @@ const (
 var (
 	beginPortRange = DefaultPortRangeStart
 	endPortRange   = DefaultPortRangeEnd
+	portRangeLock  sync.RWMutex
+	// the range set up by init, restored by ResetPortRange
+	systemPortRange = [2]int{DefaultPortRangeStart, DefaultPortRangeEnd}
 )
 
 type portMap struct {
@@ -25,9 +28,10 @@ type portMap struct {
 }
 
 func newPortMap() *portMap {
+	_, end := PortRange()
 	return &portMap{
 		p:    map[int]struct{}{},
-		last: endPortRange,
+		last: end,
 	}
 }
 
@@ -99,12 +103,36 @@ func init() {
 	}
 	beginPortRange = start
 	endPortRange = end
+	systemPortRange = [2]int{start, end}
 }
 
 func PortRange() (int, int) {
+	portRangeLock.RLock()
+	defer portRangeLock.RUnlock()
 	return beginPortRange, endPortRange
 }
 
+// SetPortRange changes the range of the ports given out when no port is
+// requested. The ports allocated already are kept.
+func SetPortRange(begin, end int) error {
+	if begin <= 0 || end > 65535 || begin > end {
+		return fmt.Errorf("invalid port range %d-%d", begin, end)
+	}
+	portRangeLock.Lock()
+	beginPortRange = begin
+	endPortRange = end
+	portRangeLock.Unlock()
+	return nil
+}
+
+// ResetPortRange restores the range of the ports to the ephemeral port
+// range of the system.
+func ResetPortRange() {
+	portRangeLock.Lock()
+	beginPortRange, endPortRange = systemPortRange[0], systemPortRange[1]
+	portRangeLock.Unlock()
+}
+
 func (e ErrPortAlreadyAllocated) IP() string {
 	return e.ip
 }
@@ -182,7 +210,12 @@ func (p *PortAllocator) ReleaseAll() error {
 }
 
 func (pm *portMap) findPort() (int, error) {
+	beginPortRange, endPortRange := PortRange()
 	port := pm.last
+	if port < beginPortRange || port > endPortRange {
+		// the range has changed since the last port
+		port = endPortRange
+	}
 	for i := 0; i <= endPortRange-beginPortRange; i++ {
 		port++
 		if port > endPortRange {
@@ -0,0 +1,58 @@
+package stdcopy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNewStdWriter(t *testing.T) {
+	writer := NewStdWriter(ioutil.Discard, Stdout)
+	if writer == nil {
+		t.Fatalf("NewStdWriter with an invalid StdType should not return nil.")
+	}
+}
+
+func TestWriteWithUnitializedStdWriter(t *testing.T) {
+	writer := StdWriter{
+		Writer:  nil,
+		prefix:  Stdout,
+		sizeBuf: make([]byte, 4),
+	}
+	n, err := writer.Write([]byte("Something here"))
+	if n != 0 || err == nil {
+		t.Fatalf("Should fail when given an uncomplete or uninitialized StdWriter")
+	}
+}
+
+func TestStdCopyDemultiplex(t *testing.T) {
+	var (
+		mux    bytes.Buffer
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	)
+	NewStdWriter(&mux, Stdout).Write([]byte("out 1\n"))
+	NewStdWriter(&mux, Stderr).Write([]byte("err 1\n"))
+	NewStdWriter(&mux, Stdout).Write(bytes.Repeat([]byte{0, 'x'}, 40*1024))
+
+	written, err := StdCopy(&stdout, &stderr, &mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(6+6+80*1024) {
+		t.Fatalf("written %d bytes", written)
+	}
+	if !bytes.HasPrefix(stdout.Bytes(), []byte("out 1\n")) || stdout.Len() != 6+80*1024 {
+		t.Fatalf("unexpected stdout, %d bytes", stdout.Len())
+	}
+	if stderr.String() != "err 1\n" {
+		t.Fatalf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestStdCopyInvalidHeader(t *testing.T) {
+	src := bytes.NewReader([]byte{5, 0, 0, 0, 0, 0, 0, 1, 'x'})
+	if _, err := StdCopy(ioutil.Discard, ioutil.Discard, src); err != ErrInvalidStdHeader {
+		t.Fatalf("expected invalid header error, got %v", err)
+	}
+}
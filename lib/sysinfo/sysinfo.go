@@ -1,5 +1,7 @@
 package sysinfo
 
+import "runtime"
+
 type CpuInfo struct {
 	Processor       uint64
 	Vender_id       string
@@ -91,6 +93,11 @@ func GetCpuInfo() (*CpuInfo, error) {
 	return getCpuInfo()
 }
 
+// GetCpuNum returns the number of the CPUs usable by the process
+func GetCpuNum() int {
+	return runtime.NumCPU()
+}
+
 func GetMemInfo() (*MemInfo, error) {
 	return getMemInfo()
 }
@@ -78,18 +78,62 @@ func (a *IPAllocator) RegisterSubnet(network *net.IPNet, subnet *net.IPNet) erro
 		return ErrNetworkAlreadyRegistered
 	}
 	n := newAllocatedMap(network)
+	if err := n.setRange(network, subnet); err != nil {
+		return err
+	}
+	a.allocatedIPs[key] = n
+	return nil
+}
+
+// UnregisterSubnet removes network from the allocator with the ips
+// allocated from it
+func (a *IPAllocator) UnregisterSubnet(network *net.IPNet) {
+	a.mutex.Lock()
+	delete(a.allocatedIPs, network.String())
+	a.mutex.Unlock()
+}
+
+// SetRange changes the bounds of the ips given out of network to subnet,
+// or to the full network range if subnet is nil. It applies to the next
+// RequestIP, the ips allocated already are kept.
+func (a *IPAllocator) SetRange(network *net.IPNet, subnet *net.IPNet) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := network.String()
+	n, ok := a.allocatedIPs[key]
+	if !ok {
+		n = newAllocatedMap(network)
+	}
+	if subnet == nil {
+		subnet = network
+	}
+	if err := n.setRange(network, subnet); err != nil {
+		return err
+	}
+	a.allocatedIPs[key] = n
+	return nil
+}
+
+// CheckRange tells whether subnet could be the bounds of the ips given out
+// of network, without changing anything
+func CheckRange(network *net.IPNet, subnet *net.IPNet) error {
+	return newAllocatedMap(network).setRange(network, subnet)
+}
+
+func (allocated *allocatedMap) setRange(network *net.IPNet, subnet *net.IPNet) error {
+	full := newAllocatedMap(network)
 	beginIP, endIP := NetworkRange(subnet)
 	begin := big.NewInt(0).Add(ipToBigInt(beginIP), big.NewInt(1))
 	end := big.NewInt(0).Sub(ipToBigInt(endIP), big.NewInt(1))
 
 	// Check that subnet is within network
-	if !(begin.Cmp(n.begin) >= 0 && end.Cmp(n.end) <= 0 && begin.Cmp(end) == -1) {
+	if !(begin.Cmp(full.begin) >= 0 && end.Cmp(full.end) <= 0 && begin.Cmp(end) == -1) {
 		return ErrBadSubnet
 	}
-	n.begin.Set(begin)
-	n.end.Set(end)
-	n.last.Sub(begin, big.NewInt(1))
-	a.allocatedIPs[key] = n
+	allocated.begin.Set(begin)
+	allocated.end.Set(end)
+	allocated.last.Sub(begin, big.NewInt(1))
 	return nil
 }
 
@@ -126,6 +170,19 @@ func (a *IPAllocator) ReleaseIP(network *net.IPNet, ip net.IP) error {
 	return nil
 }
 
+// Allocated reports whether the ip is allocated in any of the networks
+func (a *IPAllocator) Allocated(ip net.IP) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, allocated := range a.allocatedIPs {
+		if _, ok := allocated.p[ip.String()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
 	if _, ok := allocated.p[ip.String()]; ok {
 		return nil, ErrIPAlreadyAllocated
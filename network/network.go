@@ -115,10 +115,12 @@ type ifaces struct {
 	sync.Mutex
 }
 
-func setupIPTables(addr net.Addr) error {
+// setupBridgeIPTables enables NAT for the addresses of a bridge, and
+// forwards the packets to it through the HYPER chain
+func setupBridgeIPTables(bridge string, addr net.Addr) error {
 	// Enable NAT
 
-	natArgs := []string{"-s", addr.String(), "!", "-o", BridgeIface, "-j", "MASQUERADE"}
+	natArgs := []string{"-s", addr.String(), "!", "-o", bridge, "-j", "MASQUERADE"}
 
 	if !iptables.Exists(iptables.Nat, "POSTROUTING", natArgs...) {
 		if output, err := iptables.Raw(append([]string{
@@ -133,7 +135,7 @@ func setupIPTables(addr net.Addr) error {
 	iptables.Raw("-N", "HYPER")
 
 	// Goto HYPER chain
-	gotoArgs := []string{"-o", BridgeIface, "-j", "HYPER"}
+	gotoArgs := []string{"-o", bridge, "-j", "HYPER"}
 	if !iptables.Exists(iptables.Filter, "FORWARD", gotoArgs...) {
 		if output, err := iptables.Raw(append([]string{"-I", "FORWARD"}, gotoArgs...)...); err != nil {
 			return fmt.Errorf("Unable to setup goto HYPER rule %s", err)
@@ -143,7 +145,7 @@ func setupIPTables(addr net.Addr) error {
 	}
 
 	// Accept all outgoing packets
-	outgoingArgs := []string{"-i", BridgeIface, "-j", "ACCEPT"}
+	outgoingArgs := []string{"-i", bridge, "-j", "ACCEPT"}
 	if !iptables.Exists(iptables.Filter, "FORWARD", outgoingArgs...) {
 		if output, err := iptables.Raw(append([]string{"-I", "FORWARD"}, outgoingArgs...)...); err != nil {
 			return fmt.Errorf("Unable to allow outgoing packets: %s", err)
@@ -153,7 +155,7 @@ func setupIPTables(addr net.Addr) error {
 	}
 
 	// Accept incoming packets for existing connections
-	existingArgs := []string{"-o", BridgeIface, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
+	existingArgs := []string{"-o", bridge, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
 
 	if !iptables.Exists(iptables.Filter, "FORWARD", existingArgs...) {
 		if output, err := iptables.Raw(append([]string{"-I", "FORWARD"}, existingArgs...)...); err != nil {
@@ -163,6 +165,24 @@ func setupIPTables(addr net.Addr) error {
 		}
 	}
 
+	return nil
+}
+
+// cleanupBridgeIPTables removes the rules added by setupBridgeIPTables
+func cleanupBridgeIPTables(bridge string, addr net.Addr) {
+	iptables.Raw("-t", string(iptables.Nat), "-D", "POSTROUTING",
+		"-s", addr.String(), "!", "-o", bridge, "-j", "MASQUERADE")
+	iptables.Raw("-D", "FORWARD", "-o", bridge, "-j", "HYPER")
+	iptables.Raw("-D", "FORWARD", "-i", bridge, "-j", "ACCEPT")
+	iptables.Raw("-D", "FORWARD", "-o", bridge, "-m", "conntrack",
+		"--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT")
+}
+
+func setupIPTables(addr net.Addr) error {
+	if err := setupBridgeIPTables(BridgeIface, addr); err != nil {
+		return err
+	}
+
 	err := Modprobe("br_netfilter")
 	if err != nil {
 		glog.V(1).Infof("modprobe br_netfilter failed %s", err)
@@ -182,7 +202,7 @@ func setupIPTables(addr net.Addr) error {
 	// Create HYPER iptables Chain
 	iptables.Raw("-t", string(iptables.Nat), "-N", "HYPER")
 	// Goto HYPER chain
-	gotoArgs = []string{"-m", "addrtype", "--dst-type", "LOCAL", "!",
+	gotoArgs := []string{"-m", "addrtype", "--dst-type", "LOCAL", "!",
 		"-d", "127.0.0.1/8", "-j", "HYPER"}
 	if !iptables.Exists(iptables.Nat, "OUTPUT", gotoArgs...) {
 		if output, err := iptables.Raw(append([]string{"-t", string(iptables.Nat),
@@ -276,7 +296,16 @@ func InitNetwork(bIface, bIP string) error {
 	}
 
 	ipAllocator.RequestIP(bridgeIPv4Net, bridgeIPv4Net.IP)
-	return nil
+
+	networkLock.Lock()
+	defer networkLock.Unlock()
+	networks[DefaultNetwork] = &Network{
+		Name:   DefaultNetwork,
+		Bridge: BridgeIface,
+		Subnet: bridgeIPv4Net.String(),
+		ipNet:  bridgeIPv4Net,
+	}
+	return setupIsolation()
 }
 
 // Return the first IPv4 address for the specified network interface
@@ -828,6 +857,17 @@ func Modprobe(module string) error {
 	return nil
 }
 
+// portMapArgs returns the DNAT rule of the nat table and the ACCEPT rule of
+// the filter table mapping a host port to a port of a pod
+func portMapArgs(proto string, hostPort int, containerip string, containerPort int) ([]string, []string) {
+	natArgs := []string{"-p", proto, "-m", proto, "--dport",
+		strconv.Itoa(hostPort), "-j", "DNAT", "--to-destination",
+		net.JoinHostPort(containerip, strconv.Itoa(containerPort))}
+	filterArgs := []string{"-d", containerip, "-p", proto, "-m", proto,
+		"--dport", strconv.Itoa(containerPort), "-j", "ACCEPT"}
+	return natArgs, filterArgs
+}
+
 func SetupPortMaps(containerip string, maps []pod.UserContainerPort) error {
 	if len(maps) == 0 {
 		return nil
@@ -842,9 +882,7 @@ func SetupPortMaps(containerip string, maps []pod.UserContainerPort) error {
 			proto = "tcp"
 		}
 
-		natArgs := []string{"-p", proto, "-m", proto, "--dport",
-			strconv.Itoa(m.HostPort), "-j", "DNAT", "--to-destination",
-			net.JoinHostPort(containerip, strconv.Itoa(m.ContainerPort))}
+		natArgs, filterArgs := portMapArgs(proto, m.HostPort, containerip, m.ContainerPort)
 
 		if iptables.PortMapExists("HYPER", natArgs) {
 			return nil
@@ -864,8 +902,6 @@ func SetupPortMaps(containerip string, maps []pod.UserContainerPort) error {
 			return err
 		}
 
-		filterArgs := []string{"-d", containerip, "-p", proto, "-m", proto,
-			"--dport", strconv.Itoa(m.ContainerPort), "-j", "ACCEPT"}
 		if output, err := iptables.Raw(append([]string{"-I", "HYPER"}, filterArgs...)...); err != nil {
 			return fmt.Errorf("Unable to setup forward rule in HYPER chain: %s", err)
 		} else if len(output) != 0 {
@@ -896,34 +932,31 @@ func ReleasePortMaps(containerip string, maps []pod.UserContainerPort) error {
 			proto = "tcp"
 		}
 
-		natArgs := []string{"-p", proto, "-m", proto, "--dport",
-			strconv.Itoa(m.HostPort), "-j", "DNAT", "--to-destination",
-			net.JoinHostPort(containerip, strconv.Itoa(m.ContainerPort))}
-
+		natArgs, filterArgs := portMapArgs(proto, m.HostPort, containerip, m.ContainerPort)
 		iptables.OperatePortMap(iptables.Delete, "HYPER", natArgs)
-
-		filterArgs := []string{"-d", containerip, "-p", proto, "-m", proto,
-			"--dport", strconv.Itoa(m.ContainerPort), "-j", "ACCEPT"}
 		iptables.Raw(append([]string{"-D", "HYPER"}, filterArgs...)...)
 	}
 	/* forbid to map ports twice */
 	return nil
 }
 
-func UpAndAddToBridge(name string) error {
+func UpAndAddToBridge(name, bridge string) error {
+	if bridge == "" {
+		bridge = BridgeIface
+	}
 	inf, err := net.InterfaceByName(name)
 	if err != nil {
 		glog.Error("cannot find network interface ", name)
 		return err
 	}
-	brg, err := net.InterfaceByName(BridgeIface)
+	brg, err := net.InterfaceByName(bridge)
 	if err != nil {
-		glog.Error("cannot find bridge interface ", BridgeIface)
+		glog.Error("cannot find bridge interface ", bridge)
 		return err
 	}
 	err = AddToBridge(inf, brg)
 	if err != nil {
-		glog.Errorf("cannot add %s to %s ", name, BridgeIface)
+		glog.Errorf("cannot add %s to %s ", name, bridge)
 		return err
 	}
 	err = NetworkLinkUp(inf)
@@ -935,18 +968,26 @@ func UpAndAddToBridge(name string) error {
 	return nil
 }
 
-func Allocate(requestedIP string, addrOnly bool, maps []pod.UserContainerPort) (*Settings, error) {
+// Allocate an ip address of the named network, and a tap device on its
+// bridge unless addrOnly is set, the default network is used if the name
+// is empty
+func Allocate(name, requestedIP string, addrOnly bool, maps []pod.UserContainerPort) (*Settings, error) {
 	var (
 		req   ifReq
 		errno syscall.Errno
 	)
 
-	ip, err := ipAllocator.RequestIP(bridgeIPv4Net, net.ParseIP(requestedIP))
+	nw, err := GetNetwork(name)
 	if err != nil {
 		return nil, err
 	}
 
-	maskSize, _ := bridgeIPv4Net.Mask.Size()
+	ip, err := ipAllocator.RequestIP(nw.ipNet, net.ParseIP(requestedIP))
+	if err != nil {
+		return nil, err
+	}
+
+	maskSize, _ := nw.ipNet.Mask.Size()
 
 	mac, err := GenRandomMac()
 	if err != nil {
@@ -959,8 +1000,8 @@ func Allocate(requestedIP string, addrOnly bool, maps []pod.UserContainerPort) (
 		return &Settings{
 			Mac:         mac,
 			IPAddress:   ip.String(),
-			Gateway:     bridgeIPv4Net.IP.String(),
-			Bridge:      BridgeIface,
+			Gateway:     nw.ipNet.IP.String(),
+			Bridge:      nw.Bridge,
 			IPPrefixLen: maskSize,
 			Device:      "",
 			File:        nil,
@@ -992,16 +1033,16 @@ func Allocate(requestedIP string, addrOnly bool, maps []pod.UserContainerPort) (
 		return nil, err
 	}
 
-	bIface, err := net.InterfaceByName(BridgeIface)
+	bIface, err := net.InterfaceByName(nw.Bridge)
 	if err != nil {
-		glog.Errorf("get interface by name %s failed", BridgeIface)
+		glog.Errorf("get interface by name %s failed", nw.Bridge)
 		tapFile.Close()
 		return nil, err
 	}
 
 	err = AddToBridge(tapIface, bIface)
 	if err != nil {
-		glog.Errorf("Add to bridge failed %s %s", BridgeIface, device)
+		glog.Errorf("Add to bridge failed %s %s", nw.Bridge, device)
 		tapFile.Close()
 		return nil, err
 	}
@@ -1023,22 +1064,27 @@ func Allocate(requestedIP string, addrOnly bool, maps []pod.UserContainerPort) (
 	return &Settings{
 		Mac:         mac,
 		IPAddress:   ip.String(),
-		Gateway:     bridgeIPv4Net.IP.String(),
-		Bridge:      BridgeIface,
+		Gateway:     nw.ipNet.IP.String(),
+		Bridge:      nw.Bridge,
 		IPPrefixLen: maskSize,
 		Device:      device,
 		File:        tapFile,
 	}, nil
 }
 
-// Release an interface for a select ip
-func Release(releasedIP string, maps []pod.UserContainerPort, file *os.File) error {
+// Release an interface for a select ip of the named network
+func Release(name, releasedIP string, maps []pod.UserContainerPort, file *os.File) error {
 
 	if file != nil {
 		file.Close()
 	}
 
-	if err := ipAllocator.ReleaseIP(bridgeIPv4Net, net.ParseIP(releasedIP)); err != nil {
+	nw, err := GetNetwork(name)
+	if err != nil {
+		return err
+	}
+
+	if err := ipAllocator.ReleaseIP(nw.ipNet, net.ParseIP(releasedIP)); err != nil {
 		return err
 	}
 
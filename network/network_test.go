@@ -1,6 +1,7 @@
 package network
 
 import (
+	"net"
 	"testing"
 )
 
@@ -21,13 +22,13 @@ func TestAllocate(t *testing.T) {
 		t.Error("create hyper-test bridge failed")
 	}
 
-	if setting, err := Allocate("192.168.138.2"); err != nil {
+	if setting, err := Allocate("", "192.168.138.2", false, nil); err != nil {
 		t.Error("allocate tap device and ip failed")
 	} else {
-		t.Log("alocate tap device finished. bridge %s, device %s, ip %s, gateway %s",
+		t.Logf("alocate tap device finished. bridge %s, device %s, ip %s, gateway %s",
 			setting.Bridge, setting.Device, setting.IPAddress, setting.Gateway)
 
-		if err := Release("192.168.138.2", setting.File); err != nil {
+		if err := Release("", "192.168.138.2", nil, setting.File); err != nil {
 			t.Error("release ip failed")
 		}
 	}
@@ -38,3 +39,34 @@ func TestAllocate(t *testing.T) {
 
 	t.Log("allocate finished")
 }
+
+func TestParsePortMapRule(t *testing.T) {
+	r, ok := parsePortMapRule("-A HYPER -p tcp -m tcp --dport 8080 -j DNAT --to-destination 192.168.123.2:80")
+	if !ok || r != (PortMapRule{Proto: "tcp", HostPort: 8080, IP: "192.168.123.2", Port: 80}) {
+		t.Errorf("the DNAT rule is parsed as %+v, %v", r, ok)
+	}
+	for _, line := range []string{"-N HYPER", "-A HYPER -d 192.168.123.2/32 -p tcp -m tcp --dport 80 -j ACCEPT"} {
+		if _, ok := parsePortMapRule(line); ok {
+			t.Errorf("%q is parsed as a port map", line)
+		}
+	}
+}
+
+func TestCheckIPRange(t *testing.T) {
+	saved := bridgeIPv4Net
+	defer func() { bridgeIPv4Net = saved }()
+	_, bridgeIPv4Net, _ = net.ParseCIDR("192.168.123.1/24")
+
+	for subnet, valid := range map[string]bool{
+		"":                   true,
+		"192.168.123.128/25": true,
+		"192.168.123.0/24":   true,
+		"192.168.0.0/16":     false,
+		"10.0.0.0/24":        false,
+		"192.168.123.1":      false,
+	} {
+		if err := CheckIPRange(subnet); (err == nil) != valid {
+			t.Errorf("IP range %q is checked as %v, should be valid %v", subnet, err, valid)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package network
+
+import (
+	"fmt"
+	"hyper/lib/glog"
+	"hyper/network/ipallocator"
+	"hyper/network/iptables"
+	"net"
+	"sort"
+	"sync"
+)
+
+// DefaultNetwork is the network on the bridge set up by InitNetwork, pods
+// which do not name their networks join it
+const DefaultNetwork = "default"
+
+// isolationChain drops the packets forwarded between two bridges, so pods
+// on different networks can not reach each other
+const isolationChain = "HYPER-ISOLATION"
+
+// Network is a bridge with its own address range
+type Network struct {
+	Name   string
+	Bridge string
+	Subnet string
+	ipNet  *net.IPNet
+}
+
+var (
+	networks    = make(map[string]*Network)
+	networkLock sync.RWMutex
+)
+
+// GetNetwork returns the network of the name, or the default network if the
+// name is empty
+func GetNetwork(name string) (*Network, error) {
+	if name == "" {
+		name = DefaultNetwork
+	}
+
+	networkLock.RLock()
+	defer networkLock.RUnlock()
+	nw, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("Network %s does not exist", name)
+	}
+	return nw, nil
+}
+
+// ListNetworks returns all the networks, ordered by name
+func ListNetworks() []*Network {
+	networkLock.RLock()
+	defer networkLock.RUnlock()
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*Network, len(names))
+	for i, name := range names {
+		list[i] = networks[name]
+	}
+	return list
+}
+
+// SetIPRange limits the addresses given to the pods of the default network
+// to the subnet, which must be within the bridge network. An empty subnet
+// gives out the whole bridge network again.
+func SetIPRange(subnet string) error {
+	ipnet, err := checkIPRange(subnet)
+	if err != nil {
+		return err
+	}
+	if err := ipAllocator.SetRange(bridgeIPv4Net, ipnet); err != nil {
+		return fmt.Errorf("Invalid IP range %s for the bridge network %s: %s", subnet, bridgeIPv4Net, err.Error())
+	}
+	return nil
+}
+
+// CheckIPRange tells whether SetIPRange would take the subnet, without
+// changing anything
+func CheckIPRange(subnet string) error {
+	_, err := checkIPRange(subnet)
+	return err
+}
+
+func checkIPRange(subnet string) (*net.IPNet, error) {
+	if bridgeIPv4Net == nil {
+		return nil, fmt.Errorf("The default network is not initialized")
+	}
+	if subnet == "" {
+		return nil, nil
+	}
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	if err := ipallocator.CheckRange(bridgeIPv4Net, ipnet); err != nil {
+		return nil, fmt.Errorf("Invalid IP range %s for the bridge network %s: %s", subnet, bridgeIPv4Net, err.Error())
+	}
+	return ipnet, nil
+}
+
+// CreateNetwork sets up the bridge of a new network, the addresses of its
+// pods are allocated from the subnet. The bridge is named after the network
+// if it is not given, and is created if it does not exist. Whatever is set
+// up is undone if it fails.
+func CreateNetwork(name, subnet, bridge string) (_ *Network, err error) {
+	if name == "" || name == DefaultNetwork {
+		return nil, fmt.Errorf("Invalid network name %q", name)
+	}
+	if bridge == "" {
+		bridge = "hyper-" + name
+	}
+	if len(bridge) >= IFNAMSIZ {
+		return nil, fmt.Errorf("Interface name %s too long", bridge)
+	}
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	if _, ok := networks[name]; ok {
+		return nil, fmt.Errorf("Network %s already exists", name)
+	}
+	for _, nw := range networks {
+		if nw.Bridge == bridge {
+			return nil, fmt.Errorf("Bridge %s is used by network %s", bridge, nw.Name)
+		}
+		if nw.ipNet.Contains(ipnet.IP) || ipnet.Contains(nw.ipNet.IP.Mask(nw.ipNet.Mask)) {
+			return nil, fmt.Errorf("Subnet %s overlaps with network %s (%s)", subnet, nw.Name, nw.Subnet)
+		}
+	}
+
+	addr, err := GetIfaceAddr(bridge)
+	if err != nil {
+		glog.V(1).Infof("create bridge %s, ip %s for network %s", bridge, subnet, name)
+		if err := configureBridge(subnet, bridge); err != nil {
+			glog.Error("create bridge failed")
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				DeleteBridge(bridge)
+			}
+		}()
+		if addr, err = GetIfaceAddr(bridge); err != nil {
+			return nil, err
+		}
+	} else if !ipnet.Contains(addr.(*net.IPNet).IP) {
+		return nil, fmt.Errorf("Bridge ip (%s) does not match the subnet %s", addr, subnet)
+	}
+	ipNet := addr.(*net.IPNet)
+
+	if err := ipAllocator.RegisterSubnet(ipNet, ipnet); err != nil {
+		if err != ipallocator.ErrNetworkAlreadyRegistered {
+			return nil, err
+		}
+	} else {
+		defer func() {
+			if err != nil {
+				ipAllocator.UnregisterSubnet(ipNet)
+			}
+		}()
+	}
+	ipAllocator.RequestIP(ipNet, ipNet.IP)
+
+	if err := setupBridgeIPTables(bridge, addr); err != nil {
+		cleanupBridgeIPTables(bridge, addr)
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			for _, nw := range networks {
+				unisolate(bridge, nw.Bridge)
+			}
+			cleanupBridgeIPTables(bridge, addr)
+		}
+	}()
+	for _, nw := range networks {
+		if err := isolate(bridge, nw.Bridge); err != nil {
+			return nil, err
+		}
+	}
+	// The rules of the new bridge are inserted ahead of the isolation ones
+	if err := setupIsolation(); err != nil {
+		return nil, err
+	}
+
+	nw := &Network{
+		Name:   name,
+		Bridge: bridge,
+		Subnet: ipNet.String(),
+		ipNet:  ipNet,
+	}
+	networks[name] = nw
+	return nw, nil
+}
+
+// DeleteNetwork removes the rules and the bridge of a network, the caller
+// should make sure no pod is on it.
+func DeleteNetwork(name string) error {
+	if name == DefaultNetwork {
+		return fmt.Errorf("The default network can not be removed")
+	}
+
+	networkLock.Lock()
+	defer networkLock.Unlock()
+
+	nw, ok := networks[name]
+	if !ok {
+		return fmt.Errorf("Network %s does not exist", name)
+	}
+	delete(networks, name)
+
+	for _, other := range networks {
+		unisolate(nw.Bridge, other.Bridge)
+	}
+	cleanupBridgeIPTables(nw.Bridge, nw.ipNet)
+	ipAllocator.UnregisterSubnet(nw.ipNet)
+	if err := DeleteBridge(nw.Bridge); err != nil {
+		glog.Warningf("fail to delete bridge %s: %s", nw.Bridge, err.Error())
+	}
+	return nil
+}
+
+// setupIsolation creates the isolation chain and makes it the first rule of
+// the FORWARD chain
+func setupIsolation() error {
+	iptables.Raw("-N", isolationChain)
+
+	jumpArgs := []string{"-j", isolationChain}
+	if iptables.Exists(iptables.Filter, "FORWARD", jumpArgs...) {
+		iptables.Raw(append([]string{"-D", "FORWARD"}, jumpArgs...)...)
+	}
+	if output, err := iptables.Raw(append([]string{"-I", "FORWARD"}, jumpArgs...)...); err != nil {
+		return fmt.Errorf("Unable to setup goto %s rule %s", isolationChain, err)
+	} else if len(output) != 0 {
+		return &iptables.ChainError{Chain: "FORWARD goto " + isolationChain, Output: output}
+	}
+	return nil
+}
+
+// isolate drops the packets between two bridges in both directions
+func isolate(bridge1, bridge2 string) error {
+	for _, args := range [][]string{
+		{"-i", bridge1, "-o", bridge2, "-j", "DROP"},
+		{"-i", bridge2, "-o", bridge1, "-j", "DROP"},
+	} {
+		if iptables.Exists(iptables.Filter, isolationChain, args...) {
+			continue
+		}
+		if output, err := iptables.Raw(append([]string{"-I", isolationChain}, args...)...); err != nil {
+			return fmt.Errorf("Unable to isolate %s from %s: %s", bridge1, bridge2, err)
+		} else if len(output) != 0 {
+			return &iptables.ChainError{Chain: isolationChain, Output: output}
+		}
+	}
+	return nil
+}
+
+func unisolate(bridge1, bridge2 string) {
+	iptables.Raw("-D", isolationChain, "-i", bridge1, "-o", bridge2, "-j", "DROP")
+	iptables.Raw("-D", isolationChain, "-i", bridge2, "-o", bridge1, "-j", "DROP")
+}
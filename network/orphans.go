@@ -0,0 +1,164 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"hyper/network/iptables"
+)
+
+// PortMapRule is a DNAT rule of the HYPER chain, mapping a host port to a
+// port of a pod
+type PortMapRule struct {
+	Proto    string
+	HostPort int
+	IP       string
+	Port     int
+}
+
+func (r PortMapRule) String() string {
+	return fmt.Sprintf("%s:%d->%s:%d", r.Proto, r.HostPort, r.IP, r.Port)
+}
+
+// PortMapRules lists the port maps set up in iptables, by this hyperd or by
+// a previous one
+func PortMapRules() ([]PortMapRule, error) {
+	output, err := iptables.Raw("-t", string(iptables.Nat), "-S", "HYPER")
+	if err != nil {
+		return nil, err
+	}
+	rules := []PortMapRule{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if r, ok := parsePortMapRule(line); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// parsePortMapRule parses a line of iptables -S like
+// -A HYPER -p tcp -m tcp --dport 8080 -j DNAT --to-destination 192.168.123.2:80
+func parsePortMapRule(line string) (PortMapRule, bool) {
+	var (
+		r      PortMapRule
+		dest   string
+		fields = strings.Fields(line)
+	)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "-p":
+			r.Proto = fields[i+1]
+		case "--dport":
+			r.HostPort, _ = strconv.Atoi(fields[i+1])
+		case "--to-destination":
+			dest = fields[i+1]
+		}
+	}
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil || r.Proto == "" || r.HostPort == 0 {
+		return r, false
+	}
+	r.IP = host
+	r.Port, _ = strconv.Atoi(port)
+	return r, true
+}
+
+// RemovePortMapRule removes the DNAT rule of a port map and the rule
+// accepting its packets
+func RemovePortMapRule(r PortMapRule) error {
+	natArgs, filterArgs := portMapArgs(r.Proto, r.HostPort, r.IP, r.Port)
+	iptables.Raw(append([]string{"-D", "HYPER"}, filterArgs...)...)
+	return iptables.OperatePortMap(iptables.Delete, "HYPER", natArgs)
+}
+
+// IPAllocated reports whether the ip is given to a pod by this hyperd
+func IPAllocated(ip string) bool {
+	return ipAllocator.Allocated(net.ParseIP(ip))
+}
+
+// OrphanTaps lists the tap devices on the bridges of the networks which are
+// not held by any process, a tap is only left so after a crash. The carrier
+// of a tap which is down could not be read, so a tap is only taken as an
+// orphan once it could be attached.
+func OrphanTaps() ([]string, error) {
+	taps := []string{}
+	for _, nw := range ListNetworks() {
+		ifaces, err := ioutil.ReadDir(path.Join("/sys/class/net", nw.Bridge, "brif"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, iface := range ifaces {
+			dir := path.Join("/sys/class/net", iface.Name())
+			if _, err := os.Stat(path.Join(dir, "tun_flags")); err != nil {
+				continue
+			}
+			// the carrier of a tap is on as long as its file is open
+			carrier, err := ioutil.ReadFile(path.Join(dir, "carrier"))
+			if err == nil && strings.TrimSpace(string(carrier)) != "0" {
+				continue
+			}
+			if !tapHeld(iface.Name()) {
+				taps = append(taps, iface.Name())
+			}
+		}
+	}
+	return taps, nil
+}
+
+// attachTap opens the tap device of the name, a tap held by a process could
+// not be attached and fails with EBUSY
+func attachTap(name string) (*os.File, error) {
+	var req ifReq
+	if len(name) >= IFNAMSIZ {
+		return nil, fmt.Errorf("Interface name %s too long", name)
+	}
+	file, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	copy(req.Name[:], name)
+	req.Flags = CIFF_TAP | CIFF_NO_PI | CIFF_ONE_QUEUE
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(),
+		uintptr(syscall.TUNSETIFF), uintptr(unsafe.Pointer(&req))); errno != 0 {
+		file.Close()
+		return nil, errno
+	}
+	return file, nil
+}
+
+// tapHeld reports whether the tap is held by a process, the tap is released
+// at once if it is not. A tap which could not be probed is taken as held.
+func tapHeld(name string) bool {
+	file, err := attachTap(name)
+	if err != nil {
+		return true
+	}
+	file.Close()
+	return false
+}
+
+// DeleteTap deletes a tap device which is not held by any process
+func DeleteTap(name string) error {
+	file, err := attachTap(name)
+	if err != nil {
+		return fmt.Errorf("attach tap device %s failed: %s", name, err.Error())
+	}
+	defer file.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(),
+		uintptr(syscall.TUNSETPERSIST), 0); errno != 0 {
+		return fmt.Errorf("delete tap device %s failed: %s", name, errno.Error())
+	}
+	return nil
+}
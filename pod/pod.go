@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"reflect"
 	"regexp"
+	"strings"
 )
 
 // Pod Data Structure
@@ -69,14 +71,31 @@ type UserVolume struct {
 	Driver string `json:"driver"`
 }
 
+type UserInterface struct {
+	Network string `json:"network"`
+	Ip      string `json:"ip"`
+}
+
 type UserPod struct {
 	Name       string          `json:"id"`
 	Containers []UserContainer `json:"containers"`
 	Resource   UserResource    `json:"resource"`
 	Files      []UserFile      `json:"files"`
 	Volumes    []UserVolume    `json:"volumes"`
+	Networks   []UserInterface `json:"networks"`
 	Tty        bool            `json:"tty"`
 	Type       string          `json:"type"`
+	// Kernel is the name of a kernel in the catalog of the daemon, the
+	// default kernel is used if it is empty
+	Kernel       string            `json:"kernel"`
+	KernelParams []string          `json:"kernelParams"`
+	Sysctl       map[string]string `json:"sysctl"`
+	// RecordSessions records the attach and exec sessions of the pod
+	RecordSessions bool `json:"recordSessions"`
+	// MaxRetries limits the restarts of the pod, 0 for no limit
+	MaxRetries int `json:"maxRetries"`
+	// Labels are kept for the users, hyperd does nothing with them
+	Labels map[string]string `json:"labels"`
 }
 
 func ProcessPodFile(jsonFile string) (*UserPod, error) {
@@ -132,6 +151,24 @@ func ProcessPodBytes(body []byte) (*UserPod, error) {
 	return &userPod, nil
 }
 
+// ProcessContainerBytes parses the spec of a single container, to be added
+// to an existing pod.
+func ProcessContainerBytes(body []byte) (*UserContainer, error) {
+	var container UserContainer
+	if err := json.Unmarshal(body, &container); err != nil {
+		return nil, err
+	}
+
+	if container.Image == "" {
+		return nil, fmt.Errorf("Please specific your image for your container, it can not be null!\n")
+	}
+	if container.Name == "" {
+		return nil, fmt.Errorf("Please specific the name of your container, it can not be null!\n")
+	}
+
+	return &container, nil
+}
+
 func RandStr(strSize int, randType string) string {
 	var dictionary string
 	if randType == "alphanum" {
@@ -173,6 +210,36 @@ func (pod *UserPod) Validate() error {
 			return errors.New("Files name does not unique")
 		}
 	}
+	for _, inf := range pod.Networks {
+		if inf.Network == "" {
+			return errors.New("Network name should not be empty")
+		}
+		if inf.Ip != "" && net.ParseIP(inf.Ip) == nil {
+			return fmt.Errorf("Invalid ip address %s of network %s", inf.Ip, inf.Network)
+		}
+	}
+	if uniq, _ := keySet(pod.Networks); !uniq {
+		return errors.New("Networks name does not unique")
+	}
+
+	if pod.MaxRetries < 0 {
+		return fmt.Errorf("Invalid maxRetries %d", pod.MaxRetries)
+	}
+
+	for _, param := range pod.KernelParams {
+		if err := validateKernelParam(param); err != nil {
+			return err
+		}
+	}
+	for key, value := range pod.Sysctl {
+		if !sysctlReg.MatchString(key) {
+			return fmt.Errorf("Invalid sysctl %q", key)
+		}
+		if value == "" || strings.ContainsAny(value, "\n\x00") {
+			return fmt.Errorf("Invalid value %q of sysctl %s", value, key)
+		}
+	}
+
 	var permReg = regexp.MustCompile("0[0-7]{3}")
 	for idx, container := range pod.Containers {
 
@@ -208,6 +275,49 @@ func (pod *UserPod) Validate() error {
 	return nil
 }
 
+// the kernel parameters a pod could append to the command line of its VM,
+// the ones the VM relies on, such as console, panic and init, are not here
+var kernelParams = map[string]bool{
+	"quiet":                            true,
+	"debug":                            true,
+	"loglevel":                         true,
+	"ignore_loglevel":                  true,
+	"printk.time":                      true,
+	"nokaslr":                          true,
+	"mitigations":                      true,
+	"pti":                              true,
+	"nopti":                            true,
+	"spectre_v2":                       true,
+	"nosmt":                            true,
+	"transparent_hugepage":             true,
+	"numa_balancing":                   true,
+	"clocksource":                      true,
+	"tsc":                              true,
+	"no_timer_check":                   true,
+	"nohz":                             true,
+	"audit":                            true,
+	"selinux":                          true,
+	"apparmor":                         true,
+	"cgroup_enable":                    true,
+	"cgroup_disable":                   true,
+	"swapaccount":                      true,
+	"systemd.unified_cgroup_hierarchy": true,
+	"random.trust_cpu":                 true,
+}
+
+var sysctlReg = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-zA-Z0-9_\-]+)+$`)
+
+func validateKernelParam(param string) error {
+	name := strings.SplitN(param, "=", 2)[0]
+	if !kernelParams[name] {
+		return fmt.Errorf("Kernel parameter %s is not allowed", name)
+	}
+	if strings.ContainsAny(param, " \t\n\"'") {
+		return fmt.Errorf("Invalid kernel parameter %q", param)
+	}
+	return nil
+}
+
 type item interface {
 	key() string
 }
@@ -238,6 +348,7 @@ func (vol UserVolume) key() string          { return vol.Name }
 func (vol UserVolumeReference) key() string { return vol.Volume }
 func (f UserFile) key() string              { return f.Name }
 func (env UserEnvironmentVar) key() string  { return env.Env }
+func (inf UserInterface) key() string       { return inf.Network }
 
 func InterfaceSlice(slice interface{}) ([]interface{}, error) {
 	s := reflect.ValueOf(slice)
@@ -41,3 +41,72 @@ func TestProcessPodBytes(t *testing.T) {
 		t.Fatal("The ProcessPodBytes function should return an error while processing a json string without image name!")
 	}
 }
+
+func TestProcessContainerBytes(t *testing.T) {
+	jsonStr := `{ "name": "debug", "image": "busybox:latest", "command": ["sh"] }`
+	c, err := ProcessContainerBytes([]byte(jsonStr))
+	if err != nil {
+		t.Fatal("The ProcessContainerBytes function return an error while processing a right json string!")
+	}
+	if c.Name != "debug" || c.Image != "busybox:latest" || len(c.Command) != 1 {
+		t.Fatalf("The ProcessContainerBytes function return a wrong container: %v", c)
+	}
+
+	if _, err := ProcessContainerBytes([]byte(`{ "name": "debug" }`)); err == nil {
+		t.Fatal("The ProcessContainerBytes function should return an error while the image is missing!")
+	}
+	if _, err := ProcessContainerBytes([]byte(`{ "image": "busybox" }`)); err == nil {
+		t.Fatal("The ProcessContainerBytes function should return an error while the name is missing!")
+	}
+}
+
+func TestValidateNetworks(t *testing.T) {
+	jsonStr := `{ "id": "test-networks", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "networks": [{ "network": "front" }, { "network": "back", "ip": "10.10.0.5" }] }`
+	userPod, err := ProcessPodBytes([]byte(jsonStr))
+	if err != nil {
+		t.Fatal("The ProcessPodBytes function return an error while processing a right json string with networks!")
+	}
+	if err := userPod.Validate(); err != nil {
+		t.Fatalf("The networks of the pod should be valid: %s", err.Error())
+	}
+	if len(userPod.Networks) != 2 || userPod.Networks[1].Ip != "10.10.0.5" {
+		t.Fatalf("The networks of the pod are not parsed right: %v", userPod.Networks)
+	}
+
+	userPod.Networks = append(userPod.Networks, UserInterface{Network: "front"})
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while a network is joined twice!")
+	}
+
+	userPod.Networks = []UserInterface{{Network: "front", Ip: "10.10.0"}}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while the ip address is invalid!")
+	}
+}
+
+func TestValidateKernel(t *testing.T) {
+	jsonStr := `{ "id": "test-kernel", "containers" : [{ "name": "web", "image": "tomcat:latest" }], "kernel": "4.1", "kernelParams": ["quiet", "loglevel=7"], "sysctl": { "net.core.somaxconn": "1024" } }`
+	userPod, err := ProcessPodBytes([]byte(jsonStr))
+	if err != nil {
+		t.Fatal("The ProcessPodBytes function return an error while processing a right json string with kernel!")
+	}
+	if err := userPod.Validate(); err != nil {
+		t.Fatalf("The kernel of the pod should be valid: %s", err.Error())
+	}
+	if userPod.Kernel != "4.1" || len(userPod.KernelParams) != 2 || userPod.Sysctl["net.core.somaxconn"] != "1024" {
+		t.Fatalf("The kernel of the pod is not parsed right: %v", userPod)
+	}
+
+	for _, param := range []string{"init=/bin/sh", "console=tty0", "loglevel=7 init=/bin/sh"} {
+		userPod.KernelParams = []string{param}
+		if err := userPod.Validate(); err == nil {
+			t.Fatalf("The Validate function should return an error while the kernel parameter %q is given!", param)
+		}
+	}
+	userPod.KernelParams = nil
+
+	userPod.Sysctl = map[string]string{"../proc": "1"}
+	if err := userPod.Validate(); err == nil {
+		t.Fatal("The Validate function should return an error while the sysctl is invalid!")
+	}
+}
@@ -15,18 +15,23 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/gorilla/mux"
 	"hyper/engine"
 	"hyper/lib/glog"
 	"hyper/lib/portallocator"
+	"hyper/lib/stdcopy"
 	"hyper/lib/version"
 	"hyper/utils"
 )
 
 var (
 	activationLock chan struct{}
+	// the servers started by ServeApi, by their PROTO://ADDR
+	servers     = map[string]Server{}
+	serversLock sync.Mutex
 )
 
 type HttpServer struct {
@@ -211,15 +216,598 @@ func getList(eng *engine.Engine, version version.Version, w http.ResponseWriter,
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
+func getNetworkList(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("networkList")
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type listResponse struct {
+		NetworkData []string `json:"networkData"`
+	}
+	var res listResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("networkData", res.NetworkData)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getKernelList(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("kernelList")
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type listResponse struct {
+		KernelData []string `json:"kernelData"`
+	}
+	var res listResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("kernelData", res.KernelData)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getCronList(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("cronList")
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type listResponse struct {
+		CronData []string `json:"cronData"`
+	}
+	var res listResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("cronData", res.CronData)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getCronRuns(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("cronRuns", r.Form.Get("name"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type runsResponse struct {
+		RunData []string `json:"runData"`
+	}
+	var res runsResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("runData", res.RunData)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getSessionList(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("sessionList", r.Form.Get("pod"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type listResponse struct {
+		SessionData []string `json:"sessionData"`
+	}
+	var res listResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("sessionData", res.SessionData)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getSessionPlay(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("sessionPlay", r.Form.Get("id"))
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func getVmTrace(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("vmTrace", r.Form.Get("vm"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type traceResponse struct {
+		ID        string   `json:"ID"`
+		TraceData []string `json:"traceData"`
+	}
+	var res traceResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.Set("ID", res.ID)
+	env.SetList("traceData", res.TraceData)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getPodInspect(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("podInspect", r.Form.Get("podName"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	// the inspect is passed on as it is, it is not an env
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(stdoutBuf.Bytes())
+	return err
+}
+
 func getPodInfo(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	job := eng.Job("podInfo", r.Form.Get("podName"))
+	job := eng.Job("podInfo", r.Form.Get("podName"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("hostname", dat["hostname"].(string))
+	env.SetInt("restarts", (int)(dat["restarts"].(float64)))
+	lastExits := []string{}
+	if l, ok := dat["lastExits"].([]interface{}); ok {
+		for _, e := range l {
+			lastExits = append(lastExits, e.(string))
+		}
+	}
+	env.SetList("lastExits", lastExits)
+	return writeJSONEnv(w, http.StatusCreated, env)
+}
+
+func postStop(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Stop the POD name is %s\n", r.Form.Get("podName"))
+	job := eng.Job("podStop", r.Form.Get("podId"), r.Form.Get("stopVm"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+// multiplexed reports whether the client asks for stdout and stderr framed
+// on the stream, only the clients without tty could. The stream is raw by
+// default, as the older clients do not know the frames.
+func multiplexed(r *http.Request) bool {
+	return r.Form.Get("tty") != "yes" && r.Form.Get("stream") == "multiplexed"
+}
+
+func streamType(r *http.Request) string {
+	if multiplexed(r) {
+		return "application/vnd.docker.multiplexed-stream"
+	}
+	return "application/vnd.docker.raw-stream"
+}
+
+// errorStream is where the errors of a job go on a hijacked stream, they
+// are framed as stderr on a multiplexed one
+func errorStream(r *http.Request, outStream io.Writer) io.Writer {
+	if multiplexed(r) {
+		return stdcopy.NewStdWriter(outStream, stdcopy.Stderr)
+	}
+	return outStream
+}
+
+func postExec(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	var (
+		job                 = eng.Job("exec", r.Form.Get("type"), r.Form.Get("value"), r.Form.Get("command"), r.Form.Get("tag"))
+		errOut    io.Writer = os.Stderr
+		errStream io.Writer
+		execEnv   = []string{}
+	)
+
+	if r.Form.Get("env") != "" {
+		if err := json.Unmarshal([]byte(r.Form.Get("env")), &execEnv); err != nil {
+			return err
+		}
+	}
+	job.SetenvList("env", execEnv)
+	job.Setenv("workdir", r.Form.Get("workdir"))
+	job.Setenv("user", r.Form.Get("user"))
+	job.SetenvBool("tty", r.Form.Get("tty") == "yes")
+	job.SetenvBool("multiplexed", multiplexed(r))
+	job.Setenv("detachKeys", r.Form.Get("detachKeys"))
+
+	// Setting up the streaming http interface.
+	inStream, outStream, err := hijackServer(w)
+	if err != nil {
+		return err
+	}
+	defer closeStreams(inStream, outStream)
+
+	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: %s\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n", streamType(r))
+
+	errStream = errorStream(r, outStream)
+	job.Stdin.Add(inStream)
+	job.Stdout.Add(outStream)
+	job.Stderr.Set(errStream)
+
+	// Now run the user process in container.
+	job.SetCloseIO(false)
+	if err := job.Run(); err != nil {
+		fmt.Fprintf(errOut, "Error starting exec command in POD %s: %s\n", r.Form.Get("podname"), err.Error())
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func getExitCode(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("exitcode", r.Form.Get("tag"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env engine.Env
+		dat map[string]interface{}
+	)
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ExitCode", fmt.Sprintf("%v", dat["ExitCode"]))
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPortForward(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	var (
+		job       = eng.Job("portForward", r.Form.Get("podName"), r.Form.Get("port"), r.Form.Get("tag"))
+		errStream io.Writer
+	)
+
+	// Setting up the streaming http interface.
+	inStream, outStream, err := hijackServer(w)
+	if err != nil {
+		return err
+	}
+	defer closeStreams(inStream, outStream)
+
+	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+
+	// the stream of a port forward is always multiplexed, the client gets
+	// the errors as stderr
+	errStream = stdcopy.NewStdWriter(outStream, stdcopy.Stderr)
+	job.Stdin.Add(inStream)
+	job.Stdout.Add(outStream)
+	job.Stderr.Set(errStream)
+
+	job.SetCloseIO(false)
+	if err := job.Run(); err != nil {
+		fmt.Fprintf(errStream, "Error forwarding port %s of POD %s: %s\n", r.Form.Get("port"), r.Form.Get("podName"), err.Error())
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func getPodArchive(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("podArchive", r.Form.Get("podName"), r.Form.Get("container"), r.Form.Get("path"), "get")
+	w.Header().Set("Content-Type", "application/x-tar")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func getEvents(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("events")
+	job.Setenv("since", r.Form.Get("since"))
+	job.SetenvList("filter", r.Form["filter"])
+	w.Header().Set("Content-Type", "application/json")
+	job.Stdout.Add(utils.NewWriteFlusher(w))
+	return job.Run()
+}
+
+func putPodArchive(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("podArchive", r.Form.Get("podName"), r.Form.Get("container"), r.Form.Get("path"), "put")
+	job.Stdin.Add(r.Body)
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func postAttach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	var (
+		job                 = eng.Job("attach", r.Form.Get("type"), r.Form.Get("value"), r.Form.Get("tag"))
+		errOut    io.Writer = os.Stderr
+		errStream io.Writer
+	)
+
+	job.SetenvBool("tty", r.Form.Get("tty") == "yes")
+	job.SetenvBool("multiplexed", multiplexed(r))
+	job.SetenvBool("readonly", r.Form.Get("readonly") == "yes")
+	job.Setenv("detachKeys", r.Form.Get("detachKeys"))
+
+	// Setting up the streaming http interface.
+	inStream, outStream, err := hijackServer(w)
+	if err != nil {
+		return err
+	}
+	defer closeStreams(inStream, outStream)
+
+	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: %s\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n", streamType(r))
+
+	errStream = errorStream(r, outStream)
+	job.Stdin.Add(inStream)
+	job.Stdout.Add(outStream)
+	job.Stderr.Set(errStream)
+
+	// Now run the user process in container.
+	job.SetCloseIO(false)
+	if err := job.Run(); err != nil {
+		fmt.Fprintf(errOut, "Error starting attach to POD %s: %s\n", r.Form.Get("podname"), err.Error())
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func postContainerCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Image name is %s\n", r.Form.Get("imageName"))
+	job := eng.Job("create", r.Form.Get("imageName"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	stderrBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	job.Stderr.Add(stderrBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ContainerID", dat["ContainerID"].(string))
+	return writeJSONEnv(w, http.StatusCreated, env)
+}
+
+func postPodCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Args string is %s\n", r.Form.Get("podArgs"))
+	job := eng.Job("podCreate", r.Form.Get("podArgs"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodStart(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("podStart", r.Form.Get("podId"), r.Form.Get("vmId"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodRun(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("podRun", r.Form.Get("podArgs"))
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postVmCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	job := eng.Job("vmCreate", r.Form.Get("cpu"), r.Form.Get("mem"))
 	stdoutBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
+
 	if err := job.Run(); err != nil {
 		return err
 	}
@@ -234,23 +822,27 @@ func getPodInfo(eng *engine.Engine, version version.Version, w http.ResponseWrit
 		return err
 	}
 
-	env.Set("hostname", dat["hostname"].(string))
-	return writeJSONEnv(w, http.StatusCreated, env)
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postStop(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postVmKill(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	glog.V(1).Infof("Stop the POD name is %s\n", r.Form.Get("podName"))
-	job := eng.Job("podStop", r.Form.Get("podId"), r.Form.Get("stopVm"))
+	job := eng.Job("vmKill", r.Form.Get("vm"))
 	stdoutBuf := bytes.NewBuffer(nil)
+
 	job.Stdout.Add(stdoutBuf)
 
 	if err := job.Run(); err != nil {
 		return err
 	}
+
 	var (
 		env             engine.Env
 		dat             map[string]interface{}
@@ -268,90 +860,140 @@ func postStop(eng *engine.Engine, version version.Version, w http.ResponseWriter
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postExec(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postImageCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	var (
-		job                 = eng.Job("exec", r.Form.Get("type"), r.Form.Get("value"), r.Form.Get("command"), r.Form.Get("tag"))
-		errOut    io.Writer = os.Stderr
-		errStream io.Writer
-	)
+	glog.V(1).Infof("Image name is %s\n", r.Form.Get("imageName"))
+	job := eng.Job("pull", r.Form.Get("imageName"))
+	stdoutBuf := bytes.NewBuffer(nil)
 
-	// Setting up the streaming http interface.
-	inStream, outStream, err := hijackServer(w)
-	if err != nil {
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
 		return err
 	}
-	defer closeStreams(inStream, outStream)
+	return nil
+}
 
-	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+func postTtyResize(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+	job := eng.Job("tty", r.Form.Get("id"), r.Form.Get("tag"), r.Form.Get("h"), r.Form.Get("w"))
+	if err := job.Run(); err != nil {
+		return err
+	}
+	return nil
+}
 
-	errStream = outStream
-	job.Stdin.Add(inStream)
-	job.Stdout.Add(outStream)
-	job.Stderr.Set(errStream)
+func postPodRemove(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Pod(%s) is process to be removed", r.Form.Get("podId"))
+	job := eng.Job("podRm", r.Form.Get("podId"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
 
-	// Now run the user process in container.
-	job.SetCloseIO(false)
 	if err := job.Run(); err != nil {
-		fmt.Fprintf(errOut, "Error starting exec command in POD %s: %s\n", r.Form.Get("podname"), err.Error())
 		return err
 	}
-	w.WriteHeader(http.StatusNoContent)
 
-	return nil
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postAttach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postContainerAdd(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
+	glog.V(1).Infof("Add container %s to pod %s", r.Form.Get("containerArgs"), r.Form.Get("podId"))
+	job := eng.Job("containerAdd", r.Form.Get("podId"), r.Form.Get("containerArgs"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
 	var (
-		job                 = eng.Job("attach", r.Form.Get("type"), r.Form.Get("value"), r.Form.Get("tag"))
-		errOut    io.Writer = os.Stderr
-		errStream io.Writer
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
 	)
-
-	// Setting up the streaming http interface.
-	inStream, outStream, err := hijackServer(w)
-	if err != nil {
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
 		return err
 	}
-	defer closeStreams(inStream, outStream)
 
-	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
 
-	errStream = outStream
-	job.Stdin.Add(inStream)
-	job.Stdout.Add(outStream)
-	job.Stderr.Set(errStream)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postContainerRemove(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+
+	glog.V(1).Infof("Container(%s) of pod(%s) is process to be removed", r.Form.Get("container"), r.Form.Get("podId"))
+	job := eng.Job("containerRm", r.Form.Get("podId"), r.Form.Get("container"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
 
-	// Now run the user process in container.
-	job.SetCloseIO(false)
 	if err := job.Run(); err != nil {
-		fmt.Fprintf(errOut, "Error starting attach to POD %s: %s\n", r.Form.Get("podname"), err.Error())
 		return err
 	}
-	w.WriteHeader(http.StatusNoContent)
 
-	return nil
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postContainerCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postContainerRestart(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	glog.V(1).Infof("Image name is %s\n", r.Form.Get("imageName"))
-	job := eng.Job("create", r.Form.Get("imageName"))
+	glog.V(1).Infof("Restart container(%s) of pod(%s)", r.Form.Get("container"), r.Form.Get("podId"))
+	job := eng.Job("containerRestart", r.Form.Get("podId"), r.Form.Get("container"))
 	stdoutBuf := bytes.NewBuffer(nil)
-	stderrBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
-	job.Stderr.Add(stderrBuf)
+
 	if err := job.Run(); err != nil {
 		return err
 	}
@@ -366,17 +1008,24 @@ func postContainerCreate(eng *engine.Engine, version version.Version, w http.Res
 		return err
 	}
 
-	env.Set("ContainerID", dat["ContainerID"].(string))
-	return writeJSONEnv(w, http.StatusCreated, env)
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postPodCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postVolumeAttach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	glog.V(1).Infof("Args string is %s\n", r.Form.Get("podArgs"))
-	job := eng.Job("podCreate", r.Form.Get("podArgs"))
+	glog.V(1).Infof("Attach volume %s to %s of container %s in pod %s", r.Form.Get("volume"),
+		r.Form.Get("path"), r.Form.Get("container"), r.Form.Get("podId"))
+	job := eng.Job("volumeAttach", r.Form.Get("podId"), r.Form.Get("volume"), r.Form.Get("container"), r.Form.Get("path"))
+	job.SetenvBool("readonly", r.Form.Get("readonly") == "yes")
+	job.Setenv("source", r.Form.Get("source"))
+	job.Setenv("driver", r.Form.Get("driver"))
 	stdoutBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
@@ -402,13 +1051,16 @@ func postPodCreate(eng *engine.Engine, version version.Version, w http.ResponseW
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postPodStart(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postVolumeDetach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	job := eng.Job("podStart", r.Form.Get("podId"), r.Form.Get("vmId"))
+	glog.V(1).Infof("Detach volume %s from container %s in pod %s", r.Form.Get("volume"),
+		r.Form.Get("container"), r.Form.Get("podId"))
+	job := eng.Job("volumeDetach", r.Form.Get("podId"), r.Form.Get("volume"), r.Form.Get("container"))
 	stdoutBuf := bytes.NewBuffer(nil)
+
 	job.Stdout.Add(stdoutBuf)
 
 	if err := job.Run(); err != nil {
@@ -432,13 +1084,17 @@ func postPodStart(eng *engine.Engine, version version.Version, w http.ResponseWr
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postPodRun(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postNetworkCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	job := eng.Job("podRun", r.Form.Get("podArgs"))
+	glog.V(1).Infof("Create network %s", r.Form.Get("name"))
+	job := eng.Job("networkCreate", r.Form.Get("name"))
+	job.Setenv("subnet", r.Form.Get("subnet"))
+	job.Setenv("bridge", r.Form.Get("bridge"))
 	stdoutBuf := bytes.NewBuffer(nil)
+
 	job.Stdout.Add(stdoutBuf)
 
 	if err := job.Run(); err != nil {
@@ -462,12 +1118,13 @@ func postPodRun(eng *engine.Engine, version version.Version, w http.ResponseWrit
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postVmCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postNetworkRemove(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	job := eng.Job("vmCreate", r.Form.Get("cpu"), r.Form.Get("mem"))
+	glog.V(1).Infof("Remove network %s", r.Form.Get("name"))
+	job := eng.Job("networkRm", r.Form.Get("name"))
 	stdoutBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
@@ -492,13 +1149,15 @@ func postVmCreate(eng *engine.Engine, version version.Version, w http.ResponseWr
 
 	return writeJSONEnv(w, http.StatusOK, env)
 }
-
-func postVmKill(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postKernelAdd(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	job := eng.Job("vmKill", r.Form.Get("vm"))
+	glog.V(1).Infof("Add kernel %s", r.Form.Get("name"))
+	job := eng.Job("kernelAdd", r.Form.Get("name"))
+	job.Setenv("kernel", r.Form.Get("kernel"))
+	job.Setenv("initrd", r.Form.Get("initrd"))
 	stdoutBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
@@ -524,40 +1183,81 @@ func postVmKill(eng *engine.Engine, version version.Version, w http.ResponseWrit
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postImageCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postKernelRemove(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	glog.V(1).Infof("Image name is %s\n", r.Form.Get("imageName"))
-	job := eng.Job("pull", r.Form.Get("imageName"))
+	glog.V(1).Infof("Remove kernel %s", r.Form.Get("name"))
+	job := eng.Job("kernelRm", r.Form.Get("name"))
 	stdoutBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
+
 	if err := job.Run(); err != nil {
 		return err
 	}
-	return nil
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postTtyResize(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postCronCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
-	job := eng.Job("tty", r.Form.Get("id"), r.Form.Get("tag"), r.Form.Get("h"), r.Form.Get("w"))
+
+	glog.V(1).Infof("Create cron %s at %s", r.Form.Get("name"), r.Form.Get("schedule"))
+	job := eng.Job("cronCreate", r.Form.Get("podArgs"))
+	job.Setenv("schedule", r.Form.Get("schedule"))
+	job.Setenv("name", r.Form.Get("name"))
+	job.Setenv("concurrency", r.Form.Get("concurrency"))
+	job.Setenv("history", r.Form.Get("history"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
 	if err := job.Run(); err != nil {
 		return err
 	}
-	return nil
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	env.Set("Cause", dat["Cause"].(string))
+
+	return writeJSONEnv(w, http.StatusOK, env)
 }
 
-func postPodRemove(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+func postCronRemove(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := r.ParseForm(); err != nil {
 		return nil
 	}
 
-	glog.V(1).Infof("Pod(%s) is process to be removed", r.Form.Get("podId"))
-	job := eng.Job("podRm", r.Form.Get("podId"))
+	glog.V(1).Infof("Remove cron %s", r.Form.Get("name"))
+	job := eng.Job("cronRm", r.Form.Get("name"))
 	stdoutBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
@@ -582,6 +1282,62 @@ func postPodRemove(eng *engine.Engine, version version.Version, w http.ResponseW
 
 	return writeJSONEnv(w, http.StatusOK, env)
 }
+
+func postDaemonReload(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	glog.V(1).Infof("Reload the daemon config")
+	job := eng.Job("daemonReload")
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type reloadResponse struct {
+		Applied []string `json:"Applied"`
+		Restart []string `json:"Restart"`
+	}
+	var res reloadResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("Applied", res.Applied)
+	env.SetList("Restart", res.Restart)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postGc(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	glog.V(1).Infof("Collect the orphans, dry run: %s", r.Form.Get("dryRun"))
+	job := eng.Job("gc", r.Form.Get("dryRun"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type gcResponse struct {
+		Orphans []string `json:"Orphans"`
+		Errors  []string `json:"Errors"`
+	}
+	var res gcResponse
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("Orphans", res.Orphans)
+	env.SetList("Errors", res.Errors)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
 func optionsHandler(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	w.WriteHeader(http.StatusOK)
 	return nil
@@ -667,24 +1423,52 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 	}
 	m := map[string]map[string]HttpApiFunc{
 		"GET": {
-			"/info":     getInfo,
-			"/pod/info": getPodInfo,
-			"/version":  getVersion,
-			"/list":     getList,
+			"/info":         getInfo,
+			"/pod/info":     getPodInfo,
+			"/pod/inspect":  getPodInspect,
+			"/version":      getVersion,
+			"/list":         getList,
+			"/exitcode":     getExitCode,
+			"/pod/archive":  getPodArchive,
+			"/network/list": getNetworkList,
+			"/events":       getEvents,
+			"/vm/trace":     getVmTrace,
+			"/kernel/list":  getKernelList,
+			"/cron/list":    getCronList,
+			"/cron/runs":    getCronRuns,
+			"/session/list": getSessionList,
+			"/session/play": getSessionPlay,
 		},
 		"POST": {
-			"/container/create": postContainerCreate,
-			"/image/create":     postImageCreate,
-			"/pod/create":       postPodCreate,
-			"/pod/start":        postPodStart,
-			"/pod/remove":       postPodRemove,
-			"/pod/run":          postPodRun,
-			"/pod/stop":         postStop,
-			"/vm/create":        postVmCreate,
-			"/vm/kill":          postVmKill,
-			"/exec":             postExec,
-			"/attach":           postAttach,
-			"/tty/resize":       postTtyResize,
+			"/container/create":  postContainerCreate,
+			"/container/add":     postContainerAdd,
+			"/container/remove":  postContainerRemove,
+			"/container/restart": postContainerRestart,
+			"/volume/attach":     postVolumeAttach,
+			"/volume/detach":     postVolumeDetach,
+			"/network/create":    postNetworkCreate,
+			"/network/remove":    postNetworkRemove,
+			"/kernel/add":        postKernelAdd,
+			"/kernel/remove":     postKernelRemove,
+			"/cron/create":       postCronCreate,
+			"/cron/remove":       postCronRemove,
+			"/image/create":      postImageCreate,
+			"/pod/create":        postPodCreate,
+			"/pod/start":         postPodStart,
+			"/pod/remove":        postPodRemove,
+			"/pod/run":           postPodRun,
+			"/pod/stop":          postStop,
+			"/vm/create":         postVmCreate,
+			"/vm/kill":           postVmKill,
+			"/exec":              postExec,
+			"/attach":            postAttach,
+			"/pod/portforward":   postPortForward,
+			"/tty/resize":        postTtyResize,
+			"/daemon/reload":     postDaemonReload,
+			"/gc":                postGc,
+		},
+		"PUT": {
+			"/pod/archive": putPodArchive,
 		},
 		"DELETE": {},
 		"OPTIONS": {
@@ -829,9 +1613,14 @@ func ServeApi(job *engine.Job) error {
 		protoAddrs = job.Args
 		chErrors   = make(chan error, len(protoAddrs))
 	)
-	activationLock = make(chan struct{})
+	serversLock.Lock()
+	if activationLock == nil {
+		activationLock = make(chan struct{})
+	}
+	serversLock.Unlock()
 
 	for _, protoAddr := range protoAddrs {
+		protoAddr := protoAddr
 		protoAddrParts := strings.SplitN(protoAddr, "://", 2)
 		if len(protoAddrParts) != 2 {
 			return fmt.Errorf("usage: %s PROTO://ADDR [PROTO://ADDR ...]", job.Name)
@@ -843,14 +1632,22 @@ func ServeApi(job *engine.Job) error {
 				chErrors <- err
 				return
 			}
+			serversLock.Lock()
+			servers[protoAddr] = srv
+			serversLock.Unlock()
 			job.Eng.OnShutdown(func() {
-				if err := srv.Close(); err != nil {
+				if err := srv.Close(); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
 					glog.Errorf("%s\n", err)
 				}
 			})
 			if err = srv.Serve(); err != nil && strings.Contains(err.Error(), "use of closed network connection") {
 				err = nil
 			}
+			serversLock.Lock()
+			if servers[protoAddr] == srv {
+				delete(servers, protoAddr)
+			}
+			serversLock.Unlock()
 			chErrors <- err
 		}()
 	}
@@ -865,6 +1662,19 @@ func ServeApi(job *engine.Job) error {
 	return nil
 }
 
+// CloseApi stops serving the API on a PROTO://ADDR started by ServeApi, the
+// ServeApi job returns once all its addresses are closed.
+func CloseApi(protoAddr string) error {
+	serversLock.Lock()
+	srv, ok := servers[protoAddr]
+	delete(servers, protoAddr)
+	serversLock.Unlock()
+	if !ok {
+		return fmt.Errorf("The API is not served on %s", protoAddr)
+	}
+	return srv.Close()
+}
+
 // NewServer sets up the required Server and does protocol specific checking.
 func NewServer(proto, addr string, job *engine.Job) (Server, error) {
 	// Basic error and sanity checking
@@ -908,9 +1718,11 @@ func setupUnixHttp(addr string, job *engine.Job) (*HttpServer, error) {
 // Called through eng.Job("acceptconnections")
 func AcceptConnections(job *engine.Job) error {
 	// close the lock so the listeners start accepting connections
+	serversLock.Lock()
 	if activationLock != nil {
 		close(activationLock)
 	}
+	serversLock.Unlock()
 
 	return nil
 }
@@ -303,6 +303,16 @@ func DeleteVolume(dm *DeviceMapper, dev_id int) error {
 	return nil
 }
 
+// RemoveDevice deactivates a device, the thin device in the pool is kept
+func RemoveDevice(name string) error {
+	parms := fmt.Sprintf("dmsetup remove \"/dev/mapper/%s\"", name)
+	if res, err := exec.Command("/bin/sh", "-c", parms).CombinedOutput(); err != nil {
+		glog.Error(string(res))
+		return fmt.Errorf("%s", res)
+	}
+	return nil
+}
+
 // Delete the pool which is created in 'Init' function
 func DMCleanup(dm *DeviceMapper) error {
 	var parms string
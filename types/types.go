@@ -13,6 +13,9 @@ const (
 	E_BUSY
 	E_NO_TTY
 	E_JSON_PARSE_FAIL
+	E_BOOT_FAILED
+	E_CONTAINER_EXITED
+	E_NO_RESOURCE
 )
 
 // status for POD or container
@@ -25,6 +28,9 @@ const (
 
 	S_VM_IDLE
 	S_VM_ASSOCIATED
+
+	// the pod exited and waits for its restart
+	S_POD_BACKOFF
 )
 
 type QemuResponse struct {
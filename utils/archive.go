@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ScopedPath resolves p inside root, following symlinks as if root was the
+// filesystem root, so that the result never points outside of root.
+func ScopedPath(root, p string) (string, error) {
+	root = filepath.Clean(root)
+	resolved := root
+	left := strings.Split(filepath.Clean("/"+p), "/")
+	for hops := 0; len(left) > 0; {
+		elem := left[0]
+		left = left[1:]
+		if elem == "" || elem == "." {
+			continue
+		}
+		if elem == ".." {
+			if resolved != root {
+				resolved = filepath.Dir(resolved)
+			}
+			continue
+		}
+
+		next := filepath.Join(resolved, elem)
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// missing components are fine, the caller may create them
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > 255 {
+			return "", fmt.Errorf("too many links in %s", p)
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			resolved = root
+		}
+		left = append(strings.Split(link, "/"), left...)
+	}
+	return resolved, nil
+}
+
+// TarPath writes src as a tar stream, entries are named after the base name
+// of src, like tar does when given a relative path.
+func TarPath(src string, w io.Writer) error {
+	src = filepath.Clean(src)
+	if _, err := os.Lstat(src); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(src)
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ExtractArchive unpacks a tar stream created by TarPath to dest, resolved
+// inside root. If dest is an existing directory the archive goes into it,
+// otherwise the top entry of the archive is renamed to dest. The absolute
+// symlinks of the archive are relative to root, like in a container.
+func ExtractArchive(r io.Reader, root, dest string) error {
+	return extractArchive(r, root, dest, false)
+}
+
+// ExtractLocalArchive unpacks a tar stream created by TarPath to the local
+// path dest, like ExtractArchive does with the directory of dest as root.
+// The archive may come from a pod, so its symlinks are refused if they
+// point outside of that directory.
+func ExtractLocalArchive(r io.Reader, dest string) error {
+	dest = filepath.Clean(dest)
+	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+		return extractArchive(r, dest, "/", true)
+	}
+	return extractArchive(r, filepath.Dir(dest), filepath.Base(dest), true)
+}
+
+// insideRoot tells whether the host path p is root or below it
+func insideRoot(root, p string) bool {
+	root, p = filepath.Clean(root), filepath.Clean(p)
+	return p == root || strings.HasPrefix(p, root+"/") || root == "/"
+}
+
+func extractArchive(r io.Reader, root, dest string, local bool) error {
+	var (
+		dir    = filepath.Clean("/" + dest)
+		rename = ""
+	)
+	if full, err := ScopedPath(root, dir); err != nil {
+		return err
+	} else if fi, err := os.Stat(full); err != nil || !fi.IsDir() {
+		rename = filepath.Base(dir)
+		dir = filepath.Dir(dir)
+	}
+
+	tr := tar.NewReader(r)
+	top := ""
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(filepath.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if top == "" {
+			top = parts[0]
+		} else if parts[0] != top {
+			return fmt.Errorf("archive has more than one top entry: %s, %s", top, parts[0])
+		}
+		if rename != "" {
+			parts[0] = rename
+		}
+		// the entry itself replaces whatever is there, only its parents
+		// are resolved
+		entry := filepath.Join(dir, filepath.Join(parts...))
+		parent, err := ScopedPath(root, filepath.Dir(entry))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(parent, filepath.Base(entry))
+
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			link := hdr.Linkname
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(filepath.Dir(target), link)
+			}
+			if local && !insideRoot(root, link) {
+				return fmt.Errorf("symlink %s points outside of %s: %s", hdr.Name, root, hdr.Linkname)
+			}
+		case tar.TypeLink:
+			// the target of a hard link is another entry of the archive
+			lname := strings.TrimPrefix(filepath.Clean("/"+hdr.Linkname), "/")
+			lparts := strings.SplitN(lname, "/", 2)
+			if lparts[0] != top {
+				return fmt.Errorf("hard link %s points outside of the archive: %s", hdr.Name, hdr.Linkname)
+			}
+			if rename != "" {
+				lparts[0] = rename
+			}
+			src, err := ScopedPath(root, filepath.Join(dir, filepath.Join(lparts...)))
+			if err != nil {
+				return err
+			}
+			if !insideRoot(root, src) {
+				return fmt.Errorf("hard link %s points outside of %s: %s", hdr.Name, root, hdr.Linkname)
+			}
+			os.Remove(target)
+			if err := os.Link(src, target); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := extractEntry(tr, hdr, target); err != nil {
+			return err
+		}
+	}
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, target string) error {
+	mode := os.FileMode(hdr.Mode).Perm()
+	// never write through a symlink found in place of the entry
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, mode); err != nil {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		os.Remove(target)
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !os.IsPermission(err) {
+			return err
+		}
+		return nil
+	default:
+		// devices and fifos are not copied
+		return nil
+	}
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return os.Chmod(target, mode)
+}
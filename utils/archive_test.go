@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScopedPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "scoped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "usr/lib"), 0755)
+	os.Symlink("/usr/lib", filepath.Join(root, "lib"))
+	os.Symlink("../../../../etc", filepath.Join(root, "usr/escape"))
+
+	cases := map[string]string{
+		"/etc/passwd":         "etc/passwd",
+		"../../etc/passwd":    "etc/passwd",
+		"/lib/libc.so":        "usr/lib/libc.so",
+		"/usr/escape/passwd":  "etc/passwd",
+		"/usr/lib/../../boot": "boot",
+	}
+	for p, expected := range cases {
+		res, err := ScopedPath(root, p)
+		if err != nil {
+			t.Fatalf("resolve %s failed: %s", p, err.Error())
+		}
+		if res != filepath.Join(root, expected) {
+			t.Fatalf("resolve %s: expected %s, got %s", p, expected, res)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "archive-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "archive-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	os.MkdirAll(filepath.Join(src, "conf/sub"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "conf/a.conf"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "conf/sub/b.conf"), []byte("b"), 0600)
+	os.Symlink("a.conf", filepath.Join(src, "conf/link"))
+
+	var buf bytes.Buffer
+	if err := TarPath(filepath.Join(src, "conf"), &buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// into an existing directory
+	if err := ExtractArchive(bytes.NewReader(data), dst, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := ioutil.ReadFile(filepath.Join(dst, "conf/sub/b.conf")); err != nil || string(c) != "b" {
+		t.Fatalf("unexpected content %q, %v", c, err)
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "conf/sub/b.conf")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected mode %v, %v", fi, err)
+	}
+	if l, err := os.Readlink(filepath.Join(dst, "conf/link")); err != nil || l != "a.conf" {
+		t.Fatalf("unexpected link %q, %v", l, err)
+	}
+
+	// to a new name
+	if err := ExtractArchive(bytes.NewReader(data), dst, "/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := ioutil.ReadFile(filepath.Join(dst, "renamed/a.conf")); err != nil || string(c) != "a" {
+		t.Fatalf("unexpected content %q, %v", c, err)
+	}
+}
+
+func testTar(t *testing.T, hdrs ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestExtractLocalArchiveLinks(t *testing.T) {
+	dst, err := ioutil.TempDir("", "archive-dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	dir := &tar.Header{Name: "top/", Typeflag: tar.TypeDir, Mode: 0755}
+	file := &tar.Header{Name: "top/a", Typeflag: tar.TypeReg, Mode: 0644}
+	data := testTar(t, dir, file,
+		&tar.Header{Name: "top/link", Typeflag: tar.TypeSymlink, Linkname: "a"},
+		&tar.Header{Name: "top/hard", Typeflag: tar.TypeLink, Linkname: "top/a"})
+	if err := ExtractLocalArchive(bytes.NewReader(data), dst); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := ioutil.ReadFile(filepath.Join(dst, "top/hard")); err != nil || string(c) != "top/a" {
+		t.Fatalf("unexpected content of the hard link %q, %v", c, err)
+	}
+	if l, err := os.Readlink(filepath.Join(dst, "top/link")); err != nil || l != "a" {
+		t.Fatalf("unexpected link %q, %v", l, err)
+	}
+
+	for _, link := range []*tar.Header{
+		{Name: "top/evil", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		{Name: "top/evil", Typeflag: tar.TypeSymlink, Linkname: "../../etc"},
+		{Name: "top/evil", Typeflag: tar.TypeLink, Linkname: "other/passwd"},
+		{Name: "top/evil", Typeflag: tar.TypeLink, Linkname: "../etc/passwd"},
+	} {
+		data := testTar(t, dir, link, &tar.Header{Name: "top/evil/passwd", Typeflag: tar.TypeReg, Mode: 0644})
+		if err := ExtractLocalArchive(bytes.NewReader(data), filepath.Join(dst, "new")); err == nil {
+			t.Errorf("the link to %s is extracted", link.Linkname)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "new/evil/passwd")); err == nil {
+		t.Error("an entry is written through the link")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 )
 
 var (
@@ -51,6 +52,25 @@ func DownloadFile(uri, target string) error {
 	return nil
 }
 
+// CopyFile copies the file src to dst, dst is replaced if it exists
+func CopyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 func Base64Decode(fileContent string) (string, error) {
 	b64 := base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/")
 	decodeBytes, err := b64.DecodeString(fileContent)
@@ -102,3 +122,29 @@ func ConvertPermStrToInt(str string) int {
 	}
 	return res
 }
+
+// WriteFlusher flushes the http response after every write, so the data
+// streamed by a job reaches the client at once.
+type WriteFlusher struct {
+	sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func NewWriteFlusher(w io.Writer) *WriteFlusher {
+	wf := &WriteFlusher{w: w}
+	if f, ok := w.(http.Flusher); ok {
+		wf.flusher = f
+	}
+	return wf
+}
+
+func (wf *WriteFlusher) Write(b []byte) (int, error) {
+	wf.Lock()
+	defer wf.Unlock()
+	n, err := wf.w.Write(b)
+	if wf.flusher != nil {
+		wf.flusher.Flush()
+	}
+	return n, err
+}